@@ -1,6 +1,10 @@
 package main
 
 import (
+	"os"
+	// часовые пояса для окон времени в правилах перехода, если в системе нет tzdata
+	_ "time/tzdata"
+
 	"go.uber.org/zap"
 
 	"github.com/pluhe7/shortener/config"
@@ -10,6 +14,11 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == migrateDataCommand {
+		runMigrateData(os.Args[2:])
+		return
+	}
+
 	cfg := config.InitConfig()
 	logger.InitLogger(cfg.LogLevel)
 
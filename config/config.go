@@ -2,7 +2,11 @@ package config
 
 import (
 	"flag"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.uber.org/zap/zapcore"
 )
@@ -12,6 +16,54 @@ const (
 	defaultBaseURL         = "http://localhost:8080"
 	defaultLogLevel        = "info"
 	defaultFileStoragePath = "/tmp/short-url-db.json"
+
+	defaultCopyThreshold           = 1000
+	defaultDatabaseMaxOpenConns    = 25
+	defaultDatabaseMaxIdleConns    = 5
+	defaultDatabaseConnMaxLifetime = 30 * time.Minute
+	defaultDatabaseConnMaxIdleTime = 5 * time.Minute
+	defaultDatabaseConnectAttempts = 5
+	defaultDatabaseConnectBackoff  = 500 * time.Millisecond
+	defaultReplicaCheckInterval    = 5 * time.Second
+	defaultReadYourWritesWindow    = 10 * time.Second
+
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenTimeout      = 30 * time.Second
+	defaultBreakerSnapshotInterval = 5 * time.Minute
+
+	defaultCacheSize        = 10000
+	defaultCacheNegativeTTL = 30 * time.Second
+
+	defaultBloomFalsePositiveRate = 0.01
+	defaultBloomRebuildInterval   = time.Hour
+
+	defaultUnlockTTL           = 10 * time.Minute
+	defaultPasswordMaxAttempts = 5
+	defaultPasswordLockout     = 15 * time.Minute
+
+	defaultRedirectStatus          = 307
+	defaultPermanentRedirectMaxAge = 24 * time.Hour
+
+	defaultQueryConflictPolicy = "keep"
+
+	defaultUnfurlTimeout  = 5 * time.Second
+	defaultUnfurlMaxBytes = 1 << 20
+	defaultUnfurlWorkers  = 4
+
+	defaultLinkCheckInterval         = time.Hour
+	defaultLinkCheckTimeout          = 10 * time.Second
+	defaultLinkCheckConcurrency      = 8
+	defaultLinkCheckHostInterval     = time.Second
+	defaultLinkCheckFailureThreshold = 3
+
+	defaultStreamMaxItems = 100000
+
+	defaultWebhookTimeout      = 10 * time.Second
+	defaultWebhookWorkers      = 4
+	defaultWebhookPollInterval = time.Second
+	defaultWebhookMaxAttempts  = 10
+	defaultWebhookRetryBackoff = 30 * time.Second
+	defaultWebhookMaxBackoff   = 6 * time.Hour
 )
 
 type Config struct {
@@ -25,6 +77,110 @@ type Config struct {
 	FileStoragePath string
 	// DSN подключения к бд
 	DatabaseDSN string
+	// Размер пачки, начиная с которого записи сохраняются в бд через COPY
+	DatabaseCopyThreshold int
+	// Максимальное количество открытых соединений с бд, 0 - без ограничения
+	DatabaseMaxOpenConns int
+	// Максимальное количество простаивающих соединений с бд
+	DatabaseMaxIdleConns int
+	// Максимальное время жизни соединения с бд
+	DatabaseConnMaxLifetime time.Duration
+	// Максимальное время простоя соединения с бд
+	DatabaseConnMaxIdleTime time.Duration
+	// Таймаут выполнения запроса в бд, 0 - значение по умолчанию сервера бд
+	DatabaseStatementTimeout time.Duration
+	// Количество попыток подключения к бд при старте
+	DatabaseConnectAttempts int
+	// Начальная пауза между попытками подключения к бд
+	DatabaseConnectBackoff time.Duration
+	// DSN реплик бд для чтения
+	DatabaseReplicaDSNs []string
+	// Период проверки доступности реплик бд
+	DatabaseReplicaCheckInterval time.Duration
+	// Время после записи, в течение которого запись читается из основной бд, а не из реплик
+	DatabaseReadYourWritesWindow time.Duration
+	// Включение circuit breaker и отдачи сокращённых URL из локального снимка при недоступности бд
+	BreakerEnabled bool
+	// Количество ошибок бд подряд, после которого breaker размыкается
+	BreakerFailureThreshold int
+	// Время в разомкнутом состоянии до пробного запроса в бд
+	BreakerOpenTimeout time.Duration
+	// Период обновления локального снимка сокращённых URL
+	BreakerSnapshotInterval time.Duration
+	// Включение кеширования чтения сокращённых URL из хранилища
+	CacheEnabled bool
+	// Максимальное количество записей в кеше
+	CacheSize int
+	// Время жизни записи в кеше, 0 - без ограничения
+	CacheTTL time.Duration
+	// Время жизни в кеше записи о несуществующем URL, 0 - не кешировать
+	CacheNegativeTTL time.Duration
+	// Включение фильтра Блума существующих сокращённых URL. Фильтр знает только URL, сохранённые этим
+	// экземпляром, поэтому с бд он включается лишь вместе с BloomSingleInstance
+	BloomEnabled bool
+	// Подтверждение, что в бд пишет только этот экземпляр сервиса
+	BloomSingleInstance bool
+	// Допустимая доля ложноположительных ответов фильтра Блума
+	BloomFalsePositiveRate float64
+	// Период перестроения фильтра Блума, 0 - не перестраивать
+	BloomRebuildInterval time.Duration
+	// Секрет подписи токенов пользователей, пустой - генерируется при старте
+	AuthSecretKey string
+	// Время, на которое ссылка с паролем остаётся открытой после ввода верного пароля
+	UnlockTTL time.Duration
+	// Количество неверных паролей, после которого попытки временно блокируются
+	PasswordMaxAttempts int
+	// Время блокировки попыток ввода пароля
+	PasswordLockout time.Duration
+	// Подсети обратных прокси, которым доверяется X-Forwarded-For; пустой список - адрес клиента
+	// берётся из соединения, а заголовок игнорируется, чтобы его подменой нельзя было обойти ограничения
+	TrustedProxies []string
+	// HTTP статус редиректа для ссылок без своего статуса: 301, 302, 307 или 308
+	RedirectStatus int
+	// Время кеширования постоянных редиректов (301, 308) в Cache-Control
+	PermanentRedirectMaxAge time.Duration
+	// Политика конфликта параметров запроса для ссылок без своей политики: keep, replace или append
+	QueryConflictPolicy string
+	// Путь к html/template странице ссылки вне окна работы, пустой - встроенная страница
+	UnavailablePagePath string
+	// Получение заголовка, описания и тегов OpenGraph страницы исходного URL после создания ссылки
+	UnfurlEnabled bool
+	// Время на получение страницы исходного URL
+	UnfurlTimeout time.Duration
+	// Максимальное количество байт, читаемых со страницы исходного URL
+	UnfurlMaxBytes int
+	// Количество одновременно получаемых страниц
+	UnfurlWorkers int
+	// Периодическая проверка доступности исходных URL
+	LinkCheckEnabled bool
+	// Интервал между проверками всех ссылок
+	LinkCheckInterval time.Duration
+	// Время на проверку одного исходного URL
+	LinkCheckTimeout time.Duration
+	// Количество одновременных проверок
+	LinkCheckConcurrency int
+	// Минимальный интервал между проверками ссылок одного хоста
+	LinkCheckHostInterval time.Duration
+	// Количество неудачных проверок подряд, после которого ссылка считается нерабочей
+	LinkCheckFailureThreshold int
+	// Максимальное количество ссылок в одном потоковом запросе сокращения
+	StreamMaxItems int
+	// Доставка событий ссылок подпискам пользователей
+	WebhooksEnabled bool
+	// Время на одну попытку доставки
+	WebhookTimeout time.Duration
+	// Количество одновременных доставок
+	WebhookWorkers int
+	// Интервал проверки очереди доставок
+	WebhookPollInterval time.Duration
+	// Количество попыток, после которого доставка считается неудавшейся
+	WebhookMaxAttempts int
+	// Задержка перед второй попыткой, каждая следующая задержка вдвое больше предыдущей
+	WebhookRetryBackoff time.Duration
+	// Максимальная задержка между попытками
+	WebhookMaxBackoff time.Duration
+	// Разрешить запросы к исходным URL и адресам подписок на локальных и частных адресах, только для разработки
+	AllowPrivateDestinations bool
 }
 
 func (cfg *Config) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
@@ -33,6 +189,57 @@ func (cfg *Config) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
 	encoder.AddString("log level", cfg.LogLevel)
 	encoder.AddString("storage file", cfg.FileStoragePath)
 	encoder.AddString("database dsn", cfg.DatabaseDSN)
+	encoder.AddInt("database copy threshold", cfg.DatabaseCopyThreshold)
+	encoder.AddInt("database max open conns", cfg.DatabaseMaxOpenConns)
+	encoder.AddInt("database max idle conns", cfg.DatabaseMaxIdleConns)
+	encoder.AddDuration("database conn max lifetime", cfg.DatabaseConnMaxLifetime)
+	encoder.AddDuration("database conn max idle time", cfg.DatabaseConnMaxIdleTime)
+	encoder.AddDuration("database statement timeout", cfg.DatabaseStatementTimeout)
+	encoder.AddInt("database connect attempts", cfg.DatabaseConnectAttempts)
+	encoder.AddDuration("database connect backoff", cfg.DatabaseConnectBackoff)
+	encoder.AddInt("database replicas", len(cfg.DatabaseReplicaDSNs))
+	encoder.AddDuration("database replica check interval", cfg.DatabaseReplicaCheckInterval)
+	encoder.AddDuration("database read your writes window", cfg.DatabaseReadYourWritesWindow)
+	encoder.AddBool("breaker enabled", cfg.BreakerEnabled)
+	encoder.AddInt("breaker failure threshold", cfg.BreakerFailureThreshold)
+	encoder.AddDuration("breaker open timeout", cfg.BreakerOpenTimeout)
+	encoder.AddDuration("breaker snapshot interval", cfg.BreakerSnapshotInterval)
+	encoder.AddBool("cache enabled", cfg.CacheEnabled)
+	encoder.AddInt("cache size", cfg.CacheSize)
+	encoder.AddDuration("cache ttl", cfg.CacheTTL)
+	encoder.AddDuration("cache negative ttl", cfg.CacheNegativeTTL)
+	encoder.AddBool("bloom enabled", cfg.BloomEnabled)
+	encoder.AddBool("bloom single instance", cfg.BloomSingleInstance)
+	encoder.AddFloat64("bloom false positive rate", cfg.BloomFalsePositiveRate)
+	encoder.AddDuration("bloom rebuild interval", cfg.BloomRebuildInterval)
+	encoder.AddBool("auth secret set", cfg.AuthSecretKey != "")
+	encoder.AddDuration("unlock ttl", cfg.UnlockTTL)
+	encoder.AddInt("password max attempts", cfg.PasswordMaxAttempts)
+	encoder.AddDuration("password lockout", cfg.PasswordLockout)
+	encoder.AddString("trusted proxies", strings.Join(cfg.TrustedProxies, ","))
+	encoder.AddInt("redirect status", cfg.RedirectStatus)
+	encoder.AddDuration("permanent redirect max age", cfg.PermanentRedirectMaxAge)
+	encoder.AddString("query conflict policy", cfg.QueryConflictPolicy)
+	encoder.AddString("unavailable page", cfg.UnavailablePagePath)
+	encoder.AddBool("unfurl enabled", cfg.UnfurlEnabled)
+	encoder.AddDuration("unfurl timeout", cfg.UnfurlTimeout)
+	encoder.AddInt("unfurl max bytes", cfg.UnfurlMaxBytes)
+	encoder.AddInt("unfurl workers", cfg.UnfurlWorkers)
+	encoder.AddBool("link check enabled", cfg.LinkCheckEnabled)
+	encoder.AddDuration("link check interval", cfg.LinkCheckInterval)
+	encoder.AddDuration("link check timeout", cfg.LinkCheckTimeout)
+	encoder.AddInt("link check concurrency", cfg.LinkCheckConcurrency)
+	encoder.AddDuration("link check host interval", cfg.LinkCheckHostInterval)
+	encoder.AddInt("link check failure threshold", cfg.LinkCheckFailureThreshold)
+	encoder.AddInt("stream max items", cfg.StreamMaxItems)
+	encoder.AddBool("webhooks enabled", cfg.WebhooksEnabled)
+	encoder.AddDuration("webhook timeout", cfg.WebhookTimeout)
+	encoder.AddInt("webhook workers", cfg.WebhookWorkers)
+	encoder.AddDuration("webhook poll interval", cfg.WebhookPollInterval)
+	encoder.AddInt("webhook max attempts", cfg.WebhookMaxAttempts)
+	encoder.AddDuration("webhook retry backoff", cfg.WebhookRetryBackoff)
+	encoder.AddDuration("webhook max backoff", cfg.WebhookMaxBackoff)
+	encoder.AddBool("allow private destinations", cfg.AllowPrivateDestinations)
 
 	return nil
 }
@@ -53,6 +260,57 @@ func (cfg *Config) ParseFlags() {
 	logLevel := flag.String("l", defaultLogLevel, "log level; example: -l error")
 	fileStoragePath := flag.String("f", defaultFileStoragePath, "file storage path; example: -f /home/pluhe7/file.json")
 	databaseDSN := flag.String("d", "", "data source name for db; example: -d host=host port=port user=myuser password=xxxx dbname=mydb sslmode=disable")
+	databaseCopyThreshold := flag.Int("db-copy-threshold", defaultCopyThreshold, "batch size from which records are saved to db with copy; example: -db-copy-threshold 500")
+	databaseMaxOpenConns := flag.Int("db-max-open-conns", defaultDatabaseMaxOpenConns, "max open db connections, 0 is unlimited; example: -db-max-open-conns 50")
+	databaseMaxIdleConns := flag.Int("db-max-idle-conns", defaultDatabaseMaxIdleConns, "max idle db connections; example: -db-max-idle-conns 10")
+	databaseConnMaxLifetime := flag.Duration("db-conn-max-lifetime", defaultDatabaseConnMaxLifetime, "max db connection lifetime, 0 is unlimited; example: -db-conn-max-lifetime 1h")
+	databaseConnMaxIdleTime := flag.Duration("db-conn-max-idle-time", defaultDatabaseConnMaxIdleTime, "max db connection idle time, 0 is unlimited; example: -db-conn-max-idle-time 10m")
+	databaseStatementTimeout := flag.Duration("db-statement-timeout", 0, "db statement timeout, 0 uses db server default; example: -db-statement-timeout 5s")
+	databaseConnectAttempts := flag.Int("db-connect-attempts", defaultDatabaseConnectAttempts, "db connect attempts on startup; example: -db-connect-attempts 10")
+	databaseConnectBackoff := flag.Duration("db-connect-backoff", defaultDatabaseConnectBackoff, "initial pause between db connect attempts; example: -db-connect-backoff 1s")
+	databaseReplicaDSNs := flag.String("db-replicas", "", "comma separated read replica dsns; example: -db-replicas postgres://replica1/db,postgres://replica2/db")
+	databaseReplicaCheckInterval := flag.Duration("db-replica-check-interval", defaultReplicaCheckInterval, "read replica health check interval; example: -db-replica-check-interval 10s")
+	databaseReadYourWritesWindow := flag.Duration("db-read-your-writes-window", defaultReadYourWritesWindow, "time after write when record is read from primary db; example: -db-read-your-writes-window 5s")
+	breakerEnabled := flag.Bool("breaker", true, "enable db circuit breaker with snapshot fallback; example: -breaker=false")
+	breakerFailureThreshold := flag.Int("breaker-failures", defaultBreakerFailureThreshold, "consecutive db failures to open circuit breaker; example: -breaker-failures 3")
+	breakerOpenTimeout := flag.Duration("breaker-open-timeout", defaultBreakerOpenTimeout, "time before trial db request when circuit breaker is open; example: -breaker-open-timeout 10s")
+	breakerSnapshotInterval := flag.Duration("breaker-snapshot-interval", defaultBreakerSnapshotInterval, "local short urls snapshot refresh interval; example: -breaker-snapshot-interval 1m")
+	cacheEnabled := flag.Bool("cache", false, "enable storage read cache; example: -cache")
+	cacheSize := flag.Int("cache-size", defaultCacheSize, "max storage cache entries; example: -cache-size 10000")
+	cacheTTL := flag.Duration("cache-ttl", 0, "storage cache entry ttl, 0 disables expiration; example: -cache-ttl 10m")
+	cacheNegativeTTL := flag.Duration("cache-negative-ttl", defaultCacheNegativeTTL, "storage cache ttl for not found urls, 0 disables negative caching; example: -cache-negative-ttl 30s")
+	bloomEnabled := flag.Bool("bloom", false, "enable bloom filter of existing short urls; example: -bloom")
+	bloomSingleInstance := flag.Bool("bloom-single-instance", false, "confirm that no other instance writes to the db, required for bloom filter with db; example: -bloom-single-instance")
+	bloomFalsePositiveRate := flag.Float64("bloom-fp-rate", defaultBloomFalsePositiveRate, "bloom filter false positive rate; example: -bloom-fp-rate 0.001")
+	bloomRebuildInterval := flag.Duration("bloom-rebuild-interval", defaultBloomRebuildInterval, "bloom filter rebuild interval, 0 disables rebuild; example: -bloom-rebuild-interval 30m")
+	unlockTTL := flag.Duration("unlock-ttl", defaultUnlockTTL, "time protected link stays unlocked after correct password; example: -unlock-ttl 5m")
+	passwordMaxAttempts := flag.Int("password-max-attempts", defaultPasswordMaxAttempts, "wrong passwords before attempts are locked out; example: -password-max-attempts 3")
+	passwordLockout := flag.Duration("password-lockout", defaultPasswordLockout, "password attempts lockout time; example: -password-lockout 1h")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated cidrs of reverse proxies trusted to set X-Forwarded-For; example: -trusted-proxies 10.0.0.0/8,192.168.1.10/32")
+	redirectStatus := flag.Int("redirect-status", defaultRedirectStatus, "default redirect status, one of 301, 302, 307, 308; example: -redirect-status 301")
+	permanentRedirectMaxAge := flag.Duration("permanent-redirect-max-age", defaultPermanentRedirectMaxAge, "cache max age of permanent redirects; example: -permanent-redirect-max-age 168h")
+	unavailablePagePath := flag.String("unavailable-page", "", "html template of page for links outside their schedule window; example: -unavailable-page /etc/shortener/unavailable.html")
+	queryConflictPolicy := flag.String("query-conflict-policy", defaultQueryConflictPolicy, "query parameter conflict policy for passthrough links, one of keep, replace, append; example: -query-conflict-policy replace")
+	unfurlEnabled := flag.Bool("unfurl", true, "fetch title, description and opengraph tags of destination pages; example: -unfurl=false")
+	unfurlTimeout := flag.Duration("unfurl-timeout", defaultUnfurlTimeout, "destination page fetch timeout; example: -unfurl-timeout 3s")
+	unfurlMaxBytes := flag.Int("unfurl-max-bytes", defaultUnfurlMaxBytes, "max bytes read from destination page; example: -unfurl-max-bytes 262144")
+	unfurlWorkers := flag.Int("unfurl-workers", defaultUnfurlWorkers, "concurrent destination page fetches; example: -unfurl-workers 8")
+	linkCheckEnabled := flag.Bool("link-check", true, "periodically check that destinations are reachable; example: -link-check=false")
+	linkCheckInterval := flag.Duration("link-check-interval", defaultLinkCheckInterval, "interval between destination checks; example: -link-check-interval 6h")
+	linkCheckTimeout := flag.Duration("link-check-timeout", defaultLinkCheckTimeout, "single destination check timeout; example: -link-check-timeout 5s")
+	linkCheckConcurrency := flag.Int("link-check-concurrency", defaultLinkCheckConcurrency, "concurrent destination checks; example: -link-check-concurrency 16")
+	linkCheckHostInterval := flag.Duration("link-check-host-interval", defaultLinkCheckHostInterval, "min interval between checks of the same host; example: -link-check-host-interval 5s")
+	linkCheckFailureThreshold := flag.Int("link-check-failure-threshold", defaultLinkCheckFailureThreshold, "consecutive failed checks after which a link is broken; example: -link-check-failure-threshold 5")
+	streamMaxItems := flag.Int("stream-max-items", defaultStreamMaxItems, "max urls in one streaming shorten request; example: -stream-max-items 10000")
+	webhooksEnabled := flag.Bool("webhooks", true, "deliver link events to user webhooks; example: -webhooks=false")
+	webhookTimeout := flag.Duration("webhook-timeout", defaultWebhookTimeout, "single webhook delivery attempt timeout; example: -webhook-timeout 5s")
+	webhookWorkers := flag.Int("webhook-workers", defaultWebhookWorkers, "concurrent webhook deliveries; example: -webhook-workers 8")
+	webhookPollInterval := flag.Duration("webhook-poll-interval", defaultWebhookPollInterval, "interval between webhook delivery queue polls; example: -webhook-poll-interval 5s")
+	webhookMaxAttempts := flag.Int("webhook-max-attempts", defaultWebhookMaxAttempts, "delivery attempts before a webhook delivery fails; example: -webhook-max-attempts 5")
+	webhookRetryBackoff := flag.Duration("webhook-retry-backoff", defaultWebhookRetryBackoff, "delay before the first webhook retry, doubled for each next one; example: -webhook-retry-backoff 1m")
+	webhookMaxBackoff := flag.Duration("webhook-max-backoff", defaultWebhookMaxBackoff, "max delay between webhook retries; example: -webhook-max-backoff 1h")
+	allowPrivateDestinations := flag.Bool("allow-private-destinations", false, "allow requests to destinations and webhooks on private addresses, for development only; example: -allow-private-destinations")
+	authSecretKey := flag.String("auth-secret", "", "secret for signing user auth tokens, random if empty; example: -auth-secret mysecret")
 
 	flag.Parse()
 
@@ -61,6 +319,57 @@ func (cfg *Config) ParseFlags() {
 	cfg.LogLevel = *logLevel
 	cfg.FileStoragePath = *fileStoragePath
 	cfg.DatabaseDSN = *databaseDSN
+	cfg.DatabaseCopyThreshold = *databaseCopyThreshold
+	cfg.DatabaseMaxOpenConns = *databaseMaxOpenConns
+	cfg.DatabaseMaxIdleConns = *databaseMaxIdleConns
+	cfg.DatabaseConnMaxLifetime = *databaseConnMaxLifetime
+	cfg.DatabaseConnMaxIdleTime = *databaseConnMaxIdleTime
+	cfg.DatabaseStatementTimeout = *databaseStatementTimeout
+	cfg.DatabaseConnectAttempts = *databaseConnectAttempts
+	cfg.DatabaseConnectBackoff = *databaseConnectBackoff
+	cfg.DatabaseReplicaDSNs = splitList(*databaseReplicaDSNs)
+	cfg.DatabaseReplicaCheckInterval = *databaseReplicaCheckInterval
+	cfg.DatabaseReadYourWritesWindow = *databaseReadYourWritesWindow
+	cfg.BreakerEnabled = *breakerEnabled
+	cfg.BreakerFailureThreshold = *breakerFailureThreshold
+	cfg.BreakerOpenTimeout = *breakerOpenTimeout
+	cfg.BreakerSnapshotInterval = *breakerSnapshotInterval
+	cfg.CacheEnabled = *cacheEnabled
+	cfg.CacheSize = *cacheSize
+	cfg.CacheTTL = *cacheTTL
+	cfg.CacheNegativeTTL = *cacheNegativeTTL
+	cfg.BloomEnabled = *bloomEnabled
+	cfg.BloomSingleInstance = *bloomSingleInstance
+	cfg.BloomFalsePositiveRate = *bloomFalsePositiveRate
+	cfg.BloomRebuildInterval = *bloomRebuildInterval
+	cfg.AuthSecretKey = *authSecretKey
+	cfg.UnlockTTL = *unlockTTL
+	cfg.PasswordMaxAttempts = *passwordMaxAttempts
+	cfg.PasswordLockout = *passwordLockout
+	cfg.TrustedProxies = splitList(*trustedProxies)
+	cfg.RedirectStatus = *redirectStatus
+	cfg.PermanentRedirectMaxAge = *permanentRedirectMaxAge
+	cfg.QueryConflictPolicy = *queryConflictPolicy
+	cfg.UnavailablePagePath = *unavailablePagePath
+	cfg.UnfurlEnabled = *unfurlEnabled
+	cfg.UnfurlTimeout = *unfurlTimeout
+	cfg.UnfurlMaxBytes = *unfurlMaxBytes
+	cfg.UnfurlWorkers = *unfurlWorkers
+	cfg.LinkCheckEnabled = *linkCheckEnabled
+	cfg.LinkCheckInterval = *linkCheckInterval
+	cfg.LinkCheckTimeout = *linkCheckTimeout
+	cfg.LinkCheckConcurrency = *linkCheckConcurrency
+	cfg.LinkCheckHostInterval = *linkCheckHostInterval
+	cfg.LinkCheckFailureThreshold = *linkCheckFailureThreshold
+	cfg.StreamMaxItems = *streamMaxItems
+	cfg.WebhooksEnabled = *webhooksEnabled
+	cfg.WebhookTimeout = *webhookTimeout
+	cfg.WebhookWorkers = *webhookWorkers
+	cfg.WebhookPollInterval = *webhookPollInterval
+	cfg.WebhookMaxAttempts = *webhookMaxAttempts
+	cfg.WebhookRetryBackoff = *webhookRetryBackoff
+	cfg.WebhookMaxBackoff = *webhookMaxBackoff
+	cfg.AllowPrivateDestinations = *allowPrivateDestinations
 }
 
 func (cfg *Config) ParseEnv() {
@@ -83,6 +392,73 @@ func (cfg *Config) ParseEnv() {
 	if envDatabaseDSN, ok := os.LookupEnv("DATABASE_DSN"); ok {
 		cfg.DatabaseDSN = envDatabaseDSN
 	}
+
+	lookupEnvInt("DATABASE_COPY_THRESHOLD", &cfg.DatabaseCopyThreshold)
+	lookupEnvInt("DATABASE_MAX_OPEN_CONNS", &cfg.DatabaseMaxOpenConns)
+	lookupEnvInt("DATABASE_MAX_IDLE_CONNS", &cfg.DatabaseMaxIdleConns)
+	lookupEnvDuration("DATABASE_CONN_MAX_LIFETIME", &cfg.DatabaseConnMaxLifetime)
+	lookupEnvDuration("DATABASE_CONN_MAX_IDLE_TIME", &cfg.DatabaseConnMaxIdleTime)
+	lookupEnvDuration("DATABASE_STATEMENT_TIMEOUT", &cfg.DatabaseStatementTimeout)
+	lookupEnvInt("DATABASE_CONNECT_ATTEMPTS", &cfg.DatabaseConnectAttempts)
+	lookupEnvDuration("DATABASE_CONNECT_BACKOFF", &cfg.DatabaseConnectBackoff)
+	if envReplicaDSNs, ok := os.LookupEnv("DATABASE_REPLICA_DSNS"); ok {
+		cfg.DatabaseReplicaDSNs = splitList(envReplicaDSNs)
+	}
+	lookupEnvDuration("DATABASE_REPLICA_CHECK_INTERVAL", &cfg.DatabaseReplicaCheckInterval)
+	lookupEnvDuration("DATABASE_READ_YOUR_WRITES_WINDOW", &cfg.DatabaseReadYourWritesWindow)
+	lookupEnvBool("BREAKER_ENABLED", &cfg.BreakerEnabled)
+	lookupEnvInt("BREAKER_FAILURE_THRESHOLD", &cfg.BreakerFailureThreshold)
+	lookupEnvDuration("BREAKER_OPEN_TIMEOUT", &cfg.BreakerOpenTimeout)
+	lookupEnvDuration("BREAKER_SNAPSHOT_INTERVAL", &cfg.BreakerSnapshotInterval)
+	lookupEnvBool("CACHE_ENABLED", &cfg.CacheEnabled)
+	lookupEnvInt("CACHE_SIZE", &cfg.CacheSize)
+	lookupEnvDuration("CACHE_TTL", &cfg.CacheTTL)
+	lookupEnvDuration("CACHE_NEGATIVE_TTL", &cfg.CacheNegativeTTL)
+	lookupEnvBool("BLOOM_ENABLED", &cfg.BloomEnabled)
+	lookupEnvBool("BLOOM_SINGLE_INSTANCE", &cfg.BloomSingleInstance)
+	lookupEnvFloat("BLOOM_FALSE_POSITIVE_RATE", &cfg.BloomFalsePositiveRate)
+	lookupEnvDuration("BLOOM_REBUILD_INTERVAL", &cfg.BloomRebuildInterval)
+
+	if envAuthSecretKey, ok := os.LookupEnv("AUTH_SECRET_KEY"); ok {
+		cfg.AuthSecretKey = envAuthSecretKey
+	}
+
+	lookupEnvDuration("UNLOCK_TTL", &cfg.UnlockTTL)
+	lookupEnvInt("PASSWORD_MAX_ATTEMPTS", &cfg.PasswordMaxAttempts)
+	lookupEnvDuration("PASSWORD_LOCKOUT", &cfg.PasswordLockout)
+	if envTrustedProxies, ok := os.LookupEnv("TRUSTED_PROXIES"); ok {
+		cfg.TrustedProxies = splitList(envTrustedProxies)
+	}
+	lookupEnvInt("REDIRECT_STATUS", &cfg.RedirectStatus)
+	lookupEnvDuration("PERMANENT_REDIRECT_MAX_AGE", &cfg.PermanentRedirectMaxAge)
+
+	if envQueryConflictPolicy, ok := os.LookupEnv("QUERY_CONFLICT_POLICY"); ok {
+		cfg.QueryConflictPolicy = envQueryConflictPolicy
+	}
+
+	if envUnavailablePagePath, ok := os.LookupEnv("UNAVAILABLE_PAGE"); ok {
+		cfg.UnavailablePagePath = envUnavailablePagePath
+	}
+
+	lookupEnvBool("UNFURL_ENABLED", &cfg.UnfurlEnabled)
+	lookupEnvDuration("UNFURL_TIMEOUT", &cfg.UnfurlTimeout)
+	lookupEnvInt("UNFURL_MAX_BYTES", &cfg.UnfurlMaxBytes)
+	lookupEnvInt("UNFURL_WORKERS", &cfg.UnfurlWorkers)
+	lookupEnvBool("LINK_CHECK_ENABLED", &cfg.LinkCheckEnabled)
+	lookupEnvDuration("LINK_CHECK_INTERVAL", &cfg.LinkCheckInterval)
+	lookupEnvDuration("LINK_CHECK_TIMEOUT", &cfg.LinkCheckTimeout)
+	lookupEnvInt("LINK_CHECK_CONCURRENCY", &cfg.LinkCheckConcurrency)
+	lookupEnvDuration("LINK_CHECK_HOST_INTERVAL", &cfg.LinkCheckHostInterval)
+	lookupEnvInt("LINK_CHECK_FAILURE_THRESHOLD", &cfg.LinkCheckFailureThreshold)
+	lookupEnvInt("STREAM_MAX_ITEMS", &cfg.StreamMaxItems)
+	lookupEnvBool("WEBHOOKS_ENABLED", &cfg.WebhooksEnabled)
+	lookupEnvDuration("WEBHOOK_TIMEOUT", &cfg.WebhookTimeout)
+	lookupEnvInt("WEBHOOK_WORKERS", &cfg.WebhookWorkers)
+	lookupEnvDuration("WEBHOOK_POLL_INTERVAL", &cfg.WebhookPollInterval)
+	lookupEnvInt("WEBHOOK_MAX_ATTEMPTS", &cfg.WebhookMaxAttempts)
+	lookupEnvDuration("WEBHOOK_RETRY_BACKOFF", &cfg.WebhookRetryBackoff)
+	lookupEnvDuration("WEBHOOK_MAX_BACKOFF", &cfg.WebhookMaxBackoff)
+	lookupEnvBool("ALLOW_PRIVATE_DESTINATIONS", &cfg.AllowPrivateDestinations)
 }
 
 func (cfg *Config) FillEmptyWithDefault() {
@@ -98,4 +474,166 @@ func (cfg *Config) FillEmptyWithDefault() {
 	if cfg.FileStoragePath == "" {
 		cfg.FileStoragePath = defaultFileStoragePath
 	}
+	if cfg.DatabaseCopyThreshold <= 0 {
+		cfg.DatabaseCopyThreshold = defaultCopyThreshold
+	}
+	if cfg.BreakerFailureThreshold <= 0 {
+		cfg.BreakerFailureThreshold = defaultBreakerFailureThreshold
+	}
+	if cfg.BreakerOpenTimeout <= 0 {
+		cfg.BreakerOpenTimeout = defaultBreakerOpenTimeout
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = defaultCacheSize
+	}
+	// фильтр не видит URL, сохранённых другими экземплярами, и отвечал бы на них 404
+	if cfg.BloomEnabled && cfg.DatabaseDSN != "" && !cfg.BloomSingleInstance {
+		log.Printf("bloom filter is disabled: it is unsafe with a shared db, set bloom single instance if no other instance writes to it")
+		cfg.BloomEnabled = false
+	}
+	if cfg.BloomFalsePositiveRate <= 0 || cfg.BloomFalsePositiveRate >= 1 {
+		cfg.BloomFalsePositiveRate = defaultBloomFalsePositiveRate
+	}
+	if cfg.UnlockTTL <= 0 {
+		cfg.UnlockTTL = defaultUnlockTTL
+	}
+	if cfg.PasswordMaxAttempts <= 0 {
+		cfg.PasswordMaxAttempts = defaultPasswordMaxAttempts
+	}
+	if cfg.PasswordLockout <= 0 {
+		cfg.PasswordLockout = defaultPasswordLockout
+	}
+	switch cfg.RedirectStatus {
+	case 301, 302, 307, 308:
+	default:
+		log.Printf("unsupported redirect status %d, using %d", cfg.RedirectStatus, defaultRedirectStatus)
+		cfg.RedirectStatus = defaultRedirectStatus
+	}
+	if cfg.PermanentRedirectMaxAge < 0 {
+		cfg.PermanentRedirectMaxAge = defaultPermanentRedirectMaxAge
+	}
+	switch cfg.QueryConflictPolicy {
+	case "keep", "replace", "append":
+	default:
+		log.Printf("unsupported query conflict policy %q, using %q", cfg.QueryConflictPolicy, defaultQueryConflictPolicy)
+		cfg.QueryConflictPolicy = defaultQueryConflictPolicy
+	}
+	if cfg.UnfurlTimeout <= 0 {
+		cfg.UnfurlTimeout = defaultUnfurlTimeout
+	}
+	if cfg.UnfurlMaxBytes <= 0 {
+		cfg.UnfurlMaxBytes = defaultUnfurlMaxBytes
+	}
+	if cfg.UnfurlWorkers <= 0 {
+		cfg.UnfurlWorkers = defaultUnfurlWorkers
+	}
+	if cfg.LinkCheckInterval <= 0 {
+		cfg.LinkCheckInterval = defaultLinkCheckInterval
+	}
+	if cfg.LinkCheckTimeout <= 0 {
+		cfg.LinkCheckTimeout = defaultLinkCheckTimeout
+	}
+	if cfg.LinkCheckConcurrency <= 0 {
+		cfg.LinkCheckConcurrency = defaultLinkCheckConcurrency
+	}
+	if cfg.LinkCheckHostInterval < 0 {
+		cfg.LinkCheckHostInterval = defaultLinkCheckHostInterval
+	}
+	if cfg.LinkCheckFailureThreshold <= 0 {
+		cfg.LinkCheckFailureThreshold = defaultLinkCheckFailureThreshold
+	}
+	if cfg.StreamMaxItems <= 0 {
+		cfg.StreamMaxItems = defaultStreamMaxItems
+	}
+	if cfg.WebhookTimeout <= 0 {
+		cfg.WebhookTimeout = defaultWebhookTimeout
+	}
+	if cfg.WebhookWorkers <= 0 {
+		cfg.WebhookWorkers = defaultWebhookWorkers
+	}
+	if cfg.WebhookPollInterval <= 0 {
+		cfg.WebhookPollInterval = defaultWebhookPollInterval
+	}
+	if cfg.WebhookMaxAttempts <= 0 {
+		cfg.WebhookMaxAttempts = defaultWebhookMaxAttempts
+	}
+	if cfg.WebhookRetryBackoff <= 0 {
+		cfg.WebhookRetryBackoff = defaultWebhookRetryBackoff
+	}
+	if cfg.WebhookMaxBackoff < cfg.WebhookRetryBackoff {
+		cfg.WebhookMaxBackoff = max(defaultWebhookMaxBackoff, cfg.WebhookRetryBackoff)
+	}
+}
+
+func splitList(value string) []string {
+	var items []string
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func lookupEnvBool(name string, dst *bool) {
+	envValue, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	value, err := strconv.ParseBool(envValue)
+	if err != nil {
+		log.Printf("parse env %s: %v", name, err)
+		return
+	}
+
+	*dst = value
+}
+
+func lookupEnvInt(name string, dst *int) {
+	envValue, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	value, err := strconv.Atoi(envValue)
+	if err != nil {
+		log.Printf("parse env %s: %v", name, err)
+		return
+	}
+
+	*dst = value
+}
+
+func lookupEnvFloat(name string, dst *float64) {
+	envValue, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	value, err := strconv.ParseFloat(envValue, 64)
+	if err != nil {
+		log.Printf("parse env %s: %v", name, err)
+		return
+	}
+
+	*dst = value
+}
+
+func lookupEnvDuration(name string, dst *time.Duration) {
+	envValue, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	value, err := time.ParseDuration(envValue)
+	if err != nil {
+		log.Printf("parse env %s: %v", name, err)
+		return
+	}
+
+	*dst = value
 }
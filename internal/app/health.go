@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/pluhe7/shortener/internal/breaker"
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/storage"
+)
+
+func (s *Server) Health(ctx context.Context) models.HealthResponse {
+	resp := models.HealthResponse{
+		Status: models.HealthStatusOK,
+	}
+
+	start := time.Now()
+	err := s.Storage.PingContext(ctx)
+	resp.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+
+	if err != nil {
+		resp.Status = models.HealthStatusUnavailable
+		resp.Err = err
+	}
+
+	for st := s.Storage; st != nil; st = storage.Unwrap(st) {
+		switch typedStorage := st.(type) {
+		case *storage.DatabaseStorage:
+			dbStats := typedStorage.PoolStats()
+			resp.Pool = &models.PoolStats{
+				MaxOpenConnections: dbStats.MaxOpenConnections,
+				OpenConnections:    dbStats.OpenConnections,
+				InUse:              dbStats.InUse,
+				Idle:               dbStats.Idle,
+				WaitCount:          dbStats.WaitCount,
+				WaitDurationMs:     float64(dbStats.WaitDuration.Microseconds()) / 1000,
+				MaxIdleClosed:      dbStats.MaxIdleClosed,
+				MaxIdleTimeClosed:  dbStats.MaxIdleTimeClosed,
+				MaxLifetimeClosed:  dbStats.MaxLifetimeClosed,
+			}
+
+			for _, replica := range typedStorage.ReplicaStatuses() {
+				resp.Replicas = append(resp.Replicas, models.ReplicaHealth{
+					Name:    replica.Name,
+					Healthy: replica.Healthy,
+				})
+			}
+
+		case *storage.BreakerStorage:
+			breakerStatus := typedStorage.Status()
+			resp.Breaker = &models.BreakerHealth{
+				State:             breakerStatus.State,
+				Failures:          breakerStatus.Failures,
+				RetryAfterSeconds: breakerStatus.RetryAfter.Seconds(),
+				SnapshotSize:      breakerStatus.SnapshotSize,
+				SnapshotAt:        breakerStatus.SnapshotAt,
+			}
+
+			if resp.Status == models.HealthStatusOK && breakerStatus.State != breaker.StateClosed.String() {
+				resp.Status = models.HealthStatusDegraded
+			}
+
+		case *storage.CachedStorage:
+			cacheStats := typedStorage.Stats()
+			resp.Cache = &models.CacheStats{
+				Hits:         cacheStats.Hits,
+				Misses:       cacheStats.Misses,
+				NegativeHits: cacheStats.NegativeHits,
+				Evictions:    cacheStats.Evictions,
+				Size:         cacheStats.Size,
+			}
+		}
+	}
+
+	return resp
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/logger"
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+var (
+	ErrInvalidURL      = errors.New("url should be absolute http or https url")
+	ErrURLUnchanged    = errors.New("url is the same as current")
+	ErrInvalidRevision = errors.New("revision does not exist")
+)
+
+// UpdateURL меняет исходный URL своей неудалённой ссылки, изменение попадает в историю
+func (s *Server) UpdateURL(id, originalURL, userID string) (models.URLRevision, error) {
+	err := validateURL(originalURL)
+	if err != nil {
+		return models.URLRevision{}, err
+	}
+
+	record, err := s.getOwnedRecord(id, userID)
+	if err != nil {
+		return models.URLRevision{}, err
+	}
+
+	if record.IsDeleted {
+		return models.URLRevision{}, ErrURLDeleted
+	}
+
+	if record.OriginalURL == originalURL {
+		return models.URLRevision{}, ErrURLUnchanged
+	}
+
+	revision, err := s.Storage.Update(id, originalURL, userID)
+	if err != nil {
+		return models.URLRevision{}, fmt.Errorf("update in storage: %w", err)
+	}
+
+	s.scheduleUnfurl(id, originalURL)
+
+	// проверки относились к прежнему URL, новый считается рабочим до своей проверки
+	err = s.Storage.SetLinkCheck(id, nil)
+	if err != nil {
+		logger.Log.Warn("reset link check", zap.String("id", id), zap.Error(err))
+	}
+
+	return revision, nil
+}
+
+func (s *Server) URLHistory(id, userID string) ([]models.URLRevision, error) {
+	_, err := s.getOwnedRecord(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.Storage.History(id)
+	if err != nil {
+		return nil, fmt.Errorf("get history: %w", err)
+	}
+
+	return history, nil
+}
+
+// RevertURL возвращает исходный URL, который был установлен ревизией revision;
+// ревизия 0 - исходный URL до первого изменения. Откат записывается в историю новой ревизией.
+func (s *Server) RevertURL(id string, revision int, userID string) (models.URLRevision, error) {
+	history, err := s.URLHistory(id, userID)
+	if err != nil {
+		return models.URLRevision{}, err
+	}
+
+	var originalURL string
+
+	switch {
+	case revision == 0 && len(history) > 0:
+		originalURL = history[0].PreviousURL
+	case revision > 0 && revision <= len(history):
+		originalURL = history[revision-1].OriginalURL
+	default:
+		return models.URLRevision{}, ErrInvalidRevision
+	}
+
+	return s.UpdateURL(id, originalURL, userID)
+}
+
+func validateURL(rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+
+	if (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return ErrInvalidURL
+	}
+
+	return nil
+}
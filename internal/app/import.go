@@ -0,0 +1,256 @@
+package app
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+// Количество строк импорта, сохраняемых одной пачкой
+const importChunkSize = 500
+
+var (
+	ErrInvalidNotAfter = errors.New("not_after should be RFC 3339 time or YYYY-MM-DD date")
+	ErrInvalidCSVRow   = errors.New("invalid csv row")
+)
+
+// ImportColumns - колонки CSV импорта; alias и not_after необязательны
+var ImportColumns = []string{"original_url", "alias", "not_after"}
+
+// ExportColumns - колонки CSV выгрузки; первые совпадают с ImportColumns, поэтому выгрузку можно импортировать
+var ExportColumns = []string{"original_url", "alias", "not_after", "short_url", "created_at"}
+
+type importRow struct {
+	line int
+	item models.OriginalURLWithID
+	err  error
+}
+
+// ImportURLs сокращает URL из CSV с колонками ImportColumns, первой строкой может быть заголовок.
+// Строки сохраняются пачками по importChunkSize, результаты каждой части
+// передаются в fn в порядке строк. Ошибка строки попадает в её результат, ошибка чтения r или fn прерывает импорт.
+func (s *Server) ImportURLs(ctx context.Context, r io.Reader, userID string, fn func(results []models.ImportResult) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	chunk := make([]importRow, 0, importChunkSize)
+	first := true
+
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+
+		results := s.importChunk(chunk, userID)
+		chunk = chunk[:0]
+
+		return fn(results)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			first = false
+			chunk = append(chunk, importRow{line: parseErr.StartLine, err: fmt.Errorf("%w: %v", ErrInvalidCSVRow, err)})
+		} else if err != nil {
+			return fmt.Errorf("read csv: %w", err)
+		} else {
+			line, _ := reader.FieldPos(0)
+
+			if first {
+				first = false
+				fields[0] = strings.TrimPrefix(fields[0], "\ufeff")
+
+				if strings.EqualFold(strings.TrimSpace(fields[0]), ImportColumns[0]) {
+					continue
+				}
+			}
+
+			chunk = append(chunk, parseImportRow(line, fields))
+		}
+
+		if len(chunk) == importChunkSize {
+			err = flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
+}
+
+func parseImportRow(line int, fields []string) importRow {
+	row := importRow{
+		line: line,
+		item: models.OriginalURLWithID{OriginalURL: strings.TrimSpace(fields[0])},
+	}
+
+	row.err = validateURL(row.item.OriginalURL)
+	if row.err != nil {
+		return row
+	}
+
+	if len(fields) > 1 {
+		row.item.Alias = strings.TrimSpace(fields[1])
+	}
+
+	if len(fields) > 2 && strings.TrimSpace(fields[2]) != "" {
+		notAfter, err := parseNotAfter(strings.TrimSpace(fields[2]))
+		if err != nil {
+			row.err = err
+			return row
+		}
+
+		row.item.NotAfter = &notAfter
+	}
+
+	return row
+}
+
+// parseNotAfter разбирает время RFC 3339 или дату, дата - это её начало в UTC
+func parseNotAfter(value string) (time.Time, error) {
+	notAfter, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return notAfter.UTC(), nil
+	}
+
+	notAfter, err = time.Parse(time.DateOnly, value)
+	if err == nil {
+		return notAfter, nil
+	}
+
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidNotAfter, value)
+}
+
+// importChunk сохраняет строки части одной пачкой, не проверяя их по отдельности в хранилище:
+// о существующих записях сообщает отчёт о конфликтах. Строка с уже сокращённым исходным URL
+// получает существующую ссылку, строка с занятым alias - ошибку.
+// Строка с исходным URL, уже встречавшимся в части, получает результат первой такой строки.
+func (s *Server) importChunk(rows []importRow, userID string) []models.ImportResult {
+	results := make([]models.ImportResult, len(rows))
+
+	fail := func(i int, err error) {
+		results[i].Status = models.ImportFailed
+		results[i].Err = err
+	}
+
+	var items []models.OriginalURLWithID
+	firstByOriginal := make(map[string]int)
+	duplicates := make(map[int]int)
+	aliases := make(map[string]bool)
+
+	for i, row := range rows {
+		results[i] = models.ImportResult{Line: row.line, OriginalURL: row.item.OriginalURL}
+
+		if row.err != nil {
+			fail(i, row.err)
+			continue
+		}
+
+		if first, ok := firstByOriginal[row.item.OriginalURL]; ok {
+			duplicates[i] = first
+			continue
+		}
+
+		if alias := row.item.Alias; alias != "" {
+			if aliases[alias] {
+				fail(i, fmt.Errorf("%w: %s", ErrAliasTaken, alias))
+				continue
+			}
+
+			err := validateAlias(alias)
+			if err != nil {
+				fail(i, err)
+				continue
+			}
+		}
+
+		firstByOriginal[row.item.OriginalURL] = i
+		if row.item.Alias != "" {
+			aliases[row.item.Alias] = true
+		}
+
+		item := row.item
+		item.CorrelationID = strconv.Itoa(i)
+		items = append(items, item)
+	}
+
+	if len(items) > 0 {
+		shortURLs, conflicts, err := s.batchShorten(items, userID)
+		if err != nil {
+			for _, item := range items {
+				i, _ := strconv.Atoi(item.CorrelationID)
+				fail(i, err)
+			}
+		} else {
+			for j, shortURL := range shortURLs {
+				i, _ := strconv.Atoi(shortURL.CorrelationID)
+
+				if conflictErr, ok := conflicts[j]; ok {
+					var duplicateErr *duplicateURLError
+					if errors.As(conflictErr, &duplicateErr) {
+						results[i].Status = models.ImportExists
+						results[i].ShortURL = s.Config.BaseURL + "/" + duplicateErr.shortID
+
+						continue
+					}
+
+					fail(i, conflictErr)
+					continue
+				}
+
+				results[i].Status = models.ImportCreated
+				results[i].ShortURL = shortURL.ShortURL
+			}
+		}
+	}
+
+	for i, first := range duplicates {
+		results[i].ShortURL = results[first].ShortURL
+		results[i].Err = results[first].Err
+
+		results[i].Status = results[first].Status
+		if results[i].Status == models.ImportCreated {
+			results[i].Status = models.ImportExists
+		}
+	}
+
+	return results
+}
+
+// ExportURLs передаёт в fn неудалённые ссылки пользователя по одной, не загружая их в память целиком
+func (s *Server) ExportURLs(ctx context.Context, userID string, fn func(url models.ExportedURL) error) error {
+	err := s.Storage.IterateByUser(ctx, userID, func(record models.ShortURLRecord) error {
+		return fn(models.ExportedURL{
+			OriginalURL: record.OriginalURL,
+			Alias:       record.ShortURL,
+			NotAfter:    record.NotAfter,
+			ShortURL:    s.Config.BaseURL + "/" + record.ShortURL,
+			CreatedAt:   record.CreatedAt,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("iterate user urls: %w", err)
+	}
+
+	return nil
+}
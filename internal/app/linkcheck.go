@@ -0,0 +1,203 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/linkcheck"
+	"github.com/pluhe7/shortener/internal/logger"
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+// linkChecker периодически проверяет доступность исходных URL всех ссылок
+type linkChecker struct {
+	checker *linkcheck.Checker
+
+	// Не даёт запустить проверку, пока не закончилась предыдущая
+	running sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (s *Server) newLinkChecker() *linkChecker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &linkChecker{
+		checker: linkcheck.New(linkcheck.Options{
+			Timeout:      s.Config.LinkCheckTimeout,
+			Concurrency:  s.Config.LinkCheckConcurrency,
+			HostInterval: s.Config.LinkCheckHostInterval,
+			AllowPrivate: s.Config.AllowPrivateDestinations,
+		}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (s *Server) startLinkChecker() {
+	c := s.linkChecker
+
+	c.wg.Add(1)
+
+	go func() {
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(s.Config.LinkCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				err := s.CheckLinks(c.ctx)
+				if err != nil && c.ctx.Err() == nil {
+					logger.Log.Error("check links", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// stopLinkChecker прерывает идущую проверку и ждёт её завершения
+func (s *Server) stopLinkChecker() {
+	s.linkChecker.cancel()
+	s.linkChecker.wg.Wait()
+}
+
+// CheckLinks проверяет исходные URL всех неудалённых ссылок и сохраняет результаты
+func (s *Server) CheckLinks(ctx context.Context) error {
+	c := s.linkChecker
+
+	c.running.Lock()
+	defer c.running.Unlock()
+
+	var targets []linkcheck.Target
+
+	err := s.Storage.Iterate(ctx, func(record models.ShortURLRecord) error {
+		if !record.IsDeleted {
+			targets = append(targets, linkcheck.Target{ID: record.ShortURL, URL: record.OriginalURL})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate urls: %w", err)
+	}
+
+	c.checker.CheckAll(ctx, targets, func(target linkcheck.Target, result linkcheck.Result) {
+		// прерванная проверка ничего не говорит о доступности URL
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.saveLinkCheck(target, result)
+	})
+
+	return ctx.Err()
+}
+
+func (s *Server) saveLinkCheck(target linkcheck.Target, result linkcheck.Result) {
+	record, err := s.Storage.Get(target.ID)
+	if err != nil {
+		logger.Log.Warn("get checked record", zap.String("id", target.ID), zap.Error(err))
+		return
+	}
+
+	// пока шла проверка, ссылку могли удалить или изменить исходный URL
+	if record.IsDeleted || record.OriginalURL != target.URL {
+		return
+	}
+
+	check := &models.LinkCheck{
+		Status:    result.Status,
+		CheckedAt: time.Now().UTC(),
+	}
+
+	if !result.OK() {
+		check.Error = result.Err.Error()
+		check.ConsecutiveFailures = 1
+
+		if record.LinkCheck != nil {
+			check.ConsecutiveFailures += record.LinkCheck.ConsecutiveFailures
+		}
+	}
+
+	err = s.Storage.SetLinkCheck(target.ID, check)
+	if err != nil {
+		logger.Log.Warn("save link check", zap.String("id", target.ID), zap.Error(err))
+	}
+}
+
+// isBroken сообщает, что исходный URL не отвечает уже LinkCheckFailureThreshold проверок подряд
+func (s *Server) isBroken(record models.ShortURLRecord) bool {
+	return record.LinkCheck != nil && record.LinkCheck.ConsecutiveFailures > 0 &&
+		record.LinkCheck.ConsecutiveFailures >= s.Config.LinkCheckFailureThreshold
+}
+
+// brokenFallback возвращает запасной URL ссылки, если он задан и исходный URL не отвечает
+func (s *Server) brokenFallback(record models.ShortURLRecord) (string, bool) {
+	if record.BrokenFallbackURL == "" || !s.isBroken(record) {
+		return "", false
+	}
+
+	return record.BrokenFallbackURL, true
+}
+
+// BrokenURLs возвращает неудалённые ссылки пользователя, исходные URL которых не отвечают
+func (s *Server) BrokenURLs(userID string) ([]models.BrokenURL, error) {
+	records, err := s.Storage.GetByUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user urls: %w", err)
+	}
+
+	urls := make([]models.BrokenURL, 0)
+
+	for _, record := range records {
+		if !s.isBroken(record) {
+			continue
+		}
+
+		urls = append(urls, models.BrokenURL{
+			ShortURL:          s.Config.BaseURL + "/" + record.ShortURL,
+			OriginalURL:       record.OriginalURL,
+			BrokenFallbackURL: record.BrokenFallbackURL,
+			LinkCheck:         *record.LinkCheck,
+		})
+	}
+
+	return urls, nil
+}
+
+// SetURLBrokenFallback задаёт своей неудалённой ссылке URL перехода на время, пока исходный URL не отвечает;
+// пустой URL отключает запасной переход
+func (s *Server) SetURLBrokenFallback(id, fallbackURL, userID string) error {
+	if fallbackURL != "" {
+		err := validateURL(fallbackURL)
+		if err != nil {
+			return fmt.Errorf("broken fallback url: %w", err)
+		}
+	}
+
+	record, err := s.getOwnedRecord(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if record.IsDeleted {
+		return ErrURLDeleted
+	}
+
+	err = s.Storage.SetBrokenFallback(id, fallbackURL)
+	if err != nil {
+		return fmt.Errorf("set broken fallback in storage: %w", err)
+	}
+
+	return nil
+}
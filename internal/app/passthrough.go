@@ -0,0 +1,136 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/storage"
+)
+
+// Политики конфликта параметра, который есть и в исходном URL, и в запросе перехода
+const (
+	QueryConflictKeep    = "keep"
+	QueryConflictReplace = "replace"
+	QueryConflictAppend  = "append"
+)
+
+// QRSubPath - подпуть сокращённой ссылки с её QR-кодом. Подпути, которые сервер обслуживает сам, зарезервированы:
+// они не передаются в исходный URL при PathPassthrough, путь с таким первым сегментом отклоняется.
+const QRSubPath = "qr"
+
+var reservedSubPaths = map[string]bool{
+	QRSubPath: true,
+}
+
+var (
+	ErrInvalidQueryConflictPolicy = errors.New("query conflict policy should be one of keep, replace, append")
+	ErrInvalidExtraPath           = errors.New("invalid extra path")
+)
+
+func IsValidQueryConflictPolicy(policy string) bool {
+	switch policy {
+	case QueryConflictKeep, QueryConflictReplace, QueryConflictAppend:
+		return true
+	}
+
+	return false
+}
+
+// buildRedirectURL добавляет к target - исходному URL или URL правила - параметры запроса и сегменты пути
+// перехода, если это разрешено ссылкой. Лишний путь у ссылки без PathPassthrough означает несуществующий URL.
+func (s *Server) buildRedirectURL(target string, record models.ShortURLRecord, req ExpandRequest) (string, error) {
+	if req.ExtraPath != "" && !record.PathPassthrough {
+		return "", storage.ErrURLNotFound
+	}
+
+	passQuery := record.QueryPassthrough && len(req.Query) > 0
+	if req.ExtraPath == "" && !passQuery {
+		return target, nil
+	}
+
+	destination, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("parse original url: %w", err)
+	}
+
+	if req.ExtraPath != "" {
+		err = appendPath(destination, req.ExtraPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if passQuery {
+		policy := record.QueryConflictPolicy
+		if policy == "" {
+			policy = s.Config.QueryConflictPolicy
+		}
+
+		destination.RawQuery = mergeQuery(destination.Query(), req.Query, policy).Encode()
+	}
+
+	return destination.String(), nil
+}
+
+// appendPath добавляет к пути destination экранированный путь extraPath. Каждый сегмент
+// раскодируется и кодируется заново, поэтому закодированный слеш остаётся частью сегмента,
+// а сегменты "." и ".." не дают выйти за пределы пути исходного URL. Зарезервированный первый сегмент
+// отклоняется, даже если запрос дошёл сюда, например HEAD /{id}/qr или /{id}/qr/page.
+func appendPath(destination *url.URL, extraPath string) error {
+	rawSegments := strings.Split(extraPath, "/")
+	segments := make([]string, 0, len(rawSegments))
+
+	for i, rawSegment := range rawSegments {
+		segment, err := url.PathUnescape(rawSegment)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidExtraPath, err)
+		}
+
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("%w: dot segments are not allowed", ErrInvalidExtraPath)
+		}
+
+		if i == 0 && reservedSubPaths[segment] {
+			return fmt.Errorf("%w: sub-path %s is reserved", ErrInvalidExtraPath, segment)
+		}
+
+		// пустые сегменты схлопываются, кроме завершающего - он сохраняет слеш в конце пути
+		if segment == "" && i != len(rawSegments)-1 {
+			continue
+		}
+
+		segments = append(segments, url.PathEscape(segment))
+	}
+
+	escapedPath := strings.TrimSuffix(destination.EscapedPath(), "/") + "/" + strings.Join(segments, "/")
+
+	path, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidExtraPath, err)
+	}
+
+	destination.Path = path
+	destination.RawPath = escapedPath
+
+	return nil
+}
+
+func mergeQuery(destination, incoming url.Values, policy string) url.Values {
+	for key, values := range incoming {
+		_, conflict := destination[key]
+
+		switch {
+		case !conflict:
+			destination[key] = values
+		case policy == QueryConflictReplace:
+			destination[key] = values
+		case policy == QueryConflictAppend:
+			destination[key] = append(destination[key], values...)
+		}
+	}
+
+	return destination
+}
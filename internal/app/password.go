@@ -0,0 +1,68 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordRequired = errors.New("url is protected by password")
+	ErrInvalidPassword  = errors.New("invalid password")
+	ErrWrongPassword    = errors.New("wrong password")
+	ErrTooManyAttempts  = errors.New("too many wrong password attempts")
+)
+
+// TooManyAttemptsError возвращается, пока попытки ввода пароля заблокированы
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *TooManyAttemptsError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// UnlockURL проверяет пароль ссылки и выбирает редирект так же, как ExpandURL для открытой ссылки.
+// Неверные попытки считаются по ссылке и клиенту, после серии неудач попытки временно блокируются.
+// Вне окна работы пароль не проверяется: переход всё равно не раскрывает исходный URL.
+func (s *Server) UnlockURL(req ExpandRequest, password, clientID string) (Redirect, error) {
+	record, err := s.getActiveRecord(req.ID)
+	if err != nil {
+		return Redirect{}, err
+	}
+
+	if record.PasswordHash != "" && checkSchedule(record) == nil {
+		attemptsKey := req.ID + "|" + clientID
+
+		if allowed, retryAfter := s.passwordLimiter.Allow(attemptsKey); !allowed {
+			return Redirect{}, &TooManyAttemptsError{RetryAfter: retryAfter}
+		}
+
+		err = bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(password))
+		if err != nil {
+			s.passwordLimiter.Failure(attemptsKey)
+			return Redirect{}, ErrWrongPassword
+		}
+
+		s.passwordLimiter.Reset(attemptsKey)
+	}
+
+	req.Unlocked = true
+
+	return s.expandRecord(record, req)
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPassword, err)
+	}
+
+	return string(hash), nil
+}
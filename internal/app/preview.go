@@ -0,0 +1,53 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+// PreviewURL возвращает сведения о ссылке, не учитывая переход
+func (s *Server) PreviewURL(id string, unlocked bool) (models.URLPreview, error) {
+	record, err := s.getActiveRecord(id)
+	if err != nil {
+		return models.URLPreview{}, err
+	}
+
+	clicks, err := s.Storage.ClickCount(id)
+	if err != nil {
+		return models.URLPreview{}, fmt.Errorf("get click count: %w", err)
+	}
+
+	preview := models.URLPreview{
+		ShortURL:  s.Config.BaseURL + "/" + id,
+		Clicks:    clicks,
+		Protected: record.PasswordHash != "",
+		MaxClicks: record.MaxClicks,
+		NotBefore: record.NotBefore,
+		NotAfter:  record.NotAfter,
+	}
+
+	if !record.CreatedAt.IsZero() {
+		preview.CreatedAt = &record.CreatedAt
+	}
+
+	// вне окна работы по расписанию превью не должно раскрывать, куда ведёт ссылка
+	if (!preview.Protected || unlocked) && record.MaxClicks == 0 && checkSchedule(record) == nil {
+		preview.OriginalURL = record.OriginalURL
+		preview.Metadata = record.Metadata
+
+		// сведения, сохранённые до появления MetadataUnavailable, могут содержать текст ошибки
+		if record.Metadata != nil && record.Metadata.Error != "" {
+			metadata := *record.Metadata
+			metadata.Error = MetadataUnavailable
+			preview.Metadata = &metadata
+		}
+
+		if parsedURL, err := url.Parse(record.OriginalURL); err == nil {
+			preview.Domain = parsedURL.Hostname()
+		}
+	}
+
+	return preview, nil
+}
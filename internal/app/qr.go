@@ -0,0 +1,134 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/pluhe7/shortener/internal/qr"
+)
+
+const (
+	QRFormatPNG = "png"
+	QRFormatSVG = "svg"
+
+	minQRSize = 32
+	// PNG рисуется попиксельно, поэтому сторона ограничена размером, которого хватает для печати
+	maxQRSize   = 1024
+	maxQRMargin = 32
+)
+
+var ErrInvalidQROptions = errors.New("invalid qr code options")
+
+// QROptions - параметры изображения QR-кода
+type QROptions struct {
+	// png или svg
+	Format string
+	// Сторона изображения в пикселях
+	Size int
+	// Уровень коррекции ошибок: L, M, Q или H
+	Level string
+	// Ширина светлого поля вокруг кода в модулях
+	Margin int
+}
+
+func DefaultQROptions() QROptions {
+	return QROptions{
+		Format: QRFormatPNG,
+		Size:   256,
+		Level:  "M",
+		Margin: 4,
+	}
+}
+
+type QRCode struct {
+	Data        []byte
+	ContentType string
+	// Хеш содержимого и параметров изображения, не меняется, пока не меняются BaseURL и параметры
+	ETag string
+	// Изображение с этим ETag уже есть у клиента, поэтому оно не рисовалось и Data пустые
+	NotModified bool
+}
+
+// URLQRCode рисует QR-код с полным сокращённым URL; переход при этом не учитывается.
+// ETag считается до рисования, и если notModified сообщает, что изображение уже есть у клиента, оно не рисуется.
+func (s *Server) URLQRCode(id string, options QROptions, notModified func(etag string) bool) (QRCode, error) {
+	level, err := validateQROptions(options)
+	if err != nil {
+		return QRCode{}, err
+	}
+
+	_, err = s.getActiveRecord(id)
+	if err != nil {
+		return QRCode{}, err
+	}
+
+	content := s.Config.BaseURL + "/" + id
+
+	result := QRCode{
+		ContentType: "image/png",
+		ETag:        qrETag(content, level, options),
+	}
+
+	if options.Format == QRFormatSVG {
+		result.ContentType = "image/svg+xml"
+	}
+
+	if notModified != nil && notModified(result.ETag) {
+		result.NotModified = true
+		return result, nil
+	}
+
+	code, err := qr.Encode(content, level)
+	if err != nil {
+		return QRCode{}, fmt.Errorf("encode qr code: %w", err)
+	}
+
+	switch options.Format {
+	case QRFormatSVG:
+		var buf bytes.Buffer
+
+		err = code.SVG(&buf, options.Size, options.Margin)
+		result.Data = buf.Bytes()
+
+	default:
+		result.Data, err = code.PNG(options.Size, options.Margin)
+	}
+	if err != nil {
+		return QRCode{}, fmt.Errorf("render qr code: %w", err)
+	}
+
+	return result, nil
+}
+
+// qrETag хеширует всё, от чего зависит изображение, с уровнем коррекции в одном написании,
+// чтобы ?level=q и ?level=Q давали один тег
+func qrETag(content string, level qr.Level, options QROptions) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%s\x00%d",
+		content, options.Format, options.Size, level, options.Margin)))
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func validateQROptions(options QROptions) (qr.Level, error) {
+	if options.Format != QRFormatPNG && options.Format != QRFormatSVG {
+		return 0, fmt.Errorf("%w: unknown format %q", ErrInvalidQROptions, options.Format)
+	}
+
+	if options.Size < minQRSize || options.Size > maxQRSize {
+		return 0, fmt.Errorf("%w: size should be between %d and %d", ErrInvalidQROptions, minQRSize, maxQRSize)
+	}
+
+	if options.Margin < 0 || options.Margin > maxQRMargin {
+		return 0, fmt.Errorf("%w: margin should be between 0 and %d", ErrInvalidQROptions, maxQRMargin)
+	}
+
+	level, err := qr.ParseLevel(options.Level)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidQROptions, err)
+	}
+
+	return level, nil
+}
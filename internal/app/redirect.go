@@ -0,0 +1,38 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+const defaultRedirectStatus = http.StatusTemporaryRedirect
+
+var ErrInvalidRedirectStatus = errors.New("redirect status should be one of 301, 302, 307, 308")
+
+func IsValidRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+
+	return false
+}
+
+func IsPermanentRedirect(status int) bool {
+	return status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+}
+
+// redirectStatus выбирает статус редиректа ссылки, затем сервера, затем 307
+func (s *Server) redirectStatus(record models.ShortURLRecord) int {
+	if record.RedirectStatus != 0 {
+		return record.RedirectStatus
+	}
+
+	if IsValidRedirectStatus(s.Config.RedirectStatus) {
+		return s.Config.RedirectStatus
+	}
+
+	return defaultRedirectStatus
+}
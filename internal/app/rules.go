@@ -0,0 +1,71 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/rules"
+)
+
+const maxRedirectRules = 20
+
+var ErrTooManyRules = errors.New("too many redirect rules")
+
+func (s *Server) URLRules(id, userID string) ([]models.RedirectRule, error) {
+	record, err := s.getOwnedRecord(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return record.Rules, nil
+}
+
+// SetURLRules заменяет правила перехода своей неудалённой ссылки; пустой список удаляет правила
+func (s *Server) SetURLRules(id string, redirectRules []models.RedirectRule, userID string) error {
+	if len(redirectRules) > maxRedirectRules {
+		return ErrTooManyRules
+	}
+
+	for i, rule := range redirectRules {
+		err := validateURL(rule.URL)
+		if err == nil {
+			err = rules.Validate(rule)
+		}
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+
+	record, err := s.getOwnedRecord(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if record.IsDeleted {
+		return ErrURLDeleted
+	}
+
+	if len(redirectRules) == 0 {
+		redirectRules = nil
+	}
+
+	err = s.Storage.SetRules(id, redirectRules)
+	if err != nil {
+		return fmt.Errorf("set rules in storage: %w", err)
+	}
+
+	return nil
+}
+
+// matchRule возвращает URL первого подходящего правила ссылки.
+// Правила хранятся в самой записи, поэтому их проверка не обращается к хранилищу.
+func matchRule(record models.ShortURLRecord, req ExpandRequest) (string, bool) {
+	return rules.Match(record.Rules, rules.Request{
+		UserAgent:      req.UserAgent,
+		AcceptLanguage: req.AcceptLanguage,
+		Query:          req.Query,
+		Now:            time.Now(),
+	})
+}
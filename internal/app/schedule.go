@@ -0,0 +1,138 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+// Состояния окна работы ссылки
+const (
+	ScheduleUpcoming = "upcoming"
+	ScheduleActive   = "active"
+	ScheduleExpired  = "expired"
+)
+
+var (
+	ErrURLNotYetAvailable = errors.New("url is not available yet")
+	ErrURLExpired         = errors.New("url has expired")
+	ErrInvalidSchedule    = errors.New("not_after should be later than not_before")
+	ErrInvalidStatus      = errors.New("status should be one of upcoming, active, expired")
+)
+
+// ScheduleError возвращается при переходе по ссылке вне окна работы без FallbackURL
+type ScheduleError struct {
+	Err       error
+	NotBefore *time.Time
+	NotAfter  *time.Time
+}
+
+func (e *ScheduleError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ScheduleError) Unwrap() error {
+	return e.Err
+}
+
+// SetURLSchedule заменяет окно работы своей неудалённой ссылки
+func (s *Server) SetURLSchedule(id string, schedule models.Schedule, userID string) error {
+	err := validateSchedule(schedule)
+	if err != nil {
+		return err
+	}
+
+	record, err := s.getOwnedRecord(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if record.IsDeleted {
+		return ErrURLDeleted
+	}
+
+	err = s.Storage.SetSchedule(id, schedule)
+	if err != nil {
+		return fmt.Errorf("set schedule in storage: %w", err)
+	}
+
+	return nil
+}
+
+// UserURLs возвращает ссылки пользователя; status оставляет только ссылки с таким состоянием окна работы
+func (s *Server) UserURLs(userID, status string) ([]models.UserURL, error) {
+	switch status {
+	case "", ScheduleUpcoming, ScheduleActive, ScheduleExpired:
+	default:
+		return nil, ErrInvalidStatus
+	}
+
+	records, err := s.Storage.GetByUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user urls: %w", err)
+	}
+
+	now := time.Now()
+	urls := make([]models.UserURL, 0, len(records))
+
+	for _, record := range records {
+		state := scheduleState(record.Schedule, now)
+		if status != "" && state != status {
+			continue
+		}
+
+		urls = append(urls, models.UserURL{
+			ShortURL:    s.Config.BaseURL + "/" + record.ShortURL,
+			OriginalURL: record.OriginalURL,
+			NotBefore:   record.NotBefore,
+			NotAfter:    record.NotAfter,
+			Status:      state,
+		})
+	}
+
+	return urls, nil
+}
+
+func validateSchedule(schedule models.Schedule) error {
+	if schedule.NotBefore != nil && schedule.NotAfter != nil && !schedule.NotAfter.After(*schedule.NotBefore) {
+		return ErrInvalidSchedule
+	}
+
+	if schedule.FallbackURL != "" {
+		err := validateURL(schedule.FallbackURL)
+		if err != nil {
+			return fmt.Errorf("fallback url: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func scheduleState(schedule models.Schedule, now time.Time) string {
+	switch {
+	case schedule.NotBefore != nil && now.Before(*schedule.NotBefore):
+		return ScheduleUpcoming
+	case schedule.NotAfter != nil && !now.Before(*schedule.NotAfter):
+		return ScheduleExpired
+	default:
+		return ScheduleActive
+	}
+}
+
+// checkSchedule возвращает ScheduleError, если ссылка сейчас вне окна работы
+func checkSchedule(record models.ShortURLRecord) error {
+	var err error
+
+	switch scheduleState(record.Schedule, time.Now()) {
+	case ScheduleUpcoming:
+		err = ErrURLNotYetAvailable
+	case ScheduleExpired:
+		err = ErrURLExpired
+	default:
+		return nil
+	}
+
+	return &ScheduleError{Err: err, NotBefore: record.NotBefore, NotAfter: record.NotAfter}
+}
@@ -1,39 +1,145 @@
 package app
 
 import (
+	"context"
 	"fmt"
+	"net"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 
 	"github.com/pluhe7/shortener/config"
+	"github.com/pluhe7/shortener/internal/auth"
 	"github.com/pluhe7/shortener/internal/logger"
 	"github.com/pluhe7/shortener/internal/storage"
+	"github.com/pluhe7/shortener/internal/throttle"
 )
 
 type Server struct {
 	Storage storage.Storage
 	Config  *config.Config
 	Echo    *echo.Echo
+	Auth    *auth.Authenticator
+
+	passwordLimiter *throttle.Limiter
+	unfurler        *unfurler
+	linkChecker     *linkChecker
+	webhooks        *webhookDispatcher
 }
 
 func NewServer(cfg *config.Config) *Server {
-	s, err := storage.NewStorage(cfg.FileStoragePath, cfg.DatabaseDSN)
+	s, err := storage.NewStorage(cfg.FileStoragePath, cfg.DatabaseDSN, storage.DatabaseOptions{
+		CopyThreshold:    cfg.DatabaseCopyThreshold,
+		MaxOpenConns:     cfg.DatabaseMaxOpenConns,
+		MaxIdleConns:     cfg.DatabaseMaxIdleConns,
+		ConnMaxLifetime:  cfg.DatabaseConnMaxLifetime,
+		ConnMaxIdleTime:  cfg.DatabaseConnMaxIdleTime,
+		StatementTimeout: cfg.DatabaseStatementTimeout,
+		ConnectAttempts:  cfg.DatabaseConnectAttempts,
+		ConnectBackoff:   cfg.DatabaseConnectBackoff,
+
+		ReplicaDSNs:          cfg.DatabaseReplicaDSNs,
+		ReplicaCheckInterval: cfg.DatabaseReplicaCheckInterval,
+		ReadYourWritesWindow: cfg.DatabaseReadYourWritesWindow,
+	})
 	if err != nil {
 		logger.Log.Fatal("create new storage", zap.Error(err))
 	}
 
+	if cfg.DatabaseDSN != "" && cfg.BreakerEnabled {
+		s = storage.NewBreakerStorage(s, storage.BreakerOptions{
+			FailureThreshold: cfg.BreakerFailureThreshold,
+			OpenTimeout:      cfg.BreakerOpenTimeout,
+			SnapshotInterval: cfg.BreakerSnapshotInterval,
+		})
+	}
+
+	if cfg.CacheEnabled {
+		s = storage.NewCachedStorage(s, storage.CacheOptions{
+			Size:        cfg.CacheSize,
+			TTL:         cfg.CacheTTL,
+			NegativeTTL: cfg.CacheNegativeTTL,
+		})
+	}
+
+	if cfg.BloomEnabled {
+		s, err = storage.NewBloomStorage(context.Background(), s, storage.BloomOptions{
+			FalsePositiveRate: cfg.BloomFalsePositiveRate,
+			RebuildInterval:   cfg.BloomRebuildInterval,
+		})
+		if err != nil {
+			logger.Log.Fatal("create bloom storage", zap.Error(err))
+		}
+	}
+
+	authSecretKey := cfg.AuthSecretKey
+	if authSecretKey == "" {
+		authSecretKey, err = auth.NewUserID()
+		if err != nil {
+			logger.Log.Fatal("generate auth secret", zap.Error(err))
+		}
+
+		logger.Log.Warn("auth secret is not set, user tokens will not survive restart")
+	}
+
 	e := echo.New()
+	e.IPExtractor = newIPExtractor(cfg.TrustedProxies)
 
 	server := &Server{
 		Storage: s,
 		Config:  cfg,
 		Echo:    e,
+		Auth:    auth.NewAuthenticator(authSecretKey),
+
+		passwordLimiter: throttle.New(throttle.Options{
+			MaxAttempts: cfg.PasswordMaxAttempts,
+			Lockout:     cfg.PasswordLockout,
+		}),
+	}
+
+	if cfg.UnfurlEnabled {
+		server.startUnfurler()
+	}
+
+	server.linkChecker = server.newLinkChecker()
+	if cfg.LinkCheckEnabled {
+		server.startLinkChecker()
+	}
+
+	server.webhooks = server.newWebhookDispatcher()
+	if cfg.WebhooksEnabled {
+		server.startWebhookDispatcher()
 	}
 
 	return server
 }
 
+// newIPExtractor определяет адрес клиента по соединению, а за доверенными прокси - по X-Forwarded-For.
+// Без него echo берёт адрес из заголовков запроса, и клиент может подменить его, например чтобы сбросить
+// счётчик неверных паролей.
+func newIPExtractor(trustedProxies []string) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+
+	for _, proxy := range trustedProxies {
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			logger.Log.Fatal("parse trusted proxy", zap.String("proxy", proxy), zap.Error(err))
+		}
+
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
 func (s *Server) Start() error {
 	logger.Log.Info("Starting server...", zap.Object("config", s.Config))
 
@@ -48,6 +154,9 @@ func (s *Server) Start() error {
 func (s *Server) Stop() {
 	logger.Log.Info("Stopping server...")
 
+	s.stopUnfurler()
+	s.stopLinkChecker()
+	s.stopWebhookDispatcher()
 	s.Storage.Close()
 
 	logger.Log.Info("Server stopped")
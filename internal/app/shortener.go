@@ -1,57 +1,364 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/logger"
 	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/storage"
 	"github.com/pluhe7/shortener/internal/util"
 )
 
-const idLen = 8
+const (
+	idLen = 8
+
+	minAliasLen = 4
+	maxAliasLen = 32
+)
+
+// reservedAliases совпадают с путями сервиса и не могут быть сокращёнными URL
+var reservedAliases = []string{"api", "assets", "ping"}
+
+var (
+	ErrEmptyURL   = errors.New("url shouldn't be empty")
+	ErrURLDeleted = errors.New("url is deleted")
+	ErrForbidden  = errors.New("url belongs to another user")
+	ErrInvalidID  = errors.New("invalid url id")
+
+	ErrInvalidMaxClicks = errors.New("max clicks should not be negative")
+
+	ErrInvalidAlias = errors.New("alias should be 4 to 32 latin letters, digits, '-' or '_'")
+	ErrAliasTaken   = errors.New("alias is already taken")
+)
+
+type ShortenOptions struct {
+	// Пароль, без которого ссылка не раскрывается; пустой - ссылка открыта
+	Password string
+	// HTTP статус редиректа, 0 - статус по умолчанию из конфигурации
+	RedirectStatus int
+	// Передавать параметры запроса перехода в исходный URL
+	QueryPassthrough bool
+	// Политика конфликта параметров, пустая - политика по умолчанию из конфигурации
+	QueryConflictPolicy string
+	// Добавлять к исходному URL сегменты пути после сокращённого URL
+	PathPassthrough bool
+	// Максимальное количество переходов, 0 - без ограничения
+	MaxClicks int
+	// Окно работы ссылки
+	Schedule models.Schedule
+	// URL перехода, пока исходный URL не отвечает
+	BrokenFallbackURL string
+}
 
-var ErrEmptyURL = errors.New("url shouldn't be empty")
+// ExpandRequest - параметры перехода по сокращённой ссылке
+type ExpandRequest struct {
+	ID string
+	// Ссылка с паролем уже открыта верным паролем
+	Unlocked bool
+	// Учитывать переход в статистике; HEAD-запросы не учитываются
+	CountClick bool
+	// Параметры запроса перехода
+	Query url.Values
+	// Экранированный путь после сокращённого URL, без начального слеша
+	ExtraPath string
+	// Заголовки User-Agent и Accept-Language для правил перехода
+	UserAgent      string
+	AcceptLanguage string
+	// Вариант распределения, закреплённый за посетителем
+	Variant string
+}
 
-func (s *Server) ShortenURL(originalURL string) (string, error) {
+type Redirect struct {
+	URL    string
+	Status int
+	// Выбранный вариант распределения, пустой, если распределение не применялось
+	Variant string
+	// Вариант нужно закрепить за посетителем
+	Sticky bool
+	// Редирект можно кешировать: он постоянный и не зависит от посетителя, времени и счётчика переходов
+	Cacheable bool
+}
+
+func (s *Server) ShortenURL(originalURL, userID string, options ShortenOptions) (string, error) {
 	if len(originalURL) < 1 {
 		return "", ErrEmptyURL
 	}
 
 	shortID := util.GetRandomString(idLen)
 
-	err := s.Storage.Save(models.ShortURLRecord{
+	record := models.ShortURLRecord{
 		ShortURL:    shortID,
-		OriginalURL: originalURL})
+		OriginalURL: originalURL,
+		UserID:      userID,
+		CreatedAt:   time.Now().UTC()}
+
+	if options.RedirectStatus != 0 {
+		if !IsValidRedirectStatus(options.RedirectStatus) {
+			return "", ErrInvalidRedirectStatus
+		}
+
+		record.RedirectStatus = options.RedirectStatus
+	}
+
+	if options.QueryConflictPolicy != "" && !IsValidQueryConflictPolicy(options.QueryConflictPolicy) {
+		return "", ErrInvalidQueryConflictPolicy
+	}
+
+	record.QueryPassthrough = options.QueryPassthrough
+	record.QueryConflictPolicy = options.QueryConflictPolicy
+	record.PathPassthrough = options.PathPassthrough
+
+	if options.MaxClicks < 0 {
+		return "", ErrInvalidMaxClicks
+	}
+
+	record.MaxClicks = options.MaxClicks
+
+	err := validateSchedule(options.Schedule)
+	if err != nil {
+		return "", err
+	}
+
+	record.Schedule = options.Schedule
+
+	if options.BrokenFallbackURL != "" {
+		err = validateURL(options.BrokenFallbackURL)
+		if err != nil {
+			return "", fmt.Errorf("broken fallback url: %w", err)
+		}
+
+		record.BrokenFallbackURL = options.BrokenFallbackURL
+	}
+
+	if options.Password != "" {
+		passwordHash, err := hashPassword(options.Password)
+		if err != nil {
+			return "", err
+		}
+
+		record.PasswordHash = passwordHash
+	}
+
+	err = s.Storage.Save(record)
 	if err != nil {
 		return "", fmt.Errorf("save to storage: %w", err)
 	}
 
+	s.scheduleUnfurl(shortID, originalURL)
+
 	return s.Config.BaseURL + "/" + shortID, nil
 }
 
-func (s *Server) ExpandURL(id string) (string, error) {
-	if len([]rune(id)) != idLen {
-		return "", errors.New("invalid url id")
+// ExpandURL возвращает исходный URL и статус редиректа; для ссылки с паролем - только если она уже открыта
+func (s *Server) ExpandURL(req ExpandRequest) (Redirect, error) {
+	record, err := s.getActiveRecord(req.ID)
+	if err != nil {
+		return Redirect{}, err
+	}
+
+	return s.expandRecord(record, req)
+}
+
+// expandRecord выбирает редирект для уже загруженной записи
+func (s *Server) expandRecord(record models.ShortURLRecord, req ExpandRequest) (Redirect, error) {
+	err := checkSchedule(record)
+	if err != nil {
+		if record.FallbackURL == "" {
+			return Redirect{}, err
+		}
+
+		return Redirect{URL: record.FallbackURL, Status: defaultRedirectStatus}, nil
+	}
+
+	if record.PasswordHash != "" && !req.Unlocked {
+		return Redirect{}, ErrPasswordRequired
+	}
+
+	if fallbackURL, ok := s.brokenFallback(record); ok {
+		return Redirect{URL: fallbackURL, Status: defaultRedirectStatus}, nil
 	}
 
-	expandedURL, err := s.Storage.Get(id)
+	target, variant := redirectTarget(record, req)
+
+	redirectURL, err := s.buildRedirectURL(target, record, req)
 	if err != nil {
-		return "", err
+		return Redirect{}, err
+	}
+
+	err = s.countClick(record, variant, req.CountClick)
+	if err != nil {
+		return Redirect{}, err
+	}
+
+	status := s.redirectStatus(record)
+
+	return Redirect{
+		URL:       redirectURL,
+		Status:    status,
+		Variant:   variant,
+		Sticky:    variant != "" && record.Split.Sticky,
+		Cacheable: IsPermanentRedirect(status) && variant == "" && isCacheable(record),
+	}, nil
+}
+
+// isCacheable сообщает, что переход по записи всегда ведёт в одно место: браузер или прокси, закешировавшие
+// редирект, не должны пропускать пароль, окно работы, лимит переходов, правила или замену недоступного URL
+func isCacheable(record models.ShortURLRecord) bool {
+	return len(record.Rules) == 0 &&
+		record.MaxClicks == 0 &&
+		record.PasswordHash == "" &&
+		record.NotBefore == nil &&
+		record.NotAfter == nil &&
+		record.BrokenFallbackURL == ""
+}
+
+// countClick учитывает переход. Для обычной ссылки это статистика: ошибка учёта не должна мешать
+// редиректу, поэтому только логируется. Переход по ссылке с лимитом учитывается до редиректа и всегда,
+// даже для HEAD, ведь Location раскрывает исходный URL; исчерпанный лимит или ошибка хранилища не дают перейти.
+func (s *Server) countClick(record models.ShortURLRecord, variant string, countClick bool) error {
+	if record.MaxClicks == 0 {
+		if countClick {
+			s.recordClick(record.ShortURL, variant)
+		}
+
+		return nil
+	}
+
+	clicks, err := s.Storage.RecordClick(record.ShortURL, variant)
+	if err != nil {
+		return fmt.Errorf("record limited click: %w", err)
+	}
+
+	// количество берётся из RecordClick, а не из ClickCount: реплика может ещё не видеть этот переход
+	if record.UserID != "" && clicks >= int64(record.MaxClicks) {
+		s.queueExpiredEvent(record, "clicks", time.Now())
+	}
+
+	return nil
+}
+
+func (s *Server) recordClick(id, variant string) {
+	_, err := s.Storage.RecordClick(id, variant)
+	if err != nil {
+		logger.Log.Warn("record click", zap.String("id", id), zap.Error(err))
+	}
+}
+
+func (s *Server) getActiveRecord(id string) (models.ShortURLRecord, error) {
+	if !isValidID(id) {
+		return models.ShortURLRecord{}, ErrInvalidID
 	}
 
-	return expandedURL, nil
+	record, err := s.Storage.Get(id)
+	if err != nil {
+		return models.ShortURLRecord{}, err
+	}
+
+	if record.IsDeleted {
+		return models.ShortURLRecord{}, ErrURLDeleted
+	}
+
+	return record, nil
+}
+
+// DeleteURL помечает сокращённый URL удалённым, удалить можно только свой URL.
+// Повторное удаление не считается ошибкой.
+func (s *Server) DeleteURL(id, userID string) error {
+	record, err := s.getOwnedRecord(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if record.IsDeleted {
+		return nil
+	}
+
+	err = s.Storage.Delete(id)
+	if err != nil {
+		return fmt.Errorf("delete from storage: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) getOwnedRecord(id, userID string) (models.ShortURLRecord, error) {
+	record, err := s.Storage.Get(id)
+	if err != nil {
+		return models.ShortURLRecord{}, fmt.Errorf("get from storage: %w", err)
+	}
+
+	if record.UserID == "" || record.UserID != userID {
+		return models.ShortURLRecord{}, ErrForbidden
+	}
+
+	return record, nil
+}
+
+// BatchShortenURLs сокращает URL одним сохранением; ссылки с Alias получают его сокращённым URL
+func (s *Server) BatchShortenURLs(originalURLs []models.OriginalURLWithID, userID string) ([]models.ShortURLWithID, error) {
+	for _, original := range originalURLs {
+		if original.Alias == "" {
+			continue
+		}
+
+		err := s.checkAlias(original.Alias)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	shortURLs, conflicts, err := s.batchShorten(originalURLs, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conflicts) > 0 {
+		logger.Log.Warn("batch urls conflicted with existing urls", zap.Int("conflicted", len(conflicts)))
+	}
+
+	return shortURLs, nil
 }
 
-func (s *Server) BatchShortenURLs(originalURLs []models.OriginalURLWithID) ([]models.ShortURLWithID, error) {
+// batchShorten сохраняет ссылки одной пачкой и возвращает их в порядке исходных URL. Занятость alias
+// в хранилище не проверяется: ссылки, не сохранённые из-за конфликта с существующими записями,
+// получают в conflicts ошибку по своему индексу
+func (s *Server) batchShorten(originalURLs []models.OriginalURLWithID, userID string) ([]models.ShortURLWithID, map[int]error, error) {
 	records := make([]models.ShortURLRecord, 0, len(originalURLs))
 	shortURLs := make([]models.ShortURLWithID, 0, len(originalURLs))
+	createdAt := time.Now().UTC()
+	aliases := make(map[string]bool)
 
 	for _, original := range originalURLs {
-		shortID := util.GetRandomString(idLen)
+		shortID := original.Alias
+		if shortID == "" {
+			shortID = util.GetRandomString(idLen)
+		} else {
+			err := validateAlias(shortID)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if aliases[shortID] {
+				return nil, nil, fmt.Errorf("%w: %s", ErrAliasTaken, shortID)
+			}
+
+			aliases[shortID] = true
+		}
 
 		records = append(records, models.ShortURLRecord{
 			ShortURL:    shortID,
-			OriginalURL: original.OriginalURL})
+			OriginalURL: original.OriginalURL,
+			UserID:      userID,
+			CreatedAt:   createdAt,
+			Schedule:    models.Schedule{NotAfter: original.NotAfter}})
 
 		shortURLs = append(shortURLs, models.ShortURLWithID{
 			CorrelationID: original.CorrelationID,
@@ -59,12 +366,96 @@ func (s *Server) BatchShortenURLs(originalURLs []models.OriginalURLWithID) ([]mo
 		})
 	}
 
-	err := s.Storage.SaveBatch(records)
+	report, err := s.Storage.SaveBatchWithReport(context.Background(), records)
 	if err != nil {
-		return nil, fmt.Errorf("save batch: %w", err)
+		return nil, nil, fmt.Errorf("save batch: %w", err)
 	}
 
-	return shortURLs, nil
+	conflicted := make(map[string]bool, len(report.Conflicted))
+	for _, shortURL := range report.Conflicted {
+		conflicted[shortURL] = true
+	}
+
+	conflicts := make(map[int]error)
+
+	for i, record := range records {
+		if conflicted[record.ShortURL] {
+			conflicts[i] = s.conflictError(record)
+			continue
+		}
+
+		s.scheduleUnfurl(record.ShortURL, record.OriginalURL)
+	}
+
+	return shortURLs, conflicts, nil
+}
+
+// duplicateURLError - запись пачки не сохранена, потому что её исходный URL уже сокращён ссылкой shortID
+type duplicateURLError struct {
+	originalURL string
+	shortID     string
+}
+
+func (e *duplicateURLError) Error() string {
+	return fmt.Sprintf("%s: %s", storage.ErrDuplicateRecord, e.originalURL)
+}
+
+func (e *duplicateURLError) Is(target error) bool {
+	return target == storage.ErrDuplicateRecord
+}
+
+// conflictError объясняет, почему запись пачки не сохранена: её исходный URL уже сокращён,
+// возможно этой же ссылкой, или её сокращённый URL уже занят
+func (s *Server) conflictError(record models.ShortURLRecord) error {
+	shortID, err := s.Storage.GetByOriginal(record.OriginalURL)
+	if err == nil {
+		return &duplicateURLError{originalURL: record.OriginalURL, shortID: shortID}
+	}
+
+	return fmt.Errorf("%w: %s", ErrAliasTaken, record.ShortURL)
+}
+
+// validateAlias проверяет, что alias допустим, не обращаясь к хранилищу
+func validateAlias(alias string) error {
+	if !isValidID(alias) || slices.Contains(reservedAliases, strings.ToLower(alias)) {
+		return fmt.Errorf("%w: %s", ErrInvalidAlias, alias)
+	}
+
+	return nil
+}
+
+// checkAlias проверяет, что alias допустим и ещё не занят
+func (s *Server) checkAlias(alias string) error {
+	err := validateAlias(alias)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Storage.Get(alias)
+	if err == nil {
+		return fmt.Errorf("%w: %s", ErrAliasTaken, alias)
+	}
+
+	if !errors.Is(err, storage.ErrURLNotFound) {
+		return fmt.Errorf("get alias record: %w", err)
+	}
+
+	return nil
+}
+
+// isValidID сообщает, что id может быть сокращённым URL: случайным или собственным
+func isValidID(id string) bool {
+	if len(id) < minAliasLen || len(id) > maxAliasLen {
+		return false
+	}
+
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (s *Server) GetExistingShortURL(originalURL string) (string, error) {
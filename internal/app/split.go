@@ -0,0 +1,150 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+const (
+	minSplitTargets = 2
+	maxSplitTargets = 10
+	maxSplitWeight  = 10000
+)
+
+var ErrInvalidSplit = errors.New("invalid split")
+
+// SetURLSplit заменяет распределение переходов своей неудалённой ссылки; split без вариантов убирает распределение
+func (s *Server) SetURLSplit(id string, split models.Split, userID string) error {
+	err := validateSplit(split)
+	if err != nil {
+		return err
+	}
+
+	record, err := s.getOwnedRecord(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if record.IsDeleted {
+		return ErrURLDeleted
+	}
+
+	var stored *models.Split
+	if len(split.Targets) > 0 {
+		stored = &split
+	}
+
+	err = s.Storage.SetSplit(id, stored)
+	if err != nil {
+		return fmt.Errorf("set split in storage: %w", err)
+	}
+
+	return nil
+}
+
+// URLSplitStats возвращает варианты своей ссылки с количеством переходов по каждому
+func (s *Server) URLSplitStats(id, userID string) (models.SplitStats, error) {
+	record, err := s.getOwnedRecord(id, userID)
+	if err != nil {
+		return models.SplitStats{}, err
+	}
+
+	stats := models.SplitStats{
+		Targets: []models.SplitTargetStats{},
+	}
+
+	if record.Split == nil {
+		return stats, nil
+	}
+
+	clicks, err := s.Storage.VariantClicks(id)
+	if err != nil {
+		return models.SplitStats{}, fmt.Errorf("get variant clicks: %w", err)
+	}
+
+	stats.Sticky = record.Split.Sticky
+
+	for _, target := range record.Split.Targets {
+		stats.Targets = append(stats.Targets, models.SplitTargetStats{
+			URL:    target.URL,
+			Weight: target.Weight,
+			Clicks: clicks[target.URL],
+		})
+	}
+
+	return stats, nil
+}
+
+func validateSplit(split models.Split) error {
+	if len(split.Targets) == 0 {
+		return nil
+	}
+
+	if len(split.Targets) < minSplitTargets || len(split.Targets) > maxSplitTargets {
+		return fmt.Errorf("%w: split should have from %d to %d targets", ErrInvalidSplit, minSplitTargets, maxSplitTargets)
+	}
+
+	seen := make(map[string]bool, len(split.Targets))
+
+	for i, target := range split.Targets {
+		err := validateURL(target.URL)
+		if err != nil {
+			return fmt.Errorf("target %d: %w", i+1, err)
+		}
+
+		if target.Weight < 1 || target.Weight > maxSplitWeight {
+			return fmt.Errorf("%w: target %d weight should be from 1 to %d", ErrInvalidSplit, i+1, maxSplitWeight)
+		}
+
+		if seen[target.URL] {
+			return fmt.Errorf("%w: target %d url is duplicated", ErrInvalidSplit, i+1)
+		}
+		seen[target.URL] = true
+	}
+
+	return nil
+}
+
+// chooseVariant возвращает закреплённый за посетителем вариант, если он всё ещё есть среди вариантов,
+// иначе выбирает вариант случайно пропорционально весам
+func chooseVariant(split *models.Split, stickyVariant string) string {
+	totalWeight := 0
+
+	for _, target := range split.Targets {
+		if split.Sticky && target.URL == stickyVariant {
+			return target.URL
+		}
+
+		totalWeight += target.Weight
+	}
+
+	point := rand.Intn(totalWeight)
+
+	for _, target := range split.Targets {
+		if point < target.Weight {
+			return target.URL
+		}
+
+		point -= target.Weight
+	}
+
+	return split.Targets[len(split.Targets)-1].URL
+}
+
+// redirectTarget выбирает URL перехода: подходящее правило важнее распределения по вариантам,
+// без них переход ведёт на исходный URL. variant - выбранный вариант распределения, если оно применялось.
+func redirectTarget(record models.ShortURLRecord, req ExpandRequest) (target, variant string) {
+	if target, ok := matchRule(record, req); ok {
+		return target, ""
+	}
+
+	if record.Split != nil && len(record.Split.Targets) > 0 {
+		variant = chooseVariant(record.Split, req.Variant)
+		return variant, variant
+	}
+
+	return record.OriginalURL, ""
+}
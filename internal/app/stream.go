@@ -0,0 +1,191 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+const (
+	// Максимальное количество строк, сохраняемых одной пачкой
+	streamChunkSize = 100
+	// Максимальная длина строки потокового запроса
+	maxStreamLineSize = 64 << 10
+)
+
+var (
+	ErrTooManyItems = errors.New("too many items")
+	ErrLineTooLong  = errors.New("line is too long")
+	ErrInvalidItem  = errors.New("invalid item")
+)
+
+type streamItem struct {
+	line int
+	item models.OriginalURLWithID
+	err  error
+}
+
+// ShortenStream сокращает URL из NDJSON, каждая строка которого - models.OriginalURLWithID.
+// Строки сохраняются частями, каждая одной пачкой: часть сохраняется, когда в ней streamChunkSize строк
+// или когда прочитано всё, что уже пришло от клиента, и результаты её строк передаются в fn.
+// Следующие строки читаются только после возврата из fn, поэтому клиент, который не успевает читать ответ,
+// задерживает и чтение своего запроса. Строка сверх StreamMaxItems прерывает сокращение с ErrTooManyItems.
+func (s *Server) ShortenStream(ctx context.Context, r io.Reader, userID string, fn func(results []models.StreamShortenResult) error) error {
+	reader := bufio.NewReaderSize(r, maxStreamLineSize)
+	chunk := make([]streamItem, 0, streamChunkSize)
+
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+
+		results := s.shortenStreamChunk(chunk, userID)
+		chunk = chunk[:0]
+
+		return fn(results)
+	}
+
+	line, items := 0, 0
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, err := readStreamLine(reader)
+		if errors.Is(err, io.EOF) && len(data) == 0 {
+			break
+		}
+
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, ErrLineTooLong) {
+			return fmt.Errorf("read line: %w", err)
+		}
+
+		line++
+
+		if errors.Is(err, ErrLineTooLong) {
+			chunk = append(chunk, streamItem{line: line, err: err})
+		} else if len(bytes.TrimSpace(data)) > 0 {
+			items++
+			if items > s.Config.StreamMaxItems {
+				flushErr := flush()
+				if flushErr != nil {
+					return flushErr
+				}
+
+				return fmt.Errorf("%w: line %d exceeds max %d", ErrTooManyItems, line, s.Config.StreamMaxItems)
+			}
+
+			chunk = append(chunk, parseStreamItem(line, data))
+		}
+
+		if len(chunk) == streamChunkSize || reader.Buffered() == 0 {
+			flushErr := flush()
+			if flushErr != nil {
+				return flushErr
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	return flush()
+}
+
+// readStreamLine возвращает строку без перевода строки; строка длиннее буфера reader пропускается с ErrLineTooLong
+func readStreamLine(reader *bufio.Reader) ([]byte, error) {
+	data, err := reader.ReadSlice('\n')
+	if !errors.Is(err, bufio.ErrBufferFull) {
+		return bytes.TrimSuffix(data, []byte("\n")), err
+	}
+
+	for errors.Is(err, bufio.ErrBufferFull) {
+		_, err = reader.ReadSlice('\n')
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return nil, ErrLineTooLong
+}
+
+func parseStreamItem(line int, data []byte) streamItem {
+	item := streamItem{line: line}
+
+	err := json.Unmarshal(data, &item.item)
+	if err != nil {
+		item.err = fmt.Errorf("%w: %v", ErrInvalidItem, err)
+		return item
+	}
+
+	if item.item.OriginalURL == "" {
+		item.err = ErrEmptyURL
+	}
+
+	return item
+}
+
+// shortenStreamChunk сохраняет строки части без ошибок одной пачкой; ошибка сохранения попадает в результаты
+// всех этих строк, а строка, которую хранилище пропустило из-за конфликта, получает ошибку этого конфликта
+func (s *Server) shortenStreamChunk(chunk []streamItem, userID string) []models.StreamShortenResult {
+	results := make([]models.StreamShortenResult, len(chunk))
+
+	var items []models.OriginalURLWithID
+	var indexes []int
+	aliases := make(map[string]bool)
+
+	for i, item := range chunk {
+		results[i] = models.StreamShortenResult{Line: item.line, CorrelationID: item.item.CorrelationID}
+
+		err := item.err
+		if err == nil && item.item.Alias != "" {
+			if aliases[item.item.Alias] {
+				err = fmt.Errorf("%w: %s", ErrAliasTaken, item.item.Alias)
+			} else {
+				err = s.checkAlias(item.item.Alias)
+			}
+		}
+
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		if item.item.Alias != "" {
+			aliases[item.item.Alias] = true
+		}
+
+		items = append(items, item.item)
+		indexes = append(indexes, i)
+	}
+
+	if len(items) == 0 {
+		return results
+	}
+
+	// batchShorten возвращает ссылки в порядке исходных URL
+	shortURLs, conflicts, err := s.batchShorten(items, userID)
+	for j, i := range indexes {
+		rowErr := err
+		if rowErr == nil {
+			rowErr = conflicts[j]
+		}
+
+		if rowErr != nil {
+			results[i].Err = rowErr
+		} else {
+			results[i].ShortURL = shortURLs[j].ShortURL
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/logger"
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/unfurl"
+)
+
+// Размер очереди ссылок, ждущих получения страницы; при переполнении новые ссылки остаются без сведений
+const unfurlQueueSize = 1000
+
+// MetadataUnavailable - причина в сведениях о странице, которую не удалось получить. Сведения публичны,
+// поэтому настоящая ошибка, в которой могут быть адреса и ответы внутренней сети, только логируется.
+const MetadataUnavailable = "unavailable"
+
+type unfurlJob struct {
+	shortURL    string
+	originalURL string
+}
+
+// unfurler в фоне получает сведения о страницах исходных URL новых и изменённых ссылок
+type unfurler struct {
+	fetcher *unfurl.Fetcher
+	timeout time.Duration
+	jobs    chan unfurlJob
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (s *Server) startUnfurler() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	u := &unfurler{
+		fetcher: unfurl.NewFetcher(unfurl.Options{
+			Timeout:      s.Config.UnfurlTimeout,
+			MaxBytes:     int64(s.Config.UnfurlMaxBytes),
+			AllowPrivate: s.Config.AllowPrivateDestinations,
+		}),
+		timeout: s.Config.UnfurlTimeout,
+		jobs:    make(chan unfurlJob, unfurlQueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	s.unfurler = u
+
+	for i := 0; i < max(s.Config.UnfurlWorkers, 1); i++ {
+		u.wg.Add(1)
+
+		go func() {
+			defer u.wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-u.jobs:
+					s.unfurlURL(job)
+				}
+			}
+		}()
+	}
+}
+
+// stopUnfurler прерывает получение страниц и ждёт завершения обработчиков очереди
+func (s *Server) stopUnfurler() {
+	if s.unfurler == nil {
+		return
+	}
+
+	s.unfurler.cancel()
+	s.unfurler.wg.Wait()
+}
+
+// scheduleUnfurl ставит ссылку в очередь получения страницы, не дожидаясь результата
+func (s *Server) scheduleUnfurl(shortURL, originalURL string) {
+	if s.unfurler == nil {
+		return
+	}
+
+	select {
+	case s.unfurler.jobs <- unfurlJob{shortURL: shortURL, originalURL: originalURL}:
+	default:
+		logger.Log.Warn("unfurl queue is full, skipping", zap.String("id", shortURL))
+	}
+}
+
+func (s *Server) unfurlURL(job unfurlJob) {
+	ctx, cancel := context.WithTimeout(s.unfurler.ctx, s.unfurler.timeout)
+	defer cancel()
+
+	metadata, err := s.unfurler.fetcher.Fetch(ctx, job.originalURL)
+	if err != nil {
+		if s.unfurler.ctx.Err() != nil {
+			return
+		}
+
+		logger.Log.Info("unfurl destination", zap.String("id", job.shortURL), zap.Error(err))
+
+		metadata = models.URLMetadata{Error: MetadataUnavailable}
+	}
+
+	metadata.FetchedAt = time.Now().UTC()
+
+	// пока страница загружалась, исходный URL могли изменить, тогда сведения уже не о нём
+	record, err := s.Storage.Get(job.shortURL)
+	if err != nil {
+		logger.Log.Warn("get unfurled record", zap.String("id", job.shortURL), zap.Error(err))
+		return
+	}
+
+	if record.OriginalURL != job.originalURL {
+		return
+	}
+
+	err = s.Storage.SetMetadata(job.shortURL, metadata)
+	if err != nil {
+		logger.Log.Warn("save url metadata", zap.String("id", job.shortURL), zap.Error(err))
+	}
+}
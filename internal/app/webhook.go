@@ -0,0 +1,428 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/auth"
+	"github.com/pluhe7/shortener/internal/logger"
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/safehttp"
+	"github.com/pluhe7/shortener/internal/util"
+)
+
+// Заголовки запроса доставки события
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	// Подпись "sha256=<hex>" - HMAC-SHA256 секретом подписки от строки "<timestamp>.<тело запроса>"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	webhookIDLen      = 16
+	maxUserWebhooks   = 20
+	webhookLogLimit   = 100
+	webhookErrorLimit = 512
+
+	// Интервал поиска истёкших ссылок
+	expirySweepInterval = time.Minute
+	// Ссылки, окно работы которых закончилось раньше, уже не считаются истекающими:
+	// событие переживает перезапуск сервиса, но новые подписки не получают истечения старых ссылок
+	expiryLookback = 24 * time.Hour
+)
+
+var (
+	ErrInvalidWebhookEvents = errors.New("events should be link.created, link.updated, link.deleted or link.expired")
+	ErrTooManyWebhooks      = errors.New("too many webhooks")
+)
+
+var webhookEvents = []string{
+	models.EventLinkCreated,
+	models.EventLinkUpdated,
+	models.EventLinkDeleted,
+	models.EventLinkExpired,
+}
+
+// webhookPayload - тело запроса доставки события
+type webhookPayload struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Link       webhookLink `json:"link"`
+}
+
+type webhookLink struct {
+	ID          string `json:"id"`
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+}
+
+// webhookDispatcher в фоне доставляет события из очереди хранилища подпискам
+type webhookDispatcher struct {
+	client *http.Client
+
+	// Не даёт запустить доставку, пока не закончилась предыдущая
+	running sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (s *Server) newWebhookDispatcher() *webhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &webhookDispatcher{
+		client: safehttp.NewClient(s.Config.WebhookTimeout, s.Config.AllowPrivateDestinations),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (s *Server) startWebhookDispatcher() {
+	d := s.webhooks
+
+	d.wg.Add(1)
+
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(s.Config.WebhookPollInterval)
+		defer ticker.Stop()
+
+		lastSweep := time.Time{}
+
+		for {
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-ticker.C:
+				if time.Since(lastSweep) >= expirySweepInterval {
+					lastSweep = time.Now()
+
+					err := s.QueueExpiredEvents(d.ctx)
+					if err != nil && d.ctx.Err() == nil {
+						logger.Log.Error("queue expired events", zap.Error(err))
+					}
+				}
+
+				err := s.DeliverWebhooks(d.ctx)
+				if err != nil && d.ctx.Err() == nil {
+					logger.Log.Error("deliver webhooks", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// stopWebhookDispatcher прерывает идущие доставки и ждёт их завершения;
+// прерванные доставки повторятся после перезапуска, когда истечёт срок их захвата
+func (s *Server) stopWebhookDispatcher() {
+	s.webhooks.cancel()
+	s.webhooks.wg.Wait()
+}
+
+// DeliverWebhooks доставляет все события очереди, время попытки которых наступило
+func (s *Server) DeliverWebhooks(ctx context.Context) error {
+	d := s.webhooks
+
+	d.running.Lock()
+	defer d.running.Unlock()
+
+	// за одну выборку берётся не больше доставок, чем выполняется одновременно,
+	// поэтому каждая успевает закончиться до истечения захвата
+	lease := 2 * s.Config.WebhookTimeout
+
+	for ctx.Err() == nil {
+		tasks, err := s.Storage.ClaimDeliveries(lease, s.Config.WebhookWorkers)
+		if err != nil {
+			return fmt.Errorf("claim deliveries: %w", err)
+		}
+
+		if len(tasks) == 0 {
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		for _, task := range tasks {
+			wg.Add(1)
+
+			go func(task models.WebhookTask) {
+				defer wg.Done()
+
+				s.deliverWebhook(ctx, task)
+			}(task)
+		}
+
+		wg.Wait()
+	}
+
+	return ctx.Err()
+}
+
+func (s *Server) deliverWebhook(ctx context.Context, task models.WebhookTask) {
+	delivery := task.Delivery
+
+	status, err := s.sendWebhook(ctx, task)
+	// прерванная попытка не считается, доставка повторится после истечения захвата
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = status
+	delivery.Error = ""
+
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliveryDelivered
+	case delivery.Attempts >= s.Config.WebhookMaxAttempts:
+		delivery.Status = models.DeliveryFailed
+		delivery.Error = truncate(err.Error(), webhookErrorLimit)
+	default:
+		delivery.NextAttemptAt = now.Add(s.webhookBackoff(delivery.Attempts))
+		delivery.Error = truncate(err.Error(), webhookErrorLimit)
+	}
+
+	err = s.Storage.UpdateDelivery(delivery)
+	if err != nil {
+		logger.Log.Warn("save webhook delivery", zap.Int64("id", delivery.ID), zap.Error(err))
+	}
+}
+
+// sendWebhook отправляет событие подписке и возвращает HTTP статус ответа;
+// доставка успешна, только если подписка ответила статусом 2xx
+func (s *Server) sendWebhook(ctx context.Context, task models.WebhookTask) (int, error) {
+	event := task.Delivery.Event
+
+	body, err := json.Marshal(webhookPayload{
+		ID:         event.ID,
+		Type:       event.Type,
+		OccurredAt: event.OccurredAt,
+		Link: webhookLink{
+			ID:          event.LinkID,
+			ShortURL:    s.Config.BaseURL + "/" + event.LinkID,
+			OriginalURL: event.OriginalURL,
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("marshal payload: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, task.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("new request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shortener-webhook/1.0")
+	req.Header.Set(WebhookEventHeader, event.Type)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(task.Delivery.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(task.Webhook.Secret, timestamp, body))
+
+	resp, err := s.webhooks.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// тело ответа не нужно, но дочитанный ответ возвращает соединение в пул
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// SignWebhook возвращает значение заголовка WebhookSignatureHeader для тела запроса body,
+// отправленного во время timestamp - в секундах Unix
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff возвращает задержку перед попыткой, следующей за attempts неудачными:
+// WebhookRetryBackoff, увеличивающийся вдвое с каждой попыткой, но не больше WebhookMaxBackoff
+func (s *Server) webhookBackoff(attempts int) time.Duration {
+	backoff := s.Config.WebhookRetryBackoff
+
+	for i := 1; i < attempts && backoff < s.Config.WebhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, s.Config.WebhookMaxBackoff)
+}
+
+func truncate(value string, limit int) string {
+	if len(value) <= limit {
+		return value
+	}
+
+	return value[:limit]
+}
+
+// QueueExpiredEvents ставит в очередь доставки события истечения ссылок, окно работы которых
+// закончилось за последние expiryLookback; одно истечение ставится в очередь один раз.
+// Хранилище отдаёт только ссылки из этого промежутка, а не все записи
+func (s *Server) QueueExpiredEvents(ctx context.Context) error {
+	now := time.Now()
+
+	err := s.Storage.IterateExpired(ctx, now.Add(-expiryLookback), now, func(record models.ShortURLRecord) error {
+		// окончание окна в ID отличает истечение от истечения после продления окна
+		s.queueExpiredEvent(record, strconv.FormatInt(record.NotAfter.Unix(), 10), *record.NotAfter)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterate expired urls: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) queueExpiredEvent(record models.ShortURLRecord, reason string, occurredAt time.Time) {
+	err := s.Storage.AddEvent(models.WebhookEvent{
+		ID:          models.EventLinkExpired + ":" + record.ShortURL + ":" + reason,
+		Type:        models.EventLinkExpired,
+		LinkID:      record.ShortURL,
+		OriginalURL: record.OriginalURL,
+		UserID:      record.UserID,
+		OccurredAt:  occurredAt.UTC(),
+	})
+	if err != nil {
+		logger.Log.Warn("queue expired event", zap.String("id", record.ShortURL), zap.Error(err))
+	}
+}
+
+// CreateWebhook подписывает пользователя на события его ссылок; возвращённая подписка содержит секрет подписи
+func (s *Server) CreateWebhook(userID string, req models.WebhookRequest) (models.Webhook, error) {
+	err := validateURL(req.URL)
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("webhook url: %w", err)
+	}
+
+	events, err := normalizeWebhookEvents(req.Events)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	webhooks, err := s.Storage.GetWebhooks(userID)
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("get user webhooks: %w", err)
+	}
+
+	if len(webhooks) >= maxUserWebhooks {
+		return models.Webhook{}, ErrTooManyWebhooks
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		secret, err = auth.NewUserID()
+		if err != nil {
+			return models.Webhook{}, fmt.Errorf("generate webhook secret: %w", err)
+		}
+	}
+
+	webhook := models.Webhook{
+		ID:        util.GetRandomString(webhookIDLen),
+		UserID:    userID,
+		URL:       req.URL,
+		Events:    events,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err = s.Storage.CreateWebhook(webhook)
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("save webhook: %w", err)
+	}
+
+	return webhook, nil
+}
+
+// normalizeWebhookEvents проверяет события подписки и убирает повторы; пустой список - все события
+func normalizeWebhookEvents(events []string) ([]string, error) {
+	var normalized []string
+
+	for _, event := range events {
+		if !slices.Contains(webhookEvents, event) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidWebhookEvents, event)
+		}
+
+		if !slices.Contains(normalized, event) {
+			normalized = append(normalized, event)
+		}
+	}
+
+	return normalized, nil
+}
+
+// Webhooks возвращает подписки пользователя без секретов
+func (s *Server) Webhooks(userID string) ([]models.Webhook, error) {
+	webhooks, err := s.Storage.GetWebhooks(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user webhooks: %w", err)
+	}
+
+	if webhooks == nil {
+		webhooks = []models.Webhook{}
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook удаляет подписку пользователя; недоставленные события подписки больше не доставляются
+func (s *Server) DeleteWebhook(id, userID string) error {
+	err := s.Storage.DeleteWebhook(id, userID)
+	if err != nil {
+		return fmt.Errorf("delete webhook in storage: %w", err)
+	}
+
+	return nil
+}
+
+// WebhookDeliveries возвращает последние доставки подписки пользователя, новые первыми
+func (s *Server) WebhookDeliveries(id, userID string) ([]models.WebhookDelivery, error) {
+	deliveries, err := s.Storage.GetDeliveries(id, userID, webhookLogLimit)
+	if err != nil {
+		return nil, fmt.Errorf("get webhook deliveries: %w", err)
+	}
+
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+
+	return deliveries, nil
+}
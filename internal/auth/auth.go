@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CookieName  = "auth_token"
+	userIDBytes = 16
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid auth token")
+	ErrTokenExpired = errors.New("auth token expired")
+)
+
+// Authenticator выдаёт и проверяет токены вида <user id>.<hmac подпись user id>
+type Authenticator struct {
+	secret []byte
+}
+
+func NewAuthenticator(secret string) *Authenticator {
+	return &Authenticator{
+		secret: []byte(secret),
+	}
+}
+
+func NewUserID() (string, error) {
+	b := make([]byte, userIDBytes)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("read random bytes: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (a *Authenticator) BuildToken(userID string) string {
+	return userID + "." + base64.RawURLEncoding.EncodeToString(a.Sign([]byte(userID)))
+}
+
+func (a *Authenticator) ParseToken(token string) (string, error) {
+	userID, signature, ok := strings.Cut(token, ".")
+	if !ok || userID == "" {
+		return "", ErrInvalidToken
+	}
+
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if !hmac.Equal(decodedSignature, a.Sign([]byte(userID))) {
+		return "", ErrInvalidToken
+	}
+
+	return userID, nil
+}
+
+// SignExpiring выдаёт токен вида <unix время истечения>.<подпись>, подтверждающий subject до expiresAt
+func (a *Authenticator) SignExpiring(subject string, expiresAt time.Time) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+
+	return expires + "." + base64.RawURLEncoding.EncodeToString(a.Sign([]byte(subject+"|"+expires)))
+}
+
+func (a *Authenticator) VerifyExpiring(subject, token string) error {
+	expires, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidToken
+	}
+
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if !hmac.Equal(decodedSignature, a.Sign([]byte(subject+"|"+expires))) {
+		return ErrInvalidToken
+	}
+
+	if time.Now().After(time.Unix(expiresUnix, 0)) {
+		return ErrTokenExpired
+	}
+
+	return nil
+}
+
+func (a *Authenticator) Sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticator(t *testing.T) {
+	authenticator := NewAuthenticator("secret")
+
+	userID, err := NewUserID()
+	require.NoError(t, err)
+
+	token := authenticator.BuildToken(userID)
+
+	parsedUserID, err := authenticator.ParseToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, userID, parsedUserID)
+
+	for _, invalidToken := range []string{
+		"",
+		userID,
+		userID + ".",
+		"other" + token[len(userID):],
+		NewAuthenticator("other secret").BuildToken(userID),
+	} {
+		_, err = authenticator.ParseToken(invalidToken)
+		assert.ErrorIs(t, err, ErrInvalidToken, invalidToken)
+	}
+}
+
+func TestAuthenticatorExpiring(t *testing.T) {
+	authenticator := NewAuthenticator("secret")
+
+	token := authenticator.SignExpiring("aaaaaaaa", time.Now().Add(time.Minute))
+	require.NoError(t, authenticator.VerifyExpiring("aaaaaaaa", token))
+
+	assert.ErrorIs(t, authenticator.VerifyExpiring("bbbbbbbb", token), ErrInvalidToken)
+	assert.ErrorIs(t, NewAuthenticator("other secret").VerifyExpiring("aaaaaaaa", token), ErrInvalidToken)
+
+	expiredToken := authenticator.SignExpiring("aaaaaaaa", time.Now().Add(-time.Minute))
+	assert.ErrorIs(t, authenticator.VerifyExpiring("aaaaaaaa", expiredToken), ErrTokenExpired)
+}
@@ -0,0 +1,90 @@
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+type Filter struct {
+	mu        sync.RWMutex
+	bits      []uint64
+	bitsCount uint64
+	hashCount uint64
+	added     uint64
+}
+
+// New создаёт фильтр, рассчитанный на capacity элементов с заданной долей ложноположительных ответов
+func New(capacity int, falsePositiveRate float64) *Filter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+
+	bitsCount := uint64(math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if bitsCount < 64 {
+		bitsCount = 64
+	}
+
+	hashCount := uint64(math.Round(float64(bitsCount) / float64(capacity) * math.Ln2))
+	if hashCount < 1 {
+		hashCount = 1
+	}
+
+	return &Filter{
+		bits:      make([]uint64, (bitsCount+63)/64),
+		bitsCount: bitsCount,
+		hashCount: hashCount,
+	}
+}
+
+func (f *Filter) Add(value string) {
+	h1, h2 := hashPair(value)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := uint64(0); i < f.hashCount; i++ {
+		position := (h1 + i*h2) % f.bitsCount
+		f.bits[position/64] |= 1 << (position % 64)
+	}
+
+	f.added++
+}
+
+// MayContain возвращает false, только если значение точно не добавлялось в фильтр
+func (f *Filter) MayContain(value string) bool {
+	h1, h2 := hashPair(value)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for i := uint64(0); i < f.hashCount; i++ {
+		position := (h1 + i*h2) % f.bitsCount
+		if f.bits[position/64]&(1<<(position%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f *Filter) Count() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return int(f.added)
+}
+
+func hashPair(value string) (uint64, uint64) {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	h1 := hash.Sum64()
+
+	hash.Write([]byte{0xff})
+	h2 := hash.Sum64() | 1
+
+	return h1, h2
+}
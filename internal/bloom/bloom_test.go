@@ -0,0 +1,32 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	const capacity = 10000
+
+	filter := New(capacity, 0.01)
+
+	for i := 0; i < capacity; i++ {
+		filter.Add(fmt.Sprintf("added-%d", i))
+	}
+
+	for i := 0; i < capacity; i++ {
+		assert.True(t, filter.MayContain(fmt.Sprintf("added-%d", i)))
+	}
+
+	var falsePositives int
+	for i := 0; i < capacity; i++ {
+		if filter.MayContain(fmt.Sprintf("missing-%d", i)) {
+			falsePositives++
+		}
+	}
+
+	assert.Less(t, float64(falsePositives)/capacity, 0.02)
+	assert.Equal(t, capacity, filter.Count())
+}
@@ -0,0 +1,164 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+
+	return "unknown"
+}
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+type Options struct {
+	// Количество ошибок подряд, после которого breaker размыкается
+	FailureThreshold int
+	// Время в разомкнутом состоянии до пробного запроса
+	OpenTimeout time.Duration
+	// Вызывается при каждой смене состояния
+	OnStateChange func(from, to State)
+}
+
+type Breaker struct {
+	options Options
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	// в полуразомкнутом состоянии пропускается только один пробный запрос
+	trialInFlight bool
+}
+
+func New(options Options) *Breaker {
+	if options.FailureThreshold <= 0 {
+		options.FailureThreshold = 1
+	}
+
+	return &Breaker{
+		options: options,
+	}
+}
+
+// Allow возвращает ErrOpen, если запрос выполнять нельзя. После разрешённого запроса
+// нужно вызвать Success или Failure.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.options.OpenTimeout {
+			return ErrOpen
+		}
+
+		b.setState(StateHalfOpen)
+		b.trialInFlight = true
+
+		return nil
+
+	case StateHalfOpen:
+		if b.trialInFlight {
+			return ErrOpen
+		}
+
+		b.trialInFlight = true
+	}
+
+	return nil
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.trialInFlight = false
+
+	if b.state != StateClosed {
+		b.setState(StateClosed)
+	}
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trialInFlight = false
+
+	if b.state == StateHalfOpen || b.failures >= b.options.FailureThreshold {
+		b.openedAt = time.Now()
+		if b.state != StateOpen {
+			b.setState(StateOpen)
+		}
+	}
+}
+
+// Release завершает разрешённый запрос, результат которого ничего не говорит о состоянии,
+// например отменённый вызывающим: счётчик ошибок и состояние не меняются
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trialInFlight = false
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *Breaker) Failures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures
+}
+
+// RetryAfter возвращает время до следующего пробного запроса, 0 - если breaker замкнут
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateClosed {
+		return 0
+	}
+
+	retryAfter := b.options.OpenTimeout - time.Since(b.openedAt)
+	if retryAfter < 0 {
+		return 0
+	}
+
+	return retryAfter
+}
+
+func (b *Breaker) setState(state State) {
+	from := b.state
+	b.state = state
+
+	if b.options.OnStateChange != nil {
+		b.options.OnStateChange(from, state)
+	}
+}
@@ -0,0 +1,60 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	var transitions []string
+
+	b := New(Options{
+		FailureThreshold: 3,
+		OpenTimeout:      20 * time.Millisecond,
+		OnStateChange: func(from, to State) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, b.Allow())
+		b.Failure()
+	}
+
+	assert.Equal(t, StateOpen, b.State())
+	assert.ErrorIs(t, b.Allow(), ErrOpen)
+	assert.Positive(t, b.RetryAfter())
+
+	time.Sleep(30 * time.Millisecond)
+
+	require.NoError(t, b.Allow())
+	assert.Equal(t, StateHalfOpen, b.State())
+	assert.ErrorIs(t, b.Allow(), ErrOpen, "only one trial request in half-open state")
+
+	b.Failure()
+	assert.Equal(t, StateOpen, b.State())
+
+	time.Sleep(30 * time.Millisecond)
+
+	require.NoError(t, b.Allow())
+	b.Release()
+	assert.Equal(t, StateHalfOpen, b.State())
+	require.NoError(t, b.Allow(), "released trial lets the next request through")
+	b.Release()
+
+	require.NoError(t, b.Allow())
+	b.Success()
+
+	assert.Equal(t, StateClosed, b.State())
+	assert.Zero(t, b.RetryAfter())
+	assert.Equal(t, []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}, transitions)
+}
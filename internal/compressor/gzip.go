@@ -33,6 +33,20 @@ func (c *CompressWriter) WriteHeader(statusCode int) {
 	c.responseWriter.WriteHeader(statusCode)
 }
 
+// Unwrap даёт http.ResponseController доступ к исходному ResponseWriter
+func (c *CompressWriter) Unwrap() http.ResponseWriter {
+	return c.responseWriter
+}
+
+// Flush отправляет клиенту уже сжатые данные, чтобы потоковый ответ не копился в буфере gzip
+func (c *CompressWriter) Flush() {
+	c.gzipWriter.Flush()
+
+	if flusher, ok := c.responseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func (c *CompressWriter) Close() error {
 	return c.gzipWriter.Close()
 }
@@ -0,0 +1,209 @@
+"use strict";
+
+// Renders the OpenAPI document: operations grouped by tag, each with a form that sends the request.
+
+const methods = ["get", "head", "post", "put", "patch", "delete"];
+
+function el(tag, attrs, ...children) {
+    const node = document.createElement(tag);
+    for (const [name, value] of Object.entries(attrs || {})) {
+        node.setAttribute(name, value);
+    }
+    for (const child of children) {
+        node.append(child);
+    }
+    return node;
+}
+
+function resolve(spec, node) {
+    while (node && node.$ref) {
+        node = node.$ref.replace(/^#\//, "").split("/").reduce((parent, key) => parent[key], spec);
+    }
+    return node;
+}
+
+function refName(node) {
+    return node && node.$ref ? node.$ref.split("/").pop() : "";
+}
+
+// example builds a sample value of a schema to prefill request bodies
+function example(spec, schema, depth = 0) {
+    schema = resolve(spec, schema);
+    if (!schema || depth > 4) {
+        return null;
+    }
+    if (schema.allOf) {
+        return example(spec, schema.allOf[0], depth);
+    }
+    if (schema.example !== undefined) {
+        return schema.example;
+    }
+    if (schema.enum) {
+        return schema.enum[0];
+    }
+    switch (schema.type) {
+    case "object": {
+        const value = {};
+        for (const name of schema.required || Object.keys(schema.properties || {})) {
+            value[name] = example(spec, schema.properties[name], depth + 1);
+        }
+        return value;
+    }
+    case "array":
+        return [example(spec, schema.items, depth + 1)];
+    case "integer":
+    case "number":
+        return schema.minimum || 0;
+    case "boolean":
+        return false;
+    default:
+        if (schema.format === "uri") {
+            return "https://example.com";
+        }
+        if (schema.format === "date-time") {
+            return new Date().toISOString();
+        }
+        return "";
+    }
+}
+
+function schemaLabel(spec, schema) {
+    if (!schema) {
+        return "";
+    }
+    if (schema.$ref) {
+        return refName(schema);
+    }
+    if (schema.type === "array") {
+        return schemaLabel(spec, schema.items) + "[]";
+    }
+    return schema.type || "";
+}
+
+function renderParameters(spec, operation) {
+    const parameters = (operation.parameters || []).map((parameter) => resolve(spec, parameter));
+    const inputs = {};
+
+    if (parameters.length === 0) {
+        return {node: "", inputs};
+    }
+
+    const rows = parameters.map((parameter) => {
+        const input = el("input", {name: parameter.name, placeholder: parameter.in});
+        inputs[parameter.in + ":" + parameter.name] = input;
+
+        return el("dd", {},
+            el("strong", {}, parameter.name), ` (${parameter.in}${parameter.required ? ", required" : ""}) `,
+            parameter.description || "", el("br"), input);
+    });
+
+    return {node: el("dl", {}, el("dt", {}, "Parameters"), ...rows), inputs};
+}
+
+function renderOperation(spec, path, method, operation) {
+    const params = renderParameters(spec, operation);
+    const body = operation.requestBody ? resolve(spec, operation.requestBody) : null;
+    const contentType = body ? Object.keys(body.content)[0] : "";
+
+    const bodyInput = el("textarea", {name: "body"});
+    if (contentType === "application/json") {
+        bodyInput.value = JSON.stringify(example(spec, body.content[contentType].schema), null, 2);
+    }
+
+    const responses = Object.entries(operation.responses).map(([status, response]) => {
+        response = resolve(spec, response);
+        const types = Object.entries(response.content || {}).map(([type, media]) => `${type} ${schemaLabel(spec, media.schema)}`);
+
+        return el("dd", {}, el("strong", {}, status), " ", response.description, types.length ? ` - ${types.join(", ")}` : "");
+    });
+
+    const output = el("pre", {hidden: ""});
+    const send = el("button", {type: "button"}, "Send");
+
+    send.addEventListener("click", async () => {
+        let url = path.replace(/\{(\w+)\}/g, (_, name) => encodeURIComponent(params.inputs["path:" + name].value));
+        const query = new URLSearchParams();
+
+        for (const [key, input] of Object.entries(params.inputs)) {
+            if (key.startsWith("query:") && input.value !== "") {
+                query.set(input.name, input.value);
+            }
+        }
+        if (query.toString() !== "") {
+            url += "?" + query;
+        }
+
+        const init = {method: method.toUpperCase(), redirect: "manual", headers: {}};
+        if (body) {
+            init.headers["Content-Type"] = contentType;
+            init.body = bodyInput.value;
+        }
+
+        output.hidden = false;
+        output.textContent = "...";
+
+        try {
+            const response = await fetch(url, init);
+            const text = await response.text();
+            output.textContent = `${response.status} ${response.statusText}\n${response.headers.get("Content-Type") || ""}\n\n${text}`;
+        } catch (err) {
+            output.textContent = String(err);
+        }
+    });
+
+    const security = (operation.security || []).filter((requirement) => Object.keys(requirement).length > 0);
+    const auth = security.length === 0 ? "" : (operation.security.length > security.length ? "optional" : "required");
+
+    return el("details", {id: operation.operationId},
+        el("summary", {}, el("span", {class: "method method-" + method}, method), " ", el("code", {}, path), " ", operation.summary),
+        operation.description ? el("p", {}, operation.description) : "",
+        auth ? el("p", {}, `Authentication: ${auth}.`) : "",
+        params.node,
+        body ? el("dl", {}, el("dt", {}, `Request body (${contentType} ${schemaLabel(spec, body.content[contentType].schema)})`), el("dd", {}, bodyInput)) : "",
+        el("dl", {}, el("dt", {}, "Responses"), ...responses),
+        send, output);
+}
+
+function renderSchemas(spec) {
+    return Object.entries(spec.components.schemas).map(([name, schema]) =>
+        el("details", {id: "schema-" + name}, el("summary", {}, el("code", {}, name)),
+            schema.description ? el("p", {}, schema.description) : "",
+            el("pre", {}, JSON.stringify(schema, null, 2))));
+}
+
+async function render(root) {
+    const response = await fetch(root.dataset.spec);
+    const spec = await response.json();
+
+    const byTag = new Map((spec.tags || []).map((tag) => [tag.name, []]));
+
+    for (const [path, item] of Object.entries(spec.paths)) {
+        for (const method of methods) {
+            const operation = item[method];
+            if (!operation) {
+                continue;
+            }
+
+            const tag = (operation.tags || ["Other"])[0];
+            if (!byTag.has(tag)) {
+                byTag.set(tag, []);
+            }
+            byTag.get(tag).push(renderOperation(spec, path, method, operation));
+        }
+    }
+
+    root.replaceChildren(el("p", {}, spec.info.description));
+
+    for (const [tag, operations] of byTag) {
+        root.append(el("h2", {}, tag), ...operations);
+    }
+
+    root.append(el("h2", {}, "Schemas"), ...renderSchemas(spec));
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+    const root = document.getElementById("docs");
+    render(root).catch((err) => {
+        root.replaceChildren(el("p", {class: "error"}, "Failed to load the document: " + err));
+    });
+});
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// openAPISpec описывает все маршруты InitHandlers; тест сверяет его с зарегистрированными маршрутами
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func (s *SrvHandler) OpenAPIHandler(c echo.Context) error {
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, openAPISpec)
+}
+
+// DocsHandler отдаёт страницу, которая строит документацию по openapi.json и позволяет отправлять запросы
+func (s *SrvHandler) DocsHandler(c echo.Context) error {
+	return renderPage(c, http.StatusOK, "docs.html", nil)
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pluhe7/shortener/internal/app"
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+type openAPIDocument struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+// openAPISchemaTypes - типы models, которые описывает документ, по именам схем
+var openAPISchemaTypes = map[string]any{
+	"BreakerHealth":         models.BreakerHealth{},
+	"BrokenFallbackRequest": models.BrokenFallbackRequest{},
+	"BrokenURL":             models.BrokenURL{},
+	"CacheStats":            models.CacheStats{},
+	"ExportedURL":           models.ExportedURL{},
+	"HealthResponse":        models.HealthResponse{},
+	"ImportResult":          models.ImportResult{},
+	"LinkCheck":             models.LinkCheck{},
+	"OriginalURLWithID":     models.OriginalURLWithID{},
+	"PoolStats":             models.PoolStats{},
+	"Problem":               models.Problem{},
+	"QueryCondition":        models.QueryCondition{},
+	"RedirectRule":          models.RedirectRule{},
+	"RedirectRules":         models.RedirectRules{},
+	"ReplicaHealth":         models.ReplicaHealth{},
+	"RevertURLRequest":      models.RevertURLRequest{},
+	"Schedule":              models.Schedule{},
+	"ShortURLWithID":        models.ShortURLWithID{},
+	"ShortenRequest":        models.ShortenRequest{},
+	"ShortenResponse":       models.ShortenResponse{},
+	"Split":                 models.Split{},
+	"SplitStats":            models.SplitStats{},
+	"SplitTarget":           models.SplitTarget{},
+	"SplitTargetStats":      models.SplitTargetStats{},
+	"StreamShortenResult":   models.StreamShortenResult{},
+	"TimeWindow":            models.TimeWindow{},
+	"URLMetadata":           models.URLMetadata{},
+	"URLPreview":            models.URLPreview{},
+	"URLRevision":           models.URLRevision{},
+	"UpdateURLRequest":      models.UpdateURLRequest{},
+	"UserURL":               models.UserURL{},
+	"Webhook":               models.Webhook{},
+	"WebhookDelivery":       models.WebhookDelivery{},
+	"WebhookEvent":          models.WebhookEvent{},
+	"WebhookRequest":        models.WebhookRequest{},
+}
+
+// openAPIEnumSchemas - схемы без типа в models, например перечисления строк
+var openAPIEnumSchemas = map[string]bool{
+	"WebhookEventType": true,
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	srv := app.NewServer(&testConfig)
+	defer srv.Stop()
+	InitHandlers(srv)
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		responseRecorder := httptest.NewRecorder()
+		srv.Echo.ServeHTTP(responseRecorder, httptest.NewRequest(http.MethodGet, target, nil))
+
+		return responseRecorder
+	}
+
+	res := serve("/api/openapi.json")
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, echo.MIMEApplicationJSON, res.Header().Get(echo.HeaderContentType))
+
+	var doc openAPIDocument
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &doc))
+	assert.True(t, strings.HasPrefix(doc.OpenAPI, "3."))
+
+	t.Run("every route is documented", func(t *testing.T) {
+		paramPattern := regexp.MustCompile(`:(\w+)`)
+
+		var routes []string
+		for _, route := range srv.Echo.Routes() {
+			if strings.HasPrefix(route.Path, "/assets") {
+				continue
+			}
+
+			path := paramPattern.ReplaceAllString(route.Path, "{$1}")
+			path = strings.Replace(path, "*", "{path}", 1)
+
+			routes = append(routes, route.Method+" "+path)
+		}
+
+		var documented []string
+		for path, item := range doc.Paths {
+			for method := range item {
+				documented = append(documented, strings.ToUpper(method)+" "+path)
+			}
+		}
+
+		sort.Strings(routes)
+		sort.Strings(documented)
+
+		assert.Equal(t, routes, documented)
+	})
+
+	t.Run("schemas match models", func(t *testing.T) {
+		for name, schema := range doc.Components.Schemas {
+			if openAPIEnumSchemas[name] {
+				continue
+			}
+
+			model, ok := openAPISchemaTypes[name]
+			if !assert.True(t, ok, "schema %s has no model", name) {
+				continue
+			}
+
+			var properties []string
+			for property := range schema.Properties {
+				properties = append(properties, property)
+			}
+
+			assert.ElementsMatch(t, jsonFields(reflect.TypeOf(model)), properties, "schema %s", name)
+		}
+
+		for name := range openAPISchemaTypes {
+			assert.Contains(t, doc.Components.Schemas, name)
+		}
+	})
+
+	t.Run("references resolve", func(t *testing.T) {
+		var raw map[string]any
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &raw))
+
+		refs := make(map[string]bool)
+		collectRefs(raw, refs)
+		require.NotEmpty(t, refs)
+
+		for ref := range refs {
+			var node any = raw
+			for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+				object, ok := node.(map[string]any)
+				require.True(t, ok, "reference %s", ref)
+
+				node, ok = object[key]
+				require.True(t, ok, "reference %s", ref)
+			}
+		}
+	})
+
+	t.Run("docs page", func(t *testing.T) {
+		res := serve("/api/docs")
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Header().Get(echo.HeaderContentType), echo.MIMETextHTML)
+		assert.Contains(t, res.Body.String(), `data-spec="/api/openapi.json"`)
+
+		res = serve("/assets/docs.js")
+		require.Equal(t, http.StatusOK, res.Code)
+	})
+}
+
+// jsonFields возвращает имена полей JSON структуры, включая поля встроенных структур
+func jsonFields(typ reflect.Type) []string {
+	var fields []string
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			fields = append(fields, jsonFields(field.Type)...)
+			continue
+		}
+
+		fields = append(fields, name)
+	}
+
+	return fields
+}
+
+func collectRefs(node any, refs map[string]bool) {
+	switch node := node.(type) {
+	case map[string]any:
+		for key, value := range node {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				refs[ref] = true
+			}
+
+			collectRefs(value, refs)
+		}
+	case []any:
+		for _, value := range node {
+			collectRefs(value, refs)
+		}
+	}
+}
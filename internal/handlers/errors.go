@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/app"
+	"github.com/pluhe7/shortener/internal/logger"
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/rules"
+	"github.com/pluhe7/shortener/internal/storage"
+)
+
+const (
+	mimeProblemJSON = "application/problem+json"
+
+	requestIDContextKey = "request_id"
+	maxRequestIDLen     = 64
+
+	codeInternalError     = "internal_error"
+	codeInvalidBody       = "invalid_body"
+	codeUnauthorized      = "unauthorized"
+	codeUnsupportedFormat = "unsupported_format"
+)
+
+var errUnauthorized = errors.New("unauthorized")
+
+// APIError - ошибка обработчика со статусом ответа и стабильным кодом, который клиент может различать
+type APIError struct {
+	Status int
+	Code   string
+	// Описание для клиента, у внутренних ошибок пустое
+	Detail string
+	// Через сколько повторить запрос, если не 0
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// errorMappings сопоставляет ошибки приложения и хранилища статусам и кодам ответа, проверяются по порядку.
+// Коды - часть API: их можно добавлять, но не менять.
+var errorMappings = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{storage.ErrStorageUnavailable, http.StatusServiceUnavailable, "storage_unavailable"},
+
+	{storage.ErrURLNotFound, http.StatusNotFound, "url_not_found"},
+	{storage.ErrWebhookNotFound, http.StatusNotFound, "webhook_not_found"},
+	{app.ErrURLNotYetAvailable, http.StatusNotFound, "url_not_yet_available"},
+	{app.ErrURLDeleted, http.StatusGone, "url_deleted"},
+	{app.ErrURLExpired, http.StatusGone, "url_expired"},
+	{storage.ErrClickLimitReached, http.StatusGone, "click_limit_reached"},
+
+	{app.ErrForbidden, http.StatusForbidden, "forbidden"},
+	{app.ErrPasswordRequired, http.StatusForbidden, "password_required"},
+	{app.ErrWrongPassword, http.StatusForbidden, "wrong_password"},
+	{app.ErrTooManyAttempts, http.StatusTooManyRequests, "too_many_attempts"},
+
+	{storage.ErrDuplicateRecord, http.StatusConflict, "duplicate_url"},
+	{app.ErrAliasTaken, http.StatusConflict, "alias_taken"},
+	{app.ErrTooManyWebhooks, http.StatusConflict, "too_many_webhooks"},
+
+	{app.ErrEmptyURL, http.StatusBadRequest, "empty_url"},
+	{app.ErrInvalidURL, http.StatusBadRequest, "invalid_url"},
+	{app.ErrInvalidID, http.StatusBadRequest, "invalid_id"},
+	{app.ErrInvalidAlias, http.StatusBadRequest, "invalid_alias"},
+	{app.ErrInvalidPassword, http.StatusBadRequest, "invalid_password"},
+	{app.ErrInvalidRedirectStatus, http.StatusBadRequest, "invalid_redirect_status"},
+	{app.ErrInvalidQueryConflictPolicy, http.StatusBadRequest, "invalid_query_conflict_policy"},
+	{app.ErrInvalidExtraPath, http.StatusBadRequest, "invalid_extra_path"},
+	{app.ErrInvalidMaxClicks, http.StatusBadRequest, "invalid_max_clicks"},
+	{app.ErrInvalidSchedule, http.StatusBadRequest, "invalid_schedule"},
+	{app.ErrInvalidStatus, http.StatusBadRequest, "invalid_status"},
+	{app.ErrInvalidNotAfter, http.StatusBadRequest, "invalid_not_after"},
+	{app.ErrURLUnchanged, http.StatusBadRequest, "url_unchanged"},
+	{app.ErrInvalidRevision, http.StatusBadRequest, "invalid_revision"},
+	{rules.ErrInvalidRule, http.StatusBadRequest, "invalid_rule"},
+	{app.ErrTooManyRules, http.StatusBadRequest, "too_many_rules"},
+	{app.ErrInvalidSplit, http.StatusBadRequest, "invalid_split"},
+	{app.ErrInvalidQROptions, http.StatusBadRequest, "invalid_qr_options"},
+	{app.ErrInvalidWebhookEvents, http.StatusBadRequest, "invalid_webhook_events"},
+
+	{app.ErrInvalidItem, http.StatusBadRequest, "invalid_item"},
+	{app.ErrInvalidCSVRow, http.StatusBadRequest, "invalid_csv_row"},
+
+	{app.ErrTooManyItems, http.StatusRequestEntityTooLarge, "too_many_items"},
+	{app.ErrLineTooLong, http.StatusRequestEntityTooLarge, "line_too_long"},
+}
+
+func newAPIError(status int, code string, err error) *APIError {
+	return &APIError{Status: status, Code: code, Detail: err.Error(), Err: err}
+}
+
+// invalidBody - ошибка чтения или разбора тела запроса
+func invalidBody(err error) error {
+	return newAPIError(http.StatusBadRequest, codeInvalidBody, err)
+}
+
+// toAPIError сопоставляет ошибку ответу. Клиенту показывается текст только его собственных ошибок:
+// у ошибок 5xx описание - общий текст известной ошибки или пустое, если ошибка не распознана.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		apiErr = &APIError{Status: httpErr.Code, Code: statusCode(httpErr.Code), Err: err}
+
+		if message, ok := httpErr.Message.(string); ok && httpErr.Code < http.StatusInternalServerError {
+			apiErr.Detail = message
+		}
+
+		return apiErr
+	}
+
+	for _, mapping := range errorMappings {
+		if !errors.Is(err, mapping.err) {
+			continue
+		}
+
+		apiErr = &APIError{Status: mapping.status, Code: mapping.code, Detail: err.Error(), Err: err}
+
+		if mapping.status >= http.StatusInternalServerError {
+			apiErr.Detail = mapping.err.Error()
+		}
+
+		if errors.Is(err, storage.ErrStorageUnavailable) {
+			apiErr.RetryAfter = storageRetryAfter(err)
+		}
+
+		return apiErr
+	}
+
+	return &APIError{Status: http.StatusInternalServerError, Code: codeInternalError, Err: err}
+}
+
+// storageRetryAfter - время до следующей попытки breaker, но не меньше секунды
+func storageRetryAfter(err error) time.Duration {
+	retryAfter := time.Second
+
+	var unavailableErr *storage.UnavailableError
+	if errors.As(err, &unavailableErr) && unavailableErr.RetryAfter > retryAfter {
+		retryAfter = unavailableErr.RetryAfter
+	}
+
+	return retryAfter
+}
+
+// statusCode строит код ошибки из текста статуса, например not_found для 404
+func statusCode(status int) string {
+	if status == http.StatusInternalServerError {
+		return codeInternalError
+	}
+
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// resolveError сопоставляет ошибку ответу и логирует ошибки сервера с идентификатором запроса,
+// так как клиенту их подробности не отдаются
+func resolveError(c echo.Context, err error) *APIError {
+	apiErr := toAPIError(err)
+
+	if apiErr.Status >= http.StatusInternalServerError {
+		fields := []zap.Field{
+			zap.String("request_id", requestIDFromContext(c)),
+			zap.String("method", c.Request().Method),
+			zap.String("path", c.Request().URL.Path),
+			zap.Error(err),
+		}
+
+		if apiErr.Code == codeInternalError {
+			logger.Log.Error("internal error", fields...)
+		} else {
+			logger.Log.Warn("server error", fields...)
+		}
+	}
+
+	return apiErr
+}
+
+// describeError возвращает код и текст ошибки для поля ответа, в котором ошибка сообщается без статуса,
+// например для строки импорта; как и у ответа с ошибкой, подробности ошибок сервера только логируются
+func describeError(c echo.Context, err error) (code, message string) {
+	apiErr := resolveError(c, err)
+
+	return apiErr.Code, errorMessage(apiErr)
+}
+
+// errorMessage - текст ошибки для клиента: описание или, если его нет, текст статуса
+func errorMessage(apiErr *APIError) string {
+	if apiErr.Detail != "" {
+		return apiErr.Detail
+	}
+
+	return http.StatusText(apiErr.Status)
+}
+
+// HTTPErrorHandler отвечает на ошибку, которую вернул обработчик: для путей /api/ - телом
+// application/problem+json по RFC 7807, для остальных - текстом
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	apiErr := resolveError(c, err)
+
+	if apiErr.RetryAfter > 0 {
+		retryAfter := int(math.Ceil(apiErr.RetryAfter.Seconds()))
+		c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	}
+
+	var respErr error
+
+	switch {
+	case c.Request().Method == http.MethodHead:
+		respErr = c.NoContent(apiErr.Status)
+
+	case strings.HasPrefix(c.Request().URL.Path, "/api/"):
+		c.Response().Header().Set(echo.HeaderContentType, mimeProblemJSON)
+
+		respErr = c.JSON(apiErr.Status, models.Problem{
+			Type:      "about:blank",
+			Title:     http.StatusText(apiErr.Status),
+			Status:    apiErr.Status,
+			Detail:    apiErr.Detail,
+			Instance:  c.Request().URL.Path,
+			Code:      apiErr.Code,
+			RequestID: requestIDFromContext(c),
+		})
+
+	default:
+		respErr = c.String(apiErr.Status, errorMessage(apiErr))
+	}
+
+	if respErr != nil {
+		logger.Log.Warn("write error response", zap.Error(respErr))
+	}
+}
+
+// RequestID берёт идентификатор запроса из заголовка X-Request-ID или создаёт новый и возвращает его в ответе
+func RequestID(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		requestID := c.Request().Header.Get(echo.HeaderXRequestID)
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		c.Set(requestIDContextKey, requestID)
+		c.Response().Header().Set(echo.HeaderXRequestID, requestID)
+
+		return next(c)
+	}
+}
+
+func requestIDFromContext(c echo.Context) string {
+	requestID, _ := c.Get(requestIDContextKey).(string)
+
+	return requestID
+}
+
+// isValidRequestID не пропускает в логи идентификаторы клиента произвольной длины и со спецсимволами
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLen {
+		return false
+	}
+
+	for _, r := range requestID {
+		isAllowed := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:", r)
+		if !isAllowed {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+
+	_, err := rand.Read(buf)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(buf)
+}
@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
 
 	"github.com/pluhe7/shortener/internal/app"
+	"github.com/pluhe7/shortener/internal/logger"
 	"github.com/pluhe7/shortener/internal/models"
 	"github.com/pluhe7/shortener/internal/storage"
 )
@@ -23,59 +27,130 @@ type SrvHandler struct {
 func InitHandlers(srv *app.Server) {
 	srvHandler := SrvHandler{srv}
 
-	srv.Echo.Use(RequestLogger, CompressorMiddleware)
+	srv.Echo.HTTPErrorHandler = HTTPErrorHandler
+	srv.Echo.Use(RequestID, RequestLogger, CompressorMiddleware)
 
-	srv.Echo.GET(`/:id`, srvHandler.ExpandHandler)
+	authMiddleware := AuthMiddleware(srv.Auth)
+
+	if srv.Config.UnavailablePagePath != "" {
+		_, err := loadCustomPage(srv.Config.UnavailablePagePath)
+		if err != nil {
+			logger.Log.Fatal("load unavailable page", zap.Error(err))
+		}
+	}
+
+	srv.Echo.GET(`/:id`, srvHandler.ExpandHandler, PreviewSwitch(srvHandler.PreviewHandler))
+	srv.Echo.HEAD(`/:id`, srvHandler.ExpandHandler, PreviewSwitch(srvHandler.PreviewHandler))
+	srv.Echo.GET(`/:id/`+app.QRSubPath, srvHandler.QRHandler)
+	srv.Echo.GET(`/:id/*`, srvHandler.ExpandHandler, PreviewSwitch(srvHandler.PreviewHandler))
+	srv.Echo.HEAD(`/:id/*`, srvHandler.ExpandHandler, PreviewSwitch(srvHandler.PreviewHandler))
+	srv.Echo.POST(`/:id`, srvHandler.UnlockHandler)
+	srv.Echo.POST(`/:id/*`, srvHandler.UnlockHandler)
 	srv.Echo.GET(`/ping`, srvHandler.PingDatabaseHandler)
-	srv.Echo.POST(`/`, srvHandler.ShortenHandler)
-	srv.Echo.POST(`/api/shorten`, srvHandler.APIShortenHandler)
-	srv.Echo.POST(`/api/shorten/batch`, srvHandler.APIBatchShortenHandler)
+	srv.Echo.POST(`/`, srvHandler.ShortenHandler, authMiddleware)
+	srv.Echo.POST(`/api/shorten`, srvHandler.APIShortenHandler, authMiddleware)
+	srv.Echo.POST(`/api/shorten/batch`, srvHandler.APIBatchShortenHandler, authMiddleware)
+	srv.Echo.POST(`/api/shorten/stream`, srvHandler.APIStreamShortenHandler, authMiddleware)
+	srv.Echo.GET(`/api/urls/:id`, srvHandler.APIPreviewHandler)
+	srv.Echo.DELETE(`/api/urls/:id`, srvHandler.DeleteURLHandler, authMiddleware, RequireAuth)
+	srv.Echo.PATCH(`/api/urls/:id`, srvHandler.UpdateURLHandler, authMiddleware, RequireAuth)
+	srv.Echo.GET(`/api/urls/:id/history`, srvHandler.URLHistoryHandler, authMiddleware, RequireAuth)
+	srv.Echo.POST(`/api/urls/:id/revert`, srvHandler.RevertURLHandler, authMiddleware, RequireAuth)
+	srv.Echo.GET(`/api/urls/:id/rules`, srvHandler.URLRulesHandler, authMiddleware, RequireAuth)
+	srv.Echo.PUT(`/api/urls/:id/rules`, srvHandler.SetURLRulesHandler, authMiddleware, RequireAuth)
+	srv.Echo.PUT(`/api/urls/:id/schedule`, srvHandler.SetURLScheduleHandler, authMiddleware, RequireAuth)
+	srv.Echo.GET(`/api/user/urls`, srvHandler.UserURLsHandler, authMiddleware, RequireAuth)
+	srv.Echo.GET(`/api/urls/:id/split`, srvHandler.URLSplitHandler, authMiddleware, RequireAuth)
+	srv.Echo.PUT(`/api/urls/:id/split`, srvHandler.SetURLSplitHandler, authMiddleware, RequireAuth)
+	srv.Echo.PUT(`/api/urls/:id/broken-fallback`, srvHandler.SetURLBrokenFallbackHandler, authMiddleware, RequireAuth)
+	srv.Echo.GET(`/api/user/urls/broken`, srvHandler.BrokenURLsHandler, authMiddleware, RequireAuth)
+	srv.Echo.POST(`/api/import`, srvHandler.ImportHandler, authMiddleware, RequireAuth)
+	srv.Echo.GET(`/api/export`, srvHandler.ExportHandler, authMiddleware, RequireAuth)
+	srv.Echo.POST(`/api/webhooks`, srvHandler.CreateWebhookHandler, authMiddleware, RequireAuth)
+	srv.Echo.GET(`/api/webhooks`, srvHandler.WebhooksHandler, authMiddleware, RequireAuth)
+	srv.Echo.DELETE(`/api/webhooks/:id`, srvHandler.DeleteWebhookHandler, authMiddleware, RequireAuth)
+	srv.Echo.GET(`/api/webhooks/:id/deliveries`, srvHandler.WebhookDeliveriesHandler, authMiddleware, RequireAuth)
+	srv.Echo.GET(`/api/openapi.json`, srvHandler.OpenAPIHandler)
+	srv.Echo.GET(`/api/docs`, srvHandler.DocsHandler)
+
+	srv.Echo.StaticFS(`/assets`, echo.MustSubFS(webFS, "assets"))
 }
 
 func (s *SrvHandler) ExpandHandler(c echo.Context) error {
 	id := c.Param("id")
 
-	expandedURL, err := s.ExpandURL(id)
+	req := expandRequest(c, id)
+	req.Unlocked = s.isUnlocked(c, id)
+
+	redirect, err := s.ExpandURL(req)
 	if err != nil {
-		status := http.StatusBadRequest
+		if errors.Is(err, app.ErrPasswordRequired) {
+			return renderPasswordForm(c, http.StatusOK, "")
+		}
 
-		if errors.Is(err, storage.ErrURLNotFound) {
-			status = http.StatusNotFound
+		var scheduleErr *app.ScheduleError
+		if errors.As(err, &scheduleErr) {
+			return s.renderUnavailable(c, scheduleErr)
 		}
 
-		return c.String(status, fmt.Errorf("expand url error: %w", err).Error())
+		return fmt.Errorf("expand url error: %w", err)
 	}
 
-	return c.Redirect(http.StatusTemporaryRedirect, expandedURL)
+	if redirect.Cacheable {
+		maxAge := int(s.Config.PermanentRedirectMaxAge.Seconds())
+		c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age="+strconv.Itoa(maxAge))
+	} else {
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	}
+
+	if redirect.Sticky {
+		setStickyVariant(c, id, redirect.Variant)
+	}
+
+	return c.Redirect(redirect.Status, redirect.URL)
+}
+
+// expandRequest собирает параметры перехода из запроса посетителя
+func expandRequest(c echo.Context, id string) app.ExpandRequest {
+	return app.ExpandRequest{
+		ID:             id,
+		CountClick:     c.Request().Method != http.MethodHead,
+		Query:          c.QueryParams(),
+		ExtraPath:      extraPath(c),
+		UserAgent:      c.Request().UserAgent(),
+		AcceptLanguage: c.Request().Header.Get("Accept-Language"),
+		Variant:        stickyVariant(c, id),
+	}
+}
+
+// extraPath возвращает экранированную часть пути после /{id}/ для маршрута с wildcard
+func extraPath(c echo.Context) string {
+	rest := strings.TrimPrefix(c.Request().URL.EscapedPath(), "/"+c.Param("id"))
+
+	return strings.TrimPrefix(rest, "/")
 }
 
 func (s *SrvHandler) ShortenHandler(c echo.Context) error {
 	bodyBytes, err := io.ReadAll(c.Request().Body)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Errorf("read request body error: %w", err).Error())
+		return invalidBody(fmt.Errorf("read request body error: %w", err))
 	}
 
 	originalURL := string(bodyBytes)
 	respStatus := http.StatusCreated
 
-	shortURL, err := s.ShortenURL(originalURL)
+	shortURL, err := s.ShortenURL(originalURL, userIDFromContext(c), app.ShortenOptions{})
 	if err != nil {
-		err = fmt.Errorf("shorten url error: %w", err)
-
-		if errors.Is(err, app.ErrEmptyURL) {
-			return c.String(http.StatusBadRequest, err.Error())
-
-		} else if errors.Is(err, storage.ErrDuplicateRecord) {
-			shortURL, err = s.GetExistingShortURL(originalURL)
-			if err != nil {
-				return c.String(http.StatusInternalServerError, err.Error())
-			}
-
-			respStatus = http.StatusConflict
+		if !errors.Is(err, storage.ErrDuplicateRecord) {
+			return fmt.Errorf("shorten url error: %w", err)
+		}
 
-		} else {
-			return c.String(http.StatusInternalServerError, err.Error())
+		shortURL, err = s.GetExistingShortURL(originalURL)
+		if err != nil {
+			return fmt.Errorf("shorten url error: %w", err)
 		}
+
+		respStatus = http.StatusConflict
 	}
 
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextPlain)
@@ -89,29 +164,32 @@ func (s *SrvHandler) APIShortenHandler(c echo.Context) error {
 	requestDecoder := json.NewDecoder(c.Request().Body)
 	err := requestDecoder.Decode(&req)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Errorf("decode request error: %w", err).Error())
+		return invalidBody(fmt.Errorf("decode request error: %w", err))
 	}
 
 	respStatus := http.StatusCreated
 
-	shortURL, err := s.ShortenURL(req.URL)
+	shortURL, err := s.ShortenURL(req.URL, userIDFromContext(c), app.ShortenOptions{
+		Password:            req.Password,
+		RedirectStatus:      req.RedirectStatus,
+		QueryPassthrough:    req.QueryPassthrough,
+		QueryConflictPolicy: req.QueryConflictPolicy,
+		PathPassthrough:     req.PathPassthrough,
+		MaxClicks:           req.MaxClicks,
+		Schedule:            req.Schedule,
+		BrokenFallbackURL:   req.BrokenFallbackURL,
+	})
 	if err != nil {
-		err = fmt.Errorf("shorten url error: %w", err)
-
-		if errors.Is(err, app.ErrEmptyURL) {
-			return c.String(http.StatusBadRequest, err.Error())
-
-		} else if errors.Is(err, storage.ErrDuplicateRecord) {
-			shortURL, err = s.GetExistingShortURL(req.URL)
-			if err != nil {
-				return c.String(http.StatusInternalServerError, err.Error())
-			}
-
-			respStatus = http.StatusConflict
+		if !errors.Is(err, storage.ErrDuplicateRecord) {
+			return fmt.Errorf("shorten url error: %w", err)
+		}
 
-		} else {
-			return c.String(http.StatusInternalServerError, err.Error())
+		shortURL, err = s.GetExistingShortURL(req.URL)
+		if err != nil {
+			return fmt.Errorf("shorten url error: %w", err)
 		}
+
+		respStatus = http.StatusConflict
 	}
 
 	resp := models.ShortenResponse{
@@ -127,12 +205,17 @@ func (s *SrvHandler) PingDatabaseHandler(c echo.Context) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	err := s.Storage.PingContext(ctx)
-	if err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Errorf("storage ping: %w", err).Error())
+	resp := s.Health(ctx)
+	if resp.Err != nil {
+		resp.Code, resp.Error = describeError(c, resp.Err)
 	}
 
-	return c.NoContent(http.StatusOK)
+	respStatus := http.StatusOK
+	if resp.Status == models.HealthStatusUnavailable {
+		respStatus = http.StatusInternalServerError
+	}
+
+	return c.JSON(respStatus, resp)
 }
 
 func (s *SrvHandler) APIBatchShortenHandler(c echo.Context) error {
@@ -141,15 +224,145 @@ func (s *SrvHandler) APIBatchShortenHandler(c echo.Context) error {
 	requestDecoder := json.NewDecoder(c.Request().Body)
 	err := requestDecoder.Decode(&req)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Errorf("decode request error: %w", err).Error())
+		return invalidBody(fmt.Errorf("decode request error: %w", err))
 	}
 
-	shortURLs, err := s.BatchShortenURLs(req)
+	shortURLs, err := s.BatchShortenURLs(req, userIDFromContext(c))
 	if err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Errorf("shorten url error: %w", err).Error())
+		return fmt.Errorf("shorten url error: %w", err)
+	}
+
+	if withQR, _ := strconv.ParseBool(c.QueryParam("qr")); withQR {
+		for i := range shortURLs {
+			shortURLs[i].QRURL = shortURLs[i].ShortURL + "/" + app.QRSubPath
+		}
 	}
 
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 
 	return c.JSON(http.StatusCreated, shortURLs)
 }
+
+// APIStreamShortenHandler отвечает строкой NDJSON на каждую строку запроса по мере сохранения частей.
+// Ответ начинается до чтения запроса, поэтому ошибка, прервавшая сокращение, сообщается последней строкой.
+func (s *SrvHandler) APIStreamShortenHandler(c echo.Context) error {
+	enableFullDuplex(c)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, mimeNDJSON)
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	encoder := json.NewEncoder(res)
+
+	err := s.ShortenStream(c.Request().Context(), c.Request().Body, userIDFromContext(c), func(results []models.StreamShortenResult) error {
+		for _, result := range results {
+			if result.Err != nil {
+				result.Code, result.Error = describeError(c, result.Err)
+			}
+
+			err := encoder.Encode(result)
+			if err != nil {
+				return err
+			}
+		}
+
+		res.Flush()
+
+		return nil
+	})
+	if err != nil {
+		var result models.StreamShortenResult
+		result.Code, result.Error = describeError(c, fmt.Errorf("shorten stream error: %w", err))
+		_ = encoder.Encode(result)
+	}
+
+	return nil
+}
+
+func (s *SrvHandler) DeleteURLHandler(c echo.Context) error {
+	err := s.DeleteURL(c.Param("id"), userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("delete url error: %w", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *SrvHandler) UpdateURLHandler(c echo.Context) error {
+	var req models.UpdateURLRequest
+
+	err := json.NewDecoder(c.Request().Body).Decode(&req)
+	if err != nil {
+		return invalidBody(fmt.Errorf("decode request error: %w", err))
+	}
+
+	revision, err := s.UpdateURL(c.Param("id"), req.URL, userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("update url error: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, revision)
+}
+
+func (s *SrvHandler) URLHistoryHandler(c echo.Context) error {
+	history, err := s.URLHistory(c.Param("id"), userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("get url history error: %w", err)
+	}
+
+	if history == nil {
+		history = []models.URLRevision{}
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
+
+func (s *SrvHandler) RevertURLHandler(c echo.Context) error {
+	var req models.RevertURLRequest
+
+	err := json.NewDecoder(c.Request().Body).Decode(&req)
+	if err != nil {
+		return invalidBody(fmt.Errorf("decode request error: %w", err))
+	}
+
+	revision, err := s.RevertURL(c.Param("id"), req.Revision, userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("revert url error: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, revision)
+}
+
+func (s *SrvHandler) URLRulesHandler(c echo.Context) error {
+	redirectRules, err := s.URLRules(c.Param("id"), userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("get url rules error: %w", err)
+	}
+
+	if redirectRules == nil {
+		redirectRules = []models.RedirectRule{}
+	}
+
+	return c.JSON(http.StatusOK, models.RedirectRules{Rules: redirectRules})
+}
+
+func (s *SrvHandler) SetURLRulesHandler(c echo.Context) error {
+	var req models.RedirectRules
+
+	err := json.NewDecoder(c.Request().Body).Decode(&req)
+	if err != nil {
+		return invalidBody(fmt.Errorf("decode request error: %w", err))
+	}
+
+	err = s.SetURLRules(c.Param("id"), req.Rules, userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("set url rules error: %w", err)
+	}
+
+	if req.Rules == nil {
+		req.Rules = []models.RedirectRule{}
+	}
+
+	return c.JSON(http.StatusOK, req)
+}
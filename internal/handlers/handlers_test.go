@@ -3,24 +3,35 @@ package handlers
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image/png"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 
 	"github.com/pluhe7/shortener/config"
 	"github.com/pluhe7/shortener/internal/app"
+	"github.com/pluhe7/shortener/internal/logger"
 	"github.com/pluhe7/shortener/internal/models"
 	"github.com/pluhe7/shortener/internal/storage"
 	"github.com/pluhe7/shortener/internal/storage/mocks"
@@ -33,6 +44,67 @@ var testConfig = config.Config{
 
 const idLen = 8
 
+// callHandler вызывает обработчик без маршрутизации и, как echo, отвечает на его ошибку через HTTPErrorHandler
+func callHandler(c echo.Context, handler echo.HandlerFunc) {
+	err := handler(c)
+	if err != nil {
+		HTTPErrorHandler(err, c)
+	}
+}
+
+// testServer - сервер с обработчиками, которому тесты отправляют запросы через маршрутизацию
+type testServer struct {
+	*app.Server
+}
+
+// newTestServer создаёт сервер с обработчиками и останавливает его фоновые задачи по завершении теста
+func newTestServer(t *testing.T, cfg *config.Config) *testServer {
+	srv := app.NewServer(cfg)
+	t.Cleanup(srv.Stop)
+	InitHandlers(srv)
+
+	return &testServer{Server: srv}
+}
+
+// serve отправляет запрос с телом JSON; cookie, равные nil, пропускаются
+func (s *testServer) serve(method, target, body string, cookies ...*http.Cookie) *http.Response {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	for _, cookie := range cookies {
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+	}
+
+	return s.serveRequest(request)
+}
+
+// serveRequest отправляет подготовленный запрос, например с особыми заголовками или адресом клиента
+func (s *testServer) serveRequest(request *http.Request) *http.Response {
+	responseRecorder := httptest.NewRecorder()
+	s.Echo.ServeHTTP(responseRecorder, request)
+
+	return responseRecorder.Result()
+}
+
+// shorten сокращает URL запросом к /api/shorten и возвращает id ссылки и cookie пользователя:
+// переданную или, если её нет, выданную сервером
+func (s *testServer) shorten(t *testing.T, body string, cookie *http.Cookie) (string, *http.Cookie) {
+	result := s.serve(http.MethodPost, "/api/shorten", body, cookie)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+
+	var resp models.ShortenResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&resp))
+
+	if cookies := result.Cookies(); cookie == nil && len(cookies) > 0 {
+		cookie = cookies[0]
+	}
+
+	return strings.TrimPrefix(resp.Result, s.Config.BaseURL+"/"), cookie
+}
+
 func TestExpandHandler(t *testing.T) {
 	type want struct {
 		statusCode       int
@@ -83,7 +155,7 @@ func TestExpandHandler(t *testing.T) {
 		},
 		{
 			name: "wrong id len",
-			id:   "tooLongId",
+			id:   strings.Repeat("tooLongId", 4),
 			want: want{
 				statusCode: http.StatusBadRequest,
 				resp:       "expand url error: invalid url id",
@@ -93,8 +165,8 @@ func TestExpandHandler(t *testing.T) {
 			name: "not existing id",
 			id:   "notEx9",
 			want: want{
-				statusCode: http.StatusBadRequest,
-				resp:       "expand url error: invalid url id",
+				statusCode: http.StatusNotFound,
+				resp:       "expand url error: url does not exist",
 			},
 		},
 	}
@@ -109,7 +181,7 @@ func TestExpandHandler(t *testing.T) {
 			c.SetParamNames("id")
 			c.SetParamValues(test.id)
 
-			srvHandler.ExpandHandler(c)
+			callHandler(c, srvHandler.ExpandHandler)
 
 			expandResult := expandResponseRecorder.Result()
 			expandResultBody, err := io.ReadAll(expandResult.Body)
@@ -175,7 +247,7 @@ func TestShortenHandler(t *testing.T) {
 
 			c := srv.Echo.NewContext(request, responseRecorder)
 
-			srvHandler.ShortenHandler(c)
+			callHandler(c, srvHandler.ShortenHandler)
 
 			result := responseRecorder.Result()
 
@@ -230,7 +302,7 @@ func TestAPIShortenHandlerMemoryStorage(t *testing.T) {
 			url:       "",
 			want: want{
 				statusCode:  http.StatusBadRequest,
-				contentType: "",
+				contentType: mimeProblemJSON,
 				resp:        "shorten url error: url shouldn't be empty",
 			},
 		},
@@ -258,7 +330,7 @@ func TestAPIShortenHandlerMemoryStorage(t *testing.T) {
 
 			c := srv.Echo.NewContext(request, responseRecorder)
 
-			srvHandler.APIShortenHandler(c)
+			callHandler(c, srvHandler.APIShortenHandler)
 
 			result := responseRecorder.Result()
 
@@ -273,11 +345,12 @@ func TestAPIShortenHandlerMemoryStorage(t *testing.T) {
 				assert.Regexp(t, test.want.resp, resp.Result)
 
 			} else {
-				resultBody, err := io.ReadAll(result.Body)
-				defer result.Body.Close()
+				var problem models.Problem
+				err = json.NewDecoder(result.Body).Decode(&problem)
 				require.NoError(t, err)
 
-				assert.Equal(t, test.want.resp, string(resultBody))
+				assert.Equal(t, "empty_url", problem.Code)
+				assert.Equal(t, test.want.resp, problem.Detail)
 			}
 		})
 	}
@@ -327,7 +400,7 @@ func TestAPIShortenHandlerFileStorage(t *testing.T) {
 			url:       "",
 			want: want{
 				statusCode:  http.StatusBadRequest,
-				contentType: "",
+				contentType: mimeProblemJSON,
 				resp:        "shorten url error: url shouldn't be empty",
 			},
 		},
@@ -350,7 +423,7 @@ func TestAPIShortenHandlerFileStorage(t *testing.T) {
 
 			c := srv.Echo.NewContext(request, responseRecorder)
 
-			srvHandler.APIShortenHandler(c)
+			callHandler(c, srvHandler.APIShortenHandler)
 
 			result := responseRecorder.Result()
 
@@ -365,11 +438,12 @@ func TestAPIShortenHandlerFileStorage(t *testing.T) {
 				assert.Regexp(t, test.want.resp, resp.Result)
 
 			} else {
-				resultBody, err := io.ReadAll(result.Body)
-				defer result.Body.Close()
+				var problem models.Problem
+				err = json.NewDecoder(result.Body).Decode(&problem)
 				require.NoError(t, err)
 
-				assert.Equal(t, test.want.resp, string(resultBody))
+				assert.Equal(t, "empty_url", problem.Code)
+				assert.Equal(t, test.want.resp, problem.Detail)
 			}
 		})
 	}
@@ -400,6 +474,7 @@ func TestAPIShortenHandlerFileStorage(t *testing.T) {
 func TestPingDBHandler(t *testing.T) {
 	type want struct {
 		statusCode int
+		status     string
 	}
 
 	tests := []struct {
@@ -413,6 +488,7 @@ func TestPingDBHandler(t *testing.T) {
 			withError: false,
 			want: want{
 				statusCode: http.StatusOK,
+				status:     models.HealthStatusOK,
 			},
 		},
 		{
@@ -420,6 +496,7 @@ func TestPingDBHandler(t *testing.T) {
 			withError: true,
 			want: want{
 				statusCode: http.StatusInternalServerError,
+				status:     models.HealthStatusUnavailable,
 			},
 		},
 	}
@@ -446,12 +523,26 @@ func TestPingDBHandler(t *testing.T) {
 
 			c := srv.Echo.NewContext(request, responseRecorder)
 
-			srvHandler.PingDatabaseHandler(c)
+			callHandler(c, srvHandler.PingDatabaseHandler)
 
 			result := responseRecorder.Result()
 			defer result.Body.Close()
 
 			assert.Equal(t, test.want.statusCode, result.StatusCode)
+
+			var resp models.HealthResponse
+			err := json.NewDecoder(result.Body).Decode(&resp)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.want.status, resp.Status)
+
+			if test.withError {
+				assert.Equal(t, "internal_error", resp.Code)
+				assert.Equal(t, http.StatusText(http.StatusInternalServerError), resp.Error)
+			} else {
+				assert.Empty(t, resp.Code)
+				assert.Empty(t, resp.Error)
+			}
 		})
 	}
 }
@@ -503,7 +594,7 @@ func TestAPIBatchShortenHandler(t *testing.T) {
 			req:       "something",
 			want: want{
 				statusCode:  http.StatusBadRequest,
-				contentType: echo.MIMETextPlain,
+				contentType: mimeProblemJSON,
 				resp:        "decode request error",
 			},
 		},
@@ -522,8 +613,8 @@ func TestAPIBatchShortenHandler(t *testing.T) {
 			]`,
 			want: want{
 				statusCode:  http.StatusInternalServerError,
-				contentType: echo.MIMETextPlain,
-				resp:        "some error",
+				contentType: mimeProblemJSON,
+				resp:        `"code":"internal_error"`,
 			},
 		},
 	}
@@ -540,9 +631,9 @@ func TestAPIBatchShortenHandler(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			if test.withError {
-				mockStorage.EXPECT().SaveBatch(gomock.Any()).Return(errors.New("some error")).AnyTimes()
+				mockStorage.EXPECT().SaveBatchWithReport(gomock.Any(), gomock.Any()).Return(nil, errors.New("some error")).AnyTimes()
 			} else {
-				mockStorage.EXPECT().SaveBatch(gomock.Any()).Return(nil)
+				mockStorage.EXPECT().SaveBatchWithReport(gomock.Any(), gomock.Any()).Return(&models.BatchReport{}, nil)
 			}
 
 			request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", bytes.NewReader([]byte(test.req)))
@@ -550,7 +641,7 @@ func TestAPIBatchShortenHandler(t *testing.T) {
 
 			c := srv.Echo.NewContext(request, responseRecorder)
 
-			srvHandler.APIBatchShortenHandler(c)
+			callHandler(c, srvHandler.APIBatchShortenHandler)
 
 			result := responseRecorder.Result()
 
@@ -589,3 +680,2060 @@ func TestAPIBatchShortenHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestShortenHandlerStorageUnavailable(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	mockStorage := mocks.NewMockStorage(mockController)
+	mockStorage.EXPECT().Save(gomock.Any()).Return(&storage.UnavailableError{
+		RetryAfter: 1500 * time.Millisecond,
+		Err:        errors.New("circuit breaker is open"),
+	})
+
+	srv := app.NewServer(&testConfig)
+	srv.Storage = mockStorage
+	srvHandler := SrvHandler{srv}
+
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://yandex.ru"))
+	responseRecorder := httptest.NewRecorder()
+
+	c := srv.Echo.NewContext(request, responseRecorder)
+
+	callHandler(c, srvHandler.ShortenHandler)
+
+	result := responseRecorder.Result()
+	defer result.Body.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, result.StatusCode)
+	assert.Equal(t, "2", result.Header.Get(echo.HeaderRetryAfter))
+}
+
+func TestImportStorageConflict(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	// строки не ищутся в хранилище по одной: о существующих записях сообщает отчёт сохранения пачки,
+	// и только для пропущенных строк выясняется причина
+	mockStorage := mocks.NewMockStorage(mockController)
+	gomock.InOrder(
+		mockStorage.EXPECT().SaveBatchWithReport(gomock.Any(), gomock.Len(3)).DoAndReturn(
+			func(_ context.Context, records []models.ShortURLRecord) (*models.BatchReport, error) {
+				return &models.BatchReport{
+					Inserted:   []string{records[2].ShortURL},
+					Conflicted: []string{records[0].ShortURL, records[1].ShortURL},
+				}, nil
+			}),
+		mockStorage.EXPECT().GetByOriginal("https://yandex.ru").Return("abcdefgh", nil),
+		mockStorage.EXPECT().GetByOriginal("https://ya.ru").Return("", storage.ErrURLNotFound),
+	)
+
+	srv := app.NewServer(&testConfig)
+	srv.Storage = mockStorage
+	srvHandler := SrvHandler{srv}
+
+	request := httptest.NewRequest(http.MethodPost, "/api/import",
+		strings.NewReader("https://yandex.ru\nhttps://ya.ru,taken-alias\nhttps://google.com\n"))
+	request.Header.Set(echo.HeaderContentType, "text/csv")
+	responseRecorder := httptest.NewRecorder()
+
+	c := srv.Echo.NewContext(request, responseRecorder)
+
+	callHandler(c, srvHandler.ImportHandler)
+
+	result := responseRecorder.Result()
+	defer result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+
+	var results []models.ImportResult
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&results))
+
+	require.Len(t, results, 3)
+
+	assert.Equal(t, models.ImportExists, results[0].Status)
+	assert.Equal(t, testConfig.BaseURL+"/abcdefgh", results[0].ShortURL)
+
+	assert.Equal(t, models.ImportFailed, results[1].Status)
+	assert.Empty(t, results[1].ShortURL)
+	assert.Equal(t, "alias_taken", results[1].Code)
+
+	assert.Equal(t, models.ImportCreated, results[2].Status)
+	assert.NotEmpty(t, results[2].ShortURL)
+}
+
+func TestHTTPErrorHandler(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	mockStorage := mocks.NewMockStorage(mockController)
+
+	srv := app.NewServer(&testConfig)
+	srv.Storage = mockStorage
+	InitHandlers(srv)
+
+	core, logs := observer.New(zap.WarnLevel)
+	defaultLog := logger.Log
+	logger.Log = zap.New(core)
+	defer func() { logger.Log = defaultLog }()
+
+	serve := func(method, target, requestID string) (*http.Response, []byte) {
+		request := httptest.NewRequest(method, target, nil)
+		if requestID != "" {
+			request.Header.Set(echo.HeaderXRequestID, requestID)
+		}
+
+		responseRecorder := httptest.NewRecorder()
+		srv.Echo.ServeHTTP(responseRecorder, request)
+
+		result := responseRecorder.Result()
+		defer result.Body.Close()
+
+		body, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+
+		return result, body
+	}
+
+	decodeProblem := func(t *testing.T, result *http.Response, body []byte) models.Problem {
+		assert.Equal(t, mimeProblemJSON, result.Header.Get(echo.HeaderContentType))
+
+		var problem models.Problem
+		require.NoError(t, json.Unmarshal(body, &problem))
+
+		assert.Equal(t, result.StatusCode, problem.Status)
+		assert.Equal(t, http.StatusText(result.StatusCode), problem.Title)
+
+		return problem
+	}
+
+	t.Run("internal error is hidden and logged", func(t *testing.T) {
+		mockStorage.EXPECT().Get("abcdefgh").Return(models.ShortURLRecord{}, errors.New(`pq: relation "urls" does not exist`))
+
+		result, body := serve(http.MethodGet, "/api/urls/abcdefgh", "req-42")
+
+		assert.Equal(t, http.StatusInternalServerError, result.StatusCode)
+		assert.Equal(t, "req-42", result.Header.Get(echo.HeaderXRequestID))
+		assert.NotContains(t, string(body), "relation")
+
+		problem := decodeProblem(t, result, body)
+		assert.Equal(t, "internal_error", problem.Code)
+		assert.Empty(t, problem.Detail)
+		assert.Equal(t, "/api/urls/abcdefgh", problem.Instance)
+		assert.Equal(t, "req-42", problem.RequestID)
+
+		entries := logs.FilterMessage("internal error").FilterField(zap.String("request_id", "req-42")).All()
+		require.Len(t, entries, 1)
+		assert.Contains(t, entries[0].ContextMap()["error"], "relation")
+	})
+
+	t.Run("client error has code and detail", func(t *testing.T) {
+		mockStorage.EXPECT().Get("abcdefgh").Return(models.ShortURLRecord{}, storage.ErrURLNotFound)
+
+		result, body := serve(http.MethodGet, "/api/urls/abcdefgh", "")
+
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+
+		problem := decodeProblem(t, result, body)
+		assert.Equal(t, "url_not_found", problem.Code)
+		assert.Equal(t, "preview url error: url does not exist", problem.Detail)
+		assert.NotEmpty(t, problem.RequestID)
+		assert.Equal(t, problem.RequestID, result.Header.Get(echo.HeaderXRequestID))
+	})
+
+	t.Run("storage unavailable", func(t *testing.T) {
+		mockStorage.EXPECT().Get("abcdefgh").Return(models.ShortURLRecord{}, &storage.UnavailableError{
+			RetryAfter: 3 * time.Second,
+			Err:        errors.New("circuit breaker is open"),
+		})
+
+		result, body := serve(http.MethodGet, "/api/urls/abcdefgh", "")
+
+		assert.Equal(t, http.StatusServiceUnavailable, result.StatusCode)
+		assert.Equal(t, "3", result.Header.Get(echo.HeaderRetryAfter))
+
+		problem := decodeProblem(t, result, body)
+		assert.Equal(t, "storage_unavailable", problem.Code)
+		assert.Equal(t, storage.ErrStorageUnavailable.Error(), problem.Detail)
+	})
+
+	t.Run("echo error", func(t *testing.T) {
+		result, body := serve(http.MethodPatch, "/api/webhooks", "bad id\n")
+
+		assert.Equal(t, http.StatusMethodNotAllowed, result.StatusCode)
+		assert.NotEqual(t, "bad id\n", result.Header.Get(echo.HeaderXRequestID))
+
+		problem := decodeProblem(t, result, body)
+		assert.Equal(t, "method_not_allowed", problem.Code)
+	})
+
+	t.Run("plain text outside api", func(t *testing.T) {
+		mockStorage.EXPECT().Get("abcdefgh").Return(models.ShortURLRecord{}, errors.New("connection refused"))
+
+		result, body := serve(http.MethodGet, "/abcdefgh", "")
+
+		assert.Equal(t, http.StatusInternalServerError, result.StatusCode)
+		assert.Contains(t, result.Header.Get(echo.HeaderContentType), echo.MIMETextPlain)
+		assert.Equal(t, http.StatusText(http.StatusInternalServerError), string(body))
+	})
+}
+
+func TestDeleteURLHandler(t *testing.T) {
+	srv := newTestServer(t, &testConfig)
+
+	id, cookie := srv.shorten(t, `{"url":"https://yandex.ru"}`, nil)
+
+	deleteRequest := func(id string, cookie *http.Cookie) *http.Request {
+		request := httptest.NewRequest(http.MethodDelete, "/api/urls/"+id, nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+
+		return request
+	}
+
+	t.Run("anonymous", func(t *testing.T) {
+		result := srv.serveRequest(deleteRequest(id, nil))
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, result.StatusCode)
+	})
+
+	t.Run("another user", func(t *testing.T) {
+		otherToken := srv.Auth.BuildToken("another-user")
+
+		request := deleteRequest(id, nil)
+		request.Header.Set(echo.HeaderAuthorization, "Bearer "+otherToken)
+
+		result := srv.serveRequest(request)
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusForbidden, result.StatusCode)
+	})
+
+	t.Run("unknown id", func(t *testing.T) {
+		result := srv.serveRequest(deleteRequest("zzzzzzzz", cookie))
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			result := srv.serveRequest(deleteRequest(id, cookie))
+			result.Body.Close()
+
+			assert.Equal(t, http.StatusNoContent, result.StatusCode)
+		}
+	})
+
+	t.Run("deleted url is gone", func(t *testing.T) {
+		result := srv.serveRequest(httptest.NewRequest(http.MethodGet, "/"+id, nil))
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusGone, result.StatusCode)
+	})
+
+	t.Run("unknown url is not found", func(t *testing.T) {
+		result := srv.serveRequest(httptest.NewRequest(http.MethodGet, "/zzzzzzzz", nil))
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	})
+}
+
+func TestUpdateURLHandler(t *testing.T) {
+	srv := newTestServer(t, &testConfig)
+
+	id, cookie := srv.shorten(t, `{"url":"https://yandex.ru/typo"}`, nil)
+	otherID, _ := srv.shorten(t, `{"url":"https://google.com"}`, nil)
+
+	tests := []struct {
+		name       string
+		id         string
+		body       string
+		cookie     *http.Cookie
+		statusCode int
+	}{
+		{name: "anonymous", id: id, body: `{"url":"https://yandex.ru"}`, statusCode: http.StatusUnauthorized},
+		{name: "invalid url", id: id, body: `{"url":"yandex.ru"}`, cookie: cookie, statusCode: http.StatusBadRequest},
+		{name: "not owner", id: otherID, body: `{"url":"https://yandex.ru"}`, cookie: cookie, statusCode: http.StatusForbidden},
+		{name: "unknown id", id: "zzzzzzzz", body: `{"url":"https://yandex.ru"}`, cookie: cookie, statusCode: http.StatusNotFound},
+		{name: "duplicate url", id: id, body: `{"url":"https://google.com"}`, cookie: cookie, statusCode: http.StatusConflict},
+		{name: "fixed", id: id, body: `{"url":"https://yandex.ru"}`, cookie: cookie, statusCode: http.StatusOK},
+		{name: "unchanged", id: id, body: `{"url":"https://yandex.ru"}`, cookie: cookie, statusCode: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := srv.serve(http.MethodPatch, "/api/urls/"+test.id, test.body, test.cookie)
+			defer result.Body.Close()
+
+			assert.Equal(t, test.statusCode, result.StatusCode)
+		})
+	}
+
+	expand := func() string {
+		result := srv.serve(http.MethodGet, "/"+id, "")
+		defer result.Body.Close()
+
+		return result.Header.Get("Location")
+	}
+
+	assert.Equal(t, "https://yandex.ru", expand())
+
+	t.Run("history", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/api/urls/"+id+"/history", "", cookie)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var history []models.URLRevision
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&history))
+
+		require.Len(t, history, 1)
+		assert.Equal(t, 1, history[0].Revision)
+		assert.Equal(t, "https://yandex.ru/typo", history[0].PreviousURL)
+		assert.Equal(t, "https://yandex.ru", history[0].OriginalURL)
+		assert.NotEmpty(t, history[0].Editor)
+		assert.False(t, history[0].ChangedAt.IsZero())
+	})
+
+	t.Run("revert", func(t *testing.T) {
+		result := srv.serve(http.MethodPost, "/api/urls/"+id+"/revert", `{"revision":5}`, cookie)
+		result.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+		result = srv.serve(http.MethodPost, "/api/urls/"+id+"/revert", `{"revision":0}`, cookie)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var revision models.URLRevision
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&revision))
+		assert.Equal(t, 2, revision.Revision)
+
+		assert.Equal(t, "https://yandex.ru/typo", expand())
+	})
+}
+
+func TestPasswordProtectedURL(t *testing.T) {
+	cfg := testConfig
+	cfg.UnlockTTL = time.Minute
+	cfg.PasswordMaxAttempts = 2
+	cfg.PasswordLockout = time.Minute
+
+	srv := newTestServer(t, &cfg)
+
+	id, _ := srv.shorten(t, `{"url":"https://yandex.ru/private","password":"secret"}`, nil)
+
+	unlockForwarded := func(password, remoteAddr, forwardedFor string) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, "/"+id, strings.NewReader("password="+password))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		request.RemoteAddr = remoteAddr
+
+		if forwardedFor != "" {
+			request.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+			request.Header.Set(echo.HeaderXRealIP, forwardedFor)
+		}
+
+		return srv.serveRequest(request)
+	}
+
+	unlock := func(password, remoteAddr string) *http.Response {
+		return unlockForwarded(password, remoteAddr, "")
+	}
+
+	t.Run("form is served instead of redirect", func(t *testing.T) {
+		result := srv.serveRequest(httptest.NewRequest(http.MethodGet, "/"+id, nil))
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Contains(t, result.Header.Get(echo.HeaderContentType), echo.MIMETextHTML)
+		assert.Empty(t, result.Header.Get(echo.HeaderLocation))
+
+		body, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `action="/`+id+`"`)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		result := unlock("wrong", "192.0.2.1:1234")
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusForbidden, result.StatusCode)
+		assert.Empty(t, result.Cookies())
+	})
+
+	t.Run("correct password unlocks", func(t *testing.T) {
+		result := unlock("secret", "192.0.2.1:1234")
+		defer result.Body.Close()
+
+		require.Equal(t, http.StatusSeeOther, result.StatusCode)
+		assert.Equal(t, "https://yandex.ru/private", result.Header.Get(echo.HeaderLocation))
+
+		cookies := result.Cookies()
+		require.Len(t, cookies, 1)
+
+		request := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+		request.AddCookie(cookies[0])
+
+		expandResult := srv.serveRequest(request)
+		defer expandResult.Body.Close()
+
+		assert.Equal(t, http.StatusTemporaryRedirect, expandResult.StatusCode)
+		assert.Equal(t, "https://yandex.ru/private", expandResult.Header.Get(echo.HeaderLocation))
+
+		forged := *cookies[0]
+		forged.Value = srv.Auth.SignExpiring("unlock:"+id, time.Now().Add(-time.Second))
+
+		request = httptest.NewRequest(http.MethodGet, "/"+id, nil)
+		request.AddCookie(&forged)
+
+		expiredResult := srv.serveRequest(request)
+		defer expiredResult.Body.Close()
+
+		assert.Equal(t, http.StatusOK, expiredResult.StatusCode)
+	})
+
+	t.Run("wrong attempts are throttled", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			result := unlock("wrong", "192.0.2.2:1234")
+			result.Body.Close()
+			assert.Equal(t, http.StatusForbidden, result.StatusCode)
+		}
+
+		result := unlock("secret", "192.0.2.2:1234")
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusTooManyRequests, result.StatusCode)
+		assert.NotEmpty(t, result.Header.Get(echo.HeaderRetryAfter))
+
+		otherClientResult := unlock("secret", "192.0.2.3:1234")
+		defer otherClientResult.Body.Close()
+
+		assert.Equal(t, http.StatusSeeOther, otherClientResult.StatusCode)
+	})
+
+	t.Run("forwarded headers do not reset attempts", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			result := unlockForwarded("wrong", "192.0.2.4:1234", fmt.Sprintf("198.51.100.%d", i+1))
+			result.Body.Close()
+			assert.Equal(t, http.StatusForbidden, result.StatusCode)
+		}
+
+		result := unlockForwarded("secret", "192.0.2.4:1234", "198.51.100.3")
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusTooManyRequests, result.StatusCode)
+	})
+	unlockTarget := func(target, password, acceptLanguage string) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, target, strings.NewReader("password="+password))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		request.Header.Set("Accept-Language", acceptLanguage)
+		request.RemoteAddr = "192.0.2.5:1234"
+
+		return srv.serveRequest(request)
+	}
+
+	t.Run("form keeps path and query", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/"+id+"/docs?utm=1", "")
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		body, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `action="/`+id+`/docs?utm=1"`)
+	})
+
+	t.Run("unlock applies rules and passthrough", func(t *testing.T) {
+		rulesID, cookie := srv.shorten(t, `{"url":"https://yandex.ru/app","password":"secret","redirect_status":302,"query_passthrough":true}`, nil)
+
+		result := srv.serve(http.MethodPut, "/api/urls/"+rulesID+"/rules", `{"rules":[{"languages":["ru"],"url":"https://yandex.ru/ru/app"}]}`, cookie)
+		result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		result = unlockTarget("/"+rulesID+"?utm=1", "secret", "ru-RU")
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusFound, result.StatusCode)
+		assert.Equal(t, "https://yandex.ru/ru/app?utm=1", result.Header.Get(echo.HeaderLocation))
+		assert.Equal(t, "no-store", result.Header.Get(echo.HeaderCacheControl))
+	})
+
+	t.Run("unlock applies split", func(t *testing.T) {
+		splitID, cookie := srv.shorten(t, `{"url":"https://yandex.ru/landing","password":"secret"}`, nil)
+
+		result := srv.serve(http.MethodPut, "/api/urls/"+splitID+"/split",
+			`{"targets":[{"url":"https://yandex.ru/a","weight":1},{"url":"https://yandex.ru/b","weight":1}],"sticky":true}`, cookie)
+		result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		result = unlockTarget("/"+splitID, "secret", "")
+		defer result.Body.Close()
+
+		require.Equal(t, http.StatusSeeOther, result.StatusCode)
+		assert.Contains(t, []string{"https://yandex.ru/a", "https://yandex.ru/b"}, result.Header.Get(echo.HeaderLocation))
+
+		var cookieNames []string
+		for _, resultCookie := range result.Cookies() {
+			cookieNames = append(cookieNames, resultCookie.Name)
+		}
+		assert.ElementsMatch(t, []string{"unlock_" + splitID, "variant_" + splitID}, cookieNames)
+	})
+
+	t.Run("unlock before the schedule window redirects to the fallback", func(t *testing.T) {
+		future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		scheduledID, _ := srv.shorten(t, fmt.Sprintf(`{"url":"https://yandex.ru/sale","password":"secret","not_before":%q,"fallback_url":"https://yandex.ru/soon"}`, future), nil)
+
+		result := unlockTarget("/"+scheduledID, "wrong", "")
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusSeeOther, result.StatusCode)
+		assert.Equal(t, "https://yandex.ru/soon", result.Header.Get(echo.HeaderLocation))
+	})
+}
+
+func TestTrustedProxies(t *testing.T) {
+	cfg := testConfig
+	cfg.TrustedProxies = []string{"192.0.2.0/24"}
+
+	srv := app.NewServer(&cfg)
+	defer srv.Stop()
+
+	var realIP string
+	srv.Echo.GET("/ip", func(c echo.Context) error {
+		realIP = c.RealIP()
+		return c.NoContent(http.StatusOK)
+	})
+
+	for _, tt := range []struct {
+		name       string
+		remoteAddr string
+		want       string
+	}{
+		{name: "trusted proxy", remoteAddr: "192.0.2.1:1234", want: "203.0.113.7"},
+		{name: "untrusted client", remoteAddr: "198.51.100.1:1234", want: "198.51.100.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/ip", nil)
+			request.RemoteAddr = tt.remoteAddr
+			request.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+
+			srv.Echo.ServeHTTP(httptest.NewRecorder(), request)
+
+			assert.Equal(t, tt.want, realIP)
+		})
+	}
+}
+
+func TestPreviewHandler(t *testing.T) {
+	srv := newTestServer(t, &testConfig)
+
+	id, _ := srv.shorten(t, `{"url":"https://yandex.ru/maps"}`, nil)
+	protectedID, _ := srv.shorten(t, `{"url":"https://yandex.ru/private","password":"secret"}`, nil)
+
+	for i := 0; i < 2; i++ {
+		result := srv.serve(http.MethodGet, "/"+id, "")
+		result.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, result.StatusCode)
+	}
+
+	for _, target := range []string{"/" + id + "+", "/" + id + "?preview=1"} {
+		t.Run("page "+target, func(t *testing.T) {
+			result := srv.serve(http.MethodGet, target, "")
+			defer result.Body.Close()
+
+			require.Equal(t, http.StatusOK, result.StatusCode)
+			assert.Contains(t, result.Header.Get(echo.HeaderContentType), echo.MIMETextHTML)
+
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			assert.Contains(t, string(body), "https://yandex.ru/maps")
+			assert.Contains(t, string(body), "<dd>2</dd>")
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/api/urls/"+id, "")
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var preview models.URLPreview
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&preview))
+
+		assert.Equal(t, testConfig.BaseURL+"/"+id, preview.ShortURL)
+		assert.Equal(t, "https://yandex.ru/maps", preview.OriginalURL)
+		assert.Equal(t, "yandex.ru", preview.Domain)
+		assert.Equal(t, int64(2), preview.Clicks)
+		assert.NotNil(t, preview.CreatedAt)
+		assert.False(t, preview.Protected)
+	})
+
+	t.Run("protected destination is hidden", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/api/urls/"+protectedID, "")
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var preview models.URLPreview
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&preview))
+
+		assert.True(t, preview.Protected)
+		assert.Empty(t, preview.OriginalURL)
+		assert.Empty(t, preview.Domain)
+	})
+
+	t.Run("destination is hidden before the schedule window", func(t *testing.T) {
+		future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		upcomingID, _ := srv.shorten(t, fmt.Sprintf(`{"url":"https://yandex.ru/launch","not_before":%q}`, future), nil)
+
+		result := srv.serve(http.MethodGet, "/api/urls/"+upcomingID, "")
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var preview models.URLPreview
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&preview))
+
+		assert.NotNil(t, preview.NotBefore)
+		assert.Empty(t, preview.OriginalURL)
+		assert.Empty(t, preview.Domain)
+		assert.Nil(t, preview.Metadata)
+
+		result = srv.serve(http.MethodGet, "/"+upcomingID+"+", "")
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		body, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		assert.NotContains(t, string(body), "https://yandex.ru/launch")
+	})
+
+	t.Run("unknown id", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/zzzzzzzz+", "")
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	})
+
+	t.Run("assets", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/assets/style.css", "")
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+	})
+}
+
+func TestExpandHandlerRedirectStatus(t *testing.T) {
+	cfg := testConfig
+	cfg.RedirectStatus = http.StatusFound
+	cfg.PermanentRedirectMaxAge = time.Hour
+
+	srv := newTestServer(t, &cfg)
+
+	t.Run("invalid status", func(t *testing.T) {
+		result := srv.serve(http.MethodPost, "/api/shorten", `{"url":"https://yandex.ru","redirect_status":200}`)
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	})
+
+	tests := []struct {
+		name         string
+		body         string
+		statusCode   int
+		cacheControl string
+	}{
+		{
+			name:         "server default",
+			body:         `{"url":"https://yandex.ru/legacy"}`,
+			statusCode:   http.StatusFound,
+			cacheControl: "no-store",
+		},
+		{
+			name:         "moved permanently",
+			body:         `{"url":"https://yandex.ru/seo","redirect_status":301}`,
+			statusCode:   http.StatusMovedPermanently,
+			cacheControl: "public, max-age=3600",
+		},
+		{
+			name:         "permanent redirect",
+			body:         `{"url":"https://yandex.ru/seo2","redirect_status":308}`,
+			statusCode:   http.StatusPermanentRedirect,
+			cacheControl: "public, max-age=3600",
+		},
+		{
+			name:         "temporary redirect",
+			body:         `{"url":"https://yandex.ru/tmp","redirect_status":307}`,
+			statusCode:   http.StatusTemporaryRedirect,
+			cacheControl: "no-store",
+		},
+		{
+			name:         "permanent with broken fallback",
+			body:         `{"url":"https://yandex.ru/seo3","redirect_status":301,"broken_fallback_url":"https://yandex.ru/backup"}`,
+			statusCode:   http.StatusMovedPermanently,
+			cacheControl: "no-store",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, _ := srv.shorten(t, test.body, nil)
+
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				result := srv.serve(method, "/"+id, "")
+				result.Body.Close()
+
+				assert.Equal(t, test.statusCode, result.StatusCode, method)
+				assert.Equal(t, test.cacheControl, result.Header.Get(echo.HeaderCacheControl), method)
+				assert.NotEmpty(t, result.Header.Get(echo.HeaderLocation), method)
+			}
+
+			clicks, err := srv.Storage.ClickCount(id)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), clicks, "head requests should not be counted")
+		})
+	}
+
+	t.Run("permanent with schedule", func(t *testing.T) {
+		id, _ := srv.shorten(t, `{"url":"https://yandex.ru/seo4","redirect_status":308}`, nil)
+
+		notAfter := time.Now().Add(time.Hour)
+		require.NoError(t, srv.Storage.SetSchedule(id, models.Schedule{NotAfter: &notAfter}))
+
+		result := srv.serve(http.MethodGet, "/"+id, "")
+		result.Body.Close()
+
+		assert.Equal(t, http.StatusPermanentRedirect, result.StatusCode)
+		assert.Equal(t, "no-store", result.Header.Get(echo.HeaderCacheControl))
+	})
+}
+
+func TestExpandHandlerPassthrough(t *testing.T) {
+	cfg := testConfig
+	cfg.QueryConflictPolicy = "keep"
+
+	srv := newTestServer(t, &cfg)
+
+	t.Run("invalid policy", func(t *testing.T) {
+		result := srv.serve(http.MethodPost, "/api/shorten",
+			`{"url":"https://yandex.ru","query_passthrough":true,"query_conflict_policy":"merge"}`)
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	})
+
+	plainID, _ := srv.shorten(t, `{"url":"https://yandex.ru/plain?a=1"}`, nil)
+	keepID, _ := srv.shorten(t, `{"url":"https://yandex.ru/keep?a=1","query_passthrough":true}`, nil)
+	replaceID, _ := srv.shorten(t, `{"url":"https://yandex.ru/replace?a=1","query_passthrough":true,"query_conflict_policy":"replace"}`, nil)
+	appendID, _ := srv.shorten(t, `{"url":"https://yandex.ru/append?a=1","query_passthrough":true,"query_conflict_policy":"append"}`, nil)
+	pathID, _ := srv.shorten(t, `{"url":"https://yandex.ru/docs/?a=1","path_passthrough":true,"query_passthrough":true}`, nil)
+
+	tests := []struct {
+		name       string
+		target     string
+		statusCode int
+		location   string
+	}{
+		{
+			name:       "query is dropped without passthrough",
+			target:     "/" + plainID + "?utm_source=mail",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "https://yandex.ru/plain?a=1",
+		},
+		{
+			name:       "keep policy",
+			target:     "/" + keepID + "?a=2&utm_source=mail",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "https://yandex.ru/keep?a=1&utm_source=mail",
+		},
+		{
+			name:       "replace policy",
+			target:     "/" + replaceID + "?a=2&utm_source=mail",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "https://yandex.ru/replace?a=2&utm_source=mail",
+		},
+		{
+			name:       "append policy",
+			target:     "/" + appendID + "?a=2&utm_source=mail",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "https://yandex.ru/append?a=1&a=2&utm_source=mail",
+		},
+		{
+			name:       "extra path",
+			target:     "/" + pathID + "/guide/page?b=2",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "https://yandex.ru/docs/guide/page?a=1&b=2",
+		},
+		{
+			name:       "encoded segments stay encoded",
+			target:     "/" + pathID + "/a%2Fb/c%20d/",
+			statusCode: http.StatusTemporaryRedirect,
+			location:   "https://yandex.ru/docs/a%2Fb/c%20d/?a=1",
+		},
+		{
+			name:       "dot segments are rejected",
+			target:     "/" + pathID + "/%2E%2E/admin",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "extra path without passthrough",
+			target:     "/" + keepID + "/guide",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "qr sub-path is served, not passed through",
+			target:     "/" + pathID + "/qr",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "reserved sub-path is rejected",
+			target:     "/" + pathID + "/qr/page",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := srv.serve(http.MethodGet, test.target, "")
+			result.Body.Close()
+
+			assert.Equal(t, test.statusCode, result.StatusCode)
+			assert.Equal(t, test.location, result.Header.Get(echo.HeaderLocation))
+		})
+	}
+}
+
+func TestURLRulesHandler(t *testing.T) {
+	srv := newTestServer(t, &testConfig)
+
+	id, cookie := srv.shorten(t, `{"url":"https://example.com/app"}`, nil)
+
+	redirect := func(headers map[string]string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+
+		return srv.serveRequest(request)
+	}
+
+	rulesBody := `{"rules":[
+		{"device":"ios","url":"https://apps.apple.com/app/id1"},
+		{"device":"android","url":"https://play.google.com/store/apps/details?id=app"},
+		{"languages":["ru"],"url":"https://example.com/ru/app"}
+	]}`
+
+	tests := []struct {
+		name       string
+		body       string
+		cookie     *http.Cookie
+		statusCode int
+	}{
+		{name: "anonymous", body: rulesBody, statusCode: http.StatusUnauthorized},
+		{name: "no conditions", body: `{"rules":[{"url":"https://example.com"}]}`, cookie: cookie, statusCode: http.StatusBadRequest},
+		{name: "unknown device", body: `{"rules":[{"device":"tv","url":"https://example.com"}]}`, cookie: cookie, statusCode: http.StatusBadRequest},
+		{name: "invalid url", body: `{"rules":[{"device":"ios","url":"example.com"}]}`, cookie: cookie, statusCode: http.StatusBadRequest},
+		{name: "valid", body: rulesBody, cookie: cookie, statusCode: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := srv.serve(http.MethodPut, "/api/urls/"+id+"/rules", test.body, test.cookie)
+			defer result.Body.Close()
+
+			assert.Equal(t, test.statusCode, result.StatusCode)
+		})
+	}
+
+	t.Run("get rules", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/api/urls/"+id+"/rules", "", cookie)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var resp models.RedirectRules
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&resp))
+		require.Len(t, resp.Rules, 3)
+		assert.Equal(t, "ios", resp.Rules[0].Device)
+	})
+
+	redirects := []struct {
+		name     string
+		headers  map[string]string
+		location string
+	}{
+		{
+			name:     "ios",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"},
+			location: "https://apps.apple.com/app/id1",
+		},
+		{
+			name:     "android",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 14; Pixel 8)", "Accept-Language": "ru"},
+			location: "https://play.google.com/store/apps/details?id=app",
+		},
+		{
+			name:     "language",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (X11; Linux x86_64)", "Accept-Language": "ru-RU,en;q=0.8"},
+			location: "https://example.com/ru/app",
+		},
+		{
+			name:     "default",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (X11; Linux x86_64)", "Accept-Language": "en-US"},
+			location: "https://example.com/app",
+		},
+	}
+
+	for _, test := range redirects {
+		t.Run("redirect "+test.name, func(t *testing.T) {
+			result := redirect(test.headers)
+			defer result.Body.Close()
+
+			assert.Equal(t, http.StatusTemporaryRedirect, result.StatusCode)
+			assert.Equal(t, test.location, result.Header.Get(echo.HeaderLocation))
+		})
+	}
+
+	t.Run("clear rules", func(t *testing.T) {
+		result := srv.serve(http.MethodPut, "/api/urls/"+id+"/rules", `{"rules":[]}`, cookie)
+		result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		result = redirect(map[string]string{"User-Agent": "iPhone"})
+		result.Body.Close()
+		assert.Equal(t, "https://example.com/app", result.Header.Get(echo.HeaderLocation))
+	})
+}
+
+func TestURLSplitHandler(t *testing.T) {
+	srv := newTestServer(t, &testConfig)
+
+	id, cookie := srv.shorten(t, `{"url":"https://example.com/landing"}`, nil)
+
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{name: "single target", body: `{"targets":[{"url":"https://example.com/a","weight":1}]}`, statusCode: http.StatusBadRequest},
+		{name: "zero weight", body: `{"targets":[{"url":"https://example.com/a","weight":0},{"url":"https://example.com/b","weight":1}]}`, statusCode: http.StatusBadRequest},
+		{name: "duplicate target", body: `{"targets":[{"url":"https://example.com/a","weight":1},{"url":"https://example.com/a","weight":1}]}`, statusCode: http.StatusBadRequest},
+		{name: "invalid url", body: `{"targets":[{"url":"example.com/a","weight":1},{"url":"https://example.com/b","weight":1}]}`, statusCode: http.StatusBadRequest},
+		{name: "valid", body: `{"targets":[{"url":"https://example.com/a","weight":1},{"url":"https://example.com/b","weight":1}],"sticky":true}`, statusCode: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := srv.serve(http.MethodPut, "/api/urls/"+id+"/split", test.body, cookie)
+			defer result.Body.Close()
+
+			assert.Equal(t, test.statusCode, result.StatusCode)
+		})
+	}
+
+	t.Run("traffic is split", func(t *testing.T) {
+		seen := make(map[string]int)
+
+		for i := 0; i < 100; i++ {
+			result := srv.serve(http.MethodGet, "/"+id, "")
+			result.Body.Close()
+
+			seen[result.Header.Get(echo.HeaderLocation)]++
+		}
+
+		assert.Len(t, seen, 2)
+		assert.Contains(t, seen, "https://example.com/a")
+		assert.Contains(t, seen, "https://example.com/b")
+	})
+
+	t.Run("sticky variant", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/"+id, "")
+		result.Body.Close()
+
+		location := result.Header.Get(echo.HeaderLocation)
+		assert.Equal(t, "no-store", result.Header.Get(echo.HeaderCacheControl))
+
+		var variantCookie *http.Cookie
+		for _, resultCookie := range result.Cookies() {
+			if resultCookie.Name == "variant_"+id {
+				variantCookie = resultCookie
+			}
+		}
+		require.NotNil(t, variantCookie)
+
+		for i := 0; i < 20; i++ {
+			result := srv.serve(http.MethodGet, "/"+id, "", variantCookie)
+			result.Body.Close()
+
+			assert.Equal(t, location, result.Header.Get(echo.HeaderLocation))
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/api/urls/"+id+"/split", "", cookie)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var stats models.SplitStats
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&stats))
+
+		require.Len(t, stats.Targets, 2)
+		assert.True(t, stats.Sticky)
+		assert.Equal(t, int64(121), stats.Targets[0].Clicks+stats.Targets[1].Clicks)
+
+		clicks, err := srv.Storage.ClickCount(id)
+		require.NoError(t, err)
+		assert.Equal(t, int64(121), clicks)
+	})
+
+	t.Run("remove split", func(t *testing.T) {
+		result := srv.serve(http.MethodPut, "/api/urls/"+id+"/split", `{"targets":[]}`, cookie)
+		result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		result = srv.serve(http.MethodGet, "/"+id, "")
+		result.Body.Close()
+		assert.Equal(t, "https://example.com/landing", result.Header.Get(echo.HeaderLocation))
+	})
+}
+
+func TestClickLimitedURL(t *testing.T) {
+	srv := newTestServer(t, &testConfig)
+
+	t.Run("invalid max clicks", func(t *testing.T) {
+		result := srv.serve(http.MethodPost, "/api/shorten", `{"url":"https://example.com/file","max_clicks":-1}`)
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	})
+
+	id, _ := srv.shorten(t, `{"url":"https://example.com/file","max_clicks":3,"redirect_status":301}`, nil)
+
+	t.Run("preview hides destination", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/api/urls/"+id, "")
+		defer result.Body.Close()
+
+		var preview models.URLPreview
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&preview))
+
+		assert.Empty(t, preview.OriginalURL)
+		assert.Equal(t, 3, preview.MaxClicks)
+	})
+
+	const attempts = 40
+
+	var redirected, gone atomic.Int64
+	var wg sync.WaitGroup
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result := srv.serve(http.MethodGet, "/"+id, "")
+			result.Body.Close()
+
+			switch result.StatusCode {
+			case http.StatusMovedPermanently:
+				redirected.Add(1)
+				assert.Equal(t, "no-store", result.Header.Get(echo.HeaderCacheControl))
+			case http.StatusGone:
+				gone.Add(1)
+			default:
+				t.Errorf("unexpected status %d", result.StatusCode)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, int64(3), redirected.Load())
+	assert.Equal(t, int64(attempts-3), gone.Load())
+
+	result := srv.serve(http.MethodHead, "/"+id, "")
+	result.Body.Close()
+	assert.Equal(t, http.StatusGone, result.StatusCode)
+	assert.Empty(t, result.Header.Get(echo.HeaderLocation))
+}
+
+func TestScheduledURL(t *testing.T) {
+	customPage := filepath.Join(t.TempDir(), "unavailable.html")
+	require.NoError(t, os.WriteFile(customPage, []byte(`{{if .Upcoming}}soon{{else}}gone{{end}}`), 0600))
+
+	cfg := testConfig
+	cfg.UnavailablePagePath = customPage
+
+	srv := newTestServer(t, &cfg)
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	t.Run("invalid window", func(t *testing.T) {
+		body := fmt.Sprintf(`{"url":"https://example.com/bad","not_before":%q,"not_after":%q}`, future, past)
+
+		result := srv.serve(http.MethodPost, "/api/shorten", body)
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	})
+
+	upcomingID, cookie := srv.shorten(t, fmt.Sprintf(`{"url":"https://example.com/launch","not_before":%q}`, future), nil)
+	fallbackID, _ := srv.shorten(t, fmt.Sprintf(`{"url":"https://example.com/sale","not_before":%q,"fallback_url":"https://example.com/soon"}`, future), cookie)
+	expiredID, _ := srv.shorten(t, fmt.Sprintf(`{"url":"https://example.com/old","not_after":%q}`, past), cookie)
+	activeID, _ := srv.shorten(t, fmt.Sprintf(`{"url":"https://example.com/now","not_before":%q}`, past), cookie)
+
+	tests := []struct {
+		name       string
+		id         string
+		statusCode int
+		location   string
+		body       string
+	}{
+		{name: "upcoming", id: upcomingID, statusCode: http.StatusNotFound, body: "soon"},
+		{name: "fallback", id: fallbackID, statusCode: http.StatusTemporaryRedirect, location: "https://example.com/soon"},
+		{name: "expired", id: expiredID, statusCode: http.StatusGone, body: "gone"},
+		{name: "active", id: activeID, statusCode: http.StatusTemporaryRedirect, location: "https://example.com/now"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := srv.serve(http.MethodGet, "/"+test.id, "")
+			defer result.Body.Close()
+
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.statusCode, result.StatusCode)
+			assert.Equal(t, test.location, result.Header.Get(echo.HeaderLocation))
+			if test.body != "" {
+				assert.Equal(t, test.body, string(body))
+			}
+		})
+	}
+
+	listStatuses := func(status string) map[string]string {
+		result := srv.serve(http.MethodGet, "/api/user/urls?status="+status, "", cookie)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var urls []models.UserURL
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&urls))
+
+		statuses := make(map[string]string)
+		for _, url := range urls {
+			statuses[strings.TrimPrefix(url.ShortURL, cfg.BaseURL+"/")] = url.Status
+		}
+
+		return statuses
+	}
+
+	t.Run("list", func(t *testing.T) {
+		assert.Equal(t, map[string]string{upcomingID: "upcoming", fallbackID: "upcoming"}, listStatuses("upcoming"))
+		assert.Equal(t, map[string]string{expiredID: "expired"}, listStatuses("expired"))
+		assert.Len(t, listStatuses(""), 4)
+
+		result := srv.serve(http.MethodGet, "/api/user/urls?status=soon", "", cookie)
+		result.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	})
+
+	t.Run("edit window", func(t *testing.T) {
+		result := srv.serve(http.MethodPut, "/api/urls/"+upcomingID+"/schedule", fmt.Sprintf(`{"not_after":%q}`, future), cookie)
+		result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		result = srv.serve(http.MethodGet, "/"+upcomingID, "")
+		result.Body.Close()
+		assert.Equal(t, http.StatusTemporaryRedirect, result.StatusCode)
+		assert.Equal(t, "https://example.com/launch", result.Header.Get(echo.HeaderLocation))
+
+		assert.Equal(t, map[string]string{fallbackID: "upcoming"}, listStatuses("upcoming"))
+	})
+}
+
+func TestQRHandler(t *testing.T) {
+	srv := newTestServer(t, &testConfig)
+
+	id, _ := srv.shorten(t, `{"url":"https://example.com/print"}`, nil)
+
+	serveIfNoneMatch := func(target, etag string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("If-None-Match", etag)
+
+		return srv.serveRequest(request)
+	}
+
+	t.Run("png", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/"+id+"/qr?size=300&level=h&margin=2", "")
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		assert.Equal(t, "image/png", result.Header.Get(echo.HeaderContentType))
+		assert.Contains(t, result.Header.Get(echo.HeaderCacheControl), "public")
+		assert.NotEmpty(t, result.Header.Get("ETag"))
+
+		img, err := png.Decode(result.Body)
+		require.NoError(t, err)
+		assert.Equal(t, 300, img.Bounds().Dx())
+	})
+
+	t.Run("svg", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/"+id+"/qr?format=svg&size=128", "")
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		body, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, "image/svg+xml", result.Header.Get(echo.HeaderContentType))
+		assert.Contains(t, string(body), `width="128"`)
+	})
+
+	t.Run("etag", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/"+id+"/qr", "")
+		result.Body.Close()
+		etag := result.Header.Get("ETag")
+
+		result = serveIfNoneMatch("/"+id+"/qr", etag)
+		result.Body.Close()
+		assert.Equal(t, http.StatusNotModified, result.StatusCode)
+
+		result = serveIfNoneMatch("/"+id+"/qr?level=Q", etag)
+		result.Body.Close()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+
+		result = serveIfNoneMatch("/"+id+"/qr?level=q", result.Header.Get("ETag"))
+		result.Body.Close()
+		assert.Equal(t, http.StatusNotModified, result.StatusCode, "options are normalized before hashing")
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		for _, query := range []string{"format=gif", "size=abc", "size=10", "size=2048", "level=X", "margin=-1"} {
+			result := srv.serve(http.MethodGet, "/"+id+"/qr?"+query, "")
+			result.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, result.StatusCode, query)
+		}
+	})
+
+	t.Run("unknown id", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/zzzzzzzz/qr", "")
+		result.Body.Close()
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+
+		result = srv.serve(http.MethodGet, "/abc/qr", "")
+		result.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	})
+
+	t.Run("clicks are not counted", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/api/urls/"+id, "")
+		defer result.Body.Close()
+
+		var preview models.URLPreview
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&preview))
+		assert.Zero(t, preview.Clicks)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		result := srv.serve(http.MethodPost, "/api/shorten/batch?qr=true", `[{"correlation_id":"1","original_url":"https://example.com/batch"}]`)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusCreated, result.StatusCode)
+
+		var shortURLs []models.ShortURLWithID
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&shortURLs))
+		require.Len(t, shortURLs, 1)
+		assert.Equal(t, shortURLs[0].ShortURL+"/qr", shortURLs[0].QRURL)
+	})
+}
+
+func TestURLMetadata(t *testing.T) {
+	destination := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+		fmt.Fprintf(w, `<html><head><title>Page %s</title><meta property="og:type" content="article"></head></html>`, r.URL.Path)
+	}))
+	defer destination.Close()
+
+	cfg := testConfig
+	cfg.UnfurlEnabled = true
+	cfg.UnfurlTimeout = time.Second
+	cfg.UnfurlMaxBytes = 1024
+	cfg.UnfurlWorkers = 2
+	cfg.AllowPrivateDestinations = true
+
+	srv := newTestServer(t, &cfg)
+
+	metadata := func(id string) *models.URLMetadata {
+		result := srv.serve(http.MethodGet, "/api/urls/"+id, "")
+		defer result.Body.Close()
+
+		var preview models.URLPreview
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&preview))
+
+		return preview.Metadata
+	}
+
+	id, cookie := srv.shorten(t, fmt.Sprintf(`{"url":%q}`, destination.URL+"/first"), nil)
+
+	require.Eventually(t, func() bool {
+		return metadata(id) != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "Page /first", metadata(id).Title)
+	assert.Equal(t, map[string]string{"type": "article"}, metadata(id).OpenGraph)
+
+	t.Run("refetched after update", func(t *testing.T) {
+		result := srv.serve(http.MethodPatch, "/api/urls/"+id, fmt.Sprintf(`{"url":%q}`, destination.URL+"/second"), cookie)
+		result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		require.Eventually(t, func() bool {
+			return metadata(id).Title == "Page /second"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("fetch error", func(t *testing.T) {
+		failedID, _ := srv.shorten(t, `{"url":"http://127.0.0.1:1/closed"}`, nil)
+
+		require.Eventually(t, func() bool {
+			return metadata(failedID) != nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, app.MetadataUnavailable, metadata(failedID).Error, "fetch error details should not be public")
+		assert.Empty(t, metadata(failedID).Title)
+
+		require.NoError(t, srv.Storage.SetMetadata(failedID, models.URLMetadata{Error: "dial tcp 10.0.0.1:80: connection refused"}))
+		assert.Equal(t, app.MetadataUnavailable, metadata(failedID).Error, "previously stored details should be masked")
+	})
+
+	t.Run("hidden for protected links", func(t *testing.T) {
+		protectedID, _ := srv.shorten(t, fmt.Sprintf(`{"url":%q,"password":"secret"}`, destination.URL+"/secret"), nil)
+
+		require.Eventually(t, func() bool {
+			record, err := srv.Storage.Get(protectedID)
+			return err == nil && record.Metadata != nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		assert.Nil(t, metadata(protectedID))
+	})
+}
+
+func TestBrokenLinkCheck(t *testing.T) {
+	var down atomic.Bool
+
+	destination := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flaky" && down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer destination.Close()
+
+	cfg := testConfig
+	cfg.LinkCheckTimeout = time.Second
+	cfg.LinkCheckConcurrency = 2
+	cfg.LinkCheckFailureThreshold = 3
+	cfg.AllowPrivateDestinations = true
+
+	srv := newTestServer(t, &cfg)
+
+	id, cookie := srv.shorten(t, fmt.Sprintf(`{"url":%q,"broken_fallback_url":"https://yandex.ru/fallback"}`, destination.URL+"/flaky"), nil)
+	srv.shorten(t, fmt.Sprintf(`{"url":%q}`, destination.URL+"/healthy"), cookie)
+
+	location := func() string {
+		result := srv.serve(http.MethodGet, "/"+id, "")
+		defer result.Body.Close()
+
+		return result.Header.Get(echo.HeaderLocation)
+	}
+
+	brokenURLs := func() []models.BrokenURL {
+		result := srv.serve(http.MethodGet, "/api/user/urls/broken", "", cookie)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var urls []models.BrokenURL
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&urls))
+
+		return urls
+	}
+
+	down.Store(true)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, srv.CheckLinks(context.Background()))
+	}
+
+	assert.Empty(t, brokenURLs())
+	assert.Equal(t, destination.URL+"/flaky", location())
+
+	require.NoError(t, srv.CheckLinks(context.Background()))
+
+	urls := brokenURLs()
+	require.Len(t, urls, 1)
+	assert.Equal(t, cfg.BaseURL+"/"+id, urls[0].ShortURL)
+	assert.Equal(t, "https://yandex.ru/fallback", urls[0].BrokenFallbackURL)
+	assert.Equal(t, http.StatusInternalServerError, urls[0].LinkCheck.Status)
+	assert.Equal(t, 3, urls[0].LinkCheck.ConsecutiveFailures)
+	assert.NotEmpty(t, urls[0].LinkCheck.Error)
+
+	require.NoError(t, srv.CheckLinks(context.Background()))
+	assert.Equal(t, 4, brokenURLs()[0].LinkCheck.ConsecutiveFailures, "failures are counted past the threshold")
+
+	assert.Equal(t, "https://yandex.ru/fallback", location())
+
+	t.Run("set broken fallback", func(t *testing.T) {
+		result := srv.serve(http.MethodPut, "/api/urls/"+id+"/broken-fallback", `{"url":"ftp://yandex.ru"}`, cookie)
+		result.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+		result = srv.serve(http.MethodPut, "/api/urls/"+id+"/broken-fallback", `{"url":""}`)
+		result.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, result.StatusCode)
+
+		result = srv.serve(http.MethodPut, "/api/urls/"+id+"/broken-fallback", `{"url":""}`, cookie)
+		result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, destination.URL+"/flaky", location())
+
+		result = srv.serve(http.MethodPut, "/api/urls/"+id+"/broken-fallback", `{"url":"https://yandex.ru/other"}`, cookie)
+		result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "https://yandex.ru/other", location())
+	})
+
+	t.Run("recovered", func(t *testing.T) {
+		down.Store(false)
+		require.NoError(t, srv.CheckLinks(context.Background()))
+
+		assert.Empty(t, brokenURLs())
+		assert.Equal(t, destination.URL+"/flaky", location())
+	})
+
+	t.Run("invalid fallback on shorten", func(t *testing.T) {
+		result := srv.serve(http.MethodPost, "/api/shorten", `{"url":"https://yandex.ru","broken_fallback_url":"yandex"}`)
+		result.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	type received struct {
+		path     string
+		event    string
+		delivery string
+		id       string
+		linkID   string
+		original string
+	}
+
+	var (
+		mu       sync.Mutex
+		requests []received
+		failing  atomic.Bool
+	)
+
+	const secret = "test-secret"
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		if app.SignWebhook(secret, r.Header.Get(app.WebhookTimestampHeader), body) != r.Header.Get(app.WebhookSignatureHeader) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var payload struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+			Link struct {
+				ID          string `json:"id"`
+				ShortURL    string `json:"short_url"`
+				OriginalURL string `json:"original_url"`
+			} `json:"link"`
+		}
+		require.NoError(t, json.Unmarshal(body, &payload))
+		assert.Equal(t, payload.Type, r.Header.Get(app.WebhookEventHeader))
+		assert.Equal(t, testConfig.BaseURL+"/"+payload.Link.ID, payload.Link.ShortURL)
+
+		mu.Lock()
+		requests = append(requests, received{
+			path:     r.URL.Path,
+			event:    payload.Type,
+			delivery: r.Header.Get(app.WebhookDeliveryHeader),
+			id:       payload.ID,
+			linkID:   payload.Link.ID,
+			original: payload.Link.OriginalURL,
+		})
+		mu.Unlock()
+
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	takeRequests := func() []received {
+		mu.Lock()
+		defer mu.Unlock()
+
+		taken := requests
+		requests = nil
+
+		return taken
+	}
+
+	cfg := testConfig
+	cfg.WebhookTimeout = time.Second
+	cfg.WebhookWorkers = 2
+	cfg.WebhookMaxAttempts = 3
+	cfg.WebhookRetryBackoff = 20 * time.Millisecond
+	cfg.WebhookMaxBackoff = 40 * time.Millisecond
+	cfg.AllowPrivateDestinations = true
+
+	srv := newTestServer(t, &cfg)
+
+	shorten := func(url string, cookie *http.Cookie) (string, *http.Cookie) {
+		return srv.shorten(t, fmt.Sprintf(`{"url":%q,"max_clicks":1}`, url), cookie)
+	}
+
+	deliver := func() {
+		require.NoError(t, srv.DeliverWebhooks(context.Background()))
+	}
+
+	// ссылка, созданная до подписки, не порождает событие для неё
+	beforeID, cookie := shorten("https://yandex.ru/before", nil)
+
+	result := srv.serve(http.MethodPost, "/api/webhooks", fmt.Sprintf(`{"url":%q}`, receiver.URL))
+	result.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, result.StatusCode)
+
+	result = srv.serve(http.MethodPost, "/api/webhooks", `{"url":"ftp://yandex.ru"}`, cookie)
+	result.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	result = srv.serve(http.MethodPost, "/api/webhooks", fmt.Sprintf(`{"url":%q,"events":["link.clicked"]}`, receiver.URL), cookie)
+	result.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	result = srv.serve(http.MethodPost, "/api/webhooks", fmt.Sprintf(`{"url":%q,"secret":%q}`, receiver.URL+"/all", secret), cookie)
+	var all models.Webhook
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&all))
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	assert.Equal(t, secret, all.Secret)
+	assert.Empty(t, all.Events)
+
+	result = srv.serve(http.MethodPost, "/api/webhooks",
+		fmt.Sprintf(`{"url":%q,"secret":%q,"events":["link.deleted","link.deleted"]}`, receiver.URL+"/deleted", secret), cookie)
+	var deleted models.Webhook
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&deleted))
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	assert.Equal(t, []string{models.EventLinkDeleted}, deleted.Events)
+
+	result = srv.serve(http.MethodGet, "/api/webhooks", "", cookie)
+	var webhooks []models.Webhook
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&webhooks))
+	result.Body.Close()
+	require.Len(t, webhooks, 2)
+	assert.Equal(t, all.ID, webhooks[0].ID)
+	assert.Empty(t, webhooks[0].Secret)
+
+	deliveries := func(webhookID string, cookie *http.Cookie) []models.WebhookDelivery {
+		result := srv.serve(http.MethodGet, "/api/webhooks/"+webhookID+"/deliveries", "", cookie)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var deliveries []models.WebhookDelivery
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&deliveries))
+
+		return deliveries
+	}
+
+	t.Run("lifecycle events with retry", func(t *testing.T) {
+		id, _ := shorten("https://yandex.ru/created", cookie)
+
+		result := srv.serve(http.MethodPatch, "/api/urls/"+id, `{"url":"https://yandex.ru/updated"}`, cookie)
+		result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		result = srv.serve(http.MethodDelete, "/api/urls/"+id, "", cookie)
+		result.Body.Close()
+		require.Equal(t, http.StatusNoContent, result.StatusCode)
+
+		failing.Store(true)
+		deliver()
+
+		failed := takeRequests()
+		require.Len(t, failed, 4)
+
+		log := deliveries(all.ID, cookie)
+		require.Len(t, log, 3)
+		for _, delivery := range log {
+			assert.Equal(t, models.DeliveryPending, delivery.Status)
+			assert.Equal(t, 1, delivery.Attempts)
+			assert.Equal(t, http.StatusInternalServerError, delivery.ResponseStatus)
+			assert.NotEmpty(t, delivery.Error)
+			assert.True(t, delivery.NextAttemptAt.After(*delivery.LastAttemptAt))
+		}
+
+		// следующая попытка ещё не наступила
+		deliver()
+		assert.Empty(t, takeRequests())
+
+		failing.Store(false)
+		time.Sleep(cfg.WebhookRetryBackoff)
+		deliver()
+
+		retried := takeRequests()
+		require.Len(t, retried, 4)
+
+		var allEvents []string
+		for _, request := range retried {
+			assert.Equal(t, id, request.linkID)
+
+			switch request.path {
+			case "/all":
+				allEvents = append(allEvents, request.event)
+			case "/deleted":
+				assert.Equal(t, models.EventLinkDeleted, request.event)
+				assert.Equal(t, "https://yandex.ru/updated", request.original)
+			default:
+				t.Errorf("unexpected webhook path %q", request.path)
+			}
+		}
+		assert.ElementsMatch(t, []string{models.EventLinkCreated, models.EventLinkUpdated, models.EventLinkDeleted}, allEvents)
+
+		log = deliveries(all.ID, cookie)
+		require.Len(t, log, 3)
+		assert.Equal(t, models.EventLinkDeleted, log[0].Event.Type)
+		for _, delivery := range log {
+			assert.Equal(t, models.DeliveryDelivered, delivery.Status)
+			assert.Equal(t, 2, delivery.Attempts)
+			assert.Equal(t, http.StatusOK, delivery.ResponseStatus)
+			assert.Empty(t, delivery.Error)
+		}
+
+		deliver()
+		assert.Empty(t, takeRequests())
+	})
+
+	t.Run("expired events", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/"+beforeID, "")
+		result.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, result.StatusCode)
+
+		notAfter := time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+		id, _ := shorten("https://yandex.ru/scheduled", cookie)
+		result = srv.serve(http.MethodPut, "/api/urls/"+id+"/schedule", fmt.Sprintf(`{"not_after":%q}`, notAfter), cookie)
+		result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		for i := 0; i < 2; i++ {
+			require.NoError(t, srv.QueueExpiredEvents(context.Background()))
+		}
+
+		deliver()
+
+		var expired []string
+		for _, request := range takeRequests() {
+			if request.event == models.EventLinkExpired {
+				assert.Equal(t, "/all", request.path)
+				expired = append(expired, request.linkID)
+			}
+		}
+		assert.ElementsMatch(t, []string{beforeID, id}, expired)
+	})
+
+	t.Run("failed after max attempts", func(t *testing.T) {
+		failing.Store(true)
+		shorten("https://yandex.ru/unlucky", cookie)
+
+		for i := 0; i < cfg.WebhookMaxAttempts; i++ {
+			time.Sleep(cfg.WebhookMaxBackoff)
+			deliver()
+		}
+
+		assert.Len(t, takeRequests(), cfg.WebhookMaxAttempts)
+
+		log := deliveries(all.ID, cookie)
+		assert.Equal(t, models.DeliveryFailed, log[0].Status)
+		assert.Equal(t, cfg.WebhookMaxAttempts, log[0].Attempts)
+
+		time.Sleep(cfg.WebhookMaxBackoff)
+		deliver()
+		assert.Empty(t, takeRequests())
+	})
+
+	t.Run("foreign and deleted webhook", func(t *testing.T) {
+		_, otherCookie := shorten("https://yandex.ru/other", nil)
+
+		result := srv.serve(http.MethodGet, "/api/webhooks/"+all.ID+"/deliveries", "", otherCookie)
+		result.Body.Close()
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+
+		result = srv.serve(http.MethodDelete, "/api/webhooks/"+all.ID, "", otherCookie)
+		result.Body.Close()
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+
+		result = srv.serve(http.MethodDelete, "/api/webhooks/"+all.ID, "", cookie)
+		result.Body.Close()
+		assert.Equal(t, http.StatusNoContent, result.StatusCode)
+
+		result = srv.serve(http.MethodGet, "/api/webhooks/"+all.ID+"/deliveries", "", cookie)
+		result.Body.Close()
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+
+		failing.Store(false)
+		shorten("https://yandex.ru/after", cookie)
+		deliver()
+		assert.Empty(t, takeRequests())
+	})
+}
+
+func TestImportExport(t *testing.T) {
+	srv := newTestServer(t, &testConfig)
+
+	importCSV := func(body string, cookie *http.Cookie) []models.ImportResult {
+		result := srv.serve(http.MethodPost, "/api/import", body, cookie)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var results []models.ImportResult
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&results))
+
+		return results
+	}
+
+	result := srv.serve(http.MethodPost, "/", "https://yandex.ru/existing")
+	existing, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	cookie := result.Cookies()[0]
+
+	result = srv.serve(http.MethodPost, "/api/import", "https://yandex.ru/a\n")
+	result.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, result.StatusCode)
+
+	results := importCSV(`original_url,alias,not_after
+https://yandex.ru/a,,
+https://yandex.ru/b,my-alias,2030-01-02
+https://yandex.ru/c, ,2030-01-02T10:00:00+03:00
+not a url,,
+https://yandex.ru/d,ab,
+https://yandex.ru/e,my-alias,
+https://yandex.ru/existing,,
+https://yandex.ru/a,other-alias,
+https://yandex.ru/f,,tomorrow
+https://yandex.ru/g,"bad"quote,
+https://yandex.ru/h,Ping,
+`, cookie)
+
+	type row struct {
+		line   int
+		status string
+	}
+
+	var rows []row
+	for _, result := range results {
+		rows = append(rows, row{result.Line, result.Status})
+	}
+
+	assert.Equal(t, []row{
+		{2, models.ImportCreated}, {3, models.ImportCreated}, {4, models.ImportCreated}, {5, models.ImportFailed},
+		{6, models.ImportFailed}, {7, models.ImportFailed}, {8, models.ImportExists}, {9, models.ImportExists},
+		{10, models.ImportFailed}, {11, models.ImportFailed}, {12, models.ImportFailed},
+	}, rows)
+
+	assert.Equal(t, testConfig.BaseURL+"/my-alias", results[1].ShortURL)
+	assert.Equal(t, "invalid_alias", results[4].Code)
+	assert.Contains(t, results[4].Error, app.ErrInvalidAlias.Error())
+	assert.Equal(t, "alias_taken", results[5].Code)
+	assert.Contains(t, results[5].Error, app.ErrAliasTaken.Error())
+	assert.Equal(t, string(existing), results[6].ShortURL)
+	assert.Equal(t, results[0].ShortURL, results[7].ShortURL)
+	assert.Contains(t, results[8].Error, app.ErrInvalidNotAfter.Error())
+	assert.Equal(t, "invalid_csv_row", results[9].Code)
+	assert.Contains(t, results[10].Error, app.ErrInvalidAlias.Error())
+
+	result = srv.serve(http.MethodGet, "/my-alias", "")
+	result.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, result.StatusCode)
+	assert.Equal(t, "https://yandex.ru/b", result.Header.Get(echo.HeaderLocation))
+
+	t.Run("alias taken by earlier import", func(t *testing.T) {
+		results := importCSV("https://yandex.ru/i,my-alias\n", cookie)
+		require.Len(t, results, 1)
+		assert.Equal(t, 1, results[0].Line)
+		assert.Equal(t, models.ImportFailed, results[0].Status)
+
+		result := srv.serve(http.MethodPost, "/api/shorten/batch",
+			`[{"correlation_id":"1","original_url":"https://yandex.ru/i","alias":"my-alias"}]`, cookie)
+		result.Body.Close()
+		assert.Equal(t, http.StatusConflict, result.StatusCode)
+
+		result = srv.serve(http.MethodPost, "/api/shorten/batch",
+			`[{"correlation_id":"1","original_url":"https://yandex.ru/i","alias":"no/slash"}]`, cookie)
+		result.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	})
+
+	t.Run("export csv", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/api/export", "", cookie)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "text/csv; charset=utf-8", result.Header.Get(echo.HeaderContentType))
+
+		body, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+
+		records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 5)
+		assert.Equal(t, app.ExportColumns, records[0])
+
+		byOriginal := make(map[string][]string)
+		for _, record := range records[1:] {
+			byOriginal[record[0]] = record
+		}
+
+		assert.Equal(t, []string{"https://yandex.ru/b", "my-alias", "2030-01-02T00:00:00Z", testConfig.BaseURL + "/my-alias"},
+			byOriginal["https://yandex.ru/b"][:4])
+		assert.Equal(t, "2030-01-02T07:00:00Z", byOriginal["https://yandex.ru/c"][2])
+		assert.Empty(t, byOriginal["https://yandex.ru/a"][2])
+
+		// выгрузка импортируется обратно без новых ссылок
+		for _, result := range importCSV(string(body), cookie) {
+			assert.Equal(t, models.ImportExists, result.Status, result.OriginalURL)
+		}
+	})
+
+	t.Run("export ndjson", func(t *testing.T) {
+		result := srv.serve(http.MethodGet, "/api/export?format=ndjson", "", cookie)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "application/x-ndjson", result.Header.Get(echo.HeaderContentType))
+
+		var urls []models.ExportedURL
+
+		decoder := json.NewDecoder(result.Body)
+		for decoder.More() {
+			var url models.ExportedURL
+			require.NoError(t, decoder.Decode(&url))
+			urls = append(urls, url)
+		}
+
+		require.Len(t, urls, 4)
+		for _, url := range urls {
+			assert.Equal(t, testConfig.BaseURL+"/"+url.Alias, url.ShortURL)
+		}
+	})
+
+	t.Run("export of user without urls", func(t *testing.T) {
+		result := srv.serve(http.MethodPost, "/api/shorten/batch", "[]")
+		result.Body.Close()
+		cookie := result.Cookies()[0]
+
+		result = srv.serve(http.MethodGet, "/api/export", "", cookie)
+		body, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		result.Body.Close()
+		assert.Equal(t, strings.Join(app.ExportColumns, ",")+"\n", string(body))
+
+		result = srv.serve(http.MethodGet, "/api/export?format=xml", "", cookie)
+		result.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+		assert.Empty(t, importCSV("", cookie))
+	})
+
+	t.Run("large import in chunks", func(t *testing.T) {
+		result := srv.serve(http.MethodPost, "/api/shorten/batch", "[]")
+		result.Body.Close()
+		cookie := result.Cookies()[0]
+
+		var body strings.Builder
+		for i := 0; i < 1234; i++ {
+			fmt.Fprintf(&body, "https://yandex.ru/bulk/%d\n", i)
+		}
+
+		results := importCSV(body.String(), cookie)
+		require.Len(t, results, 1234)
+
+		for i, result := range results {
+			assert.Equal(t, i+1, result.Line)
+			assert.Equal(t, models.ImportCreated, result.Status)
+		}
+
+		// через настоящий сервер: строки читаются и после отправки результатов первой части
+		server := httptest.NewServer(srv.Echo)
+		defer server.Close()
+
+		body.Reset()
+		for i := 0; i < 1234; i++ {
+			fmt.Fprintf(&body, "https://yandex.ru/served/%d\n", i)
+		}
+
+		request, err := http.NewRequest(http.MethodPost, server.URL+"/api/import", strings.NewReader(body.String()))
+		require.NoError(t, err)
+		request.AddCookie(cookie)
+
+		served, err := server.Client().Do(request)
+		require.NoError(t, err)
+		defer served.Body.Close()
+		require.Equal(t, http.StatusOK, served.StatusCode)
+
+		results = nil
+		require.NoError(t, json.NewDecoder(served.Body).Decode(&results))
+		require.Len(t, results, 1234)
+		assert.Equal(t, models.ImportCreated, results[1233].Status)
+
+		result = srv.serve(http.MethodGet, "/api/export?format=ndjson", "", cookie)
+		defer result.Body.Close()
+
+		lines := 0
+		scanner := bufio.NewScanner(result.Body)
+		for scanner.Scan() {
+			lines++
+		}
+		assert.Equal(t, 2*1234, lines)
+	})
+}
+
+func TestAPIStreamShortenHandler(t *testing.T) {
+	cfg := testConfig
+	cfg.StreamMaxItems = 10
+
+	srv := app.NewServer(&cfg)
+	defer srv.Stop()
+	InitHandlers(srv)
+
+	server := httptest.NewServer(srv.Echo)
+	defer server.Close()
+
+	// ответ начинается до чтения запроса, поэтому тело запроса можно дописывать, уже читая ответ
+	stream := func(t *testing.T) (*io.PipeWriter, *http.Response) {
+		bodyReader, bodyWriter := io.Pipe()
+
+		request, err := http.NewRequest(http.MethodPost, server.URL+"/api/shorten/stream", bodyReader)
+		require.NoError(t, err)
+
+		response, err := server.Client().Do(request)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "application/x-ndjson", response.Header.Get(echo.HeaderContentType))
+
+		return bodyWriter, response
+	}
+
+	readResult := func(t *testing.T, scanner *bufio.Scanner) models.StreamShortenResult {
+		require.True(t, scanner.Scan(), scanner.Err())
+
+		var result models.StreamShortenResult
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &result))
+
+		return result
+	}
+
+	t.Run("results are written before the request ends", func(t *testing.T) {
+		body, response := stream(t)
+		defer response.Body.Close()
+		scanner := bufio.NewScanner(response.Body)
+
+		_, err := fmt.Fprintln(body, `{"correlation_id":"1","original_url":"https://yandex.ru/stream/1"}`)
+		require.NoError(t, err)
+
+		result := readResult(t, scanner)
+		assert.Equal(t, 1, result.Line)
+		assert.Equal(t, "1", result.CorrelationID)
+		assert.Empty(t, result.Error)
+
+		redirect := httptest.NewRecorder()
+		srv.Echo.ServeHTTP(redirect, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(result.ShortURL, cfg.BaseURL), nil))
+		assert.Equal(t, "https://yandex.ru/stream/1", redirect.Header().Get(echo.HeaderLocation))
+
+		_, err = fmt.Fprint(body, `{"correlation_id":"2","original_url":"https://yandex.ru/stream/2","alias":"stream-alias"}
+
+not json
+{"correlation_id":"4","original_url":""}
+{"correlation_id":"5","original_url":"https://yandex.ru/stream/5","alias":"stream-alias"}
+{"correlation_id":"6","original_url":"https://yandex.ru/stream/6","alias":"x"}
+{"correlation_id":"7","original_url":"https://yandex.ru/`+strings.Repeat("a", 70<<10)+`"}
+{"correlation_id":"8","original_url":"https://yandex.ru/stream/8"}`)
+		require.NoError(t, err)
+		require.NoError(t, body.Close())
+
+		var results []models.StreamShortenResult
+		for scanner.Scan() {
+			var result models.StreamShortenResult
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &result))
+			results = append(results, result)
+		}
+		require.NoError(t, scanner.Err())
+		require.Len(t, results, 7)
+
+		assert.Equal(t, cfg.BaseURL+"/stream-alias", results[0].ShortURL)
+		assert.Equal(t, 4, results[1].Line)
+		assert.Equal(t, "invalid_item", results[1].Code)
+		assert.Contains(t, results[1].Error, app.ErrInvalidItem.Error())
+		assert.Equal(t, "empty_url", results[2].Code)
+		assert.Equal(t, app.ErrEmptyURL.Error(), results[2].Error)
+		assert.Equal(t, "alias_taken", results[3].Code)
+		assert.Contains(t, results[3].Error, app.ErrAliasTaken.Error())
+		assert.Equal(t, "invalid_alias", results[4].Code)
+		assert.Contains(t, results[4].Error, app.ErrInvalidAlias.Error())
+		assert.Equal(t, "line_too_long", results[5].Code)
+		assert.Equal(t, app.ErrLineTooLong.Error(), results[5].Error)
+		assert.Equal(t, 9, results[6].Line)
+		assert.Equal(t, "8", results[6].CorrelationID)
+		assert.NotEmpty(t, results[6].ShortURL)
+	})
+
+	t.Run("conflicting rows are not reported as created", func(t *testing.T) {
+		body, response := stream(t)
+		defer response.Body.Close()
+		scanner := bufio.NewScanner(response.Body)
+
+		_, err := fmt.Fprint(body, `{"correlation_id":"1","original_url":"https://yandex.ru/stream/1"}
+{"correlation_id":"2","original_url":"https://yandex.ru/stream/conflict"}
+{"correlation_id":"3","original_url":"https://yandex.ru/stream/conflict"}`)
+		require.NoError(t, err)
+		require.NoError(t, body.Close())
+
+		results := []models.StreamShortenResult{readResult(t, scanner), readResult(t, scanner), readResult(t, scanner)}
+
+		assert.Empty(t, results[0].ShortURL)
+		assert.Equal(t, "duplicate_url", results[0].Code)
+		assert.Contains(t, results[0].Error, storage.ErrDuplicateRecord.Error())
+		assert.NotEmpty(t, results[1].ShortURL)
+		assert.Empty(t, results[1].Error)
+		assert.Empty(t, results[2].ShortURL)
+		assert.Equal(t, "duplicate_url", results[2].Code)
+		assert.Contains(t, results[2].Error, storage.ErrDuplicateRecord.Error())
+	})
+
+	t.Run("max items", func(t *testing.T) {
+		body, response := stream(t)
+		defer response.Body.Close()
+		scanner := bufio.NewScanner(response.Body)
+
+		go func() {
+			for i := 0; i < cfg.StreamMaxItems+5; i++ {
+				_, err := fmt.Fprintf(body, `{"correlation_id":"%d","original_url":"https://yandex.ru/limit/%d"}`+"\n", i, i)
+				if err != nil {
+					return
+				}
+			}
+			body.Close()
+		}()
+
+		for i := 0; i < cfg.StreamMaxItems; i++ {
+			result := readResult(t, scanner)
+			assert.Equal(t, strconv.Itoa(i), result.CorrelationID)
+			assert.NotEmpty(t, result.ShortURL)
+		}
+
+		result := readResult(t, scanner)
+		assert.Zero(t, result.Line)
+		assert.Contains(t, result.Error, app.ErrTooManyItems.Error())
+
+		assert.False(t, scanner.Scan())
+	})
+}
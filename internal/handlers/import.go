@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/app"
+	"github.com/pluhe7/shortener/internal/logger"
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+const (
+	mimeTextCSV = "text/csv; charset=utf-8"
+	mimeNDJSON  = "application/x-ndjson"
+
+	// Количество ссылок выгрузки, после которого накопленное отправляется клиенту
+	exportFlushInterval = 100
+)
+
+// ImportHandler отвечает JSON-массивом результатов строк, отправляя результаты каждой сохранённой части сразу.
+// После начала ответа ошибку уже не вернуть статусом, поэтому она только логируется, а ответ обрывается.
+func (s *SrvHandler) ImportHandler(c echo.Context) error {
+	enableFullDuplex(c)
+
+	res := c.Response()
+	written := 0
+
+	start := func() error {
+		res.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		res.WriteHeader(http.StatusOK)
+
+		_, err := res.Write([]byte("["))
+		return err
+	}
+
+	err := s.ImportURLs(c.Request().Context(), c.Request().Body, userIDFromContext(c), func(results []models.ImportResult) error {
+		if written == 0 {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, result := range results {
+			if result.Err != nil {
+				result.Code, result.Error = describeError(c, result.Err)
+			}
+
+			data, err := json.Marshal(result)
+			if err != nil {
+				return fmt.Errorf("marshal result: %w", err)
+			}
+
+			if written > 0 {
+				data = append([]byte(",\n"), data...)
+			}
+
+			_, err = res.Write(data)
+			if err != nil {
+				return err
+			}
+
+			written++
+		}
+
+		res.Flush()
+
+		return nil
+	})
+	if err != nil {
+		if written == 0 {
+			return invalidBody(fmt.Errorf("import urls error: %w", err))
+		}
+
+		logger.Log.Warn("import urls", zap.Int("written", written), zap.Error(err))
+
+		return nil
+	}
+
+	if written == 0 {
+		err = start()
+		if err != nil {
+			return nil
+		}
+	}
+
+	_, _ = res.Write([]byte("]\n"))
+
+	return nil
+}
+
+// ExportHandler выгружает ссылки пользователя в CSV или NDJSON по параметру format, по умолчанию CSV
+func (s *SrvHandler) ExportHandler(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	var contentType string
+
+	switch format {
+	case "csv":
+		contentType = mimeTextCSV
+	case "ndjson":
+		contentType = mimeNDJSON
+	default:
+		return newAPIError(http.StatusBadRequest, codeUnsupportedFormat,
+			fmt.Errorf("export urls error: unsupported format %q, should be csv or ndjson", format))
+	}
+
+	res := c.Response()
+	buffered := bufio.NewWriter(res)
+	csvWriter := csv.NewWriter(buffered)
+	encoder := json.NewEncoder(buffered)
+	exported := 0
+
+	start := func() error {
+		res.Header().Set(echo.HeaderContentType, contentType)
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="urls.%s"`, format))
+		res.WriteHeader(http.StatusOK)
+
+		if format == "csv" {
+			return csvWriter.Write(app.ExportColumns)
+		}
+
+		return nil
+	}
+
+	flush := func() error {
+		csvWriter.Flush()
+
+		err := csvWriter.Error()
+		if err != nil {
+			return err
+		}
+
+		err = buffered.Flush()
+		if err != nil {
+			return err
+		}
+
+		res.Flush()
+
+		return nil
+	}
+
+	err := s.ExportURLs(c.Request().Context(), userIDFromContext(c), func(url models.ExportedURL) error {
+		if exported == 0 {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if format == "csv" {
+			err = csvWriter.Write(exportRow(url))
+		} else {
+			err = encoder.Encode(url)
+		}
+		if err != nil {
+			return err
+		}
+
+		exported++
+
+		if exported%exportFlushInterval == 0 {
+			return flush()
+		}
+
+		return nil
+	})
+	if err != nil {
+		if exported == 0 {
+			return fmt.Errorf("export urls error: %w", err)
+		}
+
+		logger.Log.Warn("export urls", zap.Int("exported", exported), zap.Error(err))
+
+		return nil
+	}
+
+	if exported == 0 {
+		err = start()
+		if err != nil {
+			return nil
+		}
+	}
+
+	err = flush()
+	if err != nil {
+		logger.Log.Warn("export urls", zap.Int("exported", exported), zap.Error(err))
+	}
+
+	return nil
+}
+
+// enableFullDuplex позволяет читать тело запроса после начала ответа: иначе сервер HTTP/1 перед первой
+// записью ответа дочитывает тело запроса сам, и потоковый обработчик теряет его остаток
+func enableFullDuplex(c echo.Context) {
+	err := http.NewResponseController(c.Response()).EnableFullDuplex()
+	if err != nil {
+		logger.Log.Debug("enable full duplex", zap.Error(err))
+	}
+}
+
+func exportRow(url models.ExportedURL) []string {
+	var notAfter, createdAt string
+
+	if url.NotAfter != nil {
+		notAfter = url.NotAfter.UTC().Format(time.RFC3339)
+	}
+
+	if !url.CreatedAt.IsZero() {
+		createdAt = url.CreatedAt.UTC().Format(time.RFC3339)
+	}
+
+	return []string{url.OriginalURL, url.Alias, notAfter, url.ShortURL, createdAt}
+}
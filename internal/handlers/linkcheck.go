@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+func (s *SrvHandler) BrokenURLsHandler(c echo.Context) error {
+	urls, err := s.BrokenURLs(userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("get broken urls error: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, urls)
+}
+
+func (s *SrvHandler) SetURLBrokenFallbackHandler(c echo.Context) error {
+	var req models.BrokenFallbackRequest
+
+	err := json.NewDecoder(c.Request().Body).Decode(&req)
+	if err != nil {
+		return invalidBody(fmt.Errorf("decode request error: %w", err))
+	}
+
+	err = s.SetURLBrokenFallback(c.Param("id"), req.URL, userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("set broken fallback error: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, req)
+}
@@ -2,16 +2,23 @@ package handlers
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 
+	"github.com/pluhe7/shortener/internal/auth"
 	"github.com/pluhe7/shortener/internal/compressor"
 	"github.com/pluhe7/shortener/internal/logger"
 )
 
+const (
+	userIDContextKey     = "user_id"
+	authorizedContextKey = "authorized"
+)
+
 func RequestLogger(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		start := time.Now()
@@ -23,6 +30,7 @@ func RequestLogger(next echo.HandlerFunc) echo.HandlerFunc {
 		duration := time.Since(start)
 
 		logger.Log.Info("got incoming HTTP request",
+			zap.String("request_id", requestIDFromContext(c)),
 			zap.Duration("duration", duration),
 			zap.Int("status", c.Response().Status),
 			zap.Int64("size", c.Response().Size),
@@ -76,3 +84,64 @@ func CompressorMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		return nil
 	}
 }
+
+// AuthMiddleware определяет пользователя по подписанному токену из cookie или заголовка Authorization.
+// Если валидного токена нет, пользователю выдаётся новый идентификатор и cookie с токеном.
+func AuthMiddleware(authenticator *auth.Authenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, err := authenticator.ParseToken(requestToken(c))
+			if err == nil {
+				c.Set(userIDContextKey, userID)
+				c.Set(authorizedContextKey, true)
+
+				return next(c)
+			}
+
+			userID, err = auth.NewUserID()
+			if err != nil {
+				return fmt.Errorf("new user id error: %w", err)
+			}
+
+			c.SetCookie(&http.Cookie{
+				Name:     auth.CookieName,
+				Value:    authenticator.BuildToken(userID),
+				Path:     "/",
+				HttpOnly: true,
+			})
+			c.Set(userIDContextKey, userID)
+
+			return next(c)
+		}
+	}
+}
+
+// RequireAuth пропускает только запросы с валидным токеном, должен идти после AuthMiddleware
+func RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if authorized, _ := c.Get(authorizedContextKey).(bool); !authorized {
+			return newAPIError(http.StatusUnauthorized, codeUnauthorized, errUnauthorized)
+		}
+
+		return next(c)
+	}
+}
+
+func requestToken(c echo.Context) string {
+	if token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer "); ok {
+		return token
+	}
+
+	cookie, err := c.Cookie(auth.CookieName)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+func userIDFromContext(c echo.Context) string {
+	userID, _ := c.Get(userIDContextKey).(string)
+
+	return userID
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed templates assets
+var webFS embed.FS
+
+var pageTemplates = template.Must(template.ParseFS(webFS, "templates/*.html"))
+
+// customPages хранит разобранные шаблоны страниц, заданных в конфигурации путём к файлу
+var customPages sync.Map
+
+// loadCustomPage разбирает шаблон страницы из файла один раз за время работы сервера
+func loadCustomPage(path string) (*template.Template, error) {
+	if page, ok := customPages.Load(path); ok {
+		return page.(*template.Template), nil
+	}
+
+	page, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("parse page template: %w", err)
+	}
+
+	customPages.Store(path, page)
+
+	return page, nil
+}
+
+func renderPage(c echo.Context, status int, name string, data any) error {
+	return renderTemplate(c, status, pageTemplates.Lookup(name), data)
+}
+
+func renderTemplate(c echo.Context, status int, page *template.Template, data any) error {
+	var buf bytes.Buffer
+
+	err := page.Execute(&buf, data)
+	if err != nil {
+		return fmt.Errorf("render page %s error: %w", page.Name(), err)
+	}
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+
+	return c.HTMLBlob(status, buf.Bytes())
+}
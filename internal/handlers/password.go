@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/pluhe7/shortener/internal/app"
+)
+
+const unlockCookiePrefix = "unlock_"
+
+type passwordFormData struct {
+	// Адрес, на который отправляется форма: путь и параметры перехода сохраняются для редиректа
+	Action string
+	Error  string
+}
+
+// UnlockHandler принимает пароль из формы и, если он верный, открывает ссылку
+// на время UnlockTTL подписанной cookie и перенаправляет так же, как переход по открытой ссылке
+func (s *SrvHandler) UnlockHandler(c echo.Context) error {
+	id := c.Param("id")
+
+	redirect, err := s.UnlockURL(expandRequest(c, id), c.FormValue("password"), c.RealIP())
+	if err != nil {
+		var tooManyAttemptsErr *app.TooManyAttemptsError
+		var scheduleErr *app.ScheduleError
+
+		switch {
+		case errors.As(err, &scheduleErr):
+			return s.renderUnavailable(c, scheduleErr)
+		case errors.Is(err, app.ErrWrongPassword):
+			return renderPasswordForm(c, http.StatusForbidden, "Wrong password.")
+		case errors.As(err, &tooManyAttemptsErr):
+			retryAfter := int(math.Ceil(tooManyAttemptsErr.RetryAfter.Seconds()))
+			c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(retryAfter))
+
+			return renderPasswordForm(c, http.StatusTooManyRequests, "Too many wrong attempts, try again later.")
+		default:
+			return fmt.Errorf("unlock url error: %w", err)
+		}
+	}
+
+	expiresAt := time.Now().Add(s.Config.UnlockTTL)
+
+	c.SetCookie(&http.Cookie{
+		Name:     unlockCookiePrefix + id,
+		Value:    s.Auth.SignExpiring(unlockSubject(id), expiresAt),
+		Path:     "/" + id,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if redirect.Sticky {
+		setStickyVariant(c, id, redirect.Variant)
+	}
+
+	// ответ на отправку формы не кешируется, а 307 и 308 повторили бы POST с паролем на исходный URL
+	status := redirect.Status
+	if status == http.StatusTemporaryRedirect || status == http.StatusPermanentRedirect {
+		status = http.StatusSeeOther
+	}
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+
+	return c.Redirect(status, redirect.URL)
+}
+
+func (s *SrvHandler) isUnlocked(c echo.Context, id string) bool {
+	cookie, err := c.Cookie(unlockCookiePrefix + id)
+	if err != nil {
+		return false
+	}
+
+	return s.Auth.VerifyExpiring(unlockSubject(id), cookie.Value) == nil
+}
+
+func unlockSubject(id string) string {
+	return "unlock:" + id
+}
+
+func renderPasswordForm(c echo.Context, status int, formError string) error {
+	return renderPage(c, status, "password.html", passwordFormData{Action: c.Request().URL.RequestURI(), Error: formError})
+}
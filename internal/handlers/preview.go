@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+const previewSuffix = "+"
+
+// PreviewSwitch направляет запросы /{id}+ и /{id}?preview=1 в обработчик превью вместо перехода по ссылке
+func PreviewSwitch(preview echo.HandlerFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, isPreview := strings.CutSuffix(c.Param("id"), previewSuffix)
+			if !isPreview && c.QueryParam("preview") != "1" {
+				return next(c)
+			}
+
+			c.SetParamValues(id)
+
+			return preview(c)
+		}
+	}
+}
+
+func (s *SrvHandler) PreviewHandler(c echo.Context) error {
+	preview, err := s.previewURL(c)
+	if err != nil {
+		return err
+	}
+
+	return renderPage(c, http.StatusOK, "preview.html", preview)
+}
+
+func (s *SrvHandler) APIPreviewHandler(c echo.Context) error {
+	preview, err := s.previewURL(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, preview)
+}
+
+func (s *SrvHandler) previewURL(c echo.Context) (models.URLPreview, error) {
+	id := c.Param("id")
+
+	preview, err := s.PreviewURL(id, s.isUnlocked(c, id))
+	if err != nil {
+		return models.URLPreview{}, fmt.Errorf("preview url error: %w", err)
+	}
+
+	return preview, nil
+}
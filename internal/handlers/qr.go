@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/pluhe7/shortener/internal/app"
+)
+
+const qrMaxAge = 24 * time.Hour
+
+// QRHandler отдаёт QR-код сокращённой ссылки; параметры format, size, level и margin необязательны
+func (s *SrvHandler) QRHandler(c echo.Context) error {
+	options, err := qrOptions(c)
+	if err != nil {
+		return fmt.Errorf("qr code error: %w", err)
+	}
+
+	code, err := s.URLQRCode(c.Param("id"), options, func(etag string) bool {
+		return etagMatches(c.Request().Header.Get("If-None-Match"), etag)
+	})
+	if err != nil {
+		return fmt.Errorf("qr code error: %w", err)
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderCacheControl, "public, max-age="+strconv.Itoa(int(qrMaxAge.Seconds())))
+	header.Set("ETag", code.ETag)
+
+	if code.NotModified {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return c.Blob(http.StatusOK, code.ContentType, code.Data)
+}
+
+func qrOptions(c echo.Context) (app.QROptions, error) {
+	options := app.DefaultQROptions()
+
+	if format := c.QueryParam("format"); format != "" {
+		options.Format = strings.ToLower(format)
+	}
+
+	if level := c.QueryParam("level"); level != "" {
+		options.Level = level
+	}
+
+	for name, value := range map[string]*int{"size": &options.Size, "margin": &options.Margin} {
+		param := c.QueryParam(name)
+		if param == "" {
+			continue
+		}
+
+		parsed, err := strconv.Atoi(param)
+		if err != nil {
+			return app.QROptions{}, fmt.Errorf("%w: parse %s: %v", app.ErrInvalidQROptions, name, err)
+		}
+
+		*value = parsed
+	}
+
+	return options, nil
+}
+
+// etagMatches проверяет If-None-Match, в котором может быть несколько тегов через запятую, в том числе слабых
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+
+	return false
+}
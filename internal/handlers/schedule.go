@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/app"
+	"github.com/pluhe7/shortener/internal/logger"
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+// unavailablePageData - данные страницы ссылки вне окна работы, в том числе заданной в конфигурации
+type unavailablePageData struct {
+	Upcoming  bool
+	NotBefore *time.Time
+	NotAfter  *time.Time
+}
+
+func (s *SrvHandler) SetURLScheduleHandler(c echo.Context) error {
+	var req models.Schedule
+
+	err := json.NewDecoder(c.Request().Body).Decode(&req)
+	if err != nil {
+		return invalidBody(fmt.Errorf("decode request error: %w", err))
+	}
+
+	err = s.SetURLSchedule(c.Param("id"), req, userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("set url schedule error: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, req)
+}
+
+func (s *SrvHandler) UserURLsHandler(c echo.Context) error {
+	urls, err := s.UserURLs(userIDFromContext(c), c.QueryParam("status"))
+	if err != nil {
+		return fmt.Errorf("get user urls error: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, urls)
+}
+
+// renderUnavailable отвечает страницей ссылки вне окна работы: 404, пока окно не началось, 410 - после его конца
+func (s *SrvHandler) renderUnavailable(c echo.Context, scheduleErr *app.ScheduleError) error {
+	data := unavailablePageData{
+		Upcoming:  errors.Is(scheduleErr, app.ErrURLNotYetAvailable),
+		NotBefore: scheduleErr.NotBefore,
+		NotAfter:  scheduleErr.NotAfter,
+	}
+
+	status := http.StatusGone
+	if data.Upcoming {
+		status = http.StatusNotFound
+	}
+
+	if s.Config.UnavailablePagePath == "" {
+		return renderPage(c, status, "unavailable.html", data)
+	}
+
+	page, err := loadCustomPage(s.Config.UnavailablePagePath)
+	if err != nil {
+		logger.Log.Error("load unavailable page, using built-in", zap.Error(err))
+		return renderPage(c, status, "unavailable.html", data)
+	}
+
+	return renderTemplate(c, status, page, data)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+const (
+	variantCookiePrefix = "variant_"
+	variantCookieMaxAge = 30 * 24 * time.Hour
+)
+
+func (s *SrvHandler) URLSplitHandler(c echo.Context) error {
+	stats, err := s.URLSplitStats(c.Param("id"), userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("get url split error: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+func (s *SrvHandler) SetURLSplitHandler(c echo.Context) error {
+	var req models.Split
+
+	err := json.NewDecoder(c.Request().Body).Decode(&req)
+	if err != nil {
+		return invalidBody(fmt.Errorf("decode request error: %w", err))
+	}
+
+	err = s.SetURLSplit(c.Param("id"), req, userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("set url split error: %w", err)
+	}
+
+	return s.URLSplitHandler(c)
+}
+
+// stickyVariant возвращает вариант распределения из cookie посетителя; приложение само проверяет,
+// что вариант всё ещё есть у ссылки, поэтому cookie не подписывается
+func stickyVariant(c echo.Context, id string) string {
+	cookie, err := c.Cookie(variantCookiePrefix + id)
+	if err != nil {
+		return ""
+	}
+
+	variant, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return ""
+	}
+
+	return string(variant)
+}
+
+func setStickyVariant(c echo.Context, id, variant string) {
+	c.SetCookie(&http.Cookie{
+		Name:     variantCookiePrefix + id,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(variant)),
+		Path:     "/" + id,
+		MaxAge:   int(variantCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
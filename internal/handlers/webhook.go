@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+func (s *SrvHandler) CreateWebhookHandler(c echo.Context) error {
+	var req models.WebhookRequest
+
+	err := json.NewDecoder(c.Request().Body).Decode(&req)
+	if err != nil {
+		return invalidBody(fmt.Errorf("decode request error: %w", err))
+	}
+
+	webhook, err := s.CreateWebhook(userIDFromContext(c), req)
+	if err != nil {
+		return fmt.Errorf("create webhook error: %w", err)
+	}
+
+	return c.JSON(http.StatusCreated, webhook)
+}
+
+func (s *SrvHandler) WebhooksHandler(c echo.Context) error {
+	webhooks, err := s.Webhooks(userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("get webhooks error: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+func (s *SrvHandler) DeleteWebhookHandler(c echo.Context) error {
+	err := s.DeleteWebhook(c.Param("id"), userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("delete webhook error: %w", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *SrvHandler) WebhookDeliveriesHandler(c echo.Context) error {
+	deliveries, err := s.WebhookDeliveries(c.Param("id"), userIDFromContext(c))
+	if err != nil {
+		return fmt.Errorf("get webhook deliveries error: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, deliveries)
+}
@@ -0,0 +1,230 @@
+// Package linkcheck проверяет доступность URL с ограничением параллельности и частоты запросов к одному хосту
+package linkcheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pluhe7/shortener/internal/safehttp"
+)
+
+const (
+	userAgent = "ShortenerBot/1.0 (+link check)"
+
+	// Сколько байт тела ответа GET дочитывается, чтобы соединение можно было переиспользовать
+	maxDrainBytes = 4 << 10
+)
+
+type Options struct {
+	// Время на одну проверку, включая редиректы
+	Timeout time.Duration
+	// Количество одновременных проверок
+	Concurrency int
+	// Минимальный интервал между запросами к одному хосту
+	HostInterval time.Duration
+	// Разрешить подключения к локальным и частным адресам
+	AllowPrivate bool
+}
+
+// Target - проверяемый URL и идентификатор, по которому вызывающий сопоставит результат
+type Target struct {
+	ID  string
+	URL string
+}
+
+type Result struct {
+	// HTTP статус ответа, 0 - ответ не получен
+	Status int
+	Err    error
+}
+
+// OK сообщает, что URL доступен. 429 означает, что хост ограничил частоту наших проверок,
+// а не что страница пропала, поэтому такой ответ неудачей не считается.
+func (r Result) OK() bool {
+	return r.Err == nil && (r.Status < http.StatusBadRequest || r.Status == http.StatusTooManyRequests)
+}
+
+type Checker struct {
+	client      *http.Client
+	concurrency int
+	hosts       *hostLimiter
+}
+
+func New(options Options) *Checker {
+	return &Checker{
+		client:      safehttp.NewClient(options.Timeout, options.AllowPrivate),
+		concurrency: max(options.Concurrency, 1),
+		hosts:       newHostLimiter(options.HostInterval),
+	}
+}
+
+// CheckAll проверяет targets и передаёт каждый результат в fn, fn вызывается конкурентно.
+// Цели разных хостов чередуются, чтобы ожидание одного хоста не занимало все обработчики.
+func (c *Checker) CheckAll(ctx context.Context, targets []Target, fn func(target Target, result Result)) {
+	queue := make(chan Target)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for target := range queue {
+				fn(target, c.Check(ctx, target.URL))
+			}
+		}()
+	}
+
+	for _, target := range interleaveByHost(targets) {
+		select {
+		case <-ctx.Done():
+		case queue <- target:
+			continue
+		}
+
+		break
+	}
+
+	close(queue)
+	wg.Wait()
+}
+
+// Check отправляет HEAD, а если сервер не поддерживает HEAD - GET
+func (c *Checker) Check(ctx context.Context, rawURL string) Result {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return Result{Err: fmt.Errorf("parse url: %w", err)}
+	}
+
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return Result{Err: fmt.Errorf("unsupported scheme %q", parsedURL.Scheme)}
+	}
+
+	result := c.request(ctx, http.MethodHead, parsedURL)
+	if result.Status == http.StatusMethodNotAllowed || result.Status == http.StatusNotImplemented {
+		result = c.request(ctx, http.MethodGet, parsedURL)
+	}
+
+	return result
+}
+
+func (c *Checker) request(ctx context.Context, method string, target *url.URL) Result {
+	err := c.hosts.wait(ctx, strings.ToLower(target.Host))
+	if err != nil {
+		return Result{Err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+	if err != nil {
+		return Result{Err: fmt.Errorf("new request: %w", err)}
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Result{Err: fmt.Errorf("%s: %w", strings.ToLower(method), err)}
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+
+	result := Result{Status: resp.StatusCode}
+	if !result.OK() {
+		result.Err = fmt.Errorf("%s: unexpected status %d", strings.ToLower(method), resp.StatusCode)
+	}
+
+	return result
+}
+
+// interleaveByHost переставляет цели по кругу между хостами, сохраняя порядок внутри хоста
+func interleaveByHost(targets []Target) []Target {
+	var hosts []string
+	byHost := make(map[string][]Target)
+
+	for _, target := range targets {
+		host := target.URL
+		if parsedURL, err := url.Parse(target.URL); err == nil {
+			host = strings.ToLower(parsedURL.Host)
+		}
+
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], target)
+	}
+
+	result := make([]Target, 0, len(targets))
+	for len(result) < len(targets) {
+		for _, host := range hosts {
+			if queue := byHost[host]; len(queue) > 0 {
+				result = append(result, queue[0])
+				byHost[host] = queue[1:]
+			}
+		}
+	}
+
+	return result
+}
+
+// hostLimiter выдаёт каждому хосту не больше одного слота запроса за interval
+type hostLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func newHostLimiter(interval time.Duration) *hostLimiter {
+	return &hostLimiter{
+		interval: interval,
+		next:     make(map[string]time.Time),
+	}
+}
+
+// wait бронирует ближайший свободный слот хоста и ждёт его наступления
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	if l.interval <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+
+	now := time.Now()
+	slot := l.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[host] = slot.Add(l.interval)
+
+	// слоты в прошлом уже ничего не ограничивают
+	for otherHost, next := range l.next {
+		if next.Before(now) {
+			delete(l.next, otherHost)
+		}
+	}
+
+	l.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
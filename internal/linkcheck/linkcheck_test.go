@@ -0,0 +1,170 @@
+package linkcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pluhe7/shortener/internal/safehttp"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/get-only", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/missing", http.StatusFound)
+	})
+	mux.HandleFunc("/limited", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestCheck(t *testing.T) {
+	srv := newTestServer(t)
+
+	checker := New(Options{Timeout: time.Second, AllowPrivate: true})
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		ok     bool
+	}{
+		{name: "ok", path: "/ok", status: http.StatusOK, ok: true},
+		{name: "not found", path: "/missing", status: http.StatusNotFound},
+		{name: "head not allowed", path: "/get-only", status: http.StatusOK, ok: true},
+		{name: "redirect to missing", path: "/redirect", status: http.StatusNotFound},
+		{name: "rate limited", path: "/limited", status: http.StatusTooManyRequests, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := checker.Check(ctx, srv.URL+tt.path)
+
+			assert.Equal(t, tt.status, result.Status)
+			assert.Equal(t, tt.ok, result.OK())
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		unreachable.Close()
+
+		result := checker.Check(ctx, unreachable.URL)
+		assert.Zero(t, result.Status)
+		assert.False(t, result.OK())
+	})
+
+	t.Run("unsupported scheme", func(t *testing.T) {
+		assert.False(t, checker.Check(ctx, "ftp://example.com/file").OK())
+	})
+}
+
+func TestCheckBlocksPrivateAddresses(t *testing.T) {
+	srv := newTestServer(t)
+
+	result := New(Options{Timeout: time.Second}).Check(context.Background(), srv.URL+"/ok")
+	assert.ErrorIs(t, result.Err, safehttp.ErrForbiddenAddress)
+}
+
+func TestCheckAllLimitsHostRate(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []time.Time
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, time.Now())
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	const interval = 50 * time.Millisecond
+
+	checker := New(Options{Timeout: time.Second, Concurrency: 4, HostInterval: interval, AllowPrivate: true})
+
+	var targets []Target
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		targets = append(targets, Target{ID: path, URL: srv.URL + path})
+	}
+
+	var (
+		resultsMu sync.Mutex
+		checked   []string
+	)
+	checker.CheckAll(context.Background(), targets, func(target Target, result Result) {
+		resultsMu.Lock()
+		defer resultsMu.Unlock()
+
+		assert.True(t, result.OK(), target.URL)
+		checked = append(checked, target.ID)
+	})
+
+	assert.ElementsMatch(t, []string{"/a", "/b", "/c", "/d"}, checked)
+
+	require.Len(t, requests, 4)
+	for i := 1; i < len(requests); i++ {
+		// небольшой запас на разброс таймеров
+		assert.GreaterOrEqual(t, requests[i].Sub(requests[i-1]), interval-5*time.Millisecond)
+	}
+}
+
+func TestCheckAllCanceled(t *testing.T) {
+	srv := newTestServer(t)
+
+	checker := New(Options{Timeout: time.Second, HostInterval: time.Hour, AllowPrivate: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var targets []Target
+	for i := 0; i < 10; i++ {
+		targets = append(targets, Target{URL: srv.URL + "/ok"})
+	}
+
+	start := time.Now()
+	checker.CheckAll(ctx, targets, func(target Target, result Result) {})
+
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestInterleaveByHost(t *testing.T) {
+	targets := []Target{
+		{ID: "a1", URL: "https://a.example/1"},
+		{ID: "a2", URL: "https://a.example/2"},
+		{ID: "a3", URL: "https://A.example/3"},
+		{ID: "b1", URL: "https://b.example/1"},
+		{ID: "c1", URL: "https://c.example/1"},
+		{ID: "b2", URL: "https://b.example/2"},
+	}
+
+	var ids []string
+	for _, target := range interleaveByHost(targets) {
+		ids = append(ids, target.ID)
+	}
+
+	assert.Equal(t, "a1 b1 c1 a2 b2 a3", strings.Join(ids, " "))
+}
@@ -0,0 +1,391 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/logger"
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/storage"
+)
+
+const DefaultBatchSize = 1000
+
+var (
+	ErrUnknownStorageSpec = errors.New("unknown storage spec")
+	ErrCheckpointMismatch = errors.New("checkpoint belongs to another migration")
+	ErrSourceChanged      = errors.New("source records changed since checkpoint")
+	ErrVerificationFailed = errors.New("migration verification failed")
+)
+
+type Options struct {
+	// Описание хранилищ, используется для проверки, что чекпоинт относится к этой миграции
+	From string
+	To   string
+	// Количество записей, сохраняемых в целевое хранилище за один раз
+	BatchSize int
+	// Файл с прогрессом миграции, пустая строка - миграция не возобновляемая
+	CheckpointPath string
+	// Игнорировать существующий чекпоинт и начать миграцию заново
+	Restart bool
+}
+
+type Result struct {
+	Migrated int
+	Skipped  int
+	Source   Digest
+	Target   Digest
+}
+
+type checkpoint struct {
+	From          string `json:"from"`
+	To            string `json:"to"`
+	Processed     int    `json:"processed"`
+	LastShortURL  string `json:"last_short_url"`
+	InitialTarget Digest `json:"initial_target"`
+}
+
+// OpenStorage открывает хранилище по описанию вида file:/path/to/file.json, postgres://... или memory:
+func OpenStorage(spec string) (storage.Storage, error) {
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		return storage.NewFileStorage(strings.TrimPrefix(spec, "file:"))
+
+	case strings.HasPrefix(spec, "postgres://"), strings.HasPrefix(spec, "postgresql://"):
+		return storage.NewDatabaseStorage(spec, storage.DatabaseOptions{})
+
+	case spec == "memory:":
+		return storage.NewMemoryStorage()
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownStorageSpec, spec)
+}
+
+// Run переносит все записи source в target вместе с переходами и историей, сохраняя сокращённые URL.
+// Записи переносятся через RestoreBatch, поэтому подписчики не получают событий создания уже существующих ссылок.
+// Прогресс сохраняется в чекпоинт после каждой пачки, поэтому прерванную миграцию
+// можно запустить повторно; в конце сверяются количество записей и контрольные суммы.
+func Run(ctx context.Context, source, target storage.Storage, opts Options) (*Result, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	cp, err := loadCheckpoint(opts)
+	if err != nil {
+		return nil, fmt.Errorf("load checkpoint: %w", err)
+	}
+
+	if cp == nil {
+		initialTarget, err := ComputeDigest(ctx, target)
+		if err != nil {
+			return nil, fmt.Errorf("compute initial target digest: %w", err)
+		}
+
+		cp = &checkpoint{
+			From:          opts.From,
+			To:            opts.To,
+			InitialTarget: initialTarget,
+		}
+
+		err = saveCheckpoint(opts.CheckpointPath, cp)
+		if err != nil {
+			return nil, fmt.Errorf("save checkpoint: %w", err)
+		}
+
+	} else {
+		logger.Log.Info("resuming migration", zap.Int("processed", cp.Processed), zap.String("last short url", cp.LastShortURL))
+	}
+
+	result := &Result{Skipped: cp.Processed}
+	batch := make([]models.LinkSnapshot, 0, opts.BatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		err := target.RestoreBatch(batch)
+		if err != nil {
+			return fmt.Errorf("save batch: %w", err)
+		}
+
+		cp.Processed += len(batch)
+		cp.LastShortURL = batch[len(batch)-1].Record.ShortURL
+		result.Migrated += len(batch)
+
+		err = saveCheckpoint(opts.CheckpointPath, cp)
+		if err != nil {
+			return fmt.Errorf("save checkpoint: %w", err)
+		}
+
+		logger.Log.Info("batch migrated", zap.Int("processed", cp.Processed))
+
+		batch = batch[:0]
+
+		return nil
+	}
+
+	var index int
+	err = source.Iterate(ctx, func(record models.ShortURLRecord) error {
+		index++
+
+		if index <= result.Skipped {
+			if index == result.Skipped && record.ShortURL != cp.LastShortURL {
+				return fmt.Errorf("%w: expected %s at position %d, got %s",
+					ErrSourceChanged, cp.LastShortURL, index, record.ShortURL)
+			}
+
+			return nil
+		}
+
+		link, err := linkSnapshot(source, record)
+		if err != nil {
+			return err
+		}
+
+		link.Record.ID = 0
+		batch = append(batch, link)
+
+		if len(batch) < opts.BatchSize {
+			return nil
+		}
+
+		return flush()
+	})
+	if err != nil {
+		return result, fmt.Errorf("iterate source: %w", err)
+	}
+
+	err = flush()
+	if err != nil {
+		return result, err
+	}
+
+	result.Source, err = ComputeDigest(ctx, source)
+	if err != nil {
+		return result, fmt.Errorf("compute source digest: %w", err)
+	}
+
+	result.Target, err = ComputeDigest(ctx, target)
+	if err != nil {
+		return result, fmt.Errorf("compute target digest: %w", err)
+	}
+
+	expectedTarget := cp.InitialTarget.Combine(result.Source)
+	if result.Target != expectedTarget {
+		return result, fmt.Errorf("%w: expected target %d records with checksum %s, got %d records with checksum %s",
+			ErrVerificationFailed, expectedTarget.Count, expectedTarget.Checksum, result.Target.Count, result.Target.Checksum)
+	}
+
+	if opts.CheckpointPath != "" {
+		err = os.Remove(opts.CheckpointPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return result, fmt.Errorf("remove checkpoint: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// linkSnapshot дополняет запись её переходами и историей
+func linkSnapshot(s storage.Storage, record models.ShortURLRecord) (models.LinkSnapshot, error) {
+	clicks, err := s.ClickCount(record.ShortURL)
+	if err != nil {
+		return models.LinkSnapshot{}, fmt.Errorf("get click count of %s: %w", record.ShortURL, err)
+	}
+
+	variantClicks, err := s.VariantClicks(record.ShortURL)
+	if err != nil {
+		return models.LinkSnapshot{}, fmt.Errorf("get variant clicks of %s: %w", record.ShortURL, err)
+	}
+
+	history, err := s.History(record.ShortURL)
+	if err != nil {
+		return models.LinkSnapshot{}, fmt.Errorf("get history of %s: %w", record.ShortURL, err)
+	}
+
+	return models.LinkSnapshot{
+		Record:        record,
+		Clicks:        clicks,
+		VariantClicks: variantClicks,
+		History:       history,
+	}, nil
+}
+
+func loadCheckpoint(opts Options) (*checkpoint, error) {
+	if opts.CheckpointPath == "" || opts.Restart {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(opts.CheckpointPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+
+	var cp checkpoint
+	err = json.Unmarshal(data, &cp)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal checkpoint: %w", err)
+	}
+
+	if cp.From != opts.From || cp.To != opts.To {
+		return nil, ErrCheckpointMismatch
+	}
+
+	return &cp, nil
+}
+
+func saveCheckpoint(path string, cp *checkpoint) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("marshal checkpoint: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+
+	err = os.WriteFile(tmpPath, data, 0666)
+	if err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// Digest - количество записей и не зависящая от порядка контрольная сумма набора записей с их переходами и историей.
+// Сумма считается как XOR хешей записей, поэтому дайджест объединения непересекающихся
+// наборов равен Combine их дайджестов.
+type Digest struct {
+	Count    int    `json:"count"`
+	Checksum string `json:"checksum"`
+}
+
+func ComputeDigest(ctx context.Context, s storage.Storage) (Digest, error) {
+	var count int
+	var sum [sha256.Size]byte
+
+	err := s.Iterate(ctx, func(record models.ShortURLRecord) error {
+		link, err := linkSnapshot(s, record)
+		if err != nil {
+			return err
+		}
+
+		hash, err := linkHash(link)
+		if err != nil {
+			return err
+		}
+
+		count++
+		xorInto(&sum, hash)
+		return nil
+	})
+	if err != nil {
+		return Digest{}, err
+	}
+
+	return Digest{Count: count, Checksum: hex.EncodeToString(sum[:])}, nil
+}
+
+func (d Digest) Combine(other Digest) Digest {
+	var sum [sha256.Size]byte
+
+	for _, checksum := range []string{d.Checksum, other.Checksum} {
+		decoded, err := hex.DecodeString(checksum)
+		if err != nil || len(decoded) != sha256.Size {
+			continue
+		}
+
+		xorInto(&sum, [sha256.Size]byte(decoded))
+	}
+
+	return Digest{Count: d.Count + other.Count, Checksum: hex.EncodeToString(sum[:])}
+}
+
+// linkHash покрывает всю запись, её переходы и историю. Хеш не зависит от порядка вариантов,
+// а время берёт в UTC с точностью до микросекунд, с которой его хранит бд
+func linkHash(link models.LinkSnapshot) ([sha256.Size]byte, error) {
+	h := sha256.New()
+
+	record, err := json.Marshal(canonicalRecord(link.Record))
+	if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("marshal record %s: %w", link.Record.ShortURL, err)
+	}
+
+	fmt.Fprintf(h, "%s\x00%d\x00", record, link.Clicks)
+
+	variants := make([]string, 0, len(link.VariantClicks))
+	for variant := range link.VariantClicks {
+		variants = append(variants, variant)
+	}
+	sort.Strings(variants)
+
+	for _, variant := range variants {
+		fmt.Fprintf(h, "variant\x00%s\x00%d\x00", variant, link.VariantClicks[variant])
+	}
+
+	for _, revision := range link.History {
+		fmt.Fprintf(h, "revision\x00%d\x00%s\x00%s\x00%s\x00%s\x00", revision.Revision, revision.OriginalURL,
+			revision.PreviousURL, revision.Editor, canonicalTime(revision.ChangedAt).Format(time.RFC3339Nano))
+	}
+
+	return [sha256.Size]byte(h.Sum(nil)), nil
+}
+
+// canonicalRecord приводит запись к виду, одинаковому во всех хранилищах: без порядкового номера,
+// который каждое хранилище назначает само, и со временем в UTC с точностью до микросекунд
+func canonicalRecord(record models.ShortURLRecord) models.ShortURLRecord {
+	record.ID = 0
+	record.CreatedAt = canonicalTime(record.CreatedAt)
+	record.NotBefore = canonicalTimePointer(record.NotBefore)
+	record.NotAfter = canonicalTimePointer(record.NotAfter)
+
+	if record.Metadata != nil {
+		metadata := *record.Metadata
+		metadata.FetchedAt = canonicalTime(metadata.FetchedAt)
+		record.Metadata = &metadata
+	}
+
+	if record.LinkCheck != nil {
+		check := *record.LinkCheck
+		check.CheckedAt = canonicalTime(check.CheckedAt)
+		record.LinkCheck = &check
+	}
+
+	return record
+}
+
+func canonicalTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+func canonicalTimePointer(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	canonical := canonicalTime(*t)
+
+	return &canonical
+}
+
+func xorInto(dst *[sha256.Size]byte, src [sha256.Size]byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
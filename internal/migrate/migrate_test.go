@@ -0,0 +1,212 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/storage"
+)
+
+type failingStorage struct {
+	storage.Storage
+	batchesLeft int
+}
+
+func (s *failingStorage) RestoreBatch(links []models.LinkSnapshot) error {
+	if s.batchesLeft == 0 {
+		return errors.New("connection lost")
+	}
+	s.batchesLeft--
+
+	return s.Storage.RestoreBatch(links)
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+
+	source, err := storage.NewMemoryStorage()
+	require.NoError(t, err)
+
+	records := make([]models.ShortURLRecord, 0, 25)
+	for i := 0; i < 25; i++ {
+		records = append(records, models.ShortURLRecord{
+			ShortURL:    fmt.Sprintf("short%03d", i),
+			OriginalURL: fmt.Sprintf("https://example.com/%d", i),
+		})
+	}
+	require.NoError(t, source.SaveBatch(records))
+
+	for _, variant := range []string{"", "", "", "https://example.com/b"} {
+		_, err = source.RecordClick("short007", variant)
+		require.NoError(t, err)
+	}
+	_, err = source.Update("short007", "https://example.com/7/v2", "editor")
+	require.NoError(t, err)
+
+	target, err := storage.NewFileStorage(filepath.Join(dir, "target.json"))
+	require.NoError(t, err)
+
+	opts := Options{
+		From:           "memory:",
+		To:             "file:target.json",
+		BatchSize:      10,
+		CheckpointPath: filepath.Join(dir, "checkpoint.json"),
+	}
+
+	t.Run("interrupted", func(t *testing.T) {
+		result, err := Run(context.Background(), source, &failingStorage{Storage: target, batchesLeft: 1}, opts)
+		require.Error(t, err)
+		assert.Equal(t, 10, result.Migrated)
+		assert.FileExists(t, opts.CheckpointPath)
+	})
+
+	t.Run("resumed", func(t *testing.T) {
+		result, err := Run(context.Background(), source, target, opts)
+		require.NoError(t, err)
+
+		assert.Equal(t, 10, result.Skipped)
+		assert.Equal(t, 15, result.Migrated)
+		assert.Equal(t, result.Source, result.Target)
+		assert.NoFileExists(t, opts.CheckpointPath)
+
+		record, err := target.Get("short007")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/7/v2", record.OriginalURL)
+
+		clicks, err := target.ClickCount("short007")
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), clicks)
+
+		variantClicks, err := target.VariantClicks("short007")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int64{"https://example.com/b": 1}, variantClicks)
+
+		history, err := target.History("short007")
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, "https://example.com/7", history[0].PreviousURL)
+		assert.Equal(t, "editor", history[0].Editor)
+	})
+
+	t.Run("another checkpoint", func(t *testing.T) {
+		otherOpts := opts
+		otherOpts.To = "file:other.json"
+
+		_, err := Run(context.Background(), source, &failingStorage{Storage: target}, otherOpts)
+		require.Error(t, err)
+
+		_, err = Run(context.Background(), source, target, opts)
+		require.ErrorIs(t, err, ErrCheckpointMismatch)
+	})
+}
+
+func TestDigestCombine(t *testing.T) {
+	first, err := storage.NewMemoryStorage()
+	require.NoError(t, err)
+	require.NoError(t, first.Save(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"}))
+
+	second, err := storage.NewMemoryStorage()
+	require.NoError(t, err)
+	require.NoError(t, second.Save(models.ShortURLRecord{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com"}))
+
+	both, err := storage.NewMemoryStorage()
+	require.NoError(t, err)
+	require.NoError(t, both.Save(models.ShortURLRecord{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com"}))
+	require.NoError(t, both.Save(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"}))
+
+	firstDigest, err := ComputeDigest(context.Background(), first)
+	require.NoError(t, err)
+	secondDigest, err := ComputeDigest(context.Background(), second)
+	require.NoError(t, err)
+	bothDigest, err := ComputeDigest(context.Background(), both)
+	require.NoError(t, err)
+
+	assert.Equal(t, bothDigest, firstDigest.Combine(secondDigest))
+}
+
+func TestDigestIncludesClicks(t *testing.T) {
+	s, err := storage.NewMemoryStorage()
+	require.NoError(t, err)
+	require.NoError(t, s.Save(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"}))
+
+	before, err := ComputeDigest(context.Background(), s)
+	require.NoError(t, err)
+
+	_, err = s.RecordClick("aaaaaaaa", "")
+	require.NoError(t, err)
+
+	after, err := ComputeDigest(context.Background(), s)
+	require.NoError(t, err)
+
+	assert.Equal(t, before.Count, after.Count)
+	assert.NotEqual(t, before.Checksum, after.Checksum)
+}
+
+func TestLinkHashIncludesRecord(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 10, 0, 0, 123456789, time.UTC)
+	base := models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru", CreatedAt: createdAt}
+
+	baseHash, err := linkHash(models.LinkSnapshot{Record: base})
+	require.NoError(t, err)
+
+	notAfter := createdAt.Add(time.Hour)
+
+	for name, change := range map[string]func(record *models.ShortURLRecord){
+		"user":                func(record *models.ShortURLRecord) { record.UserID = "user" },
+		"deleted":             func(record *models.ShortURLRecord) { record.IsDeleted = true },
+		"password":            func(record *models.ShortURLRecord) { record.PasswordHash = "hash" },
+		"rules":               func(record *models.ShortURLRecord) { record.Rules = []models.RedirectRule{{URL: "https://ya.ru"}} },
+		"split":               func(record *models.ShortURLRecord) { record.Split = &models.Split{Sticky: true} },
+		"schedule":            func(record *models.ShortURLRecord) { record.NotAfter = &notAfter },
+		"max clicks":          func(record *models.ShortURLRecord) { record.MaxClicks = 1 },
+		"redirect status":     func(record *models.ShortURLRecord) { record.RedirectStatus = 301 },
+		"query passthrough":   func(record *models.ShortURLRecord) { record.QueryPassthrough = true },
+		"path passthrough":    func(record *models.ShortURLRecord) { record.PathPassthrough = true },
+		"broken fallback url": func(record *models.ShortURLRecord) { record.BrokenFallbackURL = "https://ya.ru" },
+		"metadata":            func(record *models.ShortURLRecord) { record.Metadata = &models.URLMetadata{Title: "Yandex"} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			record := base
+			change(&record)
+
+			hash, err := linkHash(models.LinkSnapshot{Record: record})
+			require.NoError(t, err)
+			assert.NotEqual(t, baseHash, hash)
+		})
+	}
+
+	t.Run("storage specific details", func(t *testing.T) {
+		record := base
+		record.ID = 42
+		record.CreatedAt = createdAt.Truncate(time.Microsecond).In(time.FixedZone("MSK", 3*60*60))
+
+		hash, err := linkHash(models.LinkSnapshot{Record: record})
+		require.NoError(t, err)
+		assert.Equal(t, baseHash, hash, "number, time zone and nanoseconds should not change the hash")
+	})
+}
+
+func TestRunQueuesNoEvents(t *testing.T) {
+	source, err := storage.NewMemoryStorage()
+	require.NoError(t, err)
+	require.NoError(t, source.Save(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru", UserID: "user"}))
+
+	target, err := storage.NewMemoryStorage()
+	require.NoError(t, err)
+	require.NoError(t, target.CreateWebhook(models.Webhook{ID: "webhook", UserID: "user", URL: "https://example.com/hook"}))
+
+	_, err = Run(context.Background(), source, target, Options{From: "memory:", To: "memory:"})
+	require.NoError(t, err)
+
+	tasks, err := target.ClaimDeliveries(time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+}
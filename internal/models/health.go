@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+const (
+	HealthStatusOK          = "ok"
+	HealthStatusDegraded    = "degraded"
+	HealthStatusUnavailable = "unavailable"
+)
+
+type HealthResponse struct {
+	Status    string          `json:"status"`
+	LatencyMs float64         `json:"latency_ms"`
+	Code      string          `json:"code,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Pool      *PoolStats      `json:"pool,omitempty"`
+	Replicas  []ReplicaHealth `json:"replicas,omitempty"`
+	Breaker   *BreakerHealth  `json:"breaker,omitempty"`
+	Cache     *CacheStats     `json:"cache,omitempty"`
+	// Ошибка проверки хранилища, по которой обработчик заполняет Code и Error; в ответ не попадает
+	Err error `json:"-"`
+}
+
+type PoolStats struct {
+	MaxOpenConnections int     `json:"max_open_connections"`
+	OpenConnections    int     `json:"open_connections"`
+	InUse              int     `json:"in_use"`
+	Idle               int     `json:"idle"`
+	WaitCount          int64   `json:"wait_count"`
+	WaitDurationMs     float64 `json:"wait_duration_ms"`
+	MaxIdleClosed      int64   `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64   `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64   `json:"max_lifetime_closed"`
+}
+
+type ReplicaHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+}
+
+type BreakerHealth struct {
+	State             string    `json:"state"`
+	Failures          int       `json:"failures"`
+	RetryAfterSeconds float64   `json:"retry_after_seconds,omitempty"`
+	SnapshotSize      int       `json:"snapshot_size"`
+	SnapshotAt        time.Time `json:"snapshot_at"`
+}
+
+type CacheStats struct {
+	Hits         uint64 `json:"hits"`
+	Misses       uint64 `json:"misses"`
+	NegativeHits uint64 `json:"negative_hits"`
+	Evictions    uint64 `json:"evictions"`
+	Size         int    `json:"size"`
+}
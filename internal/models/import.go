@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Результаты импорта строки
+const (
+	ImportCreated = "created"
+	// Ссылка на исходный URL строки уже есть, возвращается она
+	ImportExists = "exists"
+	ImportFailed = "failed"
+)
+
+// ImportResult - результат импорта одной строки CSV
+type ImportResult struct {
+	// Номер строки CSV, начиная с 1
+	Line        int    `json:"line"`
+	Status      string `json:"status"`
+	OriginalURL string `json:"original_url,omitempty"`
+	ShortURL    string `json:"short_url,omitempty"`
+	// Стабильный код ошибки строки, как у Problem
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Ошибка строки, по которой обработчик заполняет Code и Error; в ответ не попадает
+	Err error `json:"-"`
+}
+
+// ExportedURL - ссылка пользователя в выгрузке; первые поля совпадают с колонками импорта
+type ExportedURL struct {
+	OriginalURL string `json:"original_url"`
+	// Сокращённый URL без базового адреса: при повторном импорте ссылка получит его же
+	Alias     string     `json:"alias"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	ShortURL  string     `json:"short_url"`
+	CreatedAt time.Time  `json:"created_at"`
+}
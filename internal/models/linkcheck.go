@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// LinkCheck - результат последней проверки доступности исходного URL
+type LinkCheck struct {
+	// HTTP статус ответа, 0 - ответ не получен
+	Status int `json:"status"`
+	// Причина неудачной проверки, пустая при успехе
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	// Количество неудачных проверок подряд, 0 после успешной
+	ConsecutiveFailures int `json:"consecutive_failures"`
+}
+
+// BrokenURL - ссылка пользователя, исходный URL которой не отвечает
+type BrokenURL struct {
+	ShortURL          string    `json:"short_url"`
+	OriginalURL       string    `json:"original_url"`
+	BrokenFallbackURL string    `json:"broken_fallback_url,omitempty"`
+	LinkCheck         LinkCheck `json:"link_check"`
+}
+
+type BrokenFallbackRequest struct {
+	// Пустой URL отключает переход на запасной URL
+	URL string `json:"url"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// URLMetadata - сведения о странице исходного URL, полученные после создания ссылки
+type URLMetadata struct {
+	// Содержимое <title>
+	Title string `json:"title,omitempty"`
+	// Содержимое <meta name="description">
+	Description string `json:"description,omitempty"`
+	// Теги OpenGraph без префикса og:, например title, image, site_name
+	OpenGraph map[string]string `json:"open_graph,omitempty"`
+	FetchedAt time.Time         `json:"fetched_at"`
+	// Причина, по которой страницу не удалось получить, пустая при успехе; подробности ошибки только в логе
+	Error string `json:"error,omitempty"`
+}
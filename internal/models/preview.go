@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// URLPreview - сведения о сокращённой ссылке без перехода по ней.
+// У ссылки с паролем исходный URL и домен скрыты, пока она не открыта,
+// у ссылки с лимитом переходов - всегда, чтобы превью не заменяло переход,
+// у ссылки с расписанием - вне окна работы.
+// Сведения о странице скрываются вместе с исходным URL.
+type URLPreview struct {
+	ShortURL    string       `json:"short_url"`
+	OriginalURL string       `json:"original_url,omitempty"`
+	Domain      string       `json:"domain,omitempty"`
+	CreatedAt   *time.Time   `json:"created_at,omitempty"`
+	Clicks      int64        `json:"clicks"`
+	Protected   bool         `json:"protected"`
+	MaxClicks   int          `json:"max_clicks,omitempty"`
+	NotBefore   *time.Time   `json:"not_before,omitempty"`
+	NotAfter    *time.Time   `json:"not_after,omitempty"`
+	Metadata    *URLMetadata `json:"metadata,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+// Problem - ответ с ошибкой в формате RFC 7807 (application/problem+json)
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Путь запроса, на который получена ошибка
+	Instance string `json:"instance,omitempty"`
+	// Стабильный код ошибки, по которому клиент может её различать
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
@@ -0,0 +1,31 @@
+package models
+
+// RedirectRule - правило перехода по сокращённой ссылке: если выполнены все заданные условия,
+// переход ведёт на URL правила. Правила проверяются по порядку, без совпадений - на исходный URL.
+type RedirectRule struct {
+	// Класс устройства по User-Agent: ios, android, mobile, desktop или bot; mobile включает ios и android
+	Device string `json:"device,omitempty"`
+	// Языки, с которыми сравнивается самый предпочтительный язык Accept-Language; "en" совпадает и с "en-US"
+	Languages  []string        `json:"languages,omitempty"`
+	TimeWindow *TimeWindow     `json:"time_window,omitempty"`
+	Query      *QueryCondition `json:"query,omitempty"`
+	URL        string          `json:"url"`
+}
+
+type TimeWindow struct {
+	// Начало и конец окна в формате HH:MM; окно, у которого начало позже конца, переходит через полночь
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Часовой пояс IANA, пустой - UTC
+	Timezone string `json:"timezone,omitempty"`
+}
+
+type QueryCondition struct {
+	Param string `json:"param"`
+	// Ожидаемое значение параметра, пустое - достаточно наличия параметра
+	Value string `json:"value,omitempty"`
+}
+
+type RedirectRules struct {
+	Rules []RedirectRule `json:"rules"`
+}
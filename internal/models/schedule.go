@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Schedule - окно, в котором сокращённая ссылка работает; вне окна переход ведёт на FallbackURL, если он задан
+type Schedule struct {
+	// Начало окна, nil - ссылка работает сразу
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	// Конец окна, nil - без конца
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	FallbackURL string     `json:"fallback_url,omitempty"`
+}
+
+// UserURL - ссылка пользователя в списке его ссылок
+type UserURL struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	// Состояние окна работы ссылки: upcoming, active или expired
+	Status string `json:"status"`
+}
@@ -1,7 +1,18 @@
 package models
 
+import "time"
+
 type ShortenRequest struct {
-	URL string `json:"url"`
+	URL              string `json:"url"`
+	Password         string `json:"password,omitempty"`
+	RedirectStatus   int    `json:"redirect_status,omitempty"`
+	QueryPassthrough bool   `json:"query_passthrough,omitempty"`
+	// keep - оставить значения исходного URL, replace - заменить значениями запроса, append - передать оба
+	QueryConflictPolicy string `json:"query_conflict_policy,omitempty"`
+	PathPassthrough     bool   `json:"path_passthrough,omitempty"`
+	MaxClicks           int    `json:"max_clicks,omitempty"`
+	BrokenFallbackURL   string `json:"broken_fallback_url,omitempty"`
+	Schedule
 }
 
 type ShortenResponse struct {
@@ -11,9 +22,36 @@ type ShortenResponse struct {
 type OriginalURLWithID struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	// Собственный сокращённый URL вместо случайного
+	Alias string `json:"alias,omitempty"`
+	// Время, после которого ссылка перестаёт работать
+	NotAfter *time.Time `json:"not_after,omitempty"`
 }
 
 type ShortURLWithID struct {
 	CorrelationID string `json:"correlation_id"`
 	ShortURL      string `json:"short_url"`
+	// Ссылка на QR-код, заполняется по запросу с ?qr=true
+	QRURL string `json:"qr_url,omitempty"`
+}
+
+// StreamShortenResult - строка ответа потокового сокращения
+type StreamShortenResult struct {
+	// Номер строки запроса, начиная с 1; у ошибки, прервавшей сокращение, может отсутствовать
+	Line          int    `json:"line,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	ShortURL      string `json:"short_url,omitempty"`
+	// Стабильный код ошибки строки, как у Problem
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Ошибка строки, по которой обработчик заполняет Code и Error; в ответ не попадает
+	Err error `json:"-"`
+}
+
+type UpdateURLRequest struct {
+	URL string `json:"url"`
+}
+
+type RevertURLRequest struct {
+	Revision int `json:"revision"`
 }
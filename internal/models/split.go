@@ -0,0 +1,25 @@
+package models
+
+// Split - распределение переходов по сокращённой ссылке между несколькими URL пропорционально весам
+type Split struct {
+	Targets []SplitTarget `json:"targets"`
+	// Закреплять выбранный вариант за посетителем через cookie
+	Sticky bool `json:"sticky,omitempty"`
+}
+
+type SplitTarget struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// SplitStats - варианты ссылки с количеством переходов по каждому
+type SplitStats struct {
+	Targets []SplitTargetStats `json:"targets"`
+	Sticky  bool               `json:"sticky"`
+}
+
+type SplitTargetStats struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Clicks int64  `json:"clicks"`
+}
@@ -1,7 +1,63 @@
 package models
 
+import "time"
+
 type ShortURLRecord struct {
 	ID          int    `json:"uuid"`
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+	UserID      string `json:"user_id,omitempty"`
+	IsDeleted   bool   `json:"is_deleted,omitempty"`
+	// bcrypt-хеш пароля, пустой - ссылка без пароля
+	PasswordHash string `json:"password_hash,omitempty"`
+	// Время создания, нулевое у записей, созданных до появления поля
+	CreatedAt time.Time `json:"created_at"`
+	// HTTP статус редиректа, 0 - статус по умолчанию сервера
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	// Добавлять параметры запроса перехода к исходному URL
+	QueryPassthrough bool `json:"query_passthrough,omitempty"`
+	// Политика конфликта параметров запроса, пустая - политика по умолчанию сервера
+	QueryConflictPolicy string `json:"query_conflict_policy,omitempty"`
+	// Добавлять сегменты пути после сокращённого URL к пути исходного URL, кроме зарезервированных (app.QRSubPath)
+	PathPassthrough bool `json:"path_passthrough,omitempty"`
+	// Правила перехода, проверяются по порядку до первого совпадения
+	Rules []RedirectRule `json:"rules,omitempty"`
+	// Распределение переходов между вариантами, nil - переход всегда на исходный URL или URL правила
+	Split *Split `json:"split,omitempty"`
+	// Максимальное количество переходов, после которого ссылка перестаёт работать, 0 - без ограничения
+	MaxClicks int `json:"max_clicks,omitempty"`
+	// Сведения о странице исходного URL, nil - ещё не получены
+	Metadata *URLMetadata `json:"metadata,omitempty"`
+	// Результат последней проверки исходного URL, nil - ещё не проверялся
+	LinkCheck *LinkCheck `json:"link_check,omitempty"`
+	// URL, на который ведёт переход, пока исходный URL не отвечает; пустой - переход на исходный URL
+	BrokenFallbackURL string `json:"broken_fallback_url,omitempty"`
+	// Окно работы ссылки
+	Schedule
+}
+
+// URLRevision - одно изменение исходного URL сокращённой ссылки
+type URLRevision struct {
+	ShortURL    string    `json:"short_url"`
+	Revision    int       `json:"revision"`
+	OriginalURL string    `json:"original_url"`
+	PreviousURL string    `json:"previous_url"`
+	Editor      string    `json:"editor,omitempty"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+// BatchReport - результат сохранения пачки: какие сокращённые URL были добавлены,
+// а какие пропущены из-за конфликта с уже существующими записями
+type BatchReport struct {
+	Inserted   []string
+	Conflicted []string
+}
+
+// LinkSnapshot - запись со всем, что накоплено по ней отдельно от неё: переходами и историей изменений
+type LinkSnapshot struct {
+	Record ShortURLRecord
+	Clicks int64
+	// Переходы по вариантам распределения, входят и в Clicks
+	VariantClicks map[string]int64
+	History       []URLRevision
 }
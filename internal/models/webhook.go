@@ -0,0 +1,71 @@
+package models
+
+import "time"
+
+// События жизненного цикла ссылки, на которые можно подписаться
+const (
+	EventLinkCreated = "link.created"
+	EventLinkUpdated = "link.updated"
+	EventLinkDeleted = "link.deleted"
+	EventLinkExpired = "link.expired"
+)
+
+// Состояния доставки события подписке
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook - подписка пользователя на события его ссылок
+type Webhook struct {
+	ID     string `json:"id"`
+	UserID string `json:"-"`
+	URL    string `json:"url"`
+	// События, которые нужно доставлять; пустой список - все события
+	Events []string `json:"events,omitempty"`
+	// Секрет подписи HMAC, отдаётся только при создании подписки
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	// Пустой секрет генерируется
+	Secret string `json:"secret,omitempty"`
+}
+
+// WebhookEvent - событие ссылки, поставленное в очередь доставки
+type WebhookEvent struct {
+	// Ключ события: одно событие доставляется подписке один раз
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Сокращённый URL без базового адреса
+	LinkID      string    `json:"link_id"`
+	OriginalURL string    `json:"original_url"`
+	UserID      string    `json:"-"`
+	OccurredAt  time.Time `json:"occurred_at"`
+}
+
+// WebhookDelivery - доставка события подписке и результат последней попытки
+type WebhookDelivery struct {
+	ID        int64        `json:"id"`
+	WebhookID string       `json:"webhook_id"`
+	Event     WebhookEvent `json:"event"`
+	Status    string       `json:"status"`
+	Attempts  int          `json:"attempts"`
+	// Время следующей попытки ожидающей доставки
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	// HTTP статус ответа на последнюю попытку, 0 - ответ не получен
+	ResponseStatus int       `json:"response_status,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// WebhookTask - доставка, взятая в работу, вместе с подпиской, которой она адресована
+type WebhookTask struct {
+	Webhook  Webhook
+	Delivery WebhookDelivery
+}
@@ -0,0 +1,566 @@
+// Package qr кодирует текст в QR-код по ISO/IEC 18004 (версии 1-40, уровни коррекции L, M, Q, H)
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Level - уровень коррекции ошибок
+type Level int
+
+const (
+	LevelL Level = iota
+	LevelM
+	LevelQ
+	LevelH
+)
+
+const (
+	minVersion = 1
+	maxVersion = 40
+)
+
+var ErrTooLong = errors.New("text is too long for qr code")
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return LevelL, nil
+	case "M":
+		return LevelM, nil
+	case "Q":
+		return LevelQ, nil
+	case "H":
+		return LevelH, nil
+	}
+
+	return 0, fmt.Errorf("unknown error correction level %q", s)
+}
+
+func (l Level) String() string {
+	return [...]string{"L", "M", "Q", "H"}[l]
+}
+
+// formatBits - код уровня в информации о формате
+func (l Level) formatBits() int {
+	return [...]int{1, 0, 3, 2}[l]
+}
+
+// Количество кодовых слов коррекции в блоке и количество блоков по уровню и версии, индекс 0 не используется
+var (
+	eccCodewordsPerBlock = [4][41]int{
+		{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+		{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	}
+	numErrorCorrectionBlocks = [4][41]int{
+		{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+		{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+		{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+		{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+	}
+)
+
+// Code - матрица модулей QR-кода
+type Code struct {
+	Size    int
+	Version int
+	Level   Level
+	Mask    int
+
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Dark сообщает, тёмный ли модуль в столбце x строки y
+func (c *Code) Dark(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y][x]
+}
+
+// Encode кодирует текст QR-кодом минимальной версии, вмещающей его на уровне коррекции level
+func Encode(text string, level Level) (*Code, error) {
+	seg := newSegment(text)
+
+	version := minVersion
+	for ; ; version++ {
+		if version > maxVersion {
+			return nil, ErrTooLong
+		}
+
+		bits := seg.bitLength(version)
+		if bits >= 0 && bits <= numDataCodewords(version, level)*8 {
+			break
+		}
+	}
+
+	data := encodeData(seg, version, level)
+
+	return newCode(version, level, addECCAndInterleave(data, version, level)), nil
+}
+
+// encodeData собирает кодовые слова данных: режим, длину, данные, терминатор и байты-заполнители
+func encodeData(seg segment, version int, level Level) []byte {
+	capacityBits := numDataCodewords(version, level) * 8
+
+	var bb bitBuffer
+	bb.append(seg.mode.indicator, 4)
+	bb.append(seg.count, seg.mode.countBits(version))
+	bb.appendBits(seg.data)
+
+	bb.append(0, min(4, capacityBits-len(bb)))
+	bb.append(0, (8-len(bb)%8)%8)
+
+	for padByte := 0xEC; len(bb) < capacityBits; padByte ^= 0xEC ^ 0x11 {
+		bb.append(padByte, 8)
+	}
+
+	data := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			data[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	return data
+}
+
+// addECCAndInterleave делит данные на блоки, добавляет к каждому коды Рида-Соломона и чередует блоки
+func addECCAndInterleave(data []byte, version int, level Level) []byte {
+	numBlocks := numErrorCorrectionBlocks[level][version]
+	blockECCLen := eccCodewordsPerBlock[level][version]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(blockECCLen)
+
+	dataBlocks := make([][]byte, numBlocks)
+	eccBlocks := make([][]byte, numBlocks)
+
+	offset := 0
+	for i := 0; i < numBlocks; i++ {
+		dataLen := shortBlockLen - blockECCLen
+		if i >= numShortBlocks {
+			dataLen++
+		}
+
+		dataBlocks[i] = data[offset : offset+dataLen]
+		eccBlocks[i] = reedSolomonRemainder(dataBlocks[i], divisor)
+		offset += dataLen
+	}
+
+	result := make([]byte, 0, rawCodewords)
+
+	for i := 0; i <= shortBlockLen-blockECCLen; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+
+	for i := 0; i < blockECCLen; i++ {
+		for _, block := range eccBlocks {
+			result = append(result, block[i])
+		}
+	}
+
+	return result
+}
+
+func newCode(version int, level Level, codewords []byte) *Code {
+	size := version*4 + 17
+
+	c := &Code{
+		Size:       size,
+		Version:    version,
+		Level:      level,
+		modules:    newGrid(size),
+		isFunction: newGrid(size),
+	}
+
+	c.drawFunctionPatterns()
+	c.drawCodewords(codewords)
+
+	minPenalty := math.MaxInt
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+
+		penalty := c.penalty()
+		if penalty < minPenalty {
+			minPenalty = penalty
+			c.Mask = mask
+		}
+
+		// маска обратима: повторное наложение возвращает исходные модули
+		c.applyMask(mask)
+	}
+
+	c.applyMask(c.Mask)
+	c.drawFormatBits(c.Mask)
+
+	return c
+}
+
+func newGrid(size int) [][]bool {
+	grid := make([][]bool, size)
+	for i := range grid {
+		grid[i] = make([]bool, size)
+	}
+
+	return grid
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	c.drawFinderPattern(3, 3)
+	c.drawFinderPattern(c.Size-4, 3)
+	c.drawFinderPattern(3, c.Size-4)
+
+	positions := alignmentPatternPositions(c.Version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// центры, занятые узорами поиска
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+
+			c.drawAlignmentPattern(x, y)
+		}
+	}
+
+	// резервирует место информации о формате, отрисовывается после выбора маски
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+// drawFinderPattern рисует узор поиска с разделителем вокруг центра x, y
+func (c *Code) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+
+			distance := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, distance != 2 && distance != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	bits := formatInfo(c.Level, mask)
+
+	// первая копия - вокруг верхнего левого узора поиска
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(bits, i))
+	}
+	c.setFunction(8, 7, bit(bits, 6))
+	c.setFunction(8, 8, bit(bits, 7))
+	c.setFunction(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(bits, i))
+	}
+
+	// вторая копия - у верхнего правого и нижнего левого узоров
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(bits, i))
+	}
+
+	c.setFunction(8, c.Size-8, true)
+}
+
+// drawVersion рисует информацию о версии, она есть только у версий от 7
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+
+	bits := versionInfo(c.Version)
+
+	for i := 0; i < 18; i++ {
+		a := c.Size - 11 + i%3
+		b := i / 3
+
+		c.setFunction(a, b, bit(bits, i))
+		c.setFunction(b, a, bit(bits, i))
+	}
+}
+
+// drawCodewords размещает кодовые слова зигзагом по парам столбцов снизу вверх и сверху вниз
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// вертикальная линия синхронизации пропускается целиком
+		if right == 6 {
+			right = 5
+		}
+
+		upward := (right+1)&2 == 0
+
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.isFunction[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+
+				c.modules[y][x] = codewords[i>>3]>>(7-i&7)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.isFunction[y][x] && maskInverts(mask, x, y) {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+func maskInverts(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// penalty оценивает матрицу по четырём правилам стандарта; выбирается маска с наименьшей оценкой
+func (c *Code) penalty() int {
+	const (
+		penaltyN1 = 3
+		penaltyN2 = 3
+		penaltyN3 = 40
+		penaltyN4 = 10
+	)
+
+	result := 0
+
+	line := make([]bool, c.Size)
+	for _, horizontal := range []bool{true, false} {
+		for i := 0; i < c.Size; i++ {
+			for j := 0; j < c.Size; j++ {
+				if horizontal {
+					line[j] = c.modules[i][j]
+				} else {
+					line[j] = c.modules[j][i]
+				}
+			}
+
+			result += runsPenalty(line, penaltyN1) + finderLikePenalty(line, penaltyN3)
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+
+			if x > 0 && y > 0 {
+				color := c.modules[y][x]
+				if color == c.modules[y][x-1] && color == c.modules[y-1][x] && color == c.modules[y-1][x-1] {
+					result += penaltyN2
+				}
+			}
+		}
+	}
+
+	total := c.Size * c.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	result += k * penaltyN4
+
+	return result
+}
+
+// runsPenalty штрафует серии из пяти и более модулей одного цвета
+func runsPenalty(line []bool, weight int) int {
+	result := 0
+	run := 1
+
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+
+		if run >= 5 {
+			result += weight + run - 5
+		}
+		run = 1
+	}
+
+	return result
+}
+
+// finderLikePenalty штрафует участки 1:1:3:1:1 со светлыми четырьмя модулями с одной из сторон
+func finderLikePenalty(line []bool, weight int) int {
+	pattern := []bool{true, false, true, true, true, false, true}
+	result := 0
+
+	for i := 0; i+len(pattern) <= len(line); i++ {
+		matches := true
+		for j, dark := range pattern {
+			if line[i+j] != dark {
+				matches = false
+				break
+			}
+		}
+
+		if matches && (isLight(line, i-4, i) || isLight(line, i+len(pattern), i+len(pattern)+4)) {
+			result += weight
+		}
+	}
+
+	return result
+}
+
+// isLight сообщает, что модули [from, to) светлые; модули за краем считаются светлыми, как поле вокруг кода
+func isLight(line []bool, from, to int) bool {
+	for i := from; i < to; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// formatInfo возвращает 15 бит информации о формате: уровень и маска, код БЧХ (15, 5) и маска 0x5412
+func formatInfo(level Level, mask int) int {
+	data := level.formatBits()<<3 | mask
+
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = remainder<<1 ^ (remainder>>9)*0x537
+	}
+
+	return (data<<10 | remainder) ^ 0x5412
+}
+
+// versionInfo возвращает 18 бит информации о версии с кодом Голея (18, 6)
+func versionInfo(version int) int {
+	remainder := version
+	for i := 0; i < 12; i++ {
+		remainder = remainder<<1 ^ (remainder>>11)*0x1F25
+	}
+
+	return version<<12 | remainder
+}
+
+// alignmentPatternPositions возвращает координаты центров узоров выравнивания по каждой оси
+func alignmentPatternPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	size := version*4 + 17
+
+	positions := make([]int, numAlign)
+	positions[0] = 6
+	for i, pos := numAlign-1, size-7; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+
+	return positions
+}
+
+// numRawDataModules - количество модулей под данные и коррекцию без служебных узоров
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+
+		if version >= 7 {
+			result -= 36
+		}
+	}
+
+	return result
+}
+
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 -
+		eccCodewordsPerBlock[level][version]*numErrorCorrectionBlocks[level][version]
+}
+
+type bitBuffer []bool
+
+// append дописывает length младших битов value, начиная со старшего
+func (bb *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*bb = append(*bb, value>>i&1 == 1)
+	}
+}
+
+func (bb *bitBuffer) appendBits(bits []bool) {
+	*bb = append(*bb, bits...)
+}
+
+func bit(value, i int) bool {
+	return value>>i&1 == 1
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
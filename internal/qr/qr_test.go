@@ -0,0 +1,181 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeData(t *testing.T) {
+	seg := newSegment("HELLO WORLD")
+	assert.Equal(t, modeAlphanumeric, seg.mode)
+
+	data := encodeData(seg, 1, LevelM)
+	assert.Equal(t, []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}, data)
+
+	ecc := reedSolomonRemainder(data, reedSolomonDivisor(eccCodewordsPerBlock[LevelM][1]))
+	assert.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, ecc)
+}
+
+func TestSegmentModes(t *testing.T) {
+	assert.Equal(t, modeNumeric, newSegment("01234567").mode)
+	assert.Equal(t, modeAlphanumeric, newSegment("HTTP://EXAMPLE.COM/ABC").mode)
+	assert.Equal(t, modeByte, newSegment("http://localhost:8080/aBc").mode)
+	assert.Equal(t, modeByte, newSegment("").mode)
+
+	// 01234567 -> 012 345 67 -> 0000001100 0101011001 1000011
+	assert.Equal(t, 27, len(newSegment("01234567").data))
+}
+
+func TestFormatAndVersionInfo(t *testing.T) {
+	assert.Equal(t, 0b111011111000100, formatInfo(LevelL, 0))
+	assert.Equal(t, 0b101010000010010, formatInfo(LevelM, 0))
+	assert.Equal(t, 0b011010101011111, formatInfo(LevelQ, 0))
+	assert.Equal(t, 0b001011010001001, formatInfo(LevelH, 0))
+
+	assert.Equal(t, 0b000111110010010100, versionInfo(7))
+}
+
+func TestAlignmentPatternPositions(t *testing.T) {
+	assert.Empty(t, alignmentPatternPositions(1))
+	assert.Equal(t, []int{6, 18}, alignmentPatternPositions(2))
+	assert.Equal(t, []int{6, 22, 38}, alignmentPatternPositions(7))
+	assert.Equal(t, []int{6, 34, 60, 86, 112, 138}, alignmentPatternPositions(32))
+	assert.Equal(t, []int{6, 30, 58, 86, 114, 142, 170}, alignmentPatternPositions(40))
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		level   Level
+		version int
+	}{
+		{name: "hello world", text: "HELLO WORLD", level: LevelM, version: 1},
+		{name: "short url", text: "http://localhost:8080/EwHXdJfB", level: LevelM, version: 3},
+		{name: "high correction", text: "http://localhost:8080/EwHXdJfB", level: LevelH, version: 4},
+		{name: "version info", text: strings.Repeat("a", 200), level: LevelQ, version: 12},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, err := Encode(test.text, test.level)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.version, code.Version)
+			assert.Equal(t, test.version*4+17, code.Size)
+
+			for _, corner := range [][2]int{{0, 0}, {code.Size - 7, 0}, {0, code.Size - 7}} {
+				assertFinderPattern(t, code, corner[0], corner[1])
+			}
+
+			assert.Equal(t, formatInfo(test.level, code.Mask), readFormatInfo(code))
+
+			seg := newSegment(test.text)
+			codewords := addECCAndInterleave(encodeData(seg, code.Version, code.Level), code.Version, code.Level)
+			assert.Equal(t, codewords, readCodewords(code, len(codewords)))
+		})
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	_, err := Encode(strings.Repeat("a", 1300), LevelH)
+	assert.ErrorIs(t, err, ErrTooLong)
+
+	code, err := Encode(strings.Repeat("a", 1273), LevelH)
+	require.NoError(t, err)
+	assert.Equal(t, 40, code.Version)
+}
+
+func TestRender(t *testing.T) {
+	code, err := Encode("HELLO WORLD", LevelM)
+	require.NoError(t, err)
+
+	data, err := code.PNG(300, 4)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 300, img.Bounds().Dx())
+	assert.Equal(t, 300, img.Bounds().Dy())
+
+	// 29 модулей по 10 пикселей и по 5 пикселей остатка с каждой стороны
+	r, _, _, _ := img.At(45, 45).RGBA()
+	assert.Zero(t, r, "top left module is dark")
+	r, _, _, _ = img.At(44, 44).RGBA()
+	assert.NotZero(t, r, "margin is light")
+
+	var svg bytes.Buffer
+	require.NoError(t, code.SVG(&svg, 200, 2))
+	assert.Contains(t, svg.String(), `viewBox="0 0 25 25"`)
+	assert.Contains(t, svg.String(), `<path d="M2,2h7v1h-7z`)
+}
+
+func assertFinderPattern(t *testing.T, code *Code, left, top int) {
+	t.Helper()
+
+	for dy := 0; dy < 7; dy++ {
+		for dx := 0; dx < 7; dx++ {
+			ring := min(min(dx, dy), min(6-dx, 6-dy))
+			assert.Equal(t, ring != 1, code.Dark(left+dx, top+dy), "finder module %d,%d", left+dx, top+dy)
+		}
+	}
+}
+
+// readFormatInfo читает первую копию информации о формате так, как это делает сканер
+func readFormatInfo(code *Code) int {
+	bits := 0
+	set := func(i int, dark bool) {
+		if dark {
+			bits |= 1 << i
+		}
+	}
+
+	for i := 0; i <= 5; i++ {
+		set(i, code.Dark(8, i))
+	}
+	set(6, code.Dark(8, 7))
+	set(7, code.Dark(8, 8))
+	set(8, code.Dark(7, 8))
+	for i := 9; i < 15; i++ {
+		set(i, code.Dark(14-i, 8))
+	}
+
+	return bits
+}
+
+// readCodewords снимает маску и читает кодовые слова в порядке размещения
+func readCodewords(code *Code, count int) []byte {
+	result := make([]byte, count)
+	i := 0
+
+	for right := code.Size - 1; right >= 1 && i < count*8; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+
+		for vert := 0; vert < code.Size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = code.Size - 1 - vert
+			}
+
+			for x := right; x > right-2 && i < count*8; x-- {
+				if code.isFunction[y][x] {
+					continue
+				}
+
+				if code.Dark(x, y) != maskInverts(code.Mask, x, y) {
+					result[i/8] |= 1 << (7 - i%8)
+				}
+				i++
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,89 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// Image растеризует код в квадратное изображение size×size пикселей с полем margin модулей.
+// Модули масштабируются до целого числа пикселей, остаток распределяется по полю
+func (c *Code) Image(size, margin int) *image.Paletted {
+	modules := c.Size + margin*2
+	scale := max(size/modules, 1)
+	size = max(size, modules*scale)
+	offset := (size - modules*scale) / 2
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+
+			left := offset + (x+margin)*scale
+			top := offset + (y+margin)*scale
+
+			for py := top; py < top+scale; py++ {
+				row := img.Pix[py*img.Stride:]
+				for px := left; px < left+scale; px++ {
+					row[px] = 1
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+// PNG возвращает код в виде PNG; размер и поле - как у Image
+func (c *Code) PNG(size, margin int) ([]byte, error) {
+	var buf bytes.Buffer
+
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	err := encoder.Encode(&buf, c.Image(size, margin))
+	if err != nil {
+		return nil, fmt.Errorf("encode png: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SVG записывает код в виде SVG размером size×size; тёмные модули одной строки объединяются в один отрезок пути
+func (c *Code) SVG(w io.Writer, size, margin int) error {
+	modules := c.Size + margin*2
+
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; {
+			if !c.modules[y][x] {
+				x++
+				continue
+			}
+
+			start := x
+			for x < c.Size && c.modules[y][x] {
+				x++
+			}
+
+			fmt.Fprintf(&path, "M%d,%dh%dv1h-%dz", start+margin, y+margin, x-start, x-start)
+		}
+	}
+
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">
+<rect width="100%%" height="100%%" fill="#ffffff"/>
+<path d="%s" fill="#000000"/>
+</svg>
+`, size, size, modules, modules, path.String())
+	if err != nil {
+		return fmt.Errorf("write svg: %w", err)
+	}
+
+	return nil
+}
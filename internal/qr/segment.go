@@ -0,0 +1,103 @@
+package qr
+
+import "strings"
+
+const alphanumericCharset = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// mode - режим кодирования данных
+type mode struct {
+	indicator int
+	// Длина поля количества символов для версий 1-9, 10-26 и 27-40
+	countBitsByVersion [3]int
+}
+
+var (
+	modeNumeric      = mode{indicator: 0x1, countBitsByVersion: [3]int{10, 12, 14}}
+	modeAlphanumeric = mode{indicator: 0x2, countBitsByVersion: [3]int{9, 11, 13}}
+	modeByte         = mode{indicator: 0x4, countBitsByVersion: [3]int{8, 16, 16}}
+)
+
+func (m mode) countBits(version int) int {
+	return m.countBitsByVersion[(version+7)/17]
+}
+
+// segment - данные в самом компактном режиме, который подходит для всего текста
+type segment struct {
+	mode  mode
+	count int
+	data  bitBuffer
+}
+
+func newSegment(text string) segment {
+	switch {
+	case text != "" && strings.Trim(text, "0123456789") == "":
+		return numericSegment(text)
+	case text != "" && isAlphanumeric(text):
+		return alphanumericSegment(text)
+	default:
+		return byteSegment(text)
+	}
+}
+
+func numericSegment(text string) segment {
+	var bb bitBuffer
+
+	for i := 0; i < len(text); i += 3 {
+		chunk := text[i:min(i+3, len(text))]
+
+		value := 0
+		for _, c := range chunk {
+			value = value*10 + int(c-'0')
+		}
+
+		bb.append(value, len(chunk)*3+1)
+	}
+
+	return segment{mode: modeNumeric, count: len(text), data: bb}
+}
+
+func alphanumericSegment(text string) segment {
+	var bb bitBuffer
+
+	i := 0
+	for ; i+1 < len(text); i += 2 {
+		value := strings.IndexByte(alphanumericCharset, text[i])*45 + strings.IndexByte(alphanumericCharset, text[i+1])
+		bb.append(value, 11)
+	}
+
+	if i < len(text) {
+		bb.append(strings.IndexByte(alphanumericCharset, text[i]), 6)
+	}
+
+	return segment{mode: modeAlphanumeric, count: len(text), data: bb}
+}
+
+func byteSegment(text string) segment {
+	var bb bitBuffer
+
+	for i := 0; i < len(text); i++ {
+		bb.append(int(text[i]), 8)
+	}
+
+	return segment{mode: modeByte, count: len(text), data: bb}
+}
+
+// bitLength возвращает длину сегмента в битах для версии или -1, если количество символов не помещается в поле длины
+func (s segment) bitLength(version int) int {
+	countBits := s.mode.countBits(version)
+	if s.count >= 1<<countBits {
+		return -1
+	}
+
+	return 4 + countBits + len(s.data)
+}
+
+func isAlphanumeric(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if strings.IndexByte(alphanumericCharset, text[i]) < 0 {
+			return false
+		}
+	}
+
+	return true
+}
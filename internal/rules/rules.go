@@ -0,0 +1,298 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceMobile  = "mobile"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+var ErrInvalidRule = errors.New("invalid redirect rule")
+
+// Request - сведения о переходе, по которым проверяются условия правил
+type Request struct {
+	UserAgent      string
+	AcceptLanguage string
+	Query          url.Values
+	Now            time.Time
+}
+
+// Match возвращает URL первого правила, все условия которого выполнены для req
+func Match(rules []models.RedirectRule, req Request) (string, bool) {
+	if len(rules) == 0 {
+		return "", false
+	}
+
+	var device, language string
+	var languageParsed bool
+
+	for _, rule := range rules {
+		if rule.Device != "" {
+			if device == "" {
+				device = DeviceClass(req.UserAgent)
+			}
+
+			if !matchDevice(rule.Device, device) {
+				continue
+			}
+		}
+
+		if len(rule.Languages) > 0 {
+			if !languageParsed {
+				language = PreferredLanguage(req.AcceptLanguage)
+				languageParsed = true
+			}
+
+			if !matchLanguage(rule.Languages, language) {
+				continue
+			}
+		}
+
+		if rule.TimeWindow != nil && !matchTimeWindow(*rule.TimeWindow, req.Now) {
+			continue
+		}
+
+		if rule.Query != nil && !matchQuery(*rule.Query, req.Query) {
+			continue
+		}
+
+		return rule.URL, true
+	}
+
+	return "", false
+}
+
+// Validate проверяет условия правила; URL правила проверяет вызывающий
+func Validate(rule models.RedirectRule) error {
+	if rule.Device == "" && len(rule.Languages) == 0 && rule.TimeWindow == nil && rule.Query == nil {
+		return fmt.Errorf("%w: rule should have at least one condition", ErrInvalidRule)
+	}
+
+	switch rule.Device {
+	case "", DeviceIOS, DeviceAndroid, DeviceMobile, DeviceDesktop, DeviceBot:
+	default:
+		return fmt.Errorf("%w: unknown device %q", ErrInvalidRule, rule.Device)
+	}
+
+	for _, language := range rule.Languages {
+		if language == "" || language == "*" {
+			return fmt.Errorf("%w: invalid language %q", ErrInvalidRule, language)
+		}
+	}
+
+	if rule.TimeWindow != nil {
+		from, err := parseClock(rule.TimeWindow.From)
+		if err != nil {
+			return fmt.Errorf("%w: time window from: %v", ErrInvalidRule, err)
+		}
+
+		to, err := parseClock(rule.TimeWindow.To)
+		if err != nil {
+			return fmt.Errorf("%w: time window to: %v", ErrInvalidRule, err)
+		}
+
+		if from == to {
+			return fmt.Errorf("%w: time window is empty", ErrInvalidRule)
+		}
+
+		_, err = loadLocation(rule.TimeWindow.Timezone)
+		if err != nil {
+			return fmt.Errorf("%w: time window timezone: %v", ErrInvalidRule, err)
+		}
+	}
+
+	if rule.Query != nil && rule.Query.Param == "" {
+		return fmt.Errorf("%w: query param is empty", ErrInvalidRule)
+	}
+
+	return nil
+}
+
+// DeviceClass определяет класс устройства по User-Agent
+func DeviceClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case containsAny(ua, "bot", "crawler", "spider", "slurp", "facebookexternalhit"):
+		return DeviceBot
+	case containsAny(ua, "iphone", "ipad", "ipod"):
+		return DeviceIOS
+	case strings.Contains(ua, "android"):
+		return DeviceAndroid
+	case containsAny(ua, "mobile", "windows phone", "blackberry", "opera mini"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
+
+// PreferredLanguage возвращает язык Accept-Language с наибольшим весом; при равных весах - первый
+func PreferredLanguage(acceptLanguage string) string {
+	type weighted struct {
+		tag    string
+		weight float64
+	}
+
+	var languages []weighted
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		weight := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+
+		if weight <= 0 {
+			continue
+		}
+
+		languages = append(languages, weighted{tag: tag, weight: weight})
+	}
+
+	if len(languages) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].weight > languages[j].weight
+	})
+
+	return languages[0].tag
+}
+
+func matchDevice(ruleDevice, device string) bool {
+	if ruleDevice == DeviceMobile {
+		return device == DeviceMobile || device == DeviceIOS || device == DeviceAndroid
+	}
+
+	return ruleDevice == device
+}
+
+func matchLanguage(ruleLanguages []string, language string) bool {
+	if language == "" {
+		return false
+	}
+
+	for _, ruleLanguage := range ruleLanguages {
+		if strings.EqualFold(language, ruleLanguage) {
+			return true
+		}
+
+		if len(language) > len(ruleLanguage) && language[len(ruleLanguage)] == '-' &&
+			strings.EqualFold(language[:len(ruleLanguage)], ruleLanguage) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchTimeWindow(window models.TimeWindow, now time.Time) bool {
+	from, err := parseClock(window.From)
+	if err != nil {
+		return false
+	}
+
+	to, err := parseClock(window.To)
+	if err != nil {
+		return false
+	}
+
+	location, err := loadLocation(window.Timezone)
+	if err != nil {
+		return false
+	}
+
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+
+	if from < to {
+		return minute >= from && minute < to
+	}
+
+	return minute >= from || minute < to
+}
+
+func matchQuery(condition models.QueryCondition, query url.Values) bool {
+	values, ok := query[condition.Param]
+	if !ok {
+		return false
+	}
+
+	if condition.Value == "" {
+		return true
+	}
+
+	for _, value := range values {
+		if value == condition.Value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseClock переводит время HH:MM в минуты от начала суток
+func parseClock(clock string) (int, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("parse %q: %w", clock, err)
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// locations кеширует загруженные часовые пояса, чтобы не читать tzdata при каждом переходе
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+
+	if location, ok := locations.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+
+	locations.Store(name, location)
+
+	return location, nil
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+
+	return false
+}
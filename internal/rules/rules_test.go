@@ -0,0 +1,144 @@
+package rules
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148"
+	androidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36"
+	desktopUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36"
+	botUA     = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+)
+
+func TestDeviceClass(t *testing.T) {
+	assert.Equal(t, DeviceIOS, DeviceClass(iPhoneUA))
+	assert.Equal(t, DeviceAndroid, DeviceClass(androidUA))
+	assert.Equal(t, DeviceDesktop, DeviceClass(desktopUA))
+	assert.Equal(t, DeviceBot, DeviceClass(botUA))
+	assert.Equal(t, DeviceDesktop, DeviceClass(""))
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	assert.Equal(t, "ru-RU", PreferredLanguage("ru-RU,ru;q=0.9,en;q=0.8"))
+	assert.Equal(t, "en", PreferredLanguage("de;q=0.5, en;q=0.9, *;q=1"))
+	assert.Equal(t, "fr", PreferredLanguage("fr, en"))
+	assert.Equal(t, "", PreferredLanguage("de;q=0"))
+	assert.Equal(t, "", PreferredLanguage(""))
+}
+
+func TestMatch(t *testing.T) {
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	rules := []models.RedirectRule{
+		{Device: DeviceIOS, URL: "https://apps.apple.com/app"},
+		{Device: DeviceAndroid, URL: "https://play.google.com/app"},
+		{Query: &models.QueryCondition{Param: "utm_source", Value: "promo"}, URL: "https://example.com/promo"},
+		{Languages: []string{"ru"}, TimeWindow: &models.TimeWindow{From: "22:00", To: "06:00"}, URL: "https://example.com/ru/night"},
+		{Languages: []string{"ru"}, URL: "https://example.com/ru"},
+		{Device: DeviceMobile, URL: "https://m.example.com"},
+	}
+
+	tests := []struct {
+		name string
+		req  Request
+		url  string
+		ok   bool
+	}{
+		{
+			name: "ios",
+			req:  Request{UserAgent: iPhoneUA, AcceptLanguage: "ru", Now: noon},
+			url:  "https://apps.apple.com/app",
+			ok:   true,
+		},
+		{
+			name: "android",
+			req:  Request{UserAgent: androidUA, Now: noon},
+			url:  "https://play.google.com/app",
+			ok:   true,
+		},
+		{
+			name: "query value",
+			req:  Request{UserAgent: desktopUA, Query: url.Values{"utm_source": {"mail", "promo"}}, Now: noon},
+			url:  "https://example.com/promo",
+			ok:   true,
+		},
+		{
+			name: "other query value",
+			req:  Request{UserAgent: desktopUA, Query: url.Values{"utm_source": {"mail"}}, Now: noon},
+		},
+		{
+			name: "language outside time window",
+			req:  Request{UserAgent: desktopUA, AcceptLanguage: "ru-RU,en;q=0.5", Now: noon},
+			url:  "https://example.com/ru",
+			ok:   true,
+		},
+		{
+			name: "language inside time window",
+			req:  Request{UserAgent: desktopUA, AcceptLanguage: "ru-RU", Now: noon.Add(11 * time.Hour)},
+			url:  "https://example.com/ru/night",
+			ok:   true,
+		},
+		{
+			name: "less preferred language",
+			req:  Request{UserAgent: desktopUA, AcceptLanguage: "en,ru;q=0.5", Now: noon},
+		},
+		{
+			name: "default",
+			req:  Request{UserAgent: desktopUA, Now: noon},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			url, ok := Match(rules, test.req)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.url, url)
+		})
+	}
+}
+
+func TestMatchTimeWindowTimezone(t *testing.T) {
+	rules := []models.RedirectRule{
+		{TimeWindow: &models.TimeWindow{From: "09:00", To: "18:00", Timezone: "Europe/Moscow"}, URL: "https://example.com/office"},
+	}
+
+	_, ok := Match(rules, Request{Now: time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)})
+	assert.True(t, ok, "10:00 in Moscow")
+
+	_, ok = Match(rules, Request{Now: time.Date(2024, 1, 1, 15, 0, 0, 0, time.UTC)})
+	assert.False(t, ok, "18:00 in Moscow")
+}
+
+func TestValidate(t *testing.T) {
+	valid := []models.RedirectRule{
+		{Device: DeviceBot, URL: "https://example.com"},
+		{Languages: []string{"en", "de-AT"}, URL: "https://example.com"},
+		{TimeWindow: &models.TimeWindow{From: "23:00", To: "01:30", Timezone: "UTC"}, URL: "https://example.com"},
+		{Query: &models.QueryCondition{Param: "ref"}, URL: "https://example.com"},
+	}
+
+	for _, rule := range valid {
+		assert.NoError(t, Validate(rule))
+	}
+
+	invalid := []models.RedirectRule{
+		{URL: "https://example.com"},
+		{Device: "tablet", URL: "https://example.com"},
+		{Languages: []string{""}, URL: "https://example.com"},
+		{TimeWindow: &models.TimeWindow{From: "9", To: "18:00"}, URL: "https://example.com"},
+		{TimeWindow: &models.TimeWindow{From: "10:00", To: "10:00"}, URL: "https://example.com"},
+		{TimeWindow: &models.TimeWindow{From: "10:00", To: "11:00", Timezone: "Mars/Olympus"}, URL: "https://example.com"},
+		{Query: &models.QueryCondition{Value: "x"}, URL: "https://example.com"},
+	}
+
+	for _, rule := range invalid {
+		assert.ErrorIs(t, Validate(rule), ErrInvalidRule)
+	}
+}
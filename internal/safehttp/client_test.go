@@ -0,0 +1,23 @@
+package safehttp
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublic(t *testing.T) {
+	public := []string{"93.184.216.34", "8.8.8.8", "2606:4700:4700::1111"}
+	for _, addr := range public {
+		assert.True(t, isPublic(netip.MustParseAddr(addr)), addr)
+	}
+
+	blocked := []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "100.64.0.1",
+		"255.255.255.255", "224.0.0.1", "::1", "::", "fe80::1", "fd00::1", "::ffff:127.0.0.1", "::ffff:10.0.0.1",
+	}
+	for _, addr := range blocked {
+		assert.False(t, isPublic(netip.MustParseAddr(addr)), addr)
+	}
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/bloom"
+	"github.com/pluhe7/shortener/internal/logger"
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+const minBloomCapacity = 100000
+
+type BloomOptions struct {
+	// Допустимая доля ложноположительных ответов фильтра
+	FalsePositiveRate float64
+	// Период полного перестроения фильтра по данным хранилища, 0 - не перестраивать
+	RebuildInterval time.Duration
+}
+
+// BloomStorage отвечает ErrURLNotFound без обращения к хранилищу,
+// если сокращённого URL точно нет в фильтре Блума.
+// Фильтр пополняется только сохранениями через этот экземпляр, поэтому BloomStorage нельзя использовать
+// с хранилищем, в которое пишут другие экземпляры сервиса: их URL до перестроения фильтра будут не найдены
+type BloomStorage struct {
+	storage Storage
+	options BloomOptions
+
+	mu         sync.RWMutex
+	filter     *bloom.Filter
+	rebuilding bool
+	// сокращённые URL, сохранённые во время перестроения фильтра
+	pending []string
+
+	rejected atomic.Uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewBloomStorage(ctx context.Context, storage Storage, options BloomOptions) (*BloomStorage, error) {
+	s := &BloomStorage{
+		storage: storage,
+		options: options,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	err := s.Rebuild(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("build bloom filter: %w", err)
+	}
+
+	go s.rebuildLoop()
+
+	return s, nil
+}
+
+func (s *BloomStorage) Get(shortURL string) (models.ShortURLRecord, error) {
+	s.mu.RLock()
+	mayContain := s.filter.MayContain(shortURL)
+	s.mu.RUnlock()
+
+	if !mayContain {
+		s.rejected.Add(1)
+		return models.ShortURLRecord{}, ErrURLNotFound
+	}
+
+	return s.storage.Get(shortURL)
+}
+
+func (s *BloomStorage) GetByOriginal(originalURL string) (string, error) {
+	return s.storage.GetByOriginal(originalURL)
+}
+
+func (s *BloomStorage) Save(record models.ShortURLRecord) error {
+	s.add(record.ShortURL)
+
+	return s.storage.Save(record)
+}
+
+func (s *BloomStorage) SaveBatch(records []models.ShortURLRecord) error {
+	for _, record := range records {
+		s.add(record.ShortURL)
+	}
+
+	return s.storage.SaveBatch(records)
+}
+
+func (s *BloomStorage) SaveBatchWithReport(ctx context.Context, records []models.ShortURLRecord) (*models.BatchReport, error) {
+	for _, record := range records {
+		s.add(record.ShortURL)
+	}
+
+	return s.storage.SaveBatchWithReport(ctx, records)
+}
+
+func (s *BloomStorage) RestoreBatch(links []models.LinkSnapshot) error {
+	for _, link := range links {
+		s.add(link.Record.ShortURL)
+	}
+
+	return s.storage.RestoreBatch(links)
+}
+
+func (s *BloomStorage) Delete(shortURL string) error {
+	return s.storage.Delete(shortURL)
+}
+
+func (s *BloomStorage) Update(shortURL, originalURL, editor string) (models.URLRevision, error) {
+	return s.storage.Update(shortURL, originalURL, editor)
+}
+
+func (s *BloomStorage) SetRules(shortURL string, rules []models.RedirectRule) error {
+	return s.storage.SetRules(shortURL, rules)
+}
+
+func (s *BloomStorage) History(shortURL string) ([]models.URLRevision, error) {
+	return s.storage.History(shortURL)
+}
+
+func (s *BloomStorage) GetByUser(userID string) ([]models.ShortURLRecord, error) {
+	return s.storage.GetByUser(userID)
+}
+
+func (s *BloomStorage) SetSchedule(shortURL string, schedule models.Schedule) error {
+	return s.storage.SetSchedule(shortURL, schedule)
+}
+
+func (s *BloomStorage) SetSplit(shortURL string, split *models.Split) error {
+	return s.storage.SetSplit(shortURL, split)
+}
+
+func (s *BloomStorage) SetMetadata(shortURL string, metadata models.URLMetadata) error {
+	return s.storage.SetMetadata(shortURL, metadata)
+}
+
+func (s *BloomStorage) SetLinkCheck(shortURL string, check *models.LinkCheck) error {
+	return s.storage.SetLinkCheck(shortURL, check)
+}
+
+func (s *BloomStorage) SetBrokenFallback(shortURL, fallbackURL string) error {
+	return s.storage.SetBrokenFallback(shortURL, fallbackURL)
+}
+
+func (s *BloomStorage) RecordClick(shortURL, variant string) (int64, error) {
+	return s.storage.RecordClick(shortURL, variant)
+}
+
+func (s *BloomStorage) ClickCount(shortURL string) (int64, error) {
+	return s.storage.ClickCount(shortURL)
+}
+
+func (s *BloomStorage) VariantClicks(shortURL string) (map[string]int64, error) {
+	return s.storage.VariantClicks(shortURL)
+}
+
+func (s *BloomStorage) IterateByUser(ctx context.Context, userID string, fn func(record models.ShortURLRecord) error) error {
+	return s.storage.IterateByUser(ctx, userID, fn)
+}
+
+func (s *BloomStorage) IterateExpired(ctx context.Context, from, to time.Time, fn func(record models.ShortURLRecord) error) error {
+	return s.storage.IterateExpired(ctx, from, to, fn)
+}
+
+func (s *BloomStorage) Iterate(ctx context.Context, fn func(record models.ShortURLRecord) error) error {
+	return s.storage.Iterate(ctx, fn)
+}
+
+func (s *BloomStorage) CreateWebhook(webhook models.Webhook) error {
+	return s.storage.CreateWebhook(webhook)
+}
+
+func (s *BloomStorage) GetWebhooks(userID string) ([]models.Webhook, error) {
+	return s.storage.GetWebhooks(userID)
+}
+
+func (s *BloomStorage) DeleteWebhook(id, userID string) error {
+	return s.storage.DeleteWebhook(id, userID)
+}
+
+func (s *BloomStorage) AddEvent(event models.WebhookEvent) error {
+	return s.storage.AddEvent(event)
+}
+
+func (s *BloomStorage) ClaimDeliveries(lease time.Duration, limit int) ([]models.WebhookTask, error) {
+	return s.storage.ClaimDeliveries(lease, limit)
+}
+
+func (s *BloomStorage) UpdateDelivery(delivery models.WebhookDelivery) error {
+	return s.storage.UpdateDelivery(delivery)
+}
+
+func (s *BloomStorage) GetDeliveries(webhookID, userID string, limit int) ([]models.WebhookDelivery, error) {
+	return s.storage.GetDeliveries(webhookID, userID, limit)
+}
+
+func (s *BloomStorage) Close() error {
+	close(s.stop)
+	<-s.done
+
+	logger.Log.Info("bloom filter stats", zap.Uint64("rejected", s.rejected.Load()))
+
+	return s.storage.Close()
+}
+
+func (s *BloomStorage) PingContext(ctx context.Context) error {
+	return s.storage.PingContext(ctx)
+}
+
+func (s *BloomStorage) Unwrap() Storage {
+	return s.storage
+}
+
+// Rebuild заново строит фильтр по всем записям хранилища и атомарно подменяет им текущий
+func (s *BloomStorage) Rebuild(ctx context.Context) error {
+	capacity := minBloomCapacity
+
+	s.mu.Lock()
+	if s.filter != nil && s.filter.Count()*2 > capacity {
+		capacity = s.filter.Count() * 2
+	}
+	s.rebuilding = true
+	s.pending = nil
+	s.mu.Unlock()
+
+	filter := bloom.New(capacity, s.options.FalsePositiveRate)
+
+	err := s.storage.Iterate(ctx, func(record models.ShortURLRecord) error {
+		filter.Add(record.ShortURL)
+		return nil
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rebuilding = false
+	pending := s.pending
+	s.pending = nil
+
+	if err != nil {
+		return fmt.Errorf("iterate storage: %w", err)
+	}
+
+	for _, shortURL := range pending {
+		filter.Add(shortURL)
+	}
+
+	s.filter = filter
+
+	return nil
+}
+
+func (s *BloomStorage) add(shortURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.filter != nil {
+		s.filter.Add(shortURL)
+	}
+
+	if s.rebuilding {
+		s.pending = append(s.pending, shortURL)
+	}
+}
+
+func (s *BloomStorage) rebuildLoop() {
+	defer close(s.done)
+
+	if s.options.RebuildInterval <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(s.options.RebuildInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+
+		case <-ticker.C:
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-s.stop:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			err := s.Rebuild(ctx)
+			cancel()
+
+			if err != nil {
+				logger.Log.Error("rebuild bloom filter", zap.Error(err))
+			} else {
+				logger.Log.Debug("bloom filter rebuilt")
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/storage/mocks"
+)
+
+func TestBloomStorage(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	mockStorage := mocks.NewMockStorage(mockController)
+	mockStorage.EXPECT().Iterate(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, fn func(record models.ShortURLRecord) error) error {
+			return fn(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"})
+		}).Times(2)
+	mockStorage.EXPECT().Close().Return(nil)
+
+	bloomStorage, err := NewBloomStorage(context.Background(), mockStorage, BloomOptions{FalsePositiveRate: 0.001})
+	require.NoError(t, err)
+	defer bloomStorage.Close()
+
+	t.Run("existing id goes to storage", func(t *testing.T) {
+		mockStorage.EXPECT().Get("aaaaaaaa").Return(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"}, nil)
+
+		record, err := bloomStorage.Get("aaaaaaaa")
+		require.NoError(t, err)
+		assert.Equal(t, "https://yandex.ru", record.OriginalURL)
+	})
+
+	t.Run("absent id is rejected without storage", func(t *testing.T) {
+		_, err := bloomStorage.Get("zzzzzzzz")
+		require.ErrorIs(t, err, ErrURLNotFound)
+	})
+
+	t.Run("saved id is added", func(t *testing.T) {
+		mockStorage.EXPECT().Save(gomock.Any()).Return(nil)
+		mockStorage.EXPECT().Get("bbbbbbbb").Return(models.ShortURLRecord{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com"}, nil)
+
+		err := bloomStorage.Save(models.ShortURLRecord{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com"})
+		require.NoError(t, err)
+
+		_, err = bloomStorage.Get("bbbbbbbb")
+		require.NoError(t, err)
+	})
+
+	t.Run("rebuild keeps storage records", func(t *testing.T) {
+		mockStorage.EXPECT().Get("aaaaaaaa").Return(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"}, nil)
+
+		err := bloomStorage.Rebuild(context.Background())
+		require.NoError(t, err)
+
+		_, err = bloomStorage.Get("aaaaaaaa")
+		require.NoError(t, err)
+
+		_, err = bloomStorage.Get("bbbbbbbb")
+		require.ErrorIs(t, err, ErrURLNotFound)
+	})
+}
@@ -0,0 +1,620 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/breaker"
+	"github.com/pluhe7/shortener/internal/logger"
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+var ErrStorageUnavailable = errors.New("storage is temporarily unavailable")
+
+// UnavailableError возвращается, пока хранилище недоступно; RetryAfter - когда стоит повторить запрос
+type UnavailableError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrStorageUnavailable, e.Err)
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrStorageUnavailable
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+type BreakerOptions struct {
+	// Количество ошибок хранилища подряд, после которого breaker размыкается
+	FailureThreshold int
+	// Время в разомкнутом состоянии до пробного запроса в хранилище
+	OpenTimeout time.Duration
+	// Период обновления локального снимка сокращённых URL
+	SnapshotInterval time.Duration
+}
+
+type BreakerStatus struct {
+	State        string
+	Failures     int
+	RetryAfter   time.Duration
+	SnapshotSize int
+	SnapshotAt   time.Time
+}
+
+// BreakerStorage перестаёт обращаться к хранилищу после серии ошибок и, пока breaker разомкнут,
+// отдаёт сокращённые URL из периодически обновляемого локального снимка
+type BreakerStorage struct {
+	storage Storage
+	options BreakerOptions
+	breaker *breaker.Breaker
+
+	mu         sync.RWMutex
+	snapshot   map[string]models.ShortURLRecord
+	snapshotAt time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewBreakerStorage(storage Storage, options BreakerOptions) *BreakerStorage {
+	s := &BreakerStorage{
+		storage:  storage,
+		options:  options,
+		snapshot: make(map[string]models.ShortURLRecord),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	s.breaker = breaker.New(breaker.Options{
+		FailureThreshold: options.FailureThreshold,
+		OpenTimeout:      options.OpenTimeout,
+		OnStateChange: func(from, to breaker.State) {
+			if to == breaker.StateOpen {
+				logger.Log.Warn("storage circuit breaker opened, serving from snapshot",
+					zap.String("from", from.String()),
+					zap.Duration("open timeout", options.OpenTimeout),
+				)
+				return
+			}
+
+			logger.Log.Info("storage circuit breaker state changed",
+				zap.String("from", from.String()),
+				zap.String("to", to.String()),
+			)
+		},
+	})
+
+	go s.refreshSnapshotLoop()
+
+	return s
+}
+
+func (s *BreakerStorage) Get(shortURL string) (models.ShortURLRecord, error) {
+	var record models.ShortURLRecord
+
+	err := s.call(func() error {
+		var err error
+		record, err = s.storage.Get(shortURL)
+		return err
+	})
+	if err == nil || !errors.Is(err, ErrStorageUnavailable) {
+		return record, err
+	}
+
+	s.mu.RLock()
+	record, ok := s.snapshot[shortURL]
+	s.mu.RUnlock()
+
+	if !ok {
+		return models.ShortURLRecord{}, err
+	}
+
+	return record, nil
+}
+
+func (s *BreakerStorage) GetByOriginal(originalURL string) (string, error) {
+	var shortURL string
+
+	err := s.call(func() error {
+		var err error
+		shortURL, err = s.storage.GetByOriginal(originalURL)
+		return err
+	})
+
+	return shortURL, err
+}
+
+func (s *BreakerStorage) Save(record models.ShortURLRecord) error {
+	err := s.call(func() error {
+		return s.storage.Save(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.snapshot[record.ShortURL] = record
+	s.mu.Unlock()
+
+	return nil
+}
+
+// SaveBatch сохраняет пачку через SaveBatchWithReport, чтобы пропущенные из-за конфликта записи не попали в снимок
+func (s *BreakerStorage) SaveBatch(records []models.ShortURLRecord) error {
+	_, err := s.SaveBatchWithReport(context.Background(), records)
+	return err
+}
+
+func (s *BreakerStorage) SaveBatchWithReport(ctx context.Context, records []models.ShortURLRecord) (*models.BatchReport, error) {
+	var report *models.BatchReport
+
+	err := s.call(func() error {
+		var err error
+		report, err = s.storage.SaveBatchWithReport(ctx, records)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	inserted := make(map[string]bool, len(report.Inserted))
+	for _, shortURL := range report.Inserted {
+		inserted[shortURL] = true
+	}
+
+	// в снимок попадают только сохранённые записи: пропущенные заменили бы в нём существующие
+	s.mu.Lock()
+	for _, record := range records {
+		if inserted[record.ShortURL] {
+			s.snapshot[record.ShortURL] = record
+		}
+	}
+	s.mu.Unlock()
+
+	return report, nil
+}
+
+func (s *BreakerStorage) RestoreBatch(links []models.LinkSnapshot) error {
+	return s.call(func() error {
+		return s.storage.RestoreBatch(links)
+	})
+}
+
+func (s *BreakerStorage) Delete(shortURL string) error {
+	err := s.call(func() error {
+		return s.storage.Delete(shortURL)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if record, ok := s.snapshot[shortURL]; ok {
+		record.IsDeleted = true
+		s.snapshot[shortURL] = record
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *BreakerStorage) Update(shortURL, originalURL, editor string) (models.URLRevision, error) {
+	var revision models.URLRevision
+
+	err := s.call(func() error {
+		var err error
+		revision, err = s.storage.Update(shortURL, originalURL, editor)
+		return err
+	})
+	if err != nil {
+		return models.URLRevision{}, err
+	}
+
+	s.mu.Lock()
+	if record, ok := s.snapshot[shortURL]; ok {
+		record.OriginalURL = originalURL
+		s.snapshot[shortURL] = record
+	}
+	s.mu.Unlock()
+
+	return revision, nil
+}
+
+func (s *BreakerStorage) SetRules(shortURL string, rules []models.RedirectRule) error {
+	err := s.call(func() error {
+		return s.storage.SetRules(shortURL, rules)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if record, ok := s.snapshot[shortURL]; ok {
+		record.Rules = rules
+		s.snapshot[shortURL] = record
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *BreakerStorage) History(shortURL string) ([]models.URLRevision, error) {
+	var history []models.URLRevision
+
+	err := s.call(func() error {
+		var err error
+		history, err = s.storage.History(shortURL)
+		return err
+	})
+
+	return history, err
+}
+
+func (s *BreakerStorage) GetByUser(userID string) ([]models.ShortURLRecord, error) {
+	var records []models.ShortURLRecord
+
+	err := s.call(func() error {
+		var err error
+		records, err = s.storage.GetByUser(userID)
+		return err
+	})
+
+	return records, err
+}
+
+func (s *BreakerStorage) SetSchedule(shortURL string, schedule models.Schedule) error {
+	err := s.call(func() error {
+		return s.storage.SetSchedule(shortURL, schedule)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if record, ok := s.snapshot[shortURL]; ok {
+		record.Schedule = schedule
+		s.snapshot[shortURL] = record
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *BreakerStorage) SetSplit(shortURL string, split *models.Split) error {
+	err := s.call(func() error {
+		return s.storage.SetSplit(shortURL, split)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if record, ok := s.snapshot[shortURL]; ok {
+		record.Split = split
+		s.snapshot[shortURL] = record
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *BreakerStorage) SetMetadata(shortURL string, metadata models.URLMetadata) error {
+	err := s.call(func() error {
+		return s.storage.SetMetadata(shortURL, metadata)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if record, ok := s.snapshot[shortURL]; ok {
+		record.Metadata = &metadata
+		s.snapshot[shortURL] = record
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *BreakerStorage) SetLinkCheck(shortURL string, check *models.LinkCheck) error {
+	err := s.call(func() error {
+		return s.storage.SetLinkCheck(shortURL, check)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if record, ok := s.snapshot[shortURL]; ok {
+		record.LinkCheck = check
+		s.snapshot[shortURL] = record
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *BreakerStorage) SetBrokenFallback(shortURL, fallbackURL string) error {
+	err := s.call(func() error {
+		return s.storage.SetBrokenFallback(shortURL, fallbackURL)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if record, ok := s.snapshot[shortURL]; ok {
+		record.BrokenFallbackURL = fallbackURL
+		s.snapshot[shortURL] = record
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *BreakerStorage) RecordClick(shortURL, variant string) (int64, error) {
+	var clicks int64
+
+	err := s.call(func() error {
+		var err error
+		clicks, err = s.storage.RecordClick(shortURL, variant)
+		return err
+	})
+
+	return clicks, err
+}
+
+func (s *BreakerStorage) ClickCount(shortURL string) (int64, error) {
+	var clicks int64
+
+	err := s.call(func() error {
+		var err error
+		clicks, err = s.storage.ClickCount(shortURL)
+		return err
+	})
+
+	return clicks, err
+}
+
+func (s *BreakerStorage) VariantClicks(shortURL string) (map[string]int64, error) {
+	var clicks map[string]int64
+
+	err := s.call(func() error {
+		var err error
+		clicks, err = s.storage.VariantClicks(shortURL)
+		return err
+	})
+
+	return clicks, err
+}
+
+func (s *BreakerStorage) Iterate(ctx context.Context, fn func(record models.ShortURLRecord) error) error {
+	return s.call(func() error {
+		return s.storage.Iterate(ctx, fn)
+	})
+}
+
+func (s *BreakerStorage) IterateExpired(ctx context.Context, from, to time.Time, fn func(record models.ShortURLRecord) error) error {
+	return s.call(func() error {
+		return s.storage.IterateExpired(ctx, from, to, fn)
+	})
+}
+
+// IterateByUser не считает отказом хранилища ошибку fn и отмену ctx: обычно это обрыв соединения с клиентом
+func (s *BreakerStorage) IterateByUser(ctx context.Context, userID string, fn func(record models.ShortURLRecord) error) error {
+	var fnErr error
+
+	err := s.call(func() error {
+		err := s.storage.IterateByUser(ctx, userID, func(record models.ShortURLRecord) error {
+			fnErr = fn(record)
+			return fnErr
+		})
+		if fnErr != nil || ctx.Err() != nil {
+			return nil
+		}
+
+		return err
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	return err
+}
+
+func (s *BreakerStorage) Close() error {
+	close(s.stop)
+	<-s.done
+
+	return s.storage.Close()
+}
+
+func (s *BreakerStorage) PingContext(ctx context.Context) error {
+	return s.storage.PingContext(ctx)
+}
+
+func (s *BreakerStorage) Unwrap() Storage {
+	return s.storage
+}
+
+func (s *BreakerStorage) Status() BreakerStatus {
+	s.mu.RLock()
+	snapshotSize := len(s.snapshot)
+	snapshotAt := s.snapshotAt
+	s.mu.RUnlock()
+
+	return BreakerStatus{
+		State:        s.breaker.State().String(),
+		Failures:     s.breaker.Failures(),
+		RetryAfter:   s.breaker.RetryAfter(),
+		SnapshotSize: snapshotSize,
+		SnapshotAt:   snapshotAt,
+	}
+}
+
+// RefreshSnapshot заново загружает снимок из хранилища; при разомкнутом breaker снимок не трогается
+func (s *BreakerStorage) RefreshSnapshot(ctx context.Context) error {
+	snapshot := make(map[string]models.ShortURLRecord)
+
+	err := s.Iterate(ctx, func(record models.ShortURLRecord) error {
+		snapshot[record.ShortURL] = record
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.snapshot = snapshot
+	s.snapshotAt = time.Now()
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *BreakerStorage) CreateWebhook(webhook models.Webhook) error {
+	return s.call(func() error {
+		return s.storage.CreateWebhook(webhook)
+	})
+}
+
+func (s *BreakerStorage) GetWebhooks(userID string) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+
+	err := s.call(func() error {
+		var err error
+		webhooks, err = s.storage.GetWebhooks(userID)
+		return err
+	})
+
+	return webhooks, err
+}
+
+func (s *BreakerStorage) DeleteWebhook(id, userID string) error {
+	return s.call(func() error {
+		return s.storage.DeleteWebhook(id, userID)
+	})
+}
+
+func (s *BreakerStorage) AddEvent(event models.WebhookEvent) error {
+	return s.call(func() error {
+		return s.storage.AddEvent(event)
+	})
+}
+
+func (s *BreakerStorage) ClaimDeliveries(lease time.Duration, limit int) ([]models.WebhookTask, error) {
+	var tasks []models.WebhookTask
+
+	err := s.call(func() error {
+		var err error
+		tasks, err = s.storage.ClaimDeliveries(lease, limit)
+		return err
+	})
+
+	return tasks, err
+}
+
+func (s *BreakerStorage) UpdateDelivery(delivery models.WebhookDelivery) error {
+	return s.call(func() error {
+		return s.storage.UpdateDelivery(delivery)
+	})
+}
+
+func (s *BreakerStorage) GetDeliveries(webhookID, userID string, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	err := s.call(func() error {
+		var err error
+		deliveries, err = s.storage.GetDeliveries(webhookID, userID, limit)
+		return err
+	})
+
+	return deliveries, err
+}
+
+// call выполняет запрос к хранилищу через breaker. Ошибки хранилища считаются, но UnavailableError
+// возвращается, только когда breaker разомкнут; до этого вызывающий получает исходную ошибку.
+// Отмена или истёкший контекст вызывающего ничего не говорят о хранилище и не считаются.
+func (s *BreakerStorage) call(fn func() error) error {
+	err := s.breaker.Allow()
+	if err != nil {
+		return &UnavailableError{RetryAfter: s.breaker.RetryAfter(), Err: err}
+	}
+
+	err = fn()
+
+	switch {
+	case err == nil || errors.Is(err, ErrURLNotFound) || errors.Is(err, ErrDuplicateRecord) ||
+		errors.Is(err, ErrClickLimitReached) || errors.Is(err, ErrWebhookNotFound):
+		s.breaker.Success()
+
+		return err
+
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		s.breaker.Release()
+
+		return err
+	}
+
+	s.breaker.Failure()
+
+	logger.Log.Error("storage call failed", zap.Error(err), zap.Int("failures", s.breaker.Failures()))
+
+	if s.breaker.State() == breaker.StateClosed {
+		return err
+	}
+
+	return &UnavailableError{RetryAfter: s.breaker.RetryAfter(), Err: err}
+}
+
+func (s *BreakerStorage) refreshSnapshotLoop() {
+	defer close(s.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-s.stop
+		cancel()
+	}()
+
+	refresh := func() {
+		err := s.RefreshSnapshot(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Log.Warn("refresh storage snapshot", zap.Error(err))
+		}
+	}
+
+	refresh()
+
+	if s.options.SnapshotInterval <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(s.options.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/storage/mocks"
+)
+
+func TestBreakerStorage(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	mockStorage := mocks.NewMockStorage(mockController)
+
+	snapshotLoaded := make(chan struct{})
+	mockStorage.EXPECT().Iterate(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, fn func(record models.ShortURLRecord) error) error {
+			defer close(snapshotLoaded)
+			return fn(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"})
+		})
+	mockStorage.EXPECT().Close().Return(nil)
+
+	breakerStorage := NewBreakerStorage(mockStorage, BreakerOptions{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+	})
+	defer breakerStorage.Close()
+
+	<-snapshotLoaded
+
+	t.Run("not found does not open breaker", func(t *testing.T) {
+		mockStorage.EXPECT().Get("zzzzzzzz").Return(models.ShortURLRecord{}, ErrURLNotFound).Times(3)
+
+		for i := 0; i < 3; i++ {
+			_, err := breakerStorage.Get("zzzzzzzz")
+			require.ErrorIs(t, err, ErrURLNotFound)
+		}
+
+		assert.Equal(t, "closed", breakerStorage.Status().State)
+	})
+
+	t.Run("iteration callback error does not open breaker", func(t *testing.T) {
+		mockStorage.EXPECT().IterateByUser(gomock.Any(), "user", gomock.Any()).DoAndReturn(
+			func(ctx context.Context, userID string, fn func(record models.ShortURLRecord) error) error {
+				return fmt.Errorf("iterate: %w", fn(models.ShortURLRecord{ShortURL: "aaaaaaaa"}))
+			}).Times(3)
+
+		errWrite := errors.New("broken pipe")
+
+		for i := 0; i < 3; i++ {
+			err := breakerStorage.IterateByUser(context.Background(), "user", func(models.ShortURLRecord) error {
+				return errWrite
+			})
+			require.ErrorIs(t, err, errWrite)
+			require.NotErrorIs(t, err, ErrStorageUnavailable)
+		}
+
+		assert.Equal(t, "closed", breakerStorage.Status().State)
+	})
+
+	t.Run("caller cancellation does not open breaker", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		mockStorage.EXPECT().SaveBatchWithReport(ctx, gomock.Any()).Return(nil, fmt.Errorf("begin: %w", context.Canceled)).Times(3)
+
+		for i := 0; i < 3; i++ {
+			_, err := breakerStorage.SaveBatchWithReport(ctx, []models.ShortURLRecord{{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com"}})
+			require.ErrorIs(t, err, context.Canceled)
+			require.NotErrorIs(t, err, ErrStorageUnavailable)
+		}
+
+		assert.Equal(t, "closed", breakerStorage.Status().State)
+		assert.Zero(t, breakerStorage.Status().Failures)
+	})
+
+	t.Run("conflicted batch records are not added to snapshot", func(t *testing.T) {
+		records := []models.ShortURLRecord{
+			{ShortURL: "cccccccc", OriginalURL: "https://yandex.ru"},
+			{ShortURL: "dddddddd", OriginalURL: "https://ya.ru"},
+		}
+		mockStorage.EXPECT().SaveBatchWithReport(gomock.Any(), records).
+			Return(&models.BatchReport{Inserted: []string{"dddddddd"}, Conflicted: []string{"cccccccc"}}, nil)
+
+		require.NoError(t, breakerStorage.SaveBatch(records))
+	})
+
+	t.Run("failures open breaker", func(t *testing.T) {
+		errRefused := errors.New("connection refused")
+		mockStorage.EXPECT().Get("aaaaaaaa").Return(models.ShortURLRecord{}, errRefused).Times(2)
+
+		_, err := breakerStorage.Get("aaaaaaaa")
+		require.ErrorIs(t, err, errRefused)
+		require.NotErrorIs(t, err, ErrStorageUnavailable, "closed breaker returns the storage error")
+
+		record, err := breakerStorage.Get("aaaaaaaa")
+		require.NoError(t, err)
+		assert.Equal(t, "https://yandex.ru", record.OriginalURL)
+
+		assert.Equal(t, "open", breakerStorage.Status().State)
+	})
+
+	t.Run("open breaker serves snapshot", func(t *testing.T) {
+		record, err := breakerStorage.Get("aaaaaaaa")
+		require.NoError(t, err)
+		assert.Equal(t, "https://yandex.ru", record.OriginalURL)
+
+		record, err = breakerStorage.Get("dddddddd")
+		require.NoError(t, err)
+		assert.Equal(t, "https://ya.ru", record.OriginalURL)
+
+		for _, shortURL := range []string{"bbbbbbbb", "cccccccc"} {
+			_, err = breakerStorage.Get(shortURL)
+			require.ErrorIs(t, err, ErrStorageUnavailable)
+		}
+	})
+
+	t.Run("open breaker rejects writes", func(t *testing.T) {
+		err := breakerStorage.Save(models.ShortURLRecord{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com"})
+		require.ErrorIs(t, err, ErrStorageUnavailable)
+
+		var unavailableErr *UnavailableError
+		require.ErrorAs(t, err, &unavailableErr)
+		assert.Positive(t, unavailableErr.RetryAfter)
+	})
+}
@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/pluhe7/shortener/internal/logger"
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+type CacheOptions struct {
+	// Максимальное количество записей, при превышении вытесняются давно не использованные
+	Size int
+	// Время жизни найденной записи, 0 - без ограничения
+	TTL time.Duration
+	// Время жизни записи о несуществующем URL, 0 - такие записи не кешируются
+	NegativeTTL time.Duration
+}
+
+type CacheStats struct {
+	Hits         uint64
+	Misses       uint64
+	NegativeHits uint64
+	Evictions    uint64
+	Size         int
+}
+
+func (st CacheStats) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	encoder.AddUint64("hits", st.Hits)
+	encoder.AddUint64("misses", st.Misses)
+	encoder.AddUint64("negative hits", st.NegativeHits)
+	encoder.AddUint64("evictions", st.Evictions)
+	encoder.AddInt("size", st.Size)
+
+	return nil
+}
+
+type cacheEntry struct {
+	shortURL  string
+	record    models.ShortURLRecord
+	notFound  bool
+	expiresAt time.Time
+}
+
+type CachedStorage struct {
+	storage Storage
+	options CacheOptions
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// увеличивается при каждой инвалидации, чтобы результат запроса,
+	// начатого до изменения данных, не попал в кеш
+	epoch uint64
+
+	group singleflight.Group
+
+	hits         atomic.Uint64
+	misses       atomic.Uint64
+	negativeHits atomic.Uint64
+	evictions    atomic.Uint64
+}
+
+func NewCachedStorage(storage Storage, options CacheOptions) *CachedStorage {
+	return &CachedStorage{
+		storage: storage,
+		options: options,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (s *CachedStorage) Get(shortURL string) (models.ShortURLRecord, error) {
+	if entry, ok := s.lookup(shortURL); ok {
+		if entry.notFound {
+			s.negativeHits.Add(1)
+			return models.ShortURLRecord{}, ErrURLNotFound
+		}
+
+		s.hits.Add(1)
+		return entry.record, nil
+	}
+
+	s.misses.Add(1)
+
+	record, err, _ := s.group.Do(shortURL, func() (interface{}, error) {
+		epoch := s.currentEpoch()
+
+		record, err := s.storage.Get(shortURL)
+		if err != nil {
+			if errors.Is(err, ErrURLNotFound) && s.options.NegativeTTL > 0 {
+				s.add(epoch, cacheEntry{shortURL: shortURL, notFound: true}, s.options.NegativeTTL)
+			}
+
+			return models.ShortURLRecord{}, err
+		}
+
+		s.add(epoch, cacheEntry{shortURL: shortURL, record: record}, s.options.TTL)
+
+		return record, nil
+	})
+	if err != nil {
+		return models.ShortURLRecord{}, err
+	}
+
+	return record.(models.ShortURLRecord), nil
+}
+
+func (s *CachedStorage) GetByOriginal(originalURL string) (string, error) {
+	return s.storage.GetByOriginal(originalURL)
+}
+
+func (s *CachedStorage) Save(record models.ShortURLRecord) error {
+	defer s.Invalidate(record.ShortURL)
+
+	return s.storage.Save(record)
+}
+
+func (s *CachedStorage) SaveBatch(records []models.ShortURLRecord) error {
+	defer func() {
+		for _, record := range records {
+			s.Invalidate(record.ShortURL)
+		}
+	}()
+
+	return s.storage.SaveBatch(records)
+}
+
+func (s *CachedStorage) SaveBatchWithReport(ctx context.Context, records []models.ShortURLRecord) (*models.BatchReport, error) {
+	defer func() {
+		for _, record := range records {
+			s.Invalidate(record.ShortURL)
+		}
+	}()
+
+	return s.storage.SaveBatchWithReport(ctx, records)
+}
+
+func (s *CachedStorage) RestoreBatch(links []models.LinkSnapshot) error {
+	defer func() {
+		for _, link := range links {
+			s.Invalidate(link.Record.ShortURL)
+		}
+	}()
+
+	return s.storage.RestoreBatch(links)
+}
+
+func (s *CachedStorage) Delete(shortURL string) error {
+	defer s.Invalidate(shortURL)
+
+	return s.storage.Delete(shortURL)
+}
+
+func (s *CachedStorage) Update(shortURL, originalURL, editor string) (models.URLRevision, error) {
+	defer s.Invalidate(shortURL)
+
+	return s.storage.Update(shortURL, originalURL, editor)
+}
+
+func (s *CachedStorage) SetRules(shortURL string, rules []models.RedirectRule) error {
+	defer s.Invalidate(shortURL)
+
+	return s.storage.SetRules(shortURL, rules)
+}
+
+func (s *CachedStorage) History(shortURL string) ([]models.URLRevision, error) {
+	return s.storage.History(shortURL)
+}
+
+func (s *CachedStorage) GetByUser(userID string) ([]models.ShortURLRecord, error) {
+	return s.storage.GetByUser(userID)
+}
+
+func (s *CachedStorage) SetSchedule(shortURL string, schedule models.Schedule) error {
+	defer s.Invalidate(shortURL)
+
+	return s.storage.SetSchedule(shortURL, schedule)
+}
+
+func (s *CachedStorage) SetSplit(shortURL string, split *models.Split) error {
+	defer s.Invalidate(shortURL)
+
+	return s.storage.SetSplit(shortURL, split)
+}
+
+func (s *CachedStorage) SetMetadata(shortURL string, metadata models.URLMetadata) error {
+	defer s.Invalidate(shortURL)
+
+	return s.storage.SetMetadata(shortURL, metadata)
+}
+
+func (s *CachedStorage) SetLinkCheck(shortURL string, check *models.LinkCheck) error {
+	defer s.Invalidate(shortURL)
+
+	return s.storage.SetLinkCheck(shortURL, check)
+}
+
+func (s *CachedStorage) SetBrokenFallback(shortURL, fallbackURL string) error {
+	defer s.Invalidate(shortURL)
+
+	return s.storage.SetBrokenFallback(shortURL, fallbackURL)
+}
+
+func (s *CachedStorage) RecordClick(shortURL, variant string) (int64, error) {
+	return s.storage.RecordClick(shortURL, variant)
+}
+
+func (s *CachedStorage) ClickCount(shortURL string) (int64, error) {
+	return s.storage.ClickCount(shortURL)
+}
+
+func (s *CachedStorage) VariantClicks(shortURL string) (map[string]int64, error) {
+	return s.storage.VariantClicks(shortURL)
+}
+
+func (s *CachedStorage) IterateByUser(ctx context.Context, userID string, fn func(record models.ShortURLRecord) error) error {
+	return s.storage.IterateByUser(ctx, userID, fn)
+}
+
+func (s *CachedStorage) IterateExpired(ctx context.Context, from, to time.Time, fn func(record models.ShortURLRecord) error) error {
+	return s.storage.IterateExpired(ctx, from, to, fn)
+}
+
+func (s *CachedStorage) Iterate(ctx context.Context, fn func(record models.ShortURLRecord) error) error {
+	return s.storage.Iterate(ctx, fn)
+}
+
+func (s *CachedStorage) CreateWebhook(webhook models.Webhook) error {
+	return s.storage.CreateWebhook(webhook)
+}
+
+func (s *CachedStorage) GetWebhooks(userID string) ([]models.Webhook, error) {
+	return s.storage.GetWebhooks(userID)
+}
+
+func (s *CachedStorage) DeleteWebhook(id, userID string) error {
+	return s.storage.DeleteWebhook(id, userID)
+}
+
+func (s *CachedStorage) AddEvent(event models.WebhookEvent) error {
+	return s.storage.AddEvent(event)
+}
+
+func (s *CachedStorage) ClaimDeliveries(lease time.Duration, limit int) ([]models.WebhookTask, error) {
+	return s.storage.ClaimDeliveries(lease, limit)
+}
+
+func (s *CachedStorage) UpdateDelivery(delivery models.WebhookDelivery) error {
+	return s.storage.UpdateDelivery(delivery)
+}
+
+func (s *CachedStorage) GetDeliveries(webhookID, userID string, limit int) ([]models.WebhookDelivery, error) {
+	return s.storage.GetDeliveries(webhookID, userID, limit)
+}
+
+func (s *CachedStorage) Close() error {
+	logger.Log.Info("storage cache stats", zap.Object("stats", s.Stats()))
+
+	return s.storage.Close()
+}
+
+func (s *CachedStorage) PingContext(ctx context.Context) error {
+	return s.storage.PingContext(ctx)
+}
+
+func (s *CachedStorage) Unwrap() Storage {
+	return s.storage
+}
+
+// Invalidate удаляет запись из кеша, следующий Get пойдёт в хранилище
+func (s *CachedStorage) Invalidate(shortURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.epoch++
+	s.group.Forget(shortURL)
+
+	if element, ok := s.entries[shortURL]; ok {
+		s.removeElement(element)
+	}
+}
+
+func (s *CachedStorage) Stats() CacheStats {
+	s.mu.Lock()
+	size := s.lru.Len()
+	s.mu.Unlock()
+
+	return CacheStats{
+		Hits:         s.hits.Load(),
+		Misses:       s.misses.Load(),
+		NegativeHits: s.negativeHits.Load(),
+		Evictions:    s.evictions.Load(),
+		Size:         size,
+	}
+}
+
+func (s *CachedStorage) lookup(shortURL string) (cacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[shortURL]
+	if !ok {
+		return cacheEntry{}, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		s.removeElement(element)
+		return cacheEntry{}, false
+	}
+
+	s.lru.MoveToFront(element)
+
+	return *entry, true
+}
+
+func (s *CachedStorage) add(epoch uint64, entry cacheEntry, ttl time.Duration) {
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if epoch != s.epoch {
+		return
+	}
+
+	if element, ok := s.entries[entry.shortURL]; ok {
+		element.Value = &entry
+		s.lru.MoveToFront(element)
+		return
+	}
+
+	s.entries[entry.shortURL] = s.lru.PushFront(&entry)
+
+	for s.options.Size > 0 && s.lru.Len() > s.options.Size {
+		s.removeElement(s.lru.Back())
+		s.evictions.Add(1)
+	}
+}
+
+func (s *CachedStorage) removeElement(element *list.Element) {
+	s.lru.Remove(element)
+	delete(s.entries, element.Value.(*cacheEntry).shortURL)
+}
+
+func (s *CachedStorage) currentEpoch() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.epoch
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/storage/mocks"
+)
+
+func TestCachedStorageGet(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	mockStorage := mocks.NewMockStorage(mockController)
+	cachedStorage := NewCachedStorage(mockStorage, CacheOptions{Size: 2, NegativeTTL: time.Minute})
+
+	t.Run("read through", func(t *testing.T) {
+		mockStorage.EXPECT().Get("aaaaaaaa").Return(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"}, nil).Times(1)
+
+		for i := 0; i < 3; i++ {
+			record, err := cachedStorage.Get("aaaaaaaa")
+			require.NoError(t, err)
+			assert.Equal(t, "https://yandex.ru", record.OriginalURL)
+		}
+
+		stats := cachedStorage.Stats()
+		assert.Equal(t, uint64(2), stats.Hits)
+		assert.Equal(t, uint64(1), stats.Misses)
+	})
+
+	t.Run("negative caching", func(t *testing.T) {
+		mockStorage.EXPECT().Get("bbbbbbbb").Return(models.ShortURLRecord{}, ErrURLNotFound).Times(1)
+
+		for i := 0; i < 2; i++ {
+			_, err := cachedStorage.Get("bbbbbbbb")
+			require.ErrorIs(t, err, ErrURLNotFound)
+		}
+
+		assert.Equal(t, uint64(1), cachedStorage.Stats().NegativeHits)
+	})
+
+	t.Run("save invalidates", func(t *testing.T) {
+		mockStorage.EXPECT().Save(gomock.Any()).Return(nil)
+		mockStorage.EXPECT().Get("bbbbbbbb").Return(models.ShortURLRecord{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com"}, nil).Times(1)
+
+		err := cachedStorage.Save(models.ShortURLRecord{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com"})
+		require.NoError(t, err)
+
+		record, err := cachedStorage.Get("bbbbbbbb")
+		require.NoError(t, err)
+		assert.Equal(t, "https://google.com", record.OriginalURL)
+	})
+
+	t.Run("lru eviction", func(t *testing.T) {
+		mockStorage.EXPECT().Get("cccccccc").Return(models.ShortURLRecord{ShortURL: "cccccccc", OriginalURL: "https://ya.ru"}, nil).Times(1)
+		mockStorage.EXPECT().Get("aaaaaaaa").Return(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"}, nil).Times(1)
+
+		_, err := cachedStorage.Get("cccccccc")
+		require.NoError(t, err)
+
+		_, err = cachedStorage.Get("aaaaaaaa")
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, cachedStorage.Stats().Size)
+		assert.NotZero(t, cachedStorage.Stats().Evictions)
+	})
+}
+
+func TestCachedStorageTTL(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	mockStorage := mocks.NewMockStorage(mockController)
+	cachedStorage := NewCachedStorage(mockStorage, CacheOptions{Size: 10, TTL: 10 * time.Millisecond})
+
+	mockStorage.EXPECT().Get("aaaaaaaa").Return(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"}, nil).Times(2)
+
+	_, err := cachedStorage.Get("aaaaaaaa")
+	require.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+
+	_, err = cachedStorage.Get("aaaaaaaa")
+	require.NoError(t, err)
+}
+
+func TestCachedStorageCollapsesConcurrentMisses(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	mockStorage := mocks.NewMockStorage(mockController)
+	cachedStorage := NewCachedStorage(mockStorage, CacheOptions{Size: 10})
+
+	release := make(chan struct{})
+	mockStorage.EXPECT().Get("aaaaaaaa").DoAndReturn(func(string) (models.ShortURLRecord, error) {
+		<-release
+		return models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"}, nil
+	}).Times(1)
+
+	const workers = 20
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			record, err := cachedStorage.Get("aaaaaaaa")
+			assert.NoError(t, err)
+			assert.Equal(t, "https://yandex.ru", record.OriginalURL)
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+// recordColumns - выражения выборки записи в порядке полей scanRecord
+const recordColumns = "short_url, original_url, COALESCE(user_id, ''), is_deleted, COALESCE(password_hash, ''), created_at, redirect_status, " +
+	"query_passthrough, COALESCE(query_conflict_policy, ''), path_passthrough, rules, split, max_clicks, " +
+	"not_before, not_after, COALESCE(fallback_url, ''), metadata, " +
+	"link_check, COALESCE(broken_fallback_url, '')"
+
+// insertColumns - колонки вставки записи в порядке значений recordValues
+var insertColumns = []string{"short_url", "original_url", "user_id", "is_deleted", "password_hash", "created_at", "redirect_status",
+	"query_passthrough", "query_conflict_policy", "path_passthrough", "rules", "split", "max_clicks",
+	"not_before", "not_after", "fallback_url", "metadata",
+	"link_check", "broken_fallback_url"}
+
+var insertRecordQuery = buildInsertRecordQuery()
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRecord(row rowScanner) (models.ShortURLRecord, error) {
+	var record models.ShortURLRecord
+	var createdAt, notBefore, notAfter sql.NullTime
+	var rules, split, metadata, linkCheck []byte
+
+	err := row.Scan(&record.ShortURL, &record.OriginalURL, &record.UserID, &record.IsDeleted, &record.PasswordHash,
+		&createdAt, &record.RedirectStatus, &record.QueryPassthrough, &record.QueryConflictPolicy, &record.PathPassthrough,
+		&rules, &split, &record.MaxClicks, &notBefore, &notAfter, &record.FallbackURL, &metadata,
+		&linkCheck, &record.BrokenFallbackURL)
+	if err != nil {
+		return record, err
+	}
+
+	record.CreatedAt = createdAt.Time
+	record.NotBefore = timePointer(notBefore)
+	record.NotAfter = timePointer(notAfter)
+
+	if len(rules) > 0 {
+		err = json.Unmarshal(rules, &record.Rules)
+		if err != nil {
+			return record, fmt.Errorf("unmarshal rules: %w", err)
+		}
+	}
+
+	if len(split) > 0 {
+		err = json.Unmarshal(split, &record.Split)
+		if err != nil {
+			return record, fmt.Errorf("unmarshal split: %w", err)
+		}
+	}
+
+	if len(metadata) > 0 {
+		err = json.Unmarshal(metadata, &record.Metadata)
+		if err != nil {
+			return record, fmt.Errorf("unmarshal metadata: %w", err)
+		}
+	}
+
+	if len(linkCheck) > 0 {
+		err = json.Unmarshal(linkCheck, &record.LinkCheck)
+		if err != nil {
+			return record, fmt.Errorf("unmarshal link check: %w", err)
+		}
+	}
+
+	return record, nil
+}
+
+func recordValues(record models.ShortURLRecord) []any {
+	return []any{
+		record.ShortURL,
+		record.OriginalURL,
+		nullString(record.UserID),
+		record.IsDeleted,
+		nullString(record.PasswordHash),
+		nullTime(record.CreatedAt),
+		record.RedirectStatus,
+		record.QueryPassthrough,
+		nullString(record.QueryConflictPolicy),
+		record.PathPassthrough,
+		nullJSON(record.Rules),
+		nullJSON(record.Split),
+		record.MaxClicks,
+		record.NotBefore,
+		record.NotAfter,
+		nullString(record.FallbackURL),
+		nullJSON(record.Metadata),
+		nullJSON(record.LinkCheck),
+		nullString(record.BrokenFallbackURL),
+	}
+}
+
+func buildInsertRecordQuery() string {
+	placeholders := make([]string, len(insertColumns))
+	for i := range insertColumns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	return "INSERT INTO urls (" + strings.Join(insertColumns, ", ") + ") VALUES (" +
+		strings.Join(placeholders, ", ") + ") ON CONFLICT DO NOTHING"
+}
+
+func nullTime(value time.Time) *time.Time {
+	if value.IsZero() {
+		return nil
+	}
+
+	return &value
+}
+
+// nullJSON кодирует настройку записи в JSON, пустую настройку - в NULL. В настройках нет типов,
+// которые json не умеет кодировать, поэтому ошибка Marshal невозможна.
+func nullJSON(value any) *string {
+	data, _ := json.Marshal(value)
+
+	encoded := string(data)
+	if encoded == "null" || encoded == "[]" {
+		return nil
+	}
+
+	return &encoded
+}
+
+func timePointer(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+
+	return &value.Time
+}
+
+func nullString(value string) *string {
+	if value == "" {
+		return nil
+	}
+
+	return &value
+}
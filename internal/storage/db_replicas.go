@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pluhe7/shortener/internal/logger"
+)
+
+const replicaPingTimeout = time.Second
+
+type ReplicaStatus struct {
+	Name    string
+	Healthy bool
+}
+
+type dbReplica struct {
+	name    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+func newDBReplica(name string, db *sql.DB) *dbReplica {
+	replica := &dbReplica{
+		name: name,
+		db:   db,
+	}
+	replica.healthy.Store(true)
+
+	return replica
+}
+
+func (r *dbReplica) setHealthy(healthy bool, err error) {
+	if r.healthy.Swap(healthy) == healthy {
+		return
+	}
+
+	if healthy {
+		logger.Log.Info("db replica is back", zap.String("replica", r.name))
+	} else {
+		logger.Log.Warn("db replica is unhealthy", zap.String("replica", r.name), zap.Error(err))
+	}
+}
+
+// recentWrites помнит недавно записанные ключи, чтобы их чтение шло в основную бд,
+// пока реплики могут ещё не получить изменения
+type recentWrites struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	keys map[string]time.Time
+}
+
+func newRecentWrites(window time.Duration) *recentWrites {
+	return &recentWrites{
+		window: window,
+		keys:   make(map[string]time.Time),
+	}
+}
+
+func (w *recentWrites) add(keys ...string) {
+	expiresAt := time.Now().Add(w.window)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, key := range keys {
+		w.keys[key] = expiresAt
+	}
+}
+
+func (w *recentWrites) contains(key string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	expiresAt, ok := w.keys[key]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(expiresAt) {
+		delete(w.keys, key)
+		return false
+	}
+
+	return true
+}
+
+func (w *recentWrites) prune() {
+	now := time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for key, expiresAt := range w.keys {
+		if now.After(expiresAt) {
+			delete(w.keys, key)
+		}
+	}
+}
+
+// readRow выполняет запрос, возвращающий одну строку, на одной из здоровых реплик по кругу.
+// Недавно записанные ключи, ошибки реплик и отсутствие строки на реплике,
+// которая могла отстать, приводят к чтению из основной бд.
+func (s *DatabaseStorage) readRow(key string, scan func(row *sql.Row) error, query string, args ...any) error {
+	if replica := s.pickReplica(key); replica != nil {
+		err := scan(replica.db.QueryRow(query, args...))
+		if err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, sql.ErrNoRows) {
+			replica.setHealthy(false, err)
+		}
+	}
+
+	return scan(s.db.QueryRow(query, args...))
+}
+
+func (s *DatabaseStorage) pickReplica(key string) *dbReplica {
+	if len(s.replicas) == 0 || s.recentWrites.contains(key) {
+		return nil
+	}
+
+	start := s.nextReplica.Add(1)
+	for i := 0; i < len(s.replicas); i++ {
+		replica := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		if replica.healthy.Load() {
+			return replica
+		}
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) ReplicaStatuses() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(s.replicas))
+	for _, replica := range s.replicas {
+		statuses = append(statuses, ReplicaStatus{
+			Name:    replica.name,
+			Healthy: replica.healthy.Load(),
+		})
+	}
+
+	return statuses
+}
+
+func (s *DatabaseStorage) checkReplicas(ctx context.Context) {
+	for _, replica := range s.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, replicaPingTimeout)
+		err := replica.db.PingContext(pingCtx)
+		cancel()
+
+		replica.setHealthy(err == nil, err)
+	}
+
+	s.recentWrites.prune()
+}
+
+func (s *DatabaseStorage) checkReplicasLoop() {
+	defer close(s.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-s.stop
+		cancel()
+	}()
+
+	s.checkReplicas(ctx)
+
+	ticker := time.NewTicker(s.options.ReplicaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.checkReplicas(ctx)
+		}
+	}
+}
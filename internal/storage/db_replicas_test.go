@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+// fakeDriver эмулирует отдельные инстансы postgres, адресуемые по dsn,
+// и понимает только запросы, которые DatabaseStorage делает при чтении и записи
+type fakeDriver struct {
+	mu      sync.Mutex
+	servers map[string]*fakeServer
+}
+
+type fakeServer struct {
+	mu      sync.Mutex
+	urls    map[string]string
+	down    atomic.Bool
+	queries atomic.Int64
+}
+
+var testFakeDriver = &fakeDriver{servers: make(map[string]*fakeServer)}
+
+func init() {
+	sql.Register("fakepg", testFakeDriver)
+}
+
+func (d *fakeDriver) server(dsn string) *fakeServer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	srv, ok := d.servers[dsn]
+	if !ok {
+		srv = &fakeServer{urls: make(map[string]string)}
+		d.servers[dsn] = srv
+	}
+
+	return srv
+}
+
+func (d *fakeDriver) Open(dsn string) (driver.Conn, error) {
+	srv := d.server(dsn)
+	if srv.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+
+	return &fakeConn{srv: srv}, nil
+}
+
+type fakeConn struct {
+	srv *fakeServer
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{srv: c.srv, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	if c.srv.down.Load() {
+		return driver.ErrBadConn
+	}
+
+	return nil
+}
+
+type fakeStmt struct {
+	srv   *fakeServer
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s.srv.down.Load() {
+		return nil, driver.ErrBadConn
+	}
+
+	if !strings.HasPrefix(s.query, "INSERT INTO urls") {
+		return nil, errors.New("unsupported exec: " + s.query)
+	}
+
+	s.srv.mu.Lock()
+	defer s.srv.mu.Unlock()
+
+	s.srv.urls[args[0].(string)] = args[1].(string)
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.srv.down.Load() {
+		return nil, driver.ErrBadConn
+	}
+
+	s.srv.queries.Add(1)
+
+	s.srv.mu.Lock()
+	defer s.srv.mu.Unlock()
+
+	var rows [][]driver.Value
+
+	switch {
+	case strings.HasPrefix(s.query, "SELECT "+recordColumns+" FROM urls WHERE short_url"):
+		if originalURL, ok := s.srv.urls[args[0].(string)]; ok {
+			rows = append(rows, fakeRecordRow(args[0].(string), originalURL))
+		}
+
+	case strings.HasPrefix(s.query, "SELECT short_url FROM urls WHERE original_url"):
+		for shortURL, originalURL := range s.srv.urls {
+			if originalURL == args[0].(string) {
+				rows = append(rows, []driver.Value{shortURL})
+			}
+		}
+
+	default:
+		return nil, errors.New("unsupported query: " + s.query)
+	}
+
+	return &fakeRows{rows: rows}, nil
+}
+
+// fakeRecordRow строит строку выборки recordColumns; NULL в колонках, выбираемых через COALESCE, заменяется пустой строкой
+func fakeRecordRow(shortURL, originalURL string) []driver.Value {
+	values := recordValues(models.ShortURLRecord{
+		ShortURL:    shortURL,
+		OriginalURL: originalURL,
+		CreatedAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+
+	row := make([]driver.Value, len(values))
+	for i, value := range values {
+		converted, err := driver.DefaultParameterConverter.ConvertValue(value)
+		if err != nil || (converted == nil && strings.Contains(recordColumns, "COALESCE("+insertColumns[i]+",")) {
+			converted = ""
+		}
+
+		row[i] = converted
+	}
+
+	return row
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return []string{"value"}
+	}
+
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+func newFakeDatabaseStorage(t *testing.T, name string, replicaCount int) (*DatabaseStorage, *fakeServer, []*fakeServer) {
+	primaryDB, err := sql.Open("fakepg", name+"-primary")
+	require.NoError(t, err)
+	primary := testFakeDriver.server(name + "-primary")
+
+	var replicaDBs []*sql.DB
+	var replicas []*fakeServer
+
+	for i := 0; i < replicaCount; i++ {
+		dsn := name + "-replica-" + string(rune('a'+i))
+
+		replicaDB, err := sql.Open("fakepg", dsn)
+		require.NoError(t, err)
+
+		replicaDBs = append(replicaDBs, replicaDB)
+		replicas = append(replicas, testFakeDriver.server(dsn))
+	}
+
+	s := newDatabaseStorage(primaryDB, replicaDBs, DatabaseOptions{ReplicaCheckInterval: time.Hour})
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	return s, primary, replicas
+}
+
+func TestDatabaseStorageReplicaRouting(t *testing.T) {
+	s, primary, replicas := newFakeDatabaseStorage(t, "routing", 2)
+
+	for _, srv := range append([]*fakeServer{primary}, replicas...) {
+		srv.urls["aaaaaaaa"] = "https://yandex.ru"
+	}
+
+	t.Run("reads are balanced across replicas", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			record, err := s.Get("aaaaaaaa")
+			require.NoError(t, err)
+			assert.Equal(t, "https://yandex.ru", record.OriginalURL)
+		}
+
+		assert.Zero(t, primary.queries.Load())
+		assert.Equal(t, int64(2), replicas[0].queries.Load())
+		assert.Equal(t, int64(2), replicas[1].queries.Load())
+	})
+
+	t.Run("recent writes are read from primary", func(t *testing.T) {
+		err := s.Save(models.ShortURLRecord{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com"})
+		require.NoError(t, err)
+
+		replicaQueries := replicas[0].queries.Load() + replicas[1].queries.Load()
+
+		record, err := s.Get("bbbbbbbb")
+		require.NoError(t, err)
+		assert.Equal(t, "https://google.com", record.OriginalURL)
+
+		shortURL, err := s.GetByOriginal("https://google.com")
+		require.NoError(t, err)
+		assert.Equal(t, "bbbbbbbb", shortURL)
+
+		assert.Equal(t, int64(2), primary.queries.Load())
+		assert.Equal(t, replicaQueries, replicas[0].queries.Load()+replicas[1].queries.Load())
+	})
+
+	t.Run("unhealthy replica is skipped", func(t *testing.T) {
+		replicas[0].down.Store(true)
+		defer replicas[0].down.Store(false)
+
+		s.checkReplicas(context.Background())
+
+		statuses := s.ReplicaStatuses()
+		require.Len(t, statuses, 2)
+		assert.False(t, statuses[0].Healthy)
+		assert.True(t, statuses[1].Healthy)
+
+		downQueries := replicas[0].queries.Load()
+		upQueries := replicas[1].queries.Load()
+
+		for i := 0; i < 3; i++ {
+			_, err := s.Get("aaaaaaaa")
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, downQueries, replicas[0].queries.Load())
+		assert.Equal(t, upQueries+3, replicas[1].queries.Load())
+	})
+
+	t.Run("lagging replica falls back to primary", func(t *testing.T) {
+		primary.urls["cccccccc"] = "https://ya.ru"
+		primaryQueries := primary.queries.Load()
+
+		record, err := s.Get("cccccccc")
+		require.NoError(t, err)
+		assert.Equal(t, "https://ya.ru", record.OriginalURL)
+		assert.Equal(t, primaryQueries+1, primary.queries.Load())
+	})
+}
+
+func TestDatabaseStorageWithoutReplicas(t *testing.T) {
+	s, primary, _ := newFakeDatabaseStorage(t, "single", 0)
+	primary.urls["aaaaaaaa"] = "https://yandex.ru"
+
+	record, err := s.Get("aaaaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, "https://yandex.ru", record.OriginalURL)
+
+	_, err = s.Get("zzzzzzzz")
+	require.ErrorIs(t, err, ErrURLNotFound)
+}
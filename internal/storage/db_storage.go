@@ -5,56 +5,244 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
 
+	"github.com/pluhe7/shortener/internal/logger"
 	"github.com/pluhe7/shortener/internal/models"
 )
 
+const (
+	uniqueViolationCode = "23505"
+
+	DefaultCopyThreshold   = 1000
+	DefaultConnectAttempts = 5
+	DefaultConnectBackoff  = 500 * time.Millisecond
+	maxConnectBackoff      = 10 * time.Second
+
+	DefaultReplicaCheckInterval = 5 * time.Second
+	DefaultReadYourWritesWindow = 10 * time.Second
+)
+
 var ErrDuplicateRecord = errors.New("url already exist")
 
+type DatabaseOptions struct {
+	// Начиная с этого размера пачки записи сохраняются через COPY во временную таблицу
+	CopyThreshold int
+	// Максимальное количество открытых соединений, 0 - без ограничения
+	MaxOpenConns int
+	// Максимальное количество простаивающих соединений в пуле
+	MaxIdleConns int
+	// Максимальное время жизни соединения, 0 - без ограничения
+	ConnMaxLifetime time.Duration
+	// Максимальное время простоя соединения, 0 - без ограничения
+	ConnMaxIdleTime time.Duration
+	// Таймаут выполнения запроса на стороне бд, 0 - значение по умолчанию сервера
+	StatementTimeout time.Duration
+	// Количество попыток подключения к бд при старте
+	ConnectAttempts int
+	// Начальная пауза между попытками подключения, удваивается после каждой неудачи
+	ConnectBackoff time.Duration
+	// DSN реплик для чтения, пустой список - все запросы идут в основную бд
+	ReplicaDSNs []string
+	// Период проверки доступности реплик
+	ReplicaCheckInterval time.Duration
+	// Сколько времени после записи чтение этой записи идёт в основную бд
+	ReadYourWritesWindow time.Duration
+}
+
 type DatabaseStorage struct {
-	db *sql.DB
+	db      *sql.DB
+	options DatabaseOptions
+
+	replicas     []*dbReplica
+	nextReplica  atomic.Uint64
+	recentWrites *recentWrites
+
+	stop chan struct{}
+	done chan struct{}
 }
 
-func NewDatabaseStorage(dsn string) (*DatabaseStorage, error) {
-	db, err := sql.Open("pgx", dsn)
+func NewDatabaseStorage(dsn string, options DatabaseOptions) (*DatabaseStorage, error) {
+	if options.CopyThreshold <= 0 {
+		options.CopyThreshold = DefaultCopyThreshold
+	}
+	if options.ConnectAttempts <= 0 {
+		options.ConnectAttempts = DefaultConnectAttempts
+	}
+	if options.ConnectBackoff <= 0 {
+		options.ConnectBackoff = DefaultConnectBackoff
+	}
+
+	db, err := openDB(dsn, options)
 	if err != nil {
 		return nil, fmt.Errorf("open db connection: %w", err)
 	}
 
-	s := &DatabaseStorage{
-		db: db,
+	replicaDBs := make([]*sql.DB, 0, len(options.ReplicaDSNs))
+	for i, replicaDSN := range options.ReplicaDSNs {
+		replicaDB, err := openDB(replicaDSN, options)
+		if err != nil {
+			db.Close()
+			for _, opened := range replicaDBs {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("open replica %d connection: %w", i+1, err)
+		}
+
+		replicaDBs = append(replicaDBs, replicaDB)
+	}
+
+	s := newDatabaseStorage(db, replicaDBs, options)
+
+	err = s.connectWithRetry(context.Background())
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("connect to db: %w", err)
 	}
 
 	err = s.migrateURLsTable()
 	if err != nil {
+		s.Close()
 		return nil, fmt.Errorf("migrate urls table: %w", err)
 	}
 
+	err = s.migrateHistoryTable()
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("migrate history table: %w", err)
+	}
+
+	err = s.migrateVariantClicksTable()
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("migrate variant clicks table: %w", err)
+	}
+
+	err = s.migrateWebhookTables()
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("migrate webhook tables: %w", err)
+	}
+
 	return s, nil
 }
 
-func (s *DatabaseStorage) Get(shortURL string) (string, error) {
-	row := s.db.QueryRow("SELECT original_url FROM urls WHERE short_url = $1", shortURL)
+func newDatabaseStorage(db *sql.DB, replicaDBs []*sql.DB, options DatabaseOptions) *DatabaseStorage {
+	if options.ReplicaCheckInterval <= 0 {
+		options.ReplicaCheckInterval = DefaultReplicaCheckInterval
+	}
+	if options.ReadYourWritesWindow <= 0 {
+		options.ReadYourWritesWindow = DefaultReadYourWritesWindow
+	}
+
+	s := &DatabaseStorage{
+		db:           db,
+		options:      options,
+		recentWrites: newRecentWrites(options.ReadYourWritesWindow),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	for i, replicaDB := range replicaDBs {
+		s.replicas = append(s.replicas, newDBReplica(fmt.Sprintf("replica-%d", i+1), replicaDB))
+	}
+
+	if len(s.replicas) > 0 {
+		go s.checkReplicasLoop()
+	} else {
+		close(s.done)
+	}
+
+	return s
+}
+
+func openDB(dsn string, options DatabaseOptions) (*sql.DB, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parse dsn: %w", err)
+	}
+
+	if options.StatementTimeout > 0 {
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(options.StatementTimeout.Milliseconds(), 10)
+	}
+
+	db := stdlib.OpenDB(*connConfig)
+
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	db.SetMaxIdleConns(options.MaxIdleConns)
+	db.SetConnMaxLifetime(options.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(options.ConnMaxIdleTime)
+
+	return db, nil
+}
+
+func (s *DatabaseStorage) connectWithRetry(ctx context.Context) error {
+	backoff := s.options.ConnectBackoff
+
+	var err error
+	for attempt := 1; attempt <= s.options.ConnectAttempts; attempt++ {
+		err = s.db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == s.options.ConnectAttempts {
+			break
+		}
+
+		logger.Log.Warn("db is unreachable, retrying",
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+
+	return fmt.Errorf("ping after %d attempts: %w", s.options.ConnectAttempts, err)
+}
+
+func (s *DatabaseStorage) Get(shortURL string) (models.ShortURLRecord, error) {
+	var record models.ShortURLRecord
 
-	var originalURL string
-	err := row.Scan(&originalURL)
+	err := s.readRow(shortURL, func(row *sql.Row) error {
+		var err error
+		record, err = scanRecord(row)
+		return err
+	}, "SELECT "+recordColumns+" FROM urls WHERE short_url = $1", shortURL)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", ErrURLNotFound
+			return models.ShortURLRecord{}, ErrURLNotFound
 		}
-		return "", fmt.Errorf("scan original url: %w", err)
+		return models.ShortURLRecord{}, fmt.Errorf("scan record: %w", err)
 	}
 
-	return originalURL, nil
+	return record, nil
 }
 
 func (s *DatabaseStorage) GetByOriginal(originalURL string) (string, error) {
-	row := s.db.QueryRow("SELECT short_url FROM urls WHERE original_url = $1", originalURL)
-
 	var shortURL string
-	err := row.Scan(&shortURL)
+
+	err := s.readRow(originalURL, func(row *sql.Row) error {
+		return row.Scan(&shortURL)
+	}, "SELECT short_url FROM urls WHERE original_url = $1 AND NOT is_deleted", originalURL)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return "", ErrURLNotFound
@@ -66,8 +254,41 @@ func (s *DatabaseStorage) GetByOriginal(originalURL string) (string, error) {
 }
 
 func (s *DatabaseStorage) Save(record models.ShortURLRecord) error {
-	res, err := s.db.Exec(`INSERT INTO urls (short_url, original_url) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
-		record.ShortURL, record.OriginalURL)
+	s.recentWrites.add(record.ShortURL, record.OriginalURL)
+
+	ctx := context.Background()
+
+	// у анонимной ссылки нет подписок, событие ставить некому
+	if record.UserID == "" {
+		return insertRecord(ctx, s.db, record)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = insertRecord(ctx, tx, record)
+	if err != nil {
+		return err
+	}
+
+	err = addEvent(ctx, tx, linkEvent(models.EventLinkCreated, record, 0))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+func insertRecord(ctx context.Context, db execer, record models.ShortURLRecord) error {
+	res, err := db.ExecContext(ctx, insertRecordQuery, recordValues(record)...)
 	if err != nil {
 		return fmt.Errorf("insert: %w", err)
 	}
@@ -85,29 +306,743 @@ func (s *DatabaseStorage) Save(record models.ShortURLRecord) error {
 }
 
 func (s *DatabaseStorage) SaveBatch(records []models.ShortURLRecord) error {
-	tx, err := s.db.Begin()
+	report, err := s.SaveBatchWithReport(context.Background(), records)
 	if err != nil {
 		return err
 	}
+
+	if len(report.Conflicted) > 0 {
+		logger.Log.Warn("batch records conflicted with existing urls",
+			zap.Int("inserted", len(report.Inserted)),
+			zap.Strings("conflicted", report.Conflicted),
+		)
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) SaveBatchWithReport(ctx context.Context, records []models.ShortURLRecord) (*models.BatchReport, error) {
+	for _, record := range records {
+		s.recentWrites.add(record.ShortURL, record.OriginalURL)
+	}
+
+	if len(records) >= s.options.CopyThreshold {
+		return s.saveBatchCopy(ctx, records)
+	}
+
+	return s.saveBatchRowByRow(ctx, records)
+}
+
+// RestoreBatch вставляет записи построчно в одной транзакции с их переходами и историей,
+// чтобы прерванный перенос не оставил записей без накопленного по ним
+func (s *DatabaseStorage) RestoreBatch(links []models.LinkSnapshot) error {
+	ctx := context.Background()
+
+	for _, link := range links {
+		s.recentWrites.add(link.Record.ShortURL, link.Record.OriginalURL)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare("INSERT INTO urls (short_url, original_url) VALUES ($1, $2) ON CONFLICT DO NOTHING")
+	stmt, err := tx.PrepareContext(ctx, insertRecordQuery)
 	if err != nil {
 		return fmt.Errorf("prepare sql: %w", err)
 	}
 	defer stmt.Close()
 
+	var conflicted []string
+
+	for _, link := range links {
+		res, err := stmt.ExecContext(ctx, recordValues(link.Record)...)
+		if err != nil {
+			return fmt.Errorf("insert short %s for original %s error: %w", link.Record.ShortURL, link.Record.OriginalURL, err)
+		}
+
+		insertedRowsCount, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("get inserted rows count: %w", err)
+		}
+
+		if insertedRowsCount != 1 {
+			conflicted = append(conflicted, link.Record.ShortURL)
+			continue
+		}
+
+		err = restoreActivity(ctx, tx, link)
+		if err != nil {
+			return fmt.Errorf("restore activity of %s: %w", link.Record.ShortURL, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	if len(conflicted) > 0 {
+		logger.Log.Warn("restored records conflicted with existing urls",
+			zap.Int("inserted", len(links)-len(conflicted)),
+			zap.Strings("conflicted", conflicted),
+		)
+	}
+
+	return nil
+}
+
+// restoreActivity сохраняет переходы и историю только что вставленной записи
+func restoreActivity(ctx context.Context, tx *sql.Tx, link models.LinkSnapshot) error {
+	shortURL := link.Record.ShortURL
+
+	if link.Clicks > 0 {
+		_, err := tx.ExecContext(ctx, "UPDATE urls SET clicks = $2 WHERE short_url = $1", shortURL, link.Clicks)
+		if err != nil {
+			return fmt.Errorf("update clicks: %w", err)
+		}
+	}
+
+	for variant, clicks := range link.VariantClicks {
+		_, err := tx.ExecContext(ctx, "INSERT INTO url_variant_clicks (short_url, variant, clicks) VALUES ($1, $2, $3)",
+			shortURL, variant, clicks)
+		if err != nil {
+			return fmt.Errorf("insert variant clicks: %w", err)
+		}
+	}
+
+	for _, revision := range link.History {
+		_, err := tx.ExecContext(ctx, `INSERT INTO url_history (short_url, revision, original_url, previous_url, editor, changed_at)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)`,
+			shortURL, revision.Revision, revision.OriginalURL, revision.PreviousURL, revision.Editor, revision.ChangedAt)
+		if err != nil {
+			return fmt.Errorf("insert history: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) saveBatchRowByRow(ctx context.Context, records []models.ShortURLRecord) (*models.BatchReport, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertRecordQuery)
+	if err != nil {
+		return nil, fmt.Errorf("prepare sql: %w", err)
+	}
+	defer stmt.Close()
+
+	var report models.BatchReport
+
 	for _, record := range records {
-		_, err = stmt.Exec(record.ShortURL, record.OriginalURL)
+		res, err := stmt.ExecContext(ctx, recordValues(record)...)
 		if err != nil {
-			return fmt.Errorf("insert short %s for original %s error: %w", record.ShortURL, record.OriginalURL, err)
+			return nil, fmt.Errorf("insert short %s for original %s error: %w", record.ShortURL, record.OriginalURL, err)
 		}
+
+		insertedRowsCount, err := res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("get inserted rows count: %w", err)
+		}
+
+		if insertedRowsCount == 1 {
+			report.Inserted = append(report.Inserted, record.ShortURL)
+
+			err = addEvent(ctx, tx, linkEvent(models.EventLinkCreated, record, 0))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			report.Conflicted = append(report.Conflicted, record.ShortURL)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
 	}
 
-	return tx.Commit()
+	return &report, nil
+}
+
+func (s *DatabaseStorage) saveBatchCopy(ctx context.Context, records []models.ShortURLRecord) (*models.BatchReport, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get db connection: %w", err)
+	}
+	defer conn.Close()
+
+	var report *models.BatchReport
+
+	err = conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+
+		tx, err := pgxConn.Begin(ctx)
+		if err != nil {
+			return fmt.Errorf("begin: %w", err)
+		}
+		defer tx.Rollback(ctx)
+
+		_, err = tx.Exec(ctx, `CREATE TEMP TABLE urls_staging (LIKE urls INCLUDING DEFAULTS) ON COMMIT DROP`)
+		if err != nil {
+			return fmt.Errorf("create staging table: %w", err)
+		}
+
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"urls_staging"}, insertColumns,
+			pgx.CopyFromSlice(len(records), func(i int) ([]any, error) {
+				return recordValues(records[i]), nil
+			}))
+		if err != nil {
+			return fmt.Errorf("copy to staging table: %w", err)
+		}
+
+		columns := strings.Join(insertColumns, ", ")
+
+		rows, err := tx.Query(ctx, "INSERT INTO urls ("+columns+") SELECT "+columns+` FROM urls_staging
+			ON CONFLICT DO NOTHING
+			RETURNING short_url`)
+		if err != nil {
+			return fmt.Errorf("merge staging table: %w", err)
+		}
+
+		inserted, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return fmt.Errorf("collect inserted rows: %w", err)
+		}
+
+		isInserted := make(map[string]bool, len(inserted))
+		for _, shortURL := range inserted {
+			isInserted[shortURL] = true
+		}
+
+		events := &pgx.Batch{}
+		for _, record := range records {
+			if isInserted[record.ShortURL] && record.UserID != "" {
+				events.Queue(addEventQuery, addEventArgs(linkEvent(models.EventLinkCreated, record, 0))...)
+			}
+		}
+
+		if events.Len() > 0 {
+			err = tx.SendBatch(ctx, events).Close()
+			if err != nil {
+				return fmt.Errorf("queue events: %w", err)
+			}
+		}
+
+		err = tx.Commit(ctx)
+		if err != nil {
+			return fmt.Errorf("commit: %w", err)
+		}
+
+		report = newBatchReport(records, inserted)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func newBatchReport(records []models.ShortURLRecord, inserted []string) *models.BatchReport {
+	insertedSet := make(map[string]struct{}, len(inserted))
+	for _, shortURL := range inserted {
+		insertedSet[shortURL] = struct{}{}
+	}
+
+	report := &models.BatchReport{
+		Inserted: inserted,
+	}
+
+	for _, record := range records {
+		if _, ok := insertedSet[record.ShortURL]; !ok {
+			report.Conflicted = append(report.Conflicted, record.ShortURL)
+		}
+	}
+
+	return report
+}
+
+func (s *DatabaseStorage) Delete(shortURL string) error {
+	s.recentWrites.add(shortURL)
+
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	record := models.ShortURLRecord{ShortURL: shortURL}
+
+	err = tx.QueryRowContext(ctx, `SELECT original_url, COALESCE(user_id, ''), is_deleted
+		FROM urls WHERE short_url = $1 FOR UPDATE`, shortURL).
+		Scan(&record.OriginalURL, &record.UserID, &record.IsDeleted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrURLNotFound
+		}
+		return fmt.Errorf("select record: %w", err)
+	}
+
+	if record.IsDeleted {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE urls SET is_deleted = TRUE WHERE short_url = $1", shortURL)
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+
+	err = addEvent(ctx, tx, linkEvent(models.EventLinkDeleted, record, 0))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) SetRules(shortURL string, rules []models.RedirectRule) error {
+	s.recentWrites.add(shortURL)
+
+	res, err := s.db.Exec("UPDATE urls SET rules = $2 WHERE short_url = $1", shortURL, nullJSON(rules))
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+
+	updatedRowsCount, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get updated rows count: %w", err)
+	}
+
+	if updatedRowsCount == 0 {
+		return ErrURLNotFound
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) Update(shortURL, originalURL, editor string) (models.URLRevision, error) {
+	s.recentWrites.add(shortURL)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.URLRevision{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	revision := models.URLRevision{
+		ShortURL:    shortURL,
+		OriginalURL: originalURL,
+		Editor:      editor,
+	}
+
+	var userID string
+
+	err = tx.QueryRow("SELECT original_url, COALESCE(user_id, '') FROM urls WHERE short_url = $1 FOR UPDATE", shortURL).
+		Scan(&revision.PreviousURL, &userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.URLRevision{}, ErrURLNotFound
+		}
+		return models.URLRevision{}, fmt.Errorf("select original url: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE urls SET original_url = $2 WHERE short_url = $1", shortURL, originalURL)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+			return models.URLRevision{}, ErrDuplicateRecord
+		}
+		return models.URLRevision{}, fmt.Errorf("update original url: %w", err)
+	}
+
+	err = tx.QueryRow(`INSERT INTO url_history (short_url, revision, original_url, previous_url, editor)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, NULLIF($4, '') FROM url_history WHERE short_url = $1
+		RETURNING revision, changed_at`,
+		shortURL, originalURL, revision.PreviousURL, editor).Scan(&revision.Revision, &revision.ChangedAt)
+	if err != nil {
+		return models.URLRevision{}, fmt.Errorf("insert history: %w", err)
+	}
+
+	record := models.ShortURLRecord{ShortURL: shortURL, OriginalURL: originalURL, UserID: userID}
+
+	err = addEvent(context.Background(), tx, linkEvent(models.EventLinkUpdated, record, revision.Revision))
+	if err != nil {
+		return models.URLRevision{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return models.URLRevision{}, fmt.Errorf("commit: %w", err)
+	}
+
+	return revision, nil
+}
+
+func (s *DatabaseStorage) History(shortURL string) ([]models.URLRevision, error) {
+	rows, err := s.db.Query(`SELECT revision, original_url, previous_url, COALESCE(editor, ''), changed_at
+		FROM url_history WHERE short_url = $1 ORDER BY revision`, shortURL)
+	if err != nil {
+		return nil, fmt.Errorf("select history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []models.URLRevision
+	for rows.Next() {
+		revision := models.URLRevision{ShortURL: shortURL}
+
+		err = rows.Scan(&revision.Revision, &revision.OriginalURL, &revision.PreviousURL, &revision.Editor, &revision.ChangedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan revision: %w", err)
+		}
+
+		history = append(history, revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+
+	if len(history) == 0 {
+		if _, err = s.Get(shortURL); err != nil {
+			return nil, err
+		}
+	}
+
+	return history, nil
+}
+
+func (s *DatabaseStorage) GetByUser(userID string) ([]models.ShortURLRecord, error) {
+	rows, err := s.db.Query("SELECT "+recordColumns+` FROM urls
+		WHERE user_id = $1 AND NOT is_deleted
+		ORDER BY created_at NULLS FIRST, short_url`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("select user urls: %w", err)
+	}
+	defer rows.Close()
+
+	var records []models.ShortURLRecord
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan record: %w", err)
+		}
+
+		records = append(records, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+
+	return records, nil
+}
+
+func (s *DatabaseStorage) SetSchedule(shortURL string, schedule models.Schedule) error {
+	s.recentWrites.add(shortURL)
+
+	res, err := s.db.Exec("UPDATE urls SET not_before = $2, not_after = $3, fallback_url = $4 WHERE short_url = $1",
+		shortURL, schedule.NotBefore, schedule.NotAfter, nullString(schedule.FallbackURL))
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+
+	updatedRowsCount, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get updated rows count: %w", err)
+	}
+
+	if updatedRowsCount == 0 {
+		return ErrURLNotFound
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) SetMetadata(shortURL string, metadata models.URLMetadata) error {
+	s.recentWrites.add(shortURL)
+
+	res, err := s.db.Exec("UPDATE urls SET metadata = $2 WHERE short_url = $1", shortURL, nullJSON(metadata))
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+
+	updatedRowsCount, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get updated rows count: %w", err)
+	}
+
+	if updatedRowsCount == 0 {
+		return ErrURLNotFound
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) SetLinkCheck(shortURL string, check *models.LinkCheck) error {
+	s.recentWrites.add(shortURL)
+
+	res, err := s.db.Exec("UPDATE urls SET link_check = $2 WHERE short_url = $1", shortURL, nullJSON(check))
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+
+	updatedRowsCount, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get updated rows count: %w", err)
+	}
+
+	if updatedRowsCount == 0 {
+		return ErrURLNotFound
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) SetBrokenFallback(shortURL, fallbackURL string) error {
+	s.recentWrites.add(shortURL)
+
+	res, err := s.db.Exec("UPDATE urls SET broken_fallback_url = $2 WHERE short_url = $1", shortURL, nullString(fallbackURL))
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+
+	updatedRowsCount, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get updated rows count: %w", err)
+	}
+
+	if updatedRowsCount == 0 {
+		return ErrURLNotFound
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) SetSplit(shortURL string, split *models.Split) error {
+	s.recentWrites.add(shortURL)
+
+	res, err := s.db.Exec("UPDATE urls SET split = $2 WHERE short_url = $1", shortURL, nullJSON(split))
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+
+	updatedRowsCount, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get updated rows count: %w", err)
+	}
+
+	if updatedRowsCount == 0 {
+		return ErrURLNotFound
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) RecordClick(shortURL, variant string) (int64, error) {
+	// условие на лимит проверяется под блокировкой строки, поэтому параллельные переходы не превысят его
+	var clicks int64
+	err := s.db.QueryRow(`UPDATE urls SET clicks = clicks + 1
+		WHERE short_url = $1 AND (max_clicks = 0 OR clicks < max_clicks)
+		RETURNING clicks`, shortURL).Scan(&clicks)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		err = s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM urls WHERE short_url = $1)", shortURL).Scan(&exists)
+		if err != nil {
+			return 0, fmt.Errorf("check url exists: %w", err)
+		}
+
+		if !exists {
+			return 0, ErrURLNotFound
+		}
+
+		return 0, ErrClickLimitReached
+	}
+	if err != nil {
+		return 0, fmt.Errorf("update clicks: %w", err)
+	}
+
+	if variant == "" {
+		return clicks, nil
+	}
+
+	_, err = s.db.Exec(`INSERT INTO url_variant_clicks (short_url, variant, clicks) VALUES ($1, $2, 1)
+		ON CONFLICT (short_url, variant) DO UPDATE SET clicks = url_variant_clicks.clicks + 1`, shortURL, variant)
+	if err != nil {
+		return 0, fmt.Errorf("upsert variant clicks: %w", err)
+	}
+
+	return clicks, nil
+}
+
+func (s *DatabaseStorage) ClickCount(shortURL string) (int64, error) {
+	var clicks int64
+
+	err := s.readRow(shortURL, func(row *sql.Row) error {
+		return row.Scan(&clicks)
+	}, "SELECT clicks FROM urls WHERE short_url = $1", shortURL)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrURLNotFound
+		}
+		return 0, fmt.Errorf("scan clicks: %w", err)
+	}
+
+	return clicks, nil
+}
+
+func (s *DatabaseStorage) VariantClicks(shortURL string) (map[string]int64, error) {
+	// LEFT JOIN отличает запись без переходов по вариантам (одна строка с NULL) от отсутствующей записи
+	rows, err := s.db.Query(`SELECT v.variant, v.clicks FROM urls u
+		LEFT JOIN url_variant_clicks v ON v.short_url = u.short_url
+		WHERE u.short_url = $1`, shortURL)
+	if err != nil {
+		return nil, fmt.Errorf("select variant clicks: %w", err)
+	}
+	defer rows.Close()
+
+	var found bool
+	clicks := make(map[string]int64)
+
+	for rows.Next() {
+		var variant sql.NullString
+		var count sql.NullInt64
+
+		err = rows.Scan(&variant, &count)
+		if err != nil {
+			return nil, fmt.Errorf("scan variant clicks: %w", err)
+		}
+
+		found = true
+
+		if variant.Valid {
+			clicks[variant.String] = count.Int64
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	if !found {
+		return nil, ErrURLNotFound
+	}
+
+	return clicks, nil
+}
+
+func (s *DatabaseStorage) Iterate(ctx context.Context, fn func(record models.ShortURLRecord) error) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+recordColumns+" FROM urls ORDER BY short_url")
+	if err != nil {
+		return fmt.Errorf("select urls: %w", err)
+	}
+	defer rows.Close()
+
+	var id int
+	for rows.Next() {
+		id++
+
+		record, err := scanRecord(rows)
+		if err != nil {
+			return fmt.Errorf("scan record: %w", err)
+		}
+		record.ID = id
+
+		err = fn(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterate rows: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) IterateByUser(ctx context.Context, userID string, fn func(record models.ShortURLRecord) error) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+recordColumns+` FROM urls
+		WHERE user_id = $1 AND NOT is_deleted
+		ORDER BY created_at NULLS FIRST, short_url`, userID)
+	if err != nil {
+		return fmt.Errorf("select user urls: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return fmt.Errorf("scan record: %w", err)
+		}
+
+		err = fn(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterate rows: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) IterateExpired(ctx context.Context, from, to time.Time, fn func(record models.ShortURLRecord) error) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+recordColumns+` FROM urls
+		WHERE not_after BETWEEN $1 AND $2 AND NOT is_deleted AND user_id IS NOT NULL
+		ORDER BY not_after, short_url`, from, to)
+	if err != nil {
+		return fmt.Errorf("select expired urls: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return fmt.Errorf("scan record: %w", err)
+		}
+
+		err = fn(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterate rows: %w", err)
+	}
+
+	return nil
 }
 
 func (s *DatabaseStorage) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+
+	for _, replica := range s.replicas {
+		replica.db.Close()
+	}
+
 	if s.db != nil {
 		return s.db.Close()
 	}
@@ -124,9 +1059,89 @@ func (s *DatabaseStorage) migrateURLsTable() error {
 		return fmt.Errorf("execute create table query: %w", err)
 	}
 
+	_, err = s.db.Exec(`ALTER TABLE urls
+		ADD COLUMN IF NOT EXISTS user_id VARCHAR(255),
+		ADD COLUMN IF NOT EXISTS is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS password_hash TEXT,
+		ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ,
+		ADD COLUMN IF NOT EXISTS clicks BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS redirect_status INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS query_passthrough BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS query_conflict_policy VARCHAR(16),
+		ADD COLUMN IF NOT EXISTS path_passthrough BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS rules JSONB,
+		ADD COLUMN IF NOT EXISTS split JSONB,
+		ADD COLUMN IF NOT EXISTS max_clicks INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS not_before TIMESTAMPTZ,
+		ADD COLUMN IF NOT EXISTS not_after TIMESTAMPTZ,
+		ADD COLUMN IF NOT EXISTS fallback_url TEXT,
+		ADD COLUMN IF NOT EXISTS metadata JSONB,
+		ADD COLUMN IF NOT EXISTS link_check JSONB,
+		ADD COLUMN IF NOT EXISTS broken_fallback_url TEXT`)
+	if err != nil {
+		return fmt.Errorf("add record columns: %w", err)
+	}
+
+	// уникальность исходного URL нужна только среди неудалённых записей
+	_, err = s.db.Exec(`ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_key`)
+	if err != nil {
+		return fmt.Errorf("drop original url constraint: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS urls_original_url_active_idx
+		ON urls (original_url) WHERE NOT is_deleted`)
+	if err != nil {
+		return fmt.Errorf("create original url index: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id)`)
+	if err != nil {
+		return fmt.Errorf("create user id index: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS urls_not_after_idx ON urls (not_after) WHERE NOT is_deleted`)
+	if err != nil {
+		return fmt.Errorf("create not after index: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) migrateHistoryTable() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS url_history (
+		 short_url VARCHAR(255) NOT NULL REFERENCES urls (short_url),
+		 revision INTEGER NOT NULL,
+		 original_url TEXT NOT NULL,
+		 previous_url TEXT NOT NULL,
+		 editor VARCHAR(255),
+		 changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		 PRIMARY KEY (short_url, revision)
+	)`)
+	if err != nil {
+		return fmt.Errorf("execute create table query: %w", err)
+	}
+
 	return nil
 }
 
+func (s *DatabaseStorage) migrateVariantClicksTable() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS url_variant_clicks (
+		 short_url VARCHAR(255) NOT NULL REFERENCES urls (short_url),
+		 variant TEXT NOT NULL,
+		 clicks BIGINT NOT NULL DEFAULT 0,
+		 PRIMARY KEY (short_url, variant)
+	)`)
+	if err != nil {
+		return fmt.Errorf("execute create table query: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) PoolStats() sql.DBStats {
+	return s.db.Stats()
+}
+
 func (s *DatabaseStorage) PingContext(ctx context.Context) error {
 	err := s.db.PingContext(ctx)
 	if err != nil {
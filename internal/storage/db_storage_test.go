@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/util"
+)
+
+const idLenForTests = 8
+
+// Тесты и бенчмарки с реальной бд запускаются, только если задан TEST_DATABASE_DSN
+func newTestDatabaseStorage(tb testing.TB) *DatabaseStorage {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		tb.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	s, err := NewDatabaseStorage(dsn, DatabaseOptions{})
+	require.NoError(tb, err)
+
+	tb.Cleanup(func() {
+		s.Close()
+	})
+
+	return s
+}
+
+func newTestRecords(count int) []models.ShortURLRecord {
+	prefix := util.GetRandomString(idLenForTests)
+
+	records := make([]models.ShortURLRecord, 0, count)
+	for i := 0; i < count; i++ {
+		records = append(records, models.ShortURLRecord{
+			ShortURL:    fmt.Sprintf("%s%d", prefix, i),
+			OriginalURL: fmt.Sprintf("https://example.com/%s/%d", prefix, i),
+		})
+	}
+
+	return records
+}
+
+func TestNewBatchReport(t *testing.T) {
+	records := []models.ShortURLRecord{
+		{ShortURL: "aaaaaaaa"},
+		{ShortURL: "bbbbbbbb"},
+		{ShortURL: "cccccccc"},
+	}
+
+	report := newBatchReport(records, []string{"cccccccc", "aaaaaaaa"})
+
+	assert.ElementsMatch(t, []string{"aaaaaaaa", "cccccccc"}, report.Inserted)
+	assert.Equal(t, []string{"bbbbbbbb"}, report.Conflicted)
+}
+
+func TestDatabaseStorageSaveBatchReport(t *testing.T) {
+	s := newTestDatabaseStorage(t)
+
+	for name, save := range map[string]func(context.Context, []models.ShortURLRecord) (*models.BatchReport, error){
+		"row by row": s.saveBatchRowByRow,
+		"copy":       s.saveBatchCopy,
+	} {
+		t.Run(name, func(t *testing.T) {
+			records := newTestRecords(3)
+
+			err := s.Save(records[1])
+			require.NoError(t, err)
+
+			report, err := save(context.Background(), records)
+			require.NoError(t, err)
+
+			assert.ElementsMatch(t, []string{records[0].ShortURL, records[2].ShortURL}, report.Inserted)
+			assert.Equal(t, []string{records[1].ShortURL}, report.Conflicted)
+		})
+	}
+}
+
+func BenchmarkDatabaseStorageSaveBatch(b *testing.B) {
+	s := newTestDatabaseStorage(b)
+
+	for _, size := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("row by row %d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				records := newTestRecords(size)
+				b.StartTimer()
+
+				_, err := s.saveBatchRowByRow(context.Background(), records)
+				require.NoError(b, err)
+			}
+		})
+
+		b.Run(fmt.Sprintf("copy %d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				records := newTestRecords(size)
+				b.StartTimer()
+
+				_, err := s.saveBatchCopy(context.Background(), records)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+func TestDatabaseStorageClickLimit(t *testing.T) {
+	testClickLimit(t, newTestDatabaseStorage(t))
+}
+
+func TestDatabaseStorageWebhookQueue(t *testing.T) {
+	testWebhookQueue(t, newTestDatabaseStorage(t))
+}
+
+func TestDatabaseStorageRestoreBatch(t *testing.T) {
+	testRestoreBatch(t, newTestDatabaseStorage(t))
+}
+
+func TestDatabaseStorageSaveBatchConflicts(t *testing.T) {
+	testSaveBatchReport(t, newTestDatabaseStorage(t))
+}
+
+func TestDatabaseStorageIterateExpired(t *testing.T) {
+	testIterateExpired(t, newTestDatabaseStorage(t))
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+// Очередь доставок - outbox: событие изменения записи добавляется в webhook_outbox
+// в той же транзакции, что и само изменение, поэтому не теряется и не появляется без изменения
+const addEventQuery = `INSERT INTO webhook_outbox (webhook_id, event_id, event, next_attempt_at, created_at)
+	SELECT id, $2, $3, $4, $4 FROM webhooks
+	WHERE user_id = $1 AND (events IS NULL OR events ? $5)
+	ON CONFLICT (webhook_id, event_id) DO NOTHING`
+
+const deliveryColumns = `o.id, o.webhook_id, o.event, o.status, o.attempts, o.next_attempt_at, o.last_attempt_at,
+	o.response_status, COALESCE(o.error, ''), o.created_at`
+
+const webhookColumns = `w.id, w.user_id, w.url, w.events, w.secret, w.created_at`
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func addEventArgs(event models.WebhookEvent) []any {
+	data, _ := json.Marshal(event)
+
+	return []any{event.UserID, event.ID, string(data), event.OccurredAt, event.Type}
+}
+
+// addEvent ставит событие в очередь подписок владельца ссылки в рамках db, обычно транзакции изменения
+func addEvent(ctx context.Context, db execer, event models.WebhookEvent) error {
+	if event.UserID == "" {
+		return nil
+	}
+
+	_, err := db.ExecContext(ctx, addEventQuery, addEventArgs(event)...)
+	if err != nil {
+		return fmt.Errorf("queue event: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) CreateWebhook(webhook models.Webhook) error {
+	_, err := s.db.Exec(`INSERT INTO webhooks (id, user_id, url, events, secret, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		webhook.ID, webhook.UserID, webhook.URL, nullJSON(webhook.Events), webhook.Secret, webhook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert webhook: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) GetWebhooks(userID string) ([]models.Webhook, error) {
+	rows, err := s.db.Query("SELECT "+webhookColumns+` FROM webhooks w
+		WHERE w.user_id = $1 ORDER BY w.created_at, w.id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("select webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan webhook: %w", err)
+		}
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+
+	return webhooks, nil
+}
+
+func (s *DatabaseStorage) DeleteWebhook(id, userID string) error {
+	res, err := s.db.Exec("DELETE FROM webhooks WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("delete webhook: %w", err)
+	}
+
+	deletedRowsCount, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get deleted rows count: %w", err)
+	}
+
+	if deletedRowsCount == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) AddEvent(event models.WebhookEvent) error {
+	return addEvent(context.Background(), s.db, event)
+}
+
+func (s *DatabaseStorage) ClaimDeliveries(lease time.Duration, limit int) ([]models.WebhookTask, error) {
+	now := time.Now()
+
+	// SKIP LOCKED позволяет нескольким экземплярам сервиса разбирать очередь, не дожидаясь друг друга
+	rows, err := s.db.Query(`WITH due AS (
+			SELECT id FROM webhook_outbox
+			WHERE status = $1 AND next_attempt_at <= $2
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_outbox o SET next_attempt_at = $4
+		FROM due, webhooks w
+		WHERE o.id = due.id AND w.id = o.webhook_id
+		RETURNING `+deliveryColumns+", "+webhookColumns,
+		models.DeliveryPending, now, limit, now.Add(lease))
+	if err != nil {
+		return nil, fmt.Errorf("claim deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []models.WebhookTask
+	for rows.Next() {
+		var task models.WebhookTask
+
+		task.Delivery, task.Webhook, err = scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan delivery: %w", err)
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+
+	return tasks, nil
+}
+
+func (s *DatabaseStorage) UpdateDelivery(delivery models.WebhookDelivery) error {
+	_, err := s.db.Exec(`UPDATE webhook_outbox SET status = $2, attempts = $3, next_attempt_at = $4,
+		last_attempt_at = $5, response_status = $6, error = $7
+		WHERE id = $1`,
+		delivery.ID, delivery.Status, delivery.Attempts, delivery.NextAttemptAt,
+		delivery.LastAttemptAt, delivery.ResponseStatus, nullString(delivery.Error))
+	if err != nil {
+		return fmt.Errorf("update delivery: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) GetDeliveries(webhookID, userID string, limit int) ([]models.WebhookDelivery, error) {
+	var exists bool
+
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1 AND user_id = $2)", webhookID, userID).
+		Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("check webhook exists: %w", err)
+	}
+
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+
+	rows, err := s.db.Query("SELECT "+deliveryColumns+` FROM webhook_outbox o
+		WHERE o.webhook_id = $1 ORDER BY o.id DESC LIMIT $2`, webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("select deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan delivery: %w", err)
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func scanWebhook(row rowScanner) (models.Webhook, error) {
+	var webhook models.Webhook
+	var events []byte
+
+	err := row.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &events, &webhook.Secret, &webhook.CreatedAt)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	return webhook, unmarshalEvents(events, &webhook)
+}
+
+func scanTask(row rowScanner) (models.WebhookDelivery, models.Webhook, error) {
+	var webhook models.Webhook
+	var events []byte
+
+	delivery, err := scanDelivery(row,
+		&webhook.ID, &webhook.UserID, &webhook.URL, &events, &webhook.Secret, &webhook.CreatedAt)
+	if err != nil {
+		return models.WebhookDelivery{}, models.Webhook{}, err
+	}
+
+	return delivery, webhook, unmarshalEvents(events, &webhook)
+}
+
+// scanDelivery сканирует колонки доставки, а следующие за ними колонки - в extra
+func scanDelivery(row rowScanner, extra ...any) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	var event []byte
+	var lastAttemptAt sql.NullTime
+
+	dest := append([]any{&delivery.ID, &delivery.WebhookID, &event, &delivery.Status, &delivery.Attempts,
+		&delivery.NextAttemptAt, &lastAttemptAt, &delivery.ResponseStatus, &delivery.Error, &delivery.CreatedAt}, extra...)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	delivery.LastAttemptAt = timePointer(lastAttemptAt)
+
+	err = json.Unmarshal(event, &delivery.Event)
+	if err != nil {
+		return models.WebhookDelivery{}, fmt.Errorf("unmarshal event: %w", err)
+	}
+
+	return delivery, nil
+}
+
+func unmarshalEvents(events []byte, webhook *models.Webhook) error {
+	if events == nil {
+		return nil
+	}
+
+	err := json.Unmarshal(events, &webhook.Events)
+	if err != nil {
+		return fmt.Errorf("unmarshal events: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) migrateWebhookTables() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS webhooks (
+		 id VARCHAR(64) PRIMARY KEY,
+		 user_id VARCHAR(255) NOT NULL,
+		 url TEXT NOT NULL,
+		 events JSONB,
+		 secret TEXT NOT NULL,
+		 created_at TIMESTAMPTZ NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("create webhooks table: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id)`)
+	if err != nil {
+		return fmt.Errorf("create webhooks user id index: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS webhook_outbox (
+		 id BIGSERIAL PRIMARY KEY,
+		 webhook_id VARCHAR(64) NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+		 event_id TEXT NOT NULL,
+		 event JSONB NOT NULL,
+		 status VARCHAR(16) NOT NULL DEFAULT 'pending',
+		 attempts INTEGER NOT NULL DEFAULT 0,
+		 next_attempt_at TIMESTAMPTZ NOT NULL,
+		 last_attempt_at TIMESTAMPTZ,
+		 response_status INTEGER NOT NULL DEFAULT 0,
+		 error TEXT,
+		 created_at TIMESTAMPTZ NOT NULL,
+		 UNIQUE (webhook_id, event_id)
+	)`)
+	if err != nil {
+		return fmt.Errorf("create webhook outbox table: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS webhook_outbox_pending_idx
+		ON webhook_outbox (next_attempt_at) WHERE status = 'pending'`)
+	if err != nil {
+		return fmt.Errorf("create webhook outbox pending index: %w", err)
+	}
+
+	return nil
+}
@@ -4,141 +4,1020 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/pluhe7/shortener/internal/models"
 )
 
+// FileStorage хранит записи в файле построчно в JSON. Файл только дописывается:
+// изменение записи добавляет её новую версию, актуальной считается последняя.
+// История изменений исходных URL пишется рядом, в файл с суффиксом .history, переходы - в файл .clicks,
+// подписки на события - в файл .webhooks, а версии доставок событий - в файл .deliveries.
 type FileStorage struct {
 	filename string
+
+	// защищает чтение-изменение-запись, чтобы версии записей не перемешивались
+	mu sync.Mutex
+
+	// результаты проверок, не записанные в файл, потому что статус не изменился; заменяют проверку
+	// в прочитанных записях, пока запись не будет переписана
+	linkChecksMu sync.RWMutex
+	linkChecks   map[string]*models.LinkCheck
 }
 
 func NewFileStorage(filename string) (*FileStorage, error) {
 	storage := FileStorage{
-		filename: filename,
+		filename:   filename,
+		linkChecks: make(map[string]*models.LinkCheck),
 	}
 
 	return &storage, nil
 }
 
-func (s *FileStorage) Get(shortURL string) (string, error) {
-	var originalURL string
+func (s *FileStorage) Get(shortURL string) (models.ShortURLRecord, error) {
+	var found bool
+	var lastRecord models.ShortURLRecord
 
-	file, err := os.OpenFile(s.filename, os.O_RDONLY|os.O_CREATE, 0666)
+	err := s.scan(func(record models.ShortURLRecord) error {
+		if record.ShortURL == shortURL {
+			found = true
+			lastRecord = record
+		}
+
+		return nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("open file: %w", err)
+		return models.ShortURLRecord{}, err
 	}
-	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	if !found {
+		return models.ShortURLRecord{}, ErrURLNotFound
+	}
 
-	for {
-		recordBytes, err := reader.ReadBytes('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return "", fmt.Errorf("read record bytes: %w", err)
+	return lastRecord, nil
+}
+
+func (s *FileStorage) GetByOriginal(originalURL string) (string, error) {
+	var candidates []string
+	isCandidate := make(map[string]bool)
+
+	err := s.scan(func(record models.ShortURLRecord) error {
+		matches := record.OriginalURL == originalURL && !record.IsDeleted
+
+		if matches && !isCandidate[record.ShortURL] {
+			candidates = append(candidates, record.ShortURL)
+		}
+
+		isCandidate[record.ShortURL] = matches
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, shortURL := range candidates {
+		if isCandidate[shortURL] {
+			return shortURL, nil
 		}
+	}
+
+	return "", ErrURLNotFound
+}
 
-		var record models.ShortURLRecord
-		err = json.Unmarshal(recordBytes, &record)
+func (s *FileStorage) Save(record models.ShortURLRecord) error {
+	report, err := s.SaveBatchWithReport(context.Background(), []models.ShortURLRecord{record})
+	if err != nil {
+		return err
+	}
+
+	if len(report.Conflicted) > 0 {
+		return ErrDuplicateRecord
+	}
+
+	return nil
+}
+
+func (s *FileStorage) SaveBatch(records []models.ShortURLRecord) error {
+	_, err := s.SaveBatchWithReport(context.Background(), records)
+	return err
+}
+
+// SaveBatchWithReport, как и база, пропускает запись, конфликтующую с существующими
+// или с уже сохранёнными записями пачки: иначе её строка в файле заменила бы существующую запись
+func (s *FileStorage) SaveBatchWithReport(_ context.Context, records []models.ShortURLRecord) (*models.BatchReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, activeOriginals, err := s.findConflicts(records)
+	if err != nil {
+		return nil, err
+	}
+
+	var report models.BatchReport
+	var inserted []models.ShortURLRecord
+
+	for _, record := range records {
+		if existing[record.ShortURL] || activeOriginals[record.OriginalURL] {
+			report.Conflicted = append(report.Conflicted, record.ShortURL)
+			continue
+		}
+
+		existing[record.ShortURL] = true
+		activeOriginals[record.OriginalURL] = true
+		inserted = append(inserted, record)
+
+		report.Inserted = append(report.Inserted, record.ShortURL)
+	}
+
+	numbered, err := s.writeNumbered(inserted)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range numbered {
+		err = s.addEvent(linkEvent(models.EventLinkCreated, record, 0))
 		if err != nil {
-			return "", fmt.Errorf("unmarshal record: %w", err)
+			return nil, err
 		}
+	}
 
-		if record.ShortURL == shortURL {
-			originalURL = record.OriginalURL
-			break
+	return &report, nil
+}
+
+// findConflicts возвращает, какие сокращённые URL записей уже заняты
+// и какие их исходные URL уже есть у неудалённых записей
+func (s *FileStorage) findConflicts(records []models.ShortURLRecord) (map[string]bool, map[string]bool, error) {
+	shortURLs := make(map[string]bool, len(records))
+	originalURLs := make(map[string]bool, len(records))
+	for _, record := range records {
+		shortURLs[record.ShortURL] = true
+		originalURLs[record.OriginalURL] = true
+	}
+
+	existing := make(map[string]bool)
+	// последняя строка записи заменяет предыдущие, поэтому исходный URL берётся из неё
+	activeOriginalByShort := make(map[string]string)
+
+	err := s.scan(func(record models.ShortURLRecord) error {
+		if shortURLs[record.ShortURL] {
+			existing[record.ShortURL] = true
+		}
+
+		if originalURLs[record.OriginalURL] && !record.IsDeleted {
+			activeOriginalByShort[record.ShortURL] = record.OriginalURL
+		} else {
+			delete(activeOriginalByShort, record.ShortURL)
 		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if originalURL == "" {
-		return "", ErrURLNotFound
+	activeOriginals := make(map[string]bool, len(activeOriginalByShort))
+	for _, originalURL := range activeOriginalByShort {
+		activeOriginals[originalURL] = true
 	}
 
-	return originalURL, nil
+	return existing, activeOriginals, nil
 }
 
-func (s *FileStorage) GetByOriginal(originalURL string) (string, error) {
-	var shortURL string
+func (s *FileStorage) RestoreBatch(links []models.LinkSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	file, err := os.OpenFile(s.filename, os.O_RDONLY|os.O_CREATE, 0666)
+	var records []models.ShortURLRecord
+	var history []models.URLRevision
+	var clicks []clickEvent
+
+	now := time.Now()
+
+	for _, link := range links {
+		_, err := s.Get(link.Record.ShortURL)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrURLNotFound) {
+			return err
+		}
+
+		records = append(records, link.Record)
+		history = append(history, link.History...)
+		clicks = append(clicks, restoredClicks(link, now)...)
+	}
+
+	_, err := s.writeNumbered(records)
 	if err != nil {
-		return "", fmt.Errorf("open file: %w", err)
+		return err
 	}
-	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	err = appendJSONLines(s.historyFilename(), history...)
+	if err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
 
-	for {
-		recordBytes, err := reader.ReadBytes('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return "", fmt.Errorf("read record bytes: %w", err)
+	err = appendJSONLines(s.clicksFilename(), clicks...)
+	if err != nil {
+		return fmt.Errorf("write clicks: %w", err)
+	}
+
+	return nil
+}
+
+// restoredClicks сворачивает перенесённые переходы ссылки в события с количеством:
+// по одному на вариант и одно на переходы вне распределения
+func restoredClicks(link models.LinkSnapshot, at time.Time) []clickEvent {
+	var events []clickEvent
+	remaining := link.Clicks
+
+	for variant, count := range link.VariantClicks {
+		events = append(events, clickEvent{ShortURL: link.Record.ShortURL, Variant: variant, Count: count, At: at})
+		remaining -= count
+	}
+
+	if remaining > 0 {
+		events = append(events, clickEvent{ShortURL: link.Record.ShortURL, Count: remaining, At: at})
+	}
+
+	return events
+}
+
+// writeNumbered дописывает записи, присваивая им порядковые номера после уже сохранённых
+func (s *FileStorage) writeNumbered(records []models.ShortURLRecord) ([]models.ShortURLRecord, error) {
+	recordCount, err := s.getRecordCount()
+	if err != nil {
+		return nil, fmt.Errorf("get record count: %w", err)
+	}
+
+	numbered := make([]models.ShortURLRecord, 0, len(records))
+	for i, record := range records {
+		record.ID = recordCount + i + 1
+		numbered = append(numbered, record)
+	}
+
+	err = s.write(numbered...)
+	if err != nil {
+		return nil, err
+	}
+
+	return numbered, nil
+}
+
+func (s *FileStorage) Delete(shortURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.Get(shortURL)
+	if err != nil {
+		return err
+	}
+
+	if record.IsDeleted {
+		return nil
+	}
+
+	record.IsDeleted = true
+
+	err = s.write(record)
+	if err != nil {
+		return err
+	}
+
+	return s.addEvent(linkEvent(models.EventLinkDeleted, record, 0))
+}
+
+func (s *FileStorage) Update(shortURL, originalURL, editor string) (models.URLRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.Get(shortURL)
+	if err != nil {
+		return models.URLRevision{}, err
+	}
+
+	existingShortURL, err := s.GetByOriginal(originalURL)
+	if err == nil && existingShortURL != shortURL {
+		return models.URLRevision{}, ErrDuplicateRecord
+	} else if err != nil && !errors.Is(err, ErrURLNotFound) {
+		return models.URLRevision{}, err
+	}
+
+	history, err := s.History(shortURL)
+	if err != nil {
+		return models.URLRevision{}, err
+	}
+
+	revision := models.URLRevision{
+		ShortURL:    shortURL,
+		Revision:    len(history) + 1,
+		OriginalURL: originalURL,
+		PreviousURL: record.OriginalURL,
+		Editor:      editor,
+		ChangedAt:   time.Now(),
+	}
+
+	record.OriginalURL = originalURL
+
+	err = s.write(record)
+	if err != nil {
+		return models.URLRevision{}, err
+	}
+
+	err = appendJSONLines(s.historyFilename(), revision)
+	if err != nil {
+		return models.URLRevision{}, fmt.Errorf("write history: %w", err)
+	}
+
+	err = s.addEvent(linkEvent(models.EventLinkUpdated, record, revision.Revision))
+	if err != nil {
+		return models.URLRevision{}, err
+	}
+
+	return revision, nil
+}
+
+func (s *FileStorage) SetRules(shortURL string, rules []models.RedirectRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.Get(shortURL)
+	if err != nil {
+		return err
+	}
+
+	record.Rules = rules
+
+	return s.write(record)
+}
+
+func (s *FileStorage) SetMetadata(shortURL string, metadata models.URLMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.Get(shortURL)
+	if err != nil {
+		return err
+	}
+
+	record.Metadata = &metadata
+
+	return s.write(record)
+}
+
+// SetLinkCheck не дописывает запись, если статус проверки не изменился: проверки повторяются
+// для всех ссылок каждый период, и файл рос бы на копию каждой записи. Такой результат с настоящими
+// временем и счётом неудач хранится в памяти и попадает в файл со следующей версией записи;
+// после перезапуска счёт продолжается с последнего записанного результата.
+func (s *FileStorage) SetLinkCheck(shortURL string, check *models.LinkCheck) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found bool
+	var record models.ShortURLRecord
+
+	// статус сравнивается с записанным в файл, а не с результатом в памяти
+	err := scanJSONLines(s.filename, func(stored models.ShortURLRecord) error {
+		if stored.ShortURL == shortURL {
+			found = true
+			record = stored
 		}
 
-		var record models.ShortURLRecord
-		err = json.Unmarshal(recordBytes, &record)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return ErrURLNotFound
+	}
+
+	if sameLinkCheckStatus(record.LinkCheck, check) {
+		s.linkChecksMu.Lock()
+		s.linkChecks[shortURL] = check
+		s.linkChecksMu.Unlock()
+
+		return nil
+	}
+
+	record.LinkCheck = check
+
+	return s.write(record)
+}
+
+// sameLinkCheckStatus сравнивает статусы проверок
+func sameLinkCheckStatus(a, b *models.LinkCheck) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return a.Status == b.Status
+}
+
+func (s *FileStorage) SetBrokenFallback(shortURL, fallbackURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.Get(shortURL)
+	if err != nil {
+		return err
+	}
+
+	record.BrokenFallbackURL = fallbackURL
+
+	return s.write(record)
+}
+
+func (s *FileStorage) History(shortURL string) ([]models.URLRevision, error) {
+	var history []models.URLRevision
+
+	err := scanJSONLines(s.historyFilename(), func(revision models.URLRevision) error {
+		if revision.ShortURL == shortURL {
+			history = append(history, revision)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(history) == 0 {
+		if _, err = s.Get(shortURL); err != nil {
+			return nil, err
+		}
+	}
+
+	return history, nil
+}
+
+func (s *FileStorage) GetByUser(userID string) ([]models.ShortURLRecord, error) {
+	var records []models.ShortURLRecord
+
+	err := s.Iterate(context.Background(), func(record models.ShortURLRecord) error {
+		if record.UserID == userID && !record.IsDeleted {
+			records = append(records, record)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortByCreation(records)
+
+	return records, nil
+}
+
+func (s *FileStorage) SetSchedule(shortURL string, schedule models.Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.Get(shortURL)
+	if err != nil {
+		return err
+	}
+
+	record.Schedule = schedule
+
+	return s.write(record)
+}
+
+func (s *FileStorage) SetSplit(shortURL string, split *models.Split) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.Get(shortURL)
+	if err != nil {
+		return err
+	}
+
+	record.Split = split
+
+	return s.write(record)
+}
+
+type clickEvent struct {
+	ShortURL string `json:"short_url"`
+	Variant  string `json:"variant,omitempty"`
+	// Количество переходов, 0 - один переход; больше одного у переходов, перенесённых из другого хранилища
+	Count int64     `json:"count,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+func (e clickEvent) clicks() int64 {
+	if e.Count > 0 {
+		return e.Count
+	}
+
+	return 1
+}
+
+func (s *FileStorage) RecordClick(shortURL, variant string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.Get(shortURL)
+	if err != nil {
+		return 0, err
+	}
+
+	clicks, err := s.ClickCount(shortURL)
+	if err != nil {
+		return 0, err
+	}
+
+	if record.MaxClicks > 0 && clicks >= int64(record.MaxClicks) {
+		return 0, ErrClickLimitReached
+	}
+
+	err = appendJSONLines(s.clicksFilename(), clickEvent{ShortURL: shortURL, Variant: variant, At: time.Now()})
+	if err != nil {
+		return 0, fmt.Errorf("write click: %w", err)
+	}
+
+	return clicks + 1, nil
+}
+
+func (s *FileStorage) ClickCount(shortURL string) (int64, error) {
+	if _, err := s.Get(shortURL); err != nil {
+		return 0, err
+	}
+
+	var clicks int64
+
+	err := scanJSONLines(s.clicksFilename(), func(event clickEvent) error {
+		if event.ShortURL == shortURL {
+			clicks += event.clicks()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return clicks, nil
+}
+
+func (s *FileStorage) VariantClicks(shortURL string) (map[string]int64, error) {
+	if _, err := s.Get(shortURL); err != nil {
+		return nil, err
+	}
+
+	clicks := make(map[string]int64)
+
+	err := scanJSONLines(s.clicksFilename(), func(event clickEvent) error {
+		if event.ShortURL == shortURL && event.Variant != "" {
+			clicks[event.Variant] += event.clicks()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return clicks, nil
+}
+
+// Iterate отдаёт последние версии записей в порядке их последнего изменения
+func (s *FileStorage) Iterate(ctx context.Context, fn func(record models.ShortURLRecord) error) error {
+	lastLines := make(map[string]int)
+
+	var line int
+	err := s.scan(func(record models.ShortURLRecord) error {
+		lastLines[record.ShortURL] = line
+		line++
+
+		return ctx.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	line = 0
+	return s.scan(func(record models.ShortURLRecord) error {
+		defer func() { line++ }()
+
+		if lastLines[record.ShortURL] != line {
+			return nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return fn(record)
+	})
+}
+
+func (s *FileStorage) IterateByUser(ctx context.Context, userID string, fn func(record models.ShortURLRecord) error) error {
+	return s.Iterate(ctx, func(record models.ShortURLRecord) error {
+		if record.UserID != userID || record.IsDeleted {
+			return nil
+		}
+
+		return fn(record)
+	})
+}
+
+// IterateExpired просматривает весь файл: отдельного индекса по окончанию окна у файла нет
+func (s *FileStorage) IterateExpired(ctx context.Context, from, to time.Time, fn func(record models.ShortURLRecord) error) error {
+	var records []models.ShortURLRecord
+
+	err := s.Iterate(ctx, func(record models.ShortURLRecord) error {
+		if isExpiredBetween(record, from, to) {
+			records = append(records, record)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sortByNotAfter(records)
+
+	for _, record := range records {
+		err = fn(record)
 		if err != nil {
-			return "", fmt.Errorf("unmarshal record: %w", err)
+			return err
 		}
+	}
+
+	return nil
+}
+
+// webhookEntry - версия подписки в файле; у подписки, удалённой последней версией, Deleted
+type webhookEntry struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events,omitempty"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+func (s *FileStorage) CreateWebhook(webhook models.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := appendJSONLines(s.webhooksFilename(), webhookEntry{
+		ID:        webhook.ID,
+		UserID:    webhook.UserID,
+		URL:       webhook.URL,
+		Events:    webhook.Events,
+		Secret:    webhook.Secret,
+		CreatedAt: webhook.CreatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("write webhook: %w", err)
+	}
 
-		if record.OriginalURL == originalURL {
-			shortURL = record.ShortURL
-			break
+	return nil
+}
+
+func (s *FileStorage) GetWebhooks(userID string) ([]models.Webhook, error) {
+	webhooks, err := s.webhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	var userWebhooks []models.Webhook
+	for _, webhook := range webhooks {
+		if webhook.UserID == userID {
+			userWebhooks = append(userWebhooks, webhook)
 		}
 	}
 
-	if shortURL == "" {
-		return "", ErrURLNotFound
+	sortWebhooks(userWebhooks)
+
+	return userWebhooks, nil
+}
+
+func (s *FileStorage) DeleteWebhook(id, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhooks, err := s.webhooks()
+	if err != nil {
+		return err
+	}
+
+	webhook, ok := webhooks[id]
+	if !ok || webhook.UserID != userID {
+		return ErrWebhookNotFound
 	}
 
-	return shortURL, nil
+	err = appendJSONLines(s.webhooksFilename(), webhookEntry{ID: id, UserID: userID, Deleted: true})
+	if err != nil {
+		return fmt.Errorf("write webhook: %w", err)
+	}
+
+	return nil
 }
 
-func (s *FileStorage) Save(record models.ShortURLRecord) error {
-	recordCount, err := s.getRecordCount()
+func (s *FileStorage) AddEvent(event models.WebhookEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addEvent(event)
+}
+
+// addEvent дописывает доставки события подписчикам, вызывается под s.mu
+func (s *FileStorage) addEvent(event models.WebhookEvent) error {
+	if event.UserID == "" {
+		return nil
+	}
+
+	webhooks, err := s.webhooks()
 	if err != nil {
-		return fmt.Errorf("get record count: %w", err)
+		return err
 	}
 
-	record.ID = recordCount + 1
+	var subscribers []models.Webhook
+	for _, webhook := range webhooks {
+		if subscribed(webhook, event.UserID, event.Type) {
+			subscribers = append(subscribers, webhook)
+		}
+	}
 
-	w, err := newDataWriter(s.filename)
+	if len(subscribers) == 0 {
+		return nil
+	}
+
+	sortWebhooks(subscribers)
+
+	deliveries, err := s.deliveries()
 	if err != nil {
-		return fmt.Errorf("new data writer: %w", err)
+		return err
 	}
-	defer w.Close()
 
-	err = w.WriteData(&record)
+	queued := make(map[[2]string]struct{}, len(deliveries))
+	for _, delivery := range deliveries {
+		queued[[2]string{delivery.WebhookID, delivery.Event.ID}] = struct{}{}
+	}
+
+	lastID := int64(len(deliveries))
+
+	var added []models.WebhookDelivery
+	for _, webhook := range subscribers {
+		if _, ok := queued[[2]string{webhook.ID, event.ID}]; ok {
+			continue
+		}
+
+		lastID++
+		added = append(added, newDelivery(lastID, webhook.ID, event))
+	}
+
+	err = appendJSONLines(s.deliveriesFilename(), added...)
 	if err != nil {
-		return fmt.Errorf("write data: %w", err)
+		return fmt.Errorf("write deliveries: %w", err)
 	}
 
 	return nil
 }
 
-func (s *FileStorage) SaveBatch(records []models.ShortURLRecord) error {
-	w, err := newDataWriter(s.filename)
+func (s *FileStorage) ClaimDeliveries(lease time.Duration, limit int) ([]models.WebhookTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhooks, err := s.webhooks()
 	if err != nil {
-		return fmt.Errorf("new data writer: %w", err)
+		return nil, err
 	}
-	defer w.Close()
 
-	recordCount, err := s.getRecordCount()
+	deliveries, err := s.deliveries()
 	if err != nil {
-		return fmt.Errorf("get record count: %w", err)
+		return nil, err
 	}
 
-	for i, record := range records {
-		record.ID = recordCount + i + 1
+	// доставки удалённых подписок остаются в файле, но больше не отправляются
+	active := deliveries[:0]
+	for _, delivery := range deliveries {
+		if _, ok := webhooks[delivery.WebhookID]; ok {
+			active = append(active, delivery)
+		}
+	}
+
+	now := time.Now()
+	due := dueDeliveries(active, now, limit)
+
+	tasks := make([]models.WebhookTask, 0, len(due))
+	for i := range due {
+		due[i].NextAttemptAt = now.Add(lease)
+		tasks = append(tasks, models.WebhookTask{Webhook: webhooks[due[i].WebhookID], Delivery: due[i]})
+	}
+
+	err = appendJSONLines(s.deliveriesFilename(), due...)
+	if err != nil {
+		return nil, fmt.Errorf("write deliveries: %w", err)
+	}
+
+	return tasks, nil
+}
+
+func (s *FileStorage) UpdateDelivery(delivery models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhooks, err := s.webhooks()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := webhooks[delivery.WebhookID]; !ok {
+		return nil
+	}
+
+	err = appendJSONLines(s.deliveriesFilename(), delivery)
+	if err != nil {
+		return fmt.Errorf("write delivery: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileStorage) GetDeliveries(webhookID, userID string, limit int) ([]models.WebhookDelivery, error) {
+	webhooks, err := s.webhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, ok := webhooks[webhookID]
+	if !ok || webhook.UserID != userID {
+		return nil, ErrWebhookNotFound
+	}
+
+	deliveries, err := s.deliveries()
+	if err != nil {
+		return nil, err
+	}
+
+	var webhookDeliveries []models.WebhookDelivery
+	for _, delivery := range deliveries {
+		if delivery.WebhookID == webhookID {
+			webhookDeliveries = append(webhookDeliveries, delivery)
+		}
+	}
+
+	return latestDeliveries(webhookDeliveries, limit), nil
+}
+
+// webhooks возвращает последние версии неудалённых подписок по ID
+func (s *FileStorage) webhooks() (map[string]models.Webhook, error) {
+	webhooks := make(map[string]models.Webhook)
+
+	err := scanJSONLines(s.webhooksFilename(), func(entry webhookEntry) error {
+		if entry.Deleted {
+			delete(webhooks, entry.ID)
+			return nil
+		}
+
+		webhooks[entry.ID] = models.Webhook{
+			ID:        entry.ID,
+			UserID:    entry.UserID,
+			URL:       entry.URL,
+			Events:    entry.Events,
+			Secret:    entry.Secret,
+			CreatedAt: entry.CreatedAt,
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// deliveries возвращает последние версии всех доставок по возрастанию ID
+func (s *FileStorage) deliveries() ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	err := scanJSONLines(s.deliveriesFilename(), func(delivery models.WebhookDelivery) error {
+		// ID доставок идут подряд с 1, новая версия доставки заменяет прежнюю
+		if index := int(delivery.ID) - 1; index < len(deliveries) {
+			deliveries[index] = delivery
+		} else {
+			deliveries = append(deliveries, delivery)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// scan отдаёт все версии записей из файла, подставляя в них незаписанные результаты проверок
+func (s *FileStorage) scan(fn func(record models.ShortURLRecord) error) error {
+	s.linkChecksMu.RLock()
+	linkChecks := maps.Clone(s.linkChecks)
+	s.linkChecksMu.RUnlock()
+
+	if len(linkChecks) == 0 {
+		return scanJSONLines(s.filename, fn)
+	}
+
+	return scanJSONLines(s.filename, func(record models.ShortURLRecord) error {
+		if check, ok := linkChecks[record.ShortURL]; ok {
+			record.LinkCheck = check
+		}
+
+		return fn(record)
+	})
+}
+
+// write дописывает версии записей; записи читаются через scan, поэтому новая версия уже содержит
+// незаписанный результат проверки, если его не заменили
+func (s *FileStorage) write(records ...models.ShortURLRecord) error {
+	err := appendJSONLines(s.filename, records...)
+	if err != nil {
+		return err
+	}
+
+	s.linkChecksMu.Lock()
+	for _, record := range records {
+		delete(s.linkChecks, record.ShortURL)
+	}
+	s.linkChecksMu.Unlock()
+
+	return nil
+}
+
+func (s *FileStorage) historyFilename() string {
+	return s.filename + ".history"
+}
+
+func (s *FileStorage) clicksFilename() string {
+	return s.filename + ".clicks"
+}
+
+func (s *FileStorage) webhooksFilename() string {
+	return s.filename + ".webhooks"
+}
+
+func (s *FileStorage) deliveriesFilename() string {
+	return s.filename + ".deliveries"
+}
+
+func scanJSONLines[T any](filename string, fn func(value T) error) error {
+	file, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	for {
+		lineBytes, err := reader.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("read line bytes: %w", err)
+		}
+
+		var value T
+		err = json.Unmarshal(lineBytes, &value)
+		if err != nil {
+			return fmt.Errorf("unmarshal line: %w", err)
+		}
+
+		err = fn(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendJSONLines[T any](filename string, values ...T) error {
+	w, err := newDataWriter(filename)
+	if err != nil {
+		return fmt.Errorf("new data writer: %w", err)
+	}
+	defer w.Close()
 
-		err = w.WriteData(&record)
+	for _, value := range values {
+		err = w.WriteData(value)
 		if err != nil {
 			return fmt.Errorf("write data: %w", err)
 		}
@@ -198,8 +1077,8 @@ func newDataWriter(fileName string) (*dataWriter, error) {
 	}, nil
 }
 
-func (w *dataWriter) WriteData(record *models.ShortURLRecord) error {
-	return w.encoder.Encode(record)
+func (w *dataWriter) WriteData(data any) error {
+	return w.encoder.Encode(data)
 }
 
 func (w *dataWriter) Close() error {
@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+func TestFileStorageDelete(t *testing.T) {
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+
+	err = s.SaveBatch([]models.ShortURLRecord{
+		{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru", UserID: "user"},
+		{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com", UserID: "user"},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Delete("aaaaaaaa"))
+	require.NoError(t, s.Delete("aaaaaaaa"))
+	require.ErrorIs(t, s.Delete("zzzzzzzz"), ErrURLNotFound)
+
+	record, err := s.Get("aaaaaaaa")
+	require.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+	assert.Equal(t, "https://yandex.ru", record.OriginalURL)
+
+	_, err = s.GetByOriginal("https://yandex.ru")
+	require.ErrorIs(t, err, ErrURLNotFound)
+
+	err = s.Save(models.ShortURLRecord{ShortURL: "cccccccc", OriginalURL: "https://yandex.ru"})
+	require.NoError(t, err)
+
+	shortURL, err := s.GetByOriginal("https://yandex.ru")
+	require.NoError(t, err)
+	assert.Equal(t, "cccccccc", shortURL)
+
+	var iterated []models.ShortURLRecord
+	err = s.Iterate(context.Background(), func(record models.ShortURLRecord) error {
+		iterated = append(iterated, record)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Len(t, iterated, 3)
+	assert.Equal(t, "bbbbbbbb", iterated[0].ShortURL)
+	assert.Equal(t, "aaaaaaaa", iterated[1].ShortURL)
+	assert.True(t, iterated[1].IsDeleted)
+	assert.Equal(t, "cccccccc", iterated[2].ShortURL)
+}
+
+func TestFileStorageUpdate(t *testing.T) {
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+
+	err = s.SaveBatch([]models.ShortURLRecord{
+		{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru/typo"},
+		{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com"},
+	})
+	require.NoError(t, err)
+
+	_, err = s.Update("aaaaaaaa", "https://google.com", "editor")
+	require.ErrorIs(t, err, ErrDuplicateRecord)
+
+	_, err = s.Update("zzzzzzzz", "https://ya.ru", "editor")
+	require.ErrorIs(t, err, ErrURLNotFound)
+
+	revision, err := s.Update("aaaaaaaa", "https://yandex.ru", "editor")
+	require.NoError(t, err)
+	assert.Equal(t, 1, revision.Revision)
+
+	_, err = s.Update("aaaaaaaa", "https://ya.ru", "another editor")
+	require.NoError(t, err)
+
+	record, err := s.Get("aaaaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", record.OriginalURL)
+
+	history, err := s.History("aaaaaaaa")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "https://yandex.ru/typo", history[0].PreviousURL)
+	assert.Equal(t, "https://ya.ru", history[1].OriginalURL)
+	assert.Equal(t, "another editor", history[1].Editor)
+
+	history, err = s.History("bbbbbbbb")
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestFileStorageSetRules(t *testing.T) {
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+
+	err = s.Save(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"})
+	require.NoError(t, err)
+
+	rules := []models.RedirectRule{
+		{Device: "ios", URL: "https://apps.apple.com/app"},
+		{TimeWindow: &models.TimeWindow{From: "22:00", To: "06:00", Timezone: "Europe/Moscow"}, URL: "https://yandex.ru/night"},
+	}
+
+	require.NoError(t, s.SetRules("aaaaaaaa", rules))
+	require.ErrorIs(t, s.SetRules("zzzzzzzz", rules), ErrURLNotFound)
+
+	record, err := s.Get("aaaaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, rules, record.Rules)
+	assert.Equal(t, "https://yandex.ru", record.OriginalURL)
+
+	require.NoError(t, s.SetRules("aaaaaaaa", nil))
+
+	record, err = s.Get("aaaaaaaa")
+	require.NoError(t, err)
+	assert.Empty(t, record.Rules)
+}
+
+func TestFileStorageSetMetadata(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "urls.json")
+
+	s, err := NewFileStorage(filename)
+	require.NoError(t, err)
+
+	err = s.Save(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"})
+	require.NoError(t, err)
+
+	metadata := models.URLMetadata{
+		Title:     "Яндекс",
+		OpenGraph: map[string]string{"image": "https://yandex.ru/logo.png"},
+		FetchedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	require.NoError(t, s.SetMetadata("aaaaaaaa", metadata))
+	require.ErrorIs(t, s.SetMetadata("zzzzzzzz", metadata), ErrURLNotFound)
+	require.NoError(t, s.Close())
+
+	s, err = NewFileStorage(filename)
+	require.NoError(t, err)
+
+	record, err := s.Get("aaaaaaaa")
+	require.NoError(t, err)
+	require.NotNil(t, record.Metadata)
+	assert.Equal(t, metadata, *record.Metadata)
+}
+
+func TestFileStorageVariantClicks(t *testing.T) {
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+
+	err = s.Save(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"})
+	require.NoError(t, err)
+
+	split := &models.Split{
+		Targets: []models.SplitTarget{{URL: "https://yandex.ru/a", Weight: 1}, {URL: "https://yandex.ru/b", Weight: 3}},
+		Sticky:  true,
+	}
+	require.NoError(t, s.SetSplit("aaaaaaaa", split))
+
+	record, err := s.Get("aaaaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, split, record.Split)
+
+	for _, variant := range []string{"https://yandex.ru/a", "https://yandex.ru/b", "https://yandex.ru/b", ""} {
+		_, err = s.RecordClick("aaaaaaaa", variant)
+		require.NoError(t, err)
+	}
+
+	clicks, err := s.ClickCount("aaaaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), clicks)
+
+	variantClicks, err := s.VariantClicks("aaaaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"https://yandex.ru/a": 1, "https://yandex.ru/b": 2}, variantClicks)
+
+	_, err = s.VariantClicks("zzzzzzzz")
+	require.ErrorIs(t, err, ErrURLNotFound)
+}
+
+func TestFileStorageClickLimit(t *testing.T) {
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+
+	testClickLimit(t, s)
+}
+
+func TestFileStorageWebhookQueue(t *testing.T) {
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+
+	testWebhookQueue(t, s)
+}
+
+func TestFileStorageRestoreBatch(t *testing.T) {
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+
+	testRestoreBatch(t, s)
+}
+
+func TestFileStorageSaveBatchReport(t *testing.T) {
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+
+	testSaveBatchReport(t, s)
+}
+
+func TestFileStorageIterateExpired(t *testing.T) {
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+
+	testIterateExpired(t, s)
+}
+
+func TestFileStorageScheduleAndGetByUser(t *testing.T) {
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	err = s.SaveBatch([]models.ShortURLRecord{
+		{ShortURL: "bbbbbbbb", OriginalURL: "https://google.com", UserID: "user", CreatedAt: createdAt.Add(time.Hour)},
+		{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru", UserID: "user", CreatedAt: createdAt},
+		{ShortURL: "cccccccc", OriginalURL: "https://ya.ru", UserID: "other", CreatedAt: createdAt},
+		{ShortURL: "dddddddd", OriginalURL: "https://bing.com", UserID: "user", CreatedAt: createdAt},
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Delete("dddddddd"))
+
+	notBefore := createdAt.Add(24 * time.Hour)
+	schedule := models.Schedule{NotBefore: &notBefore, FallbackURL: "https://yandex.ru/soon"}
+
+	require.NoError(t, s.SetSchedule("aaaaaaaa", schedule))
+	require.ErrorIs(t, s.SetSchedule("zzzzzzzz", schedule), ErrURLNotFound)
+
+	records, err := s.GetByUser("user")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	assert.Equal(t, "aaaaaaaa", records[0].ShortURL)
+	assert.True(t, notBefore.Equal(*records[0].NotBefore))
+	assert.Equal(t, "https://yandex.ru/soon", records[0].FallbackURL)
+	assert.Equal(t, "bbbbbbbb", records[1].ShortURL)
+	assert.Nil(t, records[1].NotBefore)
+
+	var iterated []string
+	err = s.IterateByUser(context.Background(), "user", func(record models.ShortURLRecord) error {
+		iterated = append(iterated, record.ShortURL)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"aaaaaaaa", "bbbbbbbb"}, iterated)
+}
+
+func TestFileStorageLinkCheck(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "urls.json")
+
+	s, err := NewFileStorage(filename)
+	require.NoError(t, err)
+
+	err = s.Save(models.ShortURLRecord{ShortURL: "aaaaaaaa", OriginalURL: "https://yandex.ru"})
+	require.NoError(t, err)
+
+	check := &models.LinkCheck{
+		Status:              500,
+		Error:               "head: unexpected status 500",
+		CheckedAt:           time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		ConsecutiveFailures: 2,
+	}
+
+	require.NoError(t, s.SetLinkCheck("aaaaaaaa", check))
+	require.NoError(t, s.SetBrokenFallback("aaaaaaaa", "https://ya.ru"))
+	require.ErrorIs(t, s.SetLinkCheck("zzzzzzzz", check), ErrURLNotFound)
+	require.ErrorIs(t, s.SetBrokenFallback("zzzzzzzz", ""), ErrURLNotFound)
+	require.NoError(t, s.Close())
+
+	s, err = NewFileStorage(filename)
+	require.NoError(t, err)
+
+	record, err := s.Get("aaaaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, check, record.LinkCheck)
+	assert.Equal(t, "https://ya.ru", record.BrokenFallbackURL)
+
+	info, err := os.Stat(filename)
+	require.NoError(t, err)
+
+	recheck := *check
+	recheck.CheckedAt = check.CheckedAt.Add(time.Hour)
+	recheck.ConsecutiveFailures = 3
+	require.NoError(t, s.SetLinkCheck("aaaaaaaa", &recheck))
+
+	unchanged, err := os.Stat(filename)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), unchanged.Size(), "check result with unchanged status should not be written")
+
+	record, err = s.Get("aaaaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, &recheck, record.LinkCheck, "the latest check result should be reported")
+
+	records, err := s.GetByUser("")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, &recheck, records[0].LinkCheck)
+
+	// следующая версия записи уносит результат в памяти в файл
+	require.NoError(t, s.SetBrokenFallback("aaaaaaaa", "https://ya.ru/other"))
+
+	s, err = NewFileStorage(filename)
+	require.NoError(t, err)
+
+	record, err = s.Get("aaaaaaaa")
+	require.NoError(t, err)
+	assert.Equal(t, &recheck, record.LinkCheck)
+
+	require.NoError(t, s.SetLinkCheck("aaaaaaaa", nil))
+
+	record, err = s.Get("aaaaaaaa")
+	require.NoError(t, err)
+	assert.Nil(t, record.LinkCheck)
+}
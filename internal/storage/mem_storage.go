@@ -2,62 +2,596 @@ package storage
 
 import (
 	"context"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/pluhe7/shortener/internal/models"
 )
 
 type MemoryStorage struct {
-	ShortURLs map[string]string
+	mu      sync.RWMutex
+	records map[string]models.ShortURLRecord
+	// исходные URL неудалённых записей: исходный URL -> сокращённый URL
+	originals map[string]string
+	history   map[string][]models.URLRevision
+	clicks    map[string]int64
+	// переходы по вариантам распределения: сокращённый URL -> URL варианта -> количество
+	variantClicks map[string]map[string]int64
+
+	webhooks   map[string]models.Webhook
+	deliveries map[int64]models.WebhookDelivery
+	// события, уже поставленные в очередь подписок: ID подписки и ID события
+	queuedEvents   map[[2]string]struct{}
+	lastDeliveryID int64
 }
 
 func NewMemoryStorage() (*MemoryStorage, error) {
 	storage := MemoryStorage{
-		ShortURLs: make(map[string]string),
+		records:   make(map[string]models.ShortURLRecord),
+		originals: make(map[string]string),
+		history:   make(map[string][]models.URLRevision),
+		clicks:    make(map[string]int64),
+
+		variantClicks: make(map[string]map[string]int64),
+
+		webhooks:     make(map[string]models.Webhook),
+		deliveries:   make(map[int64]models.WebhookDelivery),
+		queuedEvents: make(map[[2]string]struct{}),
 	}
 
 	return &storage, nil
 }
 
-func (s *MemoryStorage) Get(shortURL string) (string, error) {
-	originalURL, ok := s.ShortURLs[shortURL]
+func (s *MemoryStorage) Get(shortURL string) (models.ShortURLRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.records[shortURL]
 	if !ok {
-		return "", ErrURLNotFound
+		return models.ShortURLRecord{}, ErrURLNotFound
 	}
 
-	return originalURL, nil
+	return record, nil
 }
 
 func (s *MemoryStorage) GetByOriginal(originalURL string) (string, error) {
-	var shortURL string
-
-	for short, original := range s.ShortURLs {
-		if original == originalURL {
-			shortURL = short
-			break
-		}
-	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if shortURL == "" {
+	shortURL, ok := s.originals[originalURL]
+	if !ok {
 		return "", ErrURLNotFound
 	}
 
 	return shortURL, nil
 }
 
+// indexOriginal запоминает исходный URL неудалённой записи, если он ещё не занят другой записью
+func (s *MemoryStorage) indexOriginal(record models.ShortURLRecord) {
+	if record.IsDeleted {
+		return
+	}
+
+	if _, ok := s.originals[record.OriginalURL]; !ok {
+		s.originals[record.OriginalURL] = record.ShortURL
+	}
+}
+
+// unindexOriginal забывает исходный URL записи, если он запомнен за ней
+func (s *MemoryStorage) unindexOriginal(record models.ShortURLRecord) {
+	if s.originals[record.OriginalURL] == record.ShortURL {
+		delete(s.originals, record.OriginalURL)
+	}
+}
+
 func (s *MemoryStorage) Save(record models.ShortURLRecord) error {
-	s.ShortURLs[record.ShortURL] = record.OriginalURL
+	report, err := s.SaveBatchWithReport(context.Background(), []models.ShortURLRecord{record})
+	if err != nil {
+		return err
+	}
+
+	if len(report.Conflicted) > 0 {
+		return ErrDuplicateRecord
+	}
 
 	return nil
 }
 
 func (s *MemoryStorage) SaveBatch(records []models.ShortURLRecord) error {
+	_, err := s.SaveBatchWithReport(context.Background(), records)
+	return err
+}
+
+// SaveBatchWithReport, как и база, пропускает запись, конфликтующую с существующими
+// или с уже сохранёнными записями пачки, а не заменяет ею существующую
+func (s *MemoryStorage) SaveBatchWithReport(_ context.Context, records []models.ShortURLRecord) (*models.BatchReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var report models.BatchReport
+
+	for _, record := range records {
+		_, exists := s.records[record.ShortURL]
+		_, originalTaken := s.originals[record.OriginalURL]
+
+		if exists || originalTaken {
+			report.Conflicted = append(report.Conflicted, record.ShortURL)
+			continue
+		}
+
+		record.ID = len(s.records) + 1
+		s.records[record.ShortURL] = record
+		s.indexOriginal(record)
+		s.addEvent(linkEvent(models.EventLinkCreated, record, 0))
+
+		report.Inserted = append(report.Inserted, record.ShortURL)
+	}
+
+	return &report, nil
+}
+
+func (s *MemoryStorage) RestoreBatch(links []models.LinkSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, link := range links {
+		record := link.Record
+		if _, ok := s.records[record.ShortURL]; ok {
+			continue
+		}
+
+		record.ID = len(s.records) + 1
+		s.records[record.ShortURL] = record
+		s.indexOriginal(record)
+
+		if link.Clicks > 0 {
+			s.clicks[record.ShortURL] = link.Clicks
+		}
+
+		if len(link.VariantClicks) > 0 {
+			s.variantClicks[record.ShortURL] = make(map[string]int64, len(link.VariantClicks))
+			for variant, clicks := range link.VariantClicks {
+				s.variantClicks[record.ShortURL][variant] = clicks
+			}
+		}
+
+		if len(link.History) > 0 {
+			s.history[record.ShortURL] = append([]models.URLRevision(nil), link.History...)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) Delete(shortURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[shortURL]
+	if !ok {
+		return ErrURLNotFound
+	}
+
+	if !record.IsDeleted {
+		s.addEvent(linkEvent(models.EventLinkDeleted, record, 0))
+	}
+
+	s.unindexOriginal(record)
+
+	record.IsDeleted = true
+	s.records[shortURL] = record
+
+	return nil
+}
+
+func (s *MemoryStorage) SetRules(shortURL string, rules []models.RedirectRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[shortURL]
+	if !ok {
+		return ErrURLNotFound
+	}
+
+	record.Rules = rules
+	s.records[shortURL] = record
+
+	return nil
+}
+
+func (s *MemoryStorage) SetMetadata(shortURL string, metadata models.URLMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[shortURL]
+	if !ok {
+		return ErrURLNotFound
+	}
+
+	record.Metadata = &metadata
+	s.records[shortURL] = record
+
+	return nil
+}
+
+func (s *MemoryStorage) SetLinkCheck(shortURL string, check *models.LinkCheck) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[shortURL]
+	if !ok {
+		return ErrURLNotFound
+	}
+
+	record.LinkCheck = check
+	s.records[shortURL] = record
+
+	return nil
+}
+
+func (s *MemoryStorage) SetBrokenFallback(shortURL, fallbackURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[shortURL]
+	if !ok {
+		return ErrURLNotFound
+	}
+
+	record.BrokenFallbackURL = fallbackURL
+	s.records[shortURL] = record
+
+	return nil
+}
+
+func (s *MemoryStorage) Update(shortURL, originalURL, editor string) (models.URLRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[shortURL]
+	if !ok {
+		return models.URLRevision{}, ErrURLNotFound
+	}
+
+	if other, ok := s.originals[originalURL]; ok && other != shortURL {
+		return models.URLRevision{}, ErrDuplicateRecord
+	}
+
+	revision := models.URLRevision{
+		ShortURL:    shortURL,
+		Revision:    len(s.history[shortURL]) + 1,
+		OriginalURL: originalURL,
+		PreviousURL: record.OriginalURL,
+		Editor:      editor,
+		ChangedAt:   time.Now(),
+	}
+
+	s.unindexOriginal(record)
+
+	record.OriginalURL = originalURL
+	s.records[shortURL] = record
+	s.indexOriginal(record)
+	s.history[shortURL] = append(s.history[shortURL], revision)
+	s.addEvent(linkEvent(models.EventLinkUpdated, record, revision.Revision))
+
+	return revision, nil
+}
+
+func (s *MemoryStorage) History(shortURL string) ([]models.URLRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.records[shortURL]; !ok {
+		return nil, ErrURLNotFound
+	}
+
+	return append([]models.URLRevision(nil), s.history[shortURL]...), nil
+}
+
+func (s *MemoryStorage) GetByUser(userID string) ([]models.ShortURLRecord, error) {
+	s.mu.RLock()
+	var records []models.ShortURLRecord
+	for _, record := range s.records {
+		if record.UserID == userID && !record.IsDeleted {
+			records = append(records, record)
+		}
+	}
+	s.mu.RUnlock()
+
+	sortByCreation(records)
+
+	return records, nil
+}
+
+func (s *MemoryStorage) SetSchedule(shortURL string, schedule models.Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[shortURL]
+	if !ok {
+		return ErrURLNotFound
+	}
+
+	record.Schedule = schedule
+	s.records[shortURL] = record
+
+	return nil
+}
+
+func (s *MemoryStorage) SetSplit(shortURL string, split *models.Split) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[shortURL]
+	if !ok {
+		return ErrURLNotFound
+	}
+
+	record.Split = split
+	s.records[shortURL] = record
+
+	return nil
+}
+
+func (s *MemoryStorage) RecordClick(shortURL, variant string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[shortURL]
+	if !ok {
+		return 0, ErrURLNotFound
+	}
+
+	if record.MaxClicks > 0 && s.clicks[shortURL] >= int64(record.MaxClicks) {
+		return 0, ErrClickLimitReached
+	}
+
+	s.clicks[shortURL]++
+
+	if variant != "" {
+		if s.variantClicks[shortURL] == nil {
+			s.variantClicks[shortURL] = make(map[string]int64)
+		}
+		s.variantClicks[shortURL][variant]++
+	}
+
+	return s.clicks[shortURL], nil
+}
+
+func (s *MemoryStorage) ClickCount(shortURL string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.records[shortURL]; !ok {
+		return 0, ErrURLNotFound
+	}
+
+	return s.clicks[shortURL], nil
+}
+
+func (s *MemoryStorage) VariantClicks(shortURL string) (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.records[shortURL]; !ok {
+		return nil, ErrURLNotFound
+	}
+
+	clicks := make(map[string]int64, len(s.variantClicks[shortURL]))
+	for variant, count := range s.variantClicks[shortURL] {
+		clicks[variant] = count
+	}
+
+	return clicks, nil
+}
+
+func (s *MemoryStorage) IterateByUser(ctx context.Context, userID string, fn func(record models.ShortURLRecord) error) error {
+	records, err := s.GetByUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err = fn(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) IterateExpired(ctx context.Context, from, to time.Time, fn func(record models.ShortURLRecord) error) error {
+	s.mu.RLock()
+	var records []models.ShortURLRecord
+	for _, record := range s.records {
+		if isExpiredBetween(record, from, to) {
+			records = append(records, record)
+		}
+	}
+	s.mu.RUnlock()
+
+	sortByNotAfter(records)
+
 	for _, record := range records {
-		s.ShortURLs[record.ShortURL] = record.OriginalURL
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := fn(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) Iterate(ctx context.Context, fn func(record models.ShortURLRecord) error) error {
+	s.mu.RLock()
+	records := make([]models.ShortURLRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ShortURL < records[j].ShortURL
+	})
+
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := fn(record)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+func (s *MemoryStorage) CreateWebhook(webhook models.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.webhooks[webhook.ID] = webhook
+
+	return nil
+}
+
+func (s *MemoryStorage) GetWebhooks(userID string) ([]models.Webhook, error) {
+	s.mu.RLock()
+	var webhooks []models.Webhook
+	for _, webhook := range s.webhooks {
+		if webhook.UserID == userID {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	s.mu.RUnlock()
+
+	sortWebhooks(webhooks)
+
+	return webhooks, nil
+}
+
+func (s *MemoryStorage) DeleteWebhook(id, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok || webhook.UserID != userID {
+		return ErrWebhookNotFound
+	}
+
+	delete(s.webhooks, id)
+
+	for deliveryID, delivery := range s.deliveries {
+		if delivery.WebhookID == id {
+			delete(s.deliveries, deliveryID)
+		}
+	}
+
+	for key := range s.queuedEvents {
+		if key[0] == id {
+			delete(s.queuedEvents, key)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) AddEvent(event models.WebhookEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addEvent(event)
+
+	return nil
+}
+
+// addEvent ставит событие в очередь подписок, вызывается под s.mu
+func (s *MemoryStorage) addEvent(event models.WebhookEvent) {
+	if event.UserID == "" {
+		return
+	}
+
+	for _, webhook := range s.webhooks {
+		if !subscribed(webhook, event.UserID, event.Type) {
+			continue
+		}
+
+		key := [2]string{webhook.ID, event.ID}
+		if _, ok := s.queuedEvents[key]; ok {
+			continue
+		}
+
+		s.queuedEvents[key] = struct{}{}
+		s.lastDeliveryID++
+		s.deliveries[s.lastDeliveryID] = newDelivery(s.lastDeliveryID, webhook.ID, event)
+	}
+}
+
+func (s *MemoryStorage) ClaimDeliveries(lease time.Duration, limit int) ([]models.WebhookTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := make([]models.WebhookDelivery, 0, len(s.deliveries))
+	for _, delivery := range s.deliveries {
+		deliveries = append(deliveries, delivery)
+	}
+
+	now := time.Now()
+	due := dueDeliveries(deliveries, now, limit)
+
+	tasks := make([]models.WebhookTask, 0, len(due))
+	for _, delivery := range due {
+		delivery.NextAttemptAt = now.Add(lease)
+		s.deliveries[delivery.ID] = delivery
+
+		tasks = append(tasks, models.WebhookTask{Webhook: s.webhooks[delivery.WebhookID], Delivery: delivery})
+	}
+
+	return tasks, nil
+}
+
+func (s *MemoryStorage) UpdateDelivery(delivery models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.deliveries[delivery.ID]; ok {
+		s.deliveries[delivery.ID] = delivery
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) GetDeliveries(webhookID, userID string, limit int) ([]models.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhook, ok := s.webhooks[webhookID]
+	if !ok || webhook.UserID != userID {
+		return nil, ErrWebhookNotFound
+	}
+
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range s.deliveries {
+		if delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	return latestDeliveries(deliveries, limit), nil
+}
+
 func (s *MemoryStorage) Close() error {
 	return nil
 }
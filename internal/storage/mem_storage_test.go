@@ -0,0 +1,386 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/util"
+)
+
+// testClickLimit проверяет, что параллельные переходы по ссылке с лимитом не превышают его
+func testClickLimit(t *testing.T, s Storage) {
+	const maxClicks = 5
+	const attempts = 50
+
+	record := newTestRecords(1)[0]
+	record.MaxClicks = maxClicks
+
+	err := s.Save(record)
+	require.NoError(t, err)
+
+	var recorded, limited atomic.Int64
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	counts := make([]int64, 0, maxClicks)
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			count, err := s.RecordClick(record.ShortURL, "")
+			switch {
+			case err == nil:
+				recorded.Add(1)
+
+				mu.Lock()
+				counts = append(counts, count)
+				mu.Unlock()
+			case assert.ErrorIs(t, err, ErrClickLimitReached):
+				limited.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, int64(maxClicks), recorded.Load())
+	assert.Equal(t, int64(attempts-maxClicks), limited.Load())
+
+	// каждый учтённый переход получает своё количество, последний - равное лимиту
+	expectedCounts := make([]int64, 0, maxClicks)
+	for i := 1; i <= maxClicks; i++ {
+		expectedCounts = append(expectedCounts, int64(i))
+	}
+	assert.ElementsMatch(t, expectedCounts, counts)
+
+	clicks, err := s.ClickCount(record.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, int64(maxClicks), clicks)
+
+	_, err = s.RecordClick("zzzzzzzz", "")
+	require.ErrorIs(t, err, ErrURLNotFound)
+}
+
+func TestMemoryStorageClickLimit(t *testing.T) {
+	s, err := NewMemoryStorage()
+	require.NoError(t, err)
+
+	testClickLimit(t, s)
+}
+
+// testWebhookQueue проверяет, что изменения записей ставят события в очередь подходящих подписок ровно один раз
+func testWebhookQueue(t *testing.T, s Storage) {
+	prefix := util.GetRandomString(idLenForTests)
+	userID := "user-" + prefix
+	createdAt := time.Now().UTC().Truncate(time.Second)
+
+	all := models.Webhook{ID: prefix + "-all", UserID: userID, URL: "https://example.com/all", Secret: "secret", CreatedAt: createdAt}
+	deleted := models.Webhook{ID: prefix + "-deleted", UserID: userID, URL: "https://example.com/deleted",
+		Events: []string{models.EventLinkDeleted}, Secret: "secret", CreatedAt: createdAt.Add(time.Second)}
+	other := models.Webhook{ID: prefix + "-other", UserID: "other-" + prefix, URL: "https://example.com/other",
+		Secret: "secret", CreatedAt: createdAt}
+
+	for _, webhook := range []models.Webhook{all, deleted, other} {
+		require.NoError(t, s.CreateWebhook(webhook))
+	}
+
+	records := newTestRecords(2)
+	records[0].UserID = userID
+
+	require.NoError(t, s.Save(records[0]))
+	require.NoError(t, s.Save(records[1]))
+
+	_, err := s.Update(records[0].ShortURL, records[0].OriginalURL+"/updated", userID)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Delete(records[0].ShortURL))
+	require.NoError(t, s.Delete(records[0].ShortURL))
+
+	expired := models.WebhookEvent{ID: "expired-" + prefix, Type: models.EventLinkExpired, LinkID: records[0].ShortURL,
+		UserID: userID, OccurredAt: createdAt}
+	require.NoError(t, s.AddEvent(expired))
+	require.NoError(t, s.AddEvent(expired))
+
+	webhooks, err := s.GetWebhooks(userID)
+	require.NoError(t, err)
+	require.Len(t, webhooks, 2)
+	assert.Equal(t, all.ID, webhooks[0].ID)
+	assert.Equal(t, []string{models.EventLinkDeleted}, webhooks[1].Events)
+
+	deliveries, err := s.GetDeliveries(all.ID, userID, 10)
+	require.NoError(t, err)
+
+	var types []string
+	for _, delivery := range deliveries {
+		types = append(types, delivery.Event.Type)
+		assert.Equal(t, models.DeliveryPending, delivery.Status)
+	}
+	assert.Equal(t, []string{models.EventLinkExpired, models.EventLinkDeleted, models.EventLinkUpdated, models.EventLinkCreated}, types)
+	assert.Equal(t, records[0].OriginalURL+"/updated", deliveries[2].Event.OriginalURL)
+
+	deliveries, err = s.GetDeliveries(deleted.ID, userID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, models.EventLinkDeleted, deliveries[0].Event.Type)
+
+	deliveries, err = s.GetDeliveries(other.ID, other.UserID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	_, err = s.GetDeliveries(all.ID, other.UserID, 10)
+	require.ErrorIs(t, err, ErrWebhookNotFound)
+
+	claimed := func() []models.WebhookTask {
+		tasks, err := s.ClaimDeliveries(time.Minute, 1000)
+		require.NoError(t, err)
+
+		var own []models.WebhookTask
+		for _, task := range tasks {
+			if task.Webhook.UserID == userID {
+				own = append(own, task)
+			}
+		}
+
+		return own
+	}
+
+	tasks := claimed()
+	require.Len(t, tasks, 5)
+	assert.Empty(t, claimed())
+
+	delivery := tasks[0].Delivery
+	delivery.Status = models.DeliveryDelivered
+	delivery.Attempts = 1
+	delivery.ResponseStatus = 204
+	require.NoError(t, s.UpdateDelivery(delivery))
+
+	deliveries, err = s.GetDeliveries(delivery.WebhookID, userID, 10)
+	require.NoError(t, err)
+
+	var updated bool
+	for _, stored := range deliveries {
+		if stored.ID == delivery.ID {
+			updated = true
+			assert.Equal(t, models.DeliveryDelivered, stored.Status)
+			assert.Equal(t, 204, stored.ResponseStatus)
+		}
+	}
+	assert.True(t, updated)
+
+	require.ErrorIs(t, s.DeleteWebhook(all.ID, other.UserID), ErrWebhookNotFound)
+	require.NoError(t, s.DeleteWebhook(all.ID, userID))
+
+	_, err = s.GetDeliveries(all.ID, userID, 10)
+	require.ErrorIs(t, err, ErrWebhookNotFound)
+
+	webhooks, err = s.GetWebhooks(userID)
+	require.NoError(t, err)
+	assert.Len(t, webhooks, 1)
+}
+
+func TestMemoryStorageWebhookQueue(t *testing.T) {
+	s, err := NewMemoryStorage()
+	require.NoError(t, err)
+
+	testWebhookQueue(t, s)
+}
+
+// testRestoreBatch проверяет, что перенесённые ссылки сохраняются с переходами и историей, без событий,
+// а ссылки, конфликтующие с существующими, пропускаются
+func testRestoreBatch(t *testing.T, s Storage) {
+	records := newTestRecords(2)
+	for i := range records {
+		records[i].UserID = "restore-user"
+	}
+
+	require.NoError(t, s.CreateWebhook(models.Webhook{ID: util.GetRandomString(16), UserID: "restore-user", URL: "https://example.com/hook"}))
+
+	existing := records[1]
+	existing.OriginalURL += "/existing"
+	require.NoError(t, s.Save(existing))
+
+	_, err := s.ClaimDeliveries(time.Minute, 100)
+	require.NoError(t, err)
+
+	changedAt := time.Now().UTC().Truncate(time.Microsecond)
+
+	err = s.RestoreBatch([]models.LinkSnapshot{
+		{
+			Record:        records[0],
+			Clicks:        5,
+			VariantClicks: map[string]int64{"https://example.com/a": 2},
+			History: []models.URLRevision{{
+				ShortURL:    records[0].ShortURL,
+				Revision:    1,
+				OriginalURL: records[0].OriginalURL,
+				PreviousURL: "https://example.com/old",
+				Editor:      "restore-user",
+				ChangedAt:   changedAt,
+			}},
+		},
+		{Record: records[1], Clicks: 3},
+	})
+	require.NoError(t, err)
+
+	clicks, err := s.ClickCount(records[0].ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), clicks)
+
+	variantClicks, err := s.VariantClicks(records[0].ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"https://example.com/a": 2}, variantClicks)
+
+	history, err := s.History(records[0].ShortURL)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, "https://example.com/old", history[0].PreviousURL)
+	assert.True(t, changedAt.Equal(history[0].ChangedAt))
+
+	clicks, err = s.RecordClick(records[0].ShortURL, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), clicks, "restored clicks should be counted")
+	clicks, err = s.ClickCount(records[0].ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), clicks)
+
+	record, err := s.Get(records[1].ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, existing.OriginalURL, record.OriginalURL, "conflicting link should be skipped")
+
+	clicks, err = s.ClickCount(records[1].ShortURL)
+	require.NoError(t, err)
+	assert.Zero(t, clicks)
+
+	tasks, err := s.ClaimDeliveries(time.Minute, 100)
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+}
+
+func TestMemoryStorageRestoreBatch(t *testing.T) {
+	s, err := NewMemoryStorage()
+	require.NoError(t, err)
+
+	testRestoreBatch(t, s)
+}
+
+// testSaveBatchReport проверяет, что запись, конфликтующая с существующей или с предыдущей записью пачки,
+// не заменяет её, а попадает в Conflicted; исходный URL удалённой или изменённой записи сокращается снова
+func testSaveBatchReport(t *testing.T, s Storage) {
+	records := newTestRecords(5)
+
+	require.NoError(t, s.Save(records[0]))
+	require.NoError(t, s.Save(records[1]))
+	require.NoError(t, s.Delete(records[1].ShortURL))
+
+	takenShort := records[2]
+	takenShort.ShortURL = records[0].ShortURL
+
+	takenOriginal := records[3]
+	takenOriginal.OriginalURL = records[0].OriginalURL
+
+	reusedOriginal := records[4]
+	reusedOriginal.OriginalURL = records[1].OriginalURL
+
+	sameBatch := newTestRecords(1)[0]
+	sameBatch.OriginalURL = reusedOriginal.OriginalURL
+
+	report, err := s.SaveBatchWithReport(context.Background(),
+		[]models.ShortURLRecord{takenShort, takenOriginal, reusedOriginal, sameBatch})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{reusedOriginal.ShortURL}, report.Inserted)
+	assert.ElementsMatch(t, []string{takenShort.ShortURL, takenOriginal.ShortURL, sameBatch.ShortURL}, report.Conflicted)
+
+	record, err := s.Get(records[0].ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, records[0].OriginalURL, record.OriginalURL, "conflicting record should not replace existing")
+
+	_, err = s.Get(takenOriginal.ShortURL)
+	require.ErrorIs(t, err, ErrURLNotFound)
+
+	require.ErrorIs(t, s.Save(takenOriginal), ErrDuplicateRecord)
+	require.ErrorIs(t, s.Save(takenShort), ErrDuplicateRecord)
+
+	updatedURL := records[0].OriginalURL + "/updated"
+	_, err = s.Update(records[0].ShortURL, updatedURL, "")
+	require.NoError(t, err)
+
+	shortURL, err := s.GetByOriginal(updatedURL)
+	require.NoError(t, err)
+	assert.Equal(t, records[0].ShortURL, shortURL)
+
+	_, err = s.GetByOriginal(records[0].OriginalURL)
+	require.ErrorIs(t, err, ErrURLNotFound)
+
+	require.NoError(t, s.Save(takenOriginal), "original url should be free after update")
+
+	takenUpdated := newTestRecords(1)[0]
+	takenUpdated.OriginalURL = updatedURL
+	require.ErrorIs(t, s.Save(takenUpdated), ErrDuplicateRecord)
+}
+
+func TestMemoryStorageSaveBatchReport(t *testing.T) {
+	s, err := NewMemoryStorage()
+	require.NoError(t, err)
+
+	testSaveBatchReport(t, s)
+}
+
+// testIterateExpired проверяет, что IterateExpired отдаёт по порядку окончания окна только неудалённые
+// записи пользователей, окно которых закончилось в заданном промежутке, включая его границы
+func testIterateExpired(t *testing.T, s Storage) {
+	records := newTestRecords(6)
+	to := time.Now().UTC().Truncate(time.Second)
+	from := to.Add(-time.Hour)
+
+	notAfter := []time.Time{to, from, from.Add(time.Minute), from.Add(-time.Second), to.Add(time.Second), from.Add(time.Minute)}
+	for i := range records {
+		records[i].UserID = "expiry-user"
+		records[i].NotAfter = &notAfter[i]
+	}
+	records[5].UserID = ""
+
+	deleted := newTestRecords(1)[0]
+	deleted.UserID = "expiry-user"
+	deleted.NotAfter = &notAfter[2]
+
+	unbounded := newTestRecords(1)[0]
+	unbounded.UserID = "expiry-user"
+
+	for _, record := range append(records, deleted, unbounded) {
+		require.NoError(t, s.Save(record))
+	}
+	require.NoError(t, s.Delete(deleted.ShortURL))
+
+	wanted := make(map[string]bool)
+	for _, record := range append(records, deleted, unbounded) {
+		wanted[record.ShortURL] = true
+	}
+
+	var got []string
+	err := s.IterateExpired(context.Background(), from, to, func(record models.ShortURLRecord) error {
+		if wanted[record.ShortURL] {
+			got = append(got, record.ShortURL)
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{records[1].ShortURL, records[2].ShortURL, records[0].ShortURL}, got)
+}
+
+func TestMemoryStorageIterateExpired(t *testing.T) {
+	s, err := NewMemoryStorage()
+	require.NoError(t, err)
+
+	testIterateExpired(t, s)
+}
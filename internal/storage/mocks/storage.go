@@ -7,6 +7,7 @@ package mocks
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	models "github.com/pluhe7/shortener/internal/models"
@@ -35,6 +36,50 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
+// AddEvent mocks base method.
+func (m *MockStorage) AddEvent(event models.WebhookEvent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddEvent", event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddEvent indicates an expected call of AddEvent.
+func (mr *MockStorageMockRecorder) AddEvent(event interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddEvent", reflect.TypeOf((*MockStorage)(nil).AddEvent), event)
+}
+
+// ClaimDeliveries mocks base method.
+func (m *MockStorage) ClaimDeliveries(lease time.Duration, limit int) ([]models.WebhookTask, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimDeliveries", lease, limit)
+	ret0, _ := ret[0].([]models.WebhookTask)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimDeliveries indicates an expected call of ClaimDeliveries.
+func (mr *MockStorageMockRecorder) ClaimDeliveries(lease, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimDeliveries", reflect.TypeOf((*MockStorage)(nil).ClaimDeliveries), lease, limit)
+}
+
+// ClickCount mocks base method.
+func (m *MockStorage) ClickCount(shortURL string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClickCount", shortURL)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClickCount indicates an expected call of ClickCount.
+func (mr *MockStorageMockRecorder) ClickCount(shortURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClickCount", reflect.TypeOf((*MockStorage)(nil).ClickCount), shortURL)
+}
+
 // Close mocks base method.
 func (m *MockStorage) Close() error {
 	m.ctrl.T.Helper()
@@ -49,11 +94,53 @@ func (mr *MockStorageMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStorage)(nil).Close))
 }
 
+// CreateWebhook mocks base method.
+func (m *MockStorage) CreateWebhook(webhook models.Webhook) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateWebhook", webhook)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateWebhook indicates an expected call of CreateWebhook.
+func (mr *MockStorageMockRecorder) CreateWebhook(webhook interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateWebhook", reflect.TypeOf((*MockStorage)(nil).CreateWebhook), webhook)
+}
+
+// Delete mocks base method.
+func (m *MockStorage) Delete(shortURL string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", shortURL)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockStorageMockRecorder) Delete(shortURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockStorage)(nil).Delete), shortURL)
+}
+
+// DeleteWebhook mocks base method.
+func (m *MockStorage) DeleteWebhook(id, userID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteWebhook", id, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteWebhook indicates an expected call of DeleteWebhook.
+func (mr *MockStorageMockRecorder) DeleteWebhook(id, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWebhook", reflect.TypeOf((*MockStorage)(nil).DeleteWebhook), id, userID)
+}
+
 // Get mocks base method.
-func (m *MockStorage) Get(shortURL string) (string, error) {
+func (m *MockStorage) Get(shortURL string) (models.ShortURLRecord, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Get", shortURL)
-	ret0, _ := ret[0].(string)
+	ret0, _ := ret[0].(models.ShortURLRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -79,6 +166,108 @@ func (mr *MockStorageMockRecorder) GetByOriginal(originalURL interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByOriginal", reflect.TypeOf((*MockStorage)(nil).GetByOriginal), originalURL)
 }
 
+// GetByUser mocks base method.
+func (m *MockStorage) GetByUser(userID string) ([]models.ShortURLRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByUser", userID)
+	ret0, _ := ret[0].([]models.ShortURLRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByUser indicates an expected call of GetByUser.
+func (mr *MockStorageMockRecorder) GetByUser(userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByUser", reflect.TypeOf((*MockStorage)(nil).GetByUser), userID)
+}
+
+// GetDeliveries mocks base method.
+func (m *MockStorage) GetDeliveries(webhookID, userID string, limit int) ([]models.WebhookDelivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeliveries", webhookID, userID, limit)
+	ret0, _ := ret[0].([]models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeliveries indicates an expected call of GetDeliveries.
+func (mr *MockStorageMockRecorder) GetDeliveries(webhookID, userID, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeliveries", reflect.TypeOf((*MockStorage)(nil).GetDeliveries), webhookID, userID, limit)
+}
+
+// GetWebhooks mocks base method.
+func (m *MockStorage) GetWebhooks(userID string) ([]models.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhooks", userID)
+	ret0, _ := ret[0].([]models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhooks indicates an expected call of GetWebhooks.
+func (mr *MockStorageMockRecorder) GetWebhooks(userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhooks", reflect.TypeOf((*MockStorage)(nil).GetWebhooks), userID)
+}
+
+// History mocks base method.
+func (m *MockStorage) History(shortURL string) ([]models.URLRevision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "History", shortURL)
+	ret0, _ := ret[0].([]models.URLRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// History indicates an expected call of History.
+func (mr *MockStorageMockRecorder) History(shortURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "History", reflect.TypeOf((*MockStorage)(nil).History), shortURL)
+}
+
+// Iterate mocks base method.
+func (m *MockStorage) Iterate(ctx context.Context, fn func(models.ShortURLRecord) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Iterate", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Iterate indicates an expected call of Iterate.
+func (mr *MockStorageMockRecorder) Iterate(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Iterate", reflect.TypeOf((*MockStorage)(nil).Iterate), ctx, fn)
+}
+
+// IterateByUser mocks base method.
+func (m *MockStorage) IterateByUser(ctx context.Context, userID string, fn func(models.ShortURLRecord) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IterateByUser", ctx, userID, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// IterateByUser indicates an expected call of IterateByUser.
+func (mr *MockStorageMockRecorder) IterateByUser(ctx, userID, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IterateByUser", reflect.TypeOf((*MockStorage)(nil).IterateByUser), ctx, userID, fn)
+}
+
+// IterateExpired mocks base method.
+func (m *MockStorage) IterateExpired(ctx context.Context, from, to time.Time, fn func(models.ShortURLRecord) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IterateExpired", ctx, from, to, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// IterateExpired indicates an expected call of IterateExpired.
+func (mr *MockStorageMockRecorder) IterateExpired(ctx, from, to, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IterateExpired", reflect.TypeOf((*MockStorage)(nil).IterateExpired), ctx, from, to, fn)
+}
+
 // PingContext mocks base method.
 func (m *MockStorage) PingContext(ctx context.Context) error {
 	m.ctrl.T.Helper()
@@ -93,6 +282,35 @@ func (mr *MockStorageMockRecorder) PingContext(ctx interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PingContext", reflect.TypeOf((*MockStorage)(nil).PingContext), ctx)
 }
 
+// RecordClick mocks base method.
+func (m *MockStorage) RecordClick(shortURL, variant string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordClick", shortURL, variant)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecordClick indicates an expected call of RecordClick.
+func (mr *MockStorageMockRecorder) RecordClick(shortURL, variant interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordClick", reflect.TypeOf((*MockStorage)(nil).RecordClick), shortURL, variant)
+}
+
+// RestoreBatch mocks base method.
+func (m *MockStorage) RestoreBatch(links []models.LinkSnapshot) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreBatch", links)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreBatch indicates an expected call of RestoreBatch.
+func (mr *MockStorageMockRecorder) RestoreBatch(links interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreBatch", reflect.TypeOf((*MockStorage)(nil).RestoreBatch), links)
+}
+
 // Save mocks base method.
 func (m *MockStorage) Save(record models.ShortURLRecord) error {
 	m.ctrl.T.Helper()
@@ -120,3 +338,146 @@ func (mr *MockStorageMockRecorder) SaveBatch(records interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBatch", reflect.TypeOf((*MockStorage)(nil).SaveBatch), records)
 }
+
+// SaveBatchWithReport mocks base method.
+func (m *MockStorage) SaveBatchWithReport(ctx context.Context, records []models.ShortURLRecord) (*models.BatchReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveBatchWithReport", ctx, records)
+	ret0, _ := ret[0].(*models.BatchReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveBatchWithReport indicates an expected call of SaveBatchWithReport.
+func (mr *MockStorageMockRecorder) SaveBatchWithReport(ctx, records interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBatchWithReport", reflect.TypeOf((*MockStorage)(nil).SaveBatchWithReport), ctx, records)
+}
+
+// SetBrokenFallback mocks base method.
+func (m *MockStorage) SetBrokenFallback(shortURL, fallbackURL string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetBrokenFallback", shortURL, fallbackURL)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetBrokenFallback indicates an expected call of SetBrokenFallback.
+func (mr *MockStorageMockRecorder) SetBrokenFallback(shortURL, fallbackURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBrokenFallback", reflect.TypeOf((*MockStorage)(nil).SetBrokenFallback), shortURL, fallbackURL)
+}
+
+// SetLinkCheck mocks base method.
+func (m *MockStorage) SetLinkCheck(shortURL string, check *models.LinkCheck) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLinkCheck", shortURL, check)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLinkCheck indicates an expected call of SetLinkCheck.
+func (mr *MockStorageMockRecorder) SetLinkCheck(shortURL, check interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLinkCheck", reflect.TypeOf((*MockStorage)(nil).SetLinkCheck), shortURL, check)
+}
+
+// SetMetadata mocks base method.
+func (m *MockStorage) SetMetadata(shortURL string, metadata models.URLMetadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetMetadata", shortURL, metadata)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMetadata indicates an expected call of SetMetadata.
+func (mr *MockStorageMockRecorder) SetMetadata(shortURL, metadata interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMetadata", reflect.TypeOf((*MockStorage)(nil).SetMetadata), shortURL, metadata)
+}
+
+// SetRules mocks base method.
+func (m *MockStorage) SetRules(shortURL string, rules []models.RedirectRule) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetRules", shortURL, rules)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRules indicates an expected call of SetRules.
+func (mr *MockStorageMockRecorder) SetRules(shortURL, rules interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRules", reflect.TypeOf((*MockStorage)(nil).SetRules), shortURL, rules)
+}
+
+// SetSchedule mocks base method.
+func (m *MockStorage) SetSchedule(shortURL string, schedule models.Schedule) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetSchedule", shortURL, schedule)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetSchedule indicates an expected call of SetSchedule.
+func (mr *MockStorageMockRecorder) SetSchedule(shortURL, schedule interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSchedule", reflect.TypeOf((*MockStorage)(nil).SetSchedule), shortURL, schedule)
+}
+
+// SetSplit mocks base method.
+func (m *MockStorage) SetSplit(shortURL string, split *models.Split) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetSplit", shortURL, split)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetSplit indicates an expected call of SetSplit.
+func (mr *MockStorageMockRecorder) SetSplit(shortURL, split interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSplit", reflect.TypeOf((*MockStorage)(nil).SetSplit), shortURL, split)
+}
+
+// Update mocks base method.
+func (m *MockStorage) Update(shortURL, originalURL, editor string) (models.URLRevision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", shortURL, originalURL, editor)
+	ret0, _ := ret[0].(models.URLRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockStorageMockRecorder) Update(shortURL, originalURL, editor interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockStorage)(nil).Update), shortURL, originalURL, editor)
+}
+
+// UpdateDelivery mocks base method.
+func (m *MockStorage) UpdateDelivery(delivery models.WebhookDelivery) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateDelivery", delivery)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateDelivery indicates an expected call of UpdateDelivery.
+func (mr *MockStorageMockRecorder) UpdateDelivery(delivery interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDelivery", reflect.TypeOf((*MockStorage)(nil).UpdateDelivery), delivery)
+}
+
+// VariantClicks mocks base method.
+func (m *MockStorage) VariantClicks(shortURL string) (map[string]int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VariantClicks", shortURL)
+	ret0, _ := ret[0].(map[string]int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VariantClicks indicates an expected call of VariantClicks.
+func (mr *MockStorageMockRecorder) VariantClicks(shortURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VariantClicks", reflect.TypeOf((*MockStorage)(nil).VariantClicks), shortURL)
+}
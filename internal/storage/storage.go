@@ -4,27 +4,140 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/pluhe7/shortener/internal/models"
 )
 
-var ErrURLNotFound = errors.New("url does not exist")
+var (
+	ErrURLNotFound       = errors.New("url does not exist")
+	ErrClickLimitReached = errors.New("url click limit reached")
+	ErrWebhookNotFound   = errors.New("webhook does not exist")
+)
 
 type Storage interface {
-	Get(shortURL string) (string, error)
+	// Get возвращает запись, в том числе удалённую - с IsDeleted
+	Get(shortURL string) (models.ShortURLRecord, error)
+	// GetByUser возвращает неудалённые записи пользователя по возрастанию времени создания
+	GetByUser(userID string) ([]models.ShortURLRecord, error)
+	// GetByOriginal ищет сокращённый URL только среди неудалённых записей
 	GetByOriginal(originalURL string) (string, error)
+	// Save и SaveBatch вместе с записью ставят в очередь доставки событие её создания.
+	// Запись, сокращённый URL которой занят или исходный URL которой уже есть у неудалённой записи,
+	// не сохраняется: Save возвращает ErrDuplicateRecord, SaveBatch пропускает её.
 	Save(record models.ShortURLRecord) error
 	SaveBatch(records []models.ShortURLRecord) error
+	// SaveBatchWithReport сохраняет пачку как SaveBatch и сообщает, какие записи пропущены из-за конфликта
+	SaveBatchWithReport(ctx context.Context, records []models.ShortURLRecord) (*models.BatchReport, error)
+	// RestoreBatch сохраняет ссылки, перенесённые из другого хранилища, вместе с переходами и историей,
+	// не ставя событий в очередь доставки: ссылки не создаются заново, а переезжают.
+	// Ссылки, конфликтующие с существующими записями, пропускаются.
+	RestoreBatch(links []models.LinkSnapshot) error
+	// Delete помечает запись удалённой, сама запись остаётся, чтобы сокращённый URL не был выдан повторно;
+	// вместе с пометкой в очередь доставки ставится событие удаления
+	Delete(shortURL string) error
+	// Update меняет исходный URL записи и добавляет изменение в историю и событие изменения в очередь доставки;
+	// ErrDuplicateRecord, если такой исходный URL уже есть у другой неудалённой записи
+	Update(shortURL, originalURL, editor string) (models.URLRevision, error)
+	// SetRules заменяет правила перехода записи
+	SetRules(shortURL string, rules []models.RedirectRule) error
+	// History возвращает изменения исходного URL записи по возрастанию номера ревизии
+	History(shortURL string) ([]models.URLRevision, error)
+	// SetSchedule заменяет окно работы записи
+	SetSchedule(shortURL string, schedule models.Schedule) error
+	// SetSplit заменяет распределение переходов записи по вариантам, nil - убирает его
+	SetSplit(shortURL string, split *models.Split) error
+	// SetMetadata сохраняет сведения о странице исходного URL записи
+	SetMetadata(shortURL string, metadata models.URLMetadata) error
+	// SetLinkCheck сохраняет результат проверки исходного URL записи, nil - сбрасывает его
+	SetLinkCheck(shortURL string, check *models.LinkCheck) error
+	// SetBrokenFallback заменяет URL перехода на время недоступности исходного URL, пустой - убирает его
+	SetBrokenFallback(shortURL, fallbackURL string) error
+	// RecordClick учитывает переход по сокращённому URL; variant - URL выбранного варианта, пустой вне распределения.
+	// У записи с MaxClicks проверка лимита и учёт перехода атомарны: когда лимит исчерпан,
+	// переход не учитывается и возвращается ErrClickLimitReached. Возвращает количество переходов с учётом этого,
+	// прочитанное там же, где переход записан, - в отличие от ClickCount, которое может читать с отстающей реплики.
+	RecordClick(shortURL, variant string) (int64, error)
+	ClickCount(shortURL string) (int64, error)
+	// VariantClicks возвращает количество переходов по каждому варианту, у которого они были
+	VariantClicks(shortURL string) (map[string]int64, error)
+	// Iterate последовательно передаёт в fn все записи хранилища в стабильном порядке,
+	// не загружая их в память целиком; ошибка fn прерывает обход
+	Iterate(ctx context.Context, fn func(record models.ShortURLRecord) error) error
+	// IterateByUser последовательно передаёт в fn неудалённые записи пользователя,
+	// не загружая их в память целиком; ошибка fn прерывает обход
+	IterateByUser(ctx context.Context, userID string, fn func(record models.ShortURLRecord) error) error
+	// IterateExpired последовательно передаёт в fn неудалённые записи пользователей, окно работы которых
+	// закончилось в промежутке от from до to включительно; ошибка fn прерывает обход
+	IterateExpired(ctx context.Context, from, to time.Time, fn func(record models.ShortURLRecord) error) error
+	// CreateWebhook сохраняет подписку пользователя на события его ссылок
+	CreateWebhook(webhook models.Webhook) error
+	// GetWebhooks возвращает подписки пользователя по возрастанию времени создания
+	GetWebhooks(userID string) ([]models.Webhook, error)
+	// DeleteWebhook удаляет подписку пользователя вместе с её доставками;
+	// ErrWebhookNotFound, если у пользователя нет такой подписки
+	DeleteWebhook(id, userID string) error
+	// AddEvent ставит событие в очередь доставки подпискам владельца ссылки, которые его ждут;
+	// событие с уже известным подписке ID повторно не ставится
+	AddEvent(event models.WebhookEvent) error
+	// ClaimDeliveries забирает до limit ожидающих доставок, время попытки которых наступило, по возрастанию
+	// этого времени и откладывает их следующую попытку на lease, чтобы их не забрал другой обработчик
+	ClaimDeliveries(lease time.Duration, limit int) ([]models.WebhookTask, error)
+	// UpdateDelivery сохраняет результат попытки доставки; доставка удалённой подписки не сохраняется
+	UpdateDelivery(delivery models.WebhookDelivery) error
+	// GetDeliveries возвращает до limit последних доставок подписки пользователя, новые первыми;
+	// ErrWebhookNotFound, если у пользователя нет такой подписки
+	GetDeliveries(webhookID, userID string, limit int) ([]models.WebhookDelivery, error)
 	Close() error
 	PingContext(ctx context.Context) error
 }
 
-func NewStorage(storageFilename, databaseDSN string) (Storage, error) {
+// sortByCreation упорядочивает записи по времени создания, записи одного времени - по сокращённому URL
+func sortByCreation(records []models.ShortURLRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].CreatedAt.Before(records[j].CreatedAt)
+		}
+
+		return records[i].ShortURL < records[j].ShortURL
+	})
+}
+
+// isExpiredBetween сообщает, попадает ли запись в выборку IterateExpired
+func isExpiredBetween(record models.ShortURLRecord, from, to time.Time) bool {
+	if record.IsDeleted || record.UserID == "" || record.NotAfter == nil {
+		return false
+	}
+
+	return !record.NotAfter.Before(from) && !record.NotAfter.After(to)
+}
+
+func sortByNotAfter(records []models.ShortURLRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].NotAfter.Equal(*records[j].NotAfter) {
+			return records[i].NotAfter.Before(*records[j].NotAfter)
+		}
+
+		return records[i].ShortURL < records[j].ShortURL
+	})
+}
+
+// Unwrap возвращает хранилище, обёрнутое декоратором, или nil, если s не декоратор
+func Unwrap(s Storage) Storage {
+	if wrapper, ok := s.(interface{ Unwrap() Storage }); ok {
+		return wrapper.Unwrap()
+	}
+
+	return nil
+}
+
+func NewStorage(storageFilename, databaseDSN string, databaseOptions DatabaseOptions) (Storage, error) {
 	var s Storage
 	var err error
 
 	if databaseDSN != "" {
-		s, err = NewDatabaseStorage(databaseDSN)
+		s, err = NewDatabaseStorage(databaseDSN, databaseOptions)
 		if err != nil {
 			return nil, fmt.Errorf("new db storage: %w", err)
 		}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pluhe7/shortener/internal/models"
+)
+
+// linkEvent - событие изменения записи. ID события определяется самим изменением,
+// поэтому одно изменение не попадёт в очередь подписки дважды.
+func linkEvent(eventType string, record models.ShortURLRecord, revision int) models.WebhookEvent {
+	id := eventType + ":" + record.ShortURL
+	if revision > 0 {
+		id += ":" + strconv.Itoa(revision)
+	}
+
+	return models.WebhookEvent{
+		ID:          id,
+		Type:        eventType,
+		LinkID:      record.ShortURL,
+		OriginalURL: record.OriginalURL,
+		UserID:      record.UserID,
+		OccurredAt:  time.Now().UTC(),
+	}
+}
+
+// subscribed сообщает, что подписка ждёт событие eventType от ссылок пользователя userID
+func subscribed(webhook models.Webhook, userID, eventType string) bool {
+	return webhook.UserID == userID && (len(webhook.Events) == 0 || slices.Contains(webhook.Events, eventType))
+}
+
+// sortWebhooks упорядочивает подписки по времени создания, подписки одного времени - по ID
+func sortWebhooks(webhooks []models.Webhook) {
+	sort.Slice(webhooks, func(i, j int) bool {
+		if !webhooks[i].CreatedAt.Equal(webhooks[j].CreatedAt) {
+			return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+		}
+
+		return webhooks[i].ID < webhooks[j].ID
+	})
+}
+
+func newDelivery(id int64, webhookID string, event models.WebhookEvent) models.WebhookDelivery {
+	now := time.Now().UTC()
+
+	return models.WebhookDelivery{
+		ID:            id,
+		WebhookID:     webhookID,
+		Event:         event,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+// dueDeliveries возвращает до limit ожидающих доставок, время попытки которых наступило, по возрастанию этого времени
+func dueDeliveries(deliveries []models.WebhookDelivery, now time.Time, limit int) []models.WebhookDelivery {
+	var due []models.WebhookDelivery
+	for _, delivery := range deliveries {
+		if delivery.Status == models.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due
+}
+
+// latestDeliveries упорядочивает доставки от новых к старым и оставляет limit первых
+func latestDeliveries(deliveries []models.WebhookDelivery, limit int) []models.WebhookDelivery {
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries
+}
@@ -0,0 +1,103 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// при таком количестве ключей из памяти удаляются устаревшие
+const pruneThreshold = 10000
+
+type Options struct {
+	// Количество неудачных попыток, после которого ключ блокируется
+	MaxAttempts int
+	// Время блокировки ключа; за это же время забываются неудачные попытки
+	Lockout time.Duration
+}
+
+// Limiter считает неудачные попытки по ключу и блокирует ключ,
+// если за время Lockout набралось MaxAttempts неудач
+type Limiter struct {
+	options Options
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+type entry struct {
+	failures     int
+	firstAt      time.Time
+	blockedUntil time.Time
+}
+
+func New(options Options) *Limiter {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 1
+	}
+
+	return &Limiter{
+		options: options,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Allow возвращает false и время до снятия блокировки, если ключ заблокирован
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return true, 0
+	}
+
+	retryAfter := time.Until(e.blockedUntil)
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	return true, 0
+}
+
+func (l *Limiter) Failure(key string) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) >= pruneThreshold {
+		l.prune(now)
+	}
+
+	e, ok := l.entries[key]
+	if !ok || l.expired(e, now) {
+		e = &entry{firstAt: now}
+		l.entries[key] = e
+	}
+
+	e.failures++
+	if e.failures >= l.options.MaxAttempts {
+		e.blockedUntil = now.Add(l.options.Lockout)
+		e.failures = 0
+		e.firstAt = e.blockedUntil
+	}
+}
+
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
+
+func (l *Limiter) expired(e *entry, now time.Time) bool {
+	return now.After(e.blockedUntil) && now.Sub(e.firstAt) > l.options.Lockout
+}
+
+func (l *Limiter) prune(now time.Time) {
+	for key, e := range l.entries {
+		if l.expired(e, now) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := New(Options{MaxAttempts: 3, Lockout: 50 * time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		limiter.Failure("key")
+
+		allowed, _ := limiter.Allow("key")
+		assert.True(t, allowed)
+	}
+
+	limiter.Failure("key")
+
+	allowed, retryAfter := limiter.Allow("key")
+	assert.False(t, allowed)
+	assert.Greater(t, retryAfter, time.Duration(0))
+
+	allowed, _ = limiter.Allow("other key")
+	assert.True(t, allowed)
+
+	time.Sleep(60 * time.Millisecond)
+
+	allowed, _ = limiter.Allow("key")
+	assert.True(t, allowed)
+
+	limiter.Failure("key")
+	limiter.Failure("key")
+	limiter.Reset("key")
+	limiter.Failure("key")
+
+	allowed, _ = limiter.Allow("key")
+	assert.True(t, allowed)
+}
@@ -0,0 +1,223 @@
+// Package unfurl получает заголовок, описание и теги OpenGraph страницы по URL
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+
+	"github.com/pluhe7/shortener/internal/models"
+	"github.com/pluhe7/shortener/internal/safehttp"
+)
+
+const (
+	// Ограничения размера сохраняемых сведений, чтобы страница не раздувала запись
+	maxValueLen       = 1000
+	maxOpenGraphTags  = 32
+	openGraphProperty = "og:"
+
+	userAgent = "ShortenerBot/1.0 (+link preview)"
+)
+
+var ErrNotHTML = errors.New("destination is not an html page")
+
+type Options struct {
+	// Общее время на получение страницы, включая редиректы
+	Timeout time.Duration
+	// Максимальное количество прочитанных байт страницы
+	MaxBytes int64
+	// Разрешить подключения к локальным и частным адресам
+	AllowPrivate bool
+}
+
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+func NewFetcher(options Options) *Fetcher {
+	return &Fetcher{
+		client:   safehttp.NewClient(options.Timeout, options.AllowPrivate),
+		maxBytes: options.MaxBytes,
+	}
+}
+
+// Fetch загружает страницу и разбирает её <head>; читается не больше MaxBytes байт
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (models.URLMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return models.URLMetadata{}, fmt.Errorf("new request: %w", err)
+	}
+
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return models.URLMetadata{}, fmt.Errorf("unsupported scheme %q", req.URL.Scheme)
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return models.URLMetadata{}, fmt.Errorf("get page: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return models.URLMetadata{}, fmt.Errorf("get page: unexpected status %d", resp.StatusCode)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "" && mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return models.URLMetadata{}, fmt.Errorf("%w: %s", ErrNotHTML, mediaType)
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, f.maxBytes), contentType)
+	if err != nil {
+		return models.URLMetadata{}, fmt.Errorf("decode charset: %w", err)
+	}
+
+	metadata, err := parseHead(body, resp.Request.URL)
+	if err != nil {
+		return models.URLMetadata{}, fmt.Errorf("parse page: %w", err)
+	}
+
+	return metadata, nil
+}
+
+// parseHead читает теги до <body>; относительные URL тегов OpenGraph разрешаются относительно pageURL
+func parseHead(r io.Reader, pageURL *url.URL) (models.URLMetadata, error) {
+	var metadata models.URLMetadata
+
+	tokenizer := html.NewTokenizer(r)
+	inTitle := false
+
+	for {
+		tokenType := tokenizer.Next()
+
+		switch tokenType {
+		case html.ErrorToken:
+			if errors.Is(tokenizer.Err(), io.EOF) {
+				return metadata, nil
+			}
+
+			return metadata, tokenizer.Err()
+
+		case html.TextToken:
+			if inTitle && metadata.Title == "" {
+				metadata.Title = clean(string(tokenizer.Text()))
+			}
+
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch atom.Lookup(name) {
+			case atom.Title:
+				inTitle = false
+			case atom.Head:
+				return metadata, nil
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+
+			switch atom.Lookup(name) {
+			case atom.Body:
+				return metadata, nil
+			case atom.Title:
+				inTitle = tokenType == html.StartTagToken
+			case atom.Meta:
+				if hasAttr {
+					addMeta(&metadata, tokenizer, pageURL)
+				}
+			}
+		}
+	}
+}
+
+func addMeta(metadata *models.URLMetadata, tokenizer *html.Tokenizer, pageURL *url.URL) {
+	var name, property, content string
+
+	for {
+		key, value, more := tokenizer.TagAttr()
+
+		switch string(key) {
+		case "name":
+			name = strings.ToLower(string(value))
+		case "property":
+			property = strings.ToLower(string(value))
+		case "content":
+			content = clean(string(value))
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	if content == "" {
+		return
+	}
+
+	if name == "description" && metadata.Description == "" {
+		metadata.Description = content
+	}
+
+	tag, ok := strings.CutPrefix(property, openGraphProperty)
+	if !ok || tag == "" {
+		return
+	}
+
+	if metadata.OpenGraph == nil {
+		metadata.OpenGraph = make(map[string]string)
+	}
+
+	if _, exists := metadata.OpenGraph[tag]; exists || len(metadata.OpenGraph) >= maxOpenGraphTags {
+		return
+	}
+
+	if tag == "image" || tag == "url" || tag == "video" || tag == "audio" {
+		content = resolve(pageURL, content)
+		if content == "" {
+			return
+		}
+	}
+
+	metadata.OpenGraph[tag] = content
+}
+
+// resolve возвращает абсолютный http(s) URL или пустую строку, если ссылка ведёт на другую схему
+func resolve(base *url.URL, ref string) string {
+	parsed, err := base.Parse(ref)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return ""
+	}
+
+	return parsed.String()
+}
+
+// clean схлопывает пробелы и обрезает значение до maxValueLen байт, не разрывая символы
+func clean(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+
+	if len(value) <= maxValueLen {
+		return value
+	}
+
+	value = value[:maxValueLen]
+	for !utf8.ValidString(value) {
+		value = value[:len(value)-1]
+	}
+
+	return value
+}
@@ -0,0 +1,128 @@
+package unfurl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pluhe7/shortener/internal/safehttp"
+)
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>  Example
+		page &amp; co </title>
+	<meta name="description" content="Page description">
+	<meta property="og:title" content="OpenGraph title">
+	<meta property="og:image" content="/images/cover.png">
+	<meta property="og:url" content="javascript:alert(1)">
+	<meta property="og:site_name" content="Example">
+</head>
+<body>
+	<meta property="og:description" content="ignored, outside head">
+</body>
+</html>`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testPage))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/cp1251", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+		// "Привет" в windows-1251
+		w.Write([]byte("<title>\xcf\xf0\xe8\xe2\xe5\xf2</title>"))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<head><!--" + strings.Repeat("x", 4096) + "--><title>Too far</title></head>"))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestFetch(t *testing.T) {
+	srv := newTestServer(t)
+
+	fetcher := NewFetcher(Options{Timeout: 200 * time.Millisecond, MaxBytes: 1024, AllowPrivate: true})
+	ctx := context.Background()
+
+	t.Run("metadata", func(t *testing.T) {
+		metadata, err := fetcher.Fetch(ctx, srv.URL+"/redirect")
+		require.NoError(t, err)
+
+		assert.Equal(t, "Example page & co", metadata.Title)
+		assert.Equal(t, "Page description", metadata.Description)
+		assert.Equal(t, map[string]string{
+			"title":     "OpenGraph title",
+			"image":     srv.URL + "/images/cover.png",
+			"site_name": "Example",
+		}, metadata.OpenGraph)
+	})
+
+	t.Run("charset", func(t *testing.T) {
+		metadata, err := fetcher.Fetch(ctx, srv.URL+"/cp1251")
+		require.NoError(t, err)
+		assert.Equal(t, "Привет", metadata.Title)
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		metadata, err := fetcher.Fetch(ctx, srv.URL+"/large")
+		require.NoError(t, err)
+		assert.Empty(t, metadata.Title)
+	})
+
+	t.Run("not html", func(t *testing.T) {
+		_, err := fetcher.Fetch(ctx, srv.URL+"/image")
+		assert.ErrorIs(t, err, ErrNotHTML)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		start := time.Now()
+
+		_, err := fetcher.Fetch(ctx, srv.URL+"/slow")
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("unsupported scheme", func(t *testing.T) {
+		_, err := fetcher.Fetch(ctx, "file:///etc/passwd")
+		assert.Error(t, err)
+	})
+}
+
+func TestFetchBlocksPrivateAddresses(t *testing.T) {
+	srv := newTestServer(t)
+
+	fetcher := NewFetcher(Options{Timeout: time.Second, MaxBytes: 1024})
+
+	for _, target := range []string{srv.URL + "/page", strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/page"} {
+		_, err := fetcher.Fetch(context.Background(), target)
+		assert.ErrorIs(t, err, safehttp.ErrForbiddenAddress, target)
+	}
+}